@@ -0,0 +1,307 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
+)
+
+// all methods are called in order, one at a time, but not always from the
+// same goroutine
+type Actor interface {
+	OnStart(self *Ref)
+	Receive(self *Ref, msg interface{})
+	OnStop(self *Ref)
+}
+
+type Directive int
+
+const (
+	// drop the message and go on
+	Resume Directive = iota
+	// replace the actor with a new instance
+	Restart
+	// stop the actor
+	Stop
+)
+
+// called after Receive panicked
+type Supervisor func(ref *Ref, msg interface{}, r interface{}) Directive
+
+var (
+	ErrStopped     = errors.New("actor stopped")
+	ErrMailboxFull = errors.New("actor mailbox full")
+	ErrExists      = errors.New("actor already exists")
+)
+
+type System struct {
+	WorkerNum  int
+	MailboxLen int
+	// messages an actor processes before yielding its worker
+	Throughput int
+	Supervisor Supervisor
+	runQueue   []*Ref
+	mutexQueue sync.Mutex
+	condQueue  *sync.Cond
+	closeFlag  bool
+	refs       map[interface{}]*Ref
+	mutexRefs  sync.Mutex
+	wg         sync.WaitGroup
+}
+
+type stopMsg struct{}
+
+type Ref struct {
+	sync.Mutex
+	id        interface{}
+	system    *System
+	newActor  func() Actor
+	actor     Actor
+	mailbox   []interface{}
+	scheduled bool
+	started   bool
+	stopped   bool
+	done      chan struct{}
+}
+
+func (s *System) Start() {
+	if s.WorkerNum <= 0 {
+		s.WorkerNum = runtime.NumCPU()
+		log.Release("invalid WorkerNum, reset to %v", s.WorkerNum)
+	}
+	if s.MailboxLen <= 0 {
+		s.MailboxLen = 1024
+		log.Release("invalid MailboxLen, reset to %v", s.MailboxLen)
+	}
+	if s.Throughput <= 0 {
+		s.Throughput = 32
+	}
+	if s.Supervisor == nil {
+		s.Supervisor = func(*Ref, interface{}, interface{}) Directive {
+			return Resume
+		}
+	}
+
+	s.refs = make(map[interface{}]*Ref)
+	s.condQueue = sync.NewCond(&s.mutexQueue)
+	for i := 0; i < s.WorkerNum; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+}
+
+// Close stops all actors and waits for them to finish
+func (s *System) Close() {
+	s.mutexRefs.Lock()
+	refs := make([]*Ref, 0, len(s.refs))
+	for _, ref := range s.refs {
+		refs = append(refs, ref)
+	}
+	s.mutexRefs.Unlock()
+
+	for _, ref := range refs {
+		ref.Stop()
+	}
+	for _, ref := range refs {
+		<-ref.done
+	}
+
+	s.mutexQueue.Lock()
+	s.closeFlag = true
+	s.mutexQueue.Unlock()
+	s.condQueue.Broadcast()
+	s.wg.Wait()
+}
+
+// goroutine safe
+func (s *System) Spawn(id interface{}, newActor func() Actor) (*Ref, error) {
+	ref := &Ref{
+		id:       id,
+		system:   s,
+		newActor: newActor,
+		actor:    newActor(),
+		done:     make(chan struct{}),
+	}
+
+	s.mutexRefs.Lock()
+	if _, ok := s.refs[id]; ok {
+		s.mutexRefs.Unlock()
+		return nil, ErrExists
+	}
+	s.refs[id] = ref
+	s.mutexRefs.Unlock()
+
+	ref.Lock()
+	ref.schedule()
+	ref.Unlock()
+	return ref, nil
+}
+
+// goroutine safe
+func (s *System) Lookup(id interface{}) *Ref {
+	s.mutexRefs.Lock()
+	defer s.mutexRefs.Unlock()
+	return s.refs[id]
+}
+
+// goroutine safe
+func (s *System) Send(id interface{}, msg interface{}) error {
+	ref := s.Lookup(id)
+	if ref == nil {
+		return fmt.Errorf("actor %v not found", id)
+	}
+	return ref.Send(msg)
+}
+
+func (s *System) enqueue(ref *Ref) {
+	s.mutexQueue.Lock()
+	s.runQueue = append(s.runQueue, ref)
+	s.mutexQueue.Unlock()
+	s.condQueue.Signal()
+}
+
+func (s *System) work() {
+	defer s.wg.Done()
+	for {
+		s.mutexQueue.Lock()
+		for len(s.runQueue) == 0 && !s.closeFlag {
+			s.condQueue.Wait()
+		}
+		if len(s.runQueue) == 0 {
+			s.mutexQueue.Unlock()
+			return
+		}
+		ref := s.runQueue[0]
+		s.runQueue[0] = nil
+		s.runQueue = s.runQueue[1:]
+		s.mutexQueue.Unlock()
+
+		ref.process()
+	}
+}
+
+func (ref *Ref) ID() interface{} {
+	return ref.id
+}
+
+// goroutine safe
+func (ref *Ref) Send(msg interface{}) error {
+	ref.Lock()
+	defer ref.Unlock()
+	if ref.stopped {
+		return ErrStopped
+	}
+	if len(ref.mailbox) >= ref.system.MailboxLen {
+		return ErrMailboxFull
+	}
+
+	ref.mailbox = append(ref.mailbox, msg)
+	ref.schedule()
+	return nil
+}
+
+// messages sent before Stop are processed first
+// goroutine safe
+func (ref *Ref) Stop() {
+	ref.Lock()
+	defer ref.Unlock()
+	if ref.stopped {
+		return
+	}
+
+	ref.stopped = true
+	ref.mailbox = append(ref.mailbox, stopMsg{})
+	ref.schedule()
+}
+
+// Done is closed after OnStop returned
+func (ref *Ref) Done() <-chan struct{} {
+	return ref.done
+}
+
+func (ref *Ref) schedule() {
+	if ref.scheduled {
+		return
+	}
+	ref.scheduled = true
+	ref.system.enqueue(ref)
+}
+
+func (ref *Ref) process() {
+	if !ref.started {
+		ref.started = true
+		ref.call(func() { ref.actor.OnStart(ref) })
+	}
+
+	for i := 0; i < ref.system.Throughput; i++ {
+		ref.Lock()
+		if len(ref.mailbox) == 0 {
+			ref.scheduled = false
+			ref.Unlock()
+			return
+		}
+		msg := ref.mailbox[0]
+		ref.mailbox[0] = nil
+		ref.mailbox = ref.mailbox[1:]
+		ref.Unlock()
+
+		if _, ok := msg.(stopMsg); ok {
+			ref.terminate()
+			return
+		}
+		ref.receive(msg)
+	}
+
+	ref.Lock()
+	ref.scheduled = false
+	ref.schedule()
+	ref.Unlock()
+}
+
+func (ref *Ref) receive(msg interface{}) {
+	r := ref.call(func() { ref.actor.Receive(ref, msg) })
+	if r == nil {
+		return
+	}
+
+	switch ref.system.Supervisor(ref, msg, r) {
+	case Restart:
+		ref.call(func() { ref.actor.OnStop(ref) })
+		ref.actor = ref.newActor()
+		ref.call(func() { ref.actor.OnStart(ref) })
+	case Stop:
+		ref.Stop()
+	}
+}
+
+func (ref *Ref) terminate() {
+	ref.call(func() { ref.actor.OnStop(ref) })
+
+	s := ref.system
+	s.mutexRefs.Lock()
+	if s.refs[ref.id] == ref {
+		delete(s.refs, ref.id)
+	}
+	s.mutexRefs.Unlock()
+
+	ref.Lock()
+	ref.mailbox = nil
+	ref.Unlock()
+	close(ref.done)
+}
+
+// returns the recovered value if f panicked
+func (ref *Ref) call(f func()) (r interface{}) {
+	defer func() {
+		if r = recover(); r != nil {
+			util.Recovered(r, util.PanicContext{Source: "actor", ID: ref.id})
+		}
+	}()
+
+	f()
+	return
+}
@@ -0,0 +1,85 @@
+package actor
+
+import (
+	"reflect"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+)
+
+// the key of the binding in the values of an agent
+const bindingKey = "leaf/actor"
+
+// Message is a message received from an agent, sent to the actor bound to
+// the agent by Forward
+type Message struct {
+	Msg   interface{}
+	Agent gate.Agent
+}
+
+type binding struct {
+	ref         *Ref
+	stopOnClose bool
+}
+
+// Close is called by the gate when the agent closes
+func (b *binding) Close() error {
+	if b.stopOnClose {
+		b.ref.Stop()
+	}
+	return nil
+}
+
+// Bind makes ref the actor of the agent, e.g. after login, so that the
+// messages of the agent are forwarded to it. The actor is stopped when the
+// agent closes if stopOnClose, otherwise it outlives the connection and may
+// be bound to the next one
+// goroutine safe
+func Bind(a gate.Agent, ref *Ref, stopOnClose bool) {
+	a.Set(bindingKey, &binding{ref, stopOnClose})
+}
+
+// goroutine safe
+func Unbind(a gate.Agent) {
+	a.Del(bindingKey)
+}
+
+// Bound returns the actor bound to the agent, nil if none
+// goroutine safe
+func Bound(a gate.Agent) *Ref {
+	b, ok := gate.Value[*binding](a, bindingKey)
+	if !ok {
+		return nil
+	}
+	return b.ref
+}
+
+// Forward returns a message handler sending the messages to the actor bound
+// to the agent as a Message, so that each player is served by its own
+// actor instead of a single module goroutine:
+//
+//	processor.SetHandler(&msg.Move{}, actor.Forward(game.ChanRPC))
+//
+// The messages of the agents without actor are routed to fallback as by
+// SetRouter, or dropped if fallback is nil
+func Forward(fallback *chanrpc.Server) func(args []interface{}) {
+	return func(args []interface{}) {
+		msg := args[0]
+		a, ok := args[1].(gate.Agent)
+		if !ok {
+			log.Error("actor: forward %T: userData is not a gate.Agent", msg)
+			return
+		}
+
+		if ref := Bound(a); ref != nil {
+			if err := ref.Send(Message{msg, a}); err != nil {
+				log.Debug("actor: forward %T to %v: %v", msg, ref.ID(), err)
+			}
+			return
+		}
+		if fallback != nil {
+			fallback.Go(reflect.TypeOf(msg), msg, a)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package actor_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/actor"
+	"github.com/czx-lab/leaf/gate"
+)
+
+type Player struct {
+	gold int
+}
+
+func (p *Player) OnStart(self *actor.Ref) {
+	fmt.Println(self.ID(), "start")
+}
+
+func (p *Player) Receive(self *actor.Ref, msg interface{}) {
+	p.gold += msg.(int)
+	fmt.Println(self.ID(), "gold", p.gold)
+}
+
+func (p *Player) OnStop(self *actor.Ref) {
+	fmt.Println(self.ID(), "stop")
+}
+
+func Example() {
+	s := &actor.System{WorkerNum: 4}
+	s.Start()
+
+	ref, err := s.Spawn("leaf", func() actor.Actor { return new(Player) })
+	if err != nil {
+		return
+	}
+	ref.Send(10)
+	ref.Send(20)
+	ref.Stop()
+	<-ref.Done()
+
+	s.Close()
+
+	// Output:
+	// leaf start
+	// leaf gold 10
+	// leaf gold 30
+	// leaf stop
+}
+
+// agent stands for a gate.Agent
+type agent struct {
+	gate.Agent
+	values gate.Values
+}
+
+func (a *agent) Set(key string, v interface{})      { a.values.Set(key, v) }
+func (a *agent) Get(key string) (interface{}, bool) { return a.values.Get(key) }
+func (a *agent) Del(key string)                     { a.values.Del(key) }
+
+type Session struct{}
+
+func (s *Session) OnStart(self *actor.Ref) {}
+
+func (s *Session) Receive(self *actor.Ref, msg interface{}) {
+	m := msg.(actor.Message)
+	fmt.Println(self.ID(), "received", m.Msg)
+}
+
+func (s *Session) OnStop(self *actor.Ref) {}
+
+func ExampleForward() {
+	s := &actor.System{WorkerNum: 4}
+	s.Start()
+
+	// e.g. processor.SetHandler(&msg.Move{}, handler)
+	handler := actor.Forward(nil)
+
+	a := new(agent)
+	ref, _ := s.Spawn("leaf", func() actor.Actor { return new(Session) })
+	actor.Bind(a, ref, true)
+	handler([]interface{}{"move", a})
+
+	// the gate clears the values of a closed agent
+	a.values.Clear()
+	<-ref.Done()
+	s.Close()
+
+	// Output:
+	// leaf received move
+}
@@ -0,0 +1,199 @@
+package aoi
+
+import (
+	"math"
+)
+
+type entity struct {
+	id   interface{}
+	x, y float64
+	cx   int
+	cy   int
+}
+
+// Grid is a grid based area of interest manager. Entities see each other if
+// their cells are at most Range cells apart (in both directions).
+// goroutine not safe
+type Grid struct {
+	// called for both entities when they start seeing each other
+	OnEnter func(watcher, target interface{})
+	// called for both entities when they stop seeing each other
+	OnLeave func(watcher, target interface{})
+	// called for the entities seeing an entity moving within their view
+	OnMove func(watcher, target interface{}, x, y float64)
+
+	cellSize float64
+	viewLen  int
+	cols     int
+	rows     int
+	cells    []map[interface{}]*entity
+	entities map[interface{}]*entity
+}
+
+// the map covers [0, width) x [0, height)
+func NewGrid(width, height, cellSize float64, viewLen int) *Grid {
+	g := new(Grid)
+	g.cellSize = cellSize
+	g.viewLen = viewLen
+	g.cols = int(math.Ceil(width / cellSize))
+	g.rows = int(math.Ceil(height / cellSize))
+	g.cells = make([]map[interface{}]*entity, g.cols*g.rows)
+	g.entities = make(map[interface{}]*entity)
+	return g
+}
+
+func (g *Grid) cell(x, y float64) (int, int) {
+	cx := int(x / g.cellSize)
+	cy := int(y / g.cellSize)
+	if cx < 0 {
+		cx = 0
+	} else if cx >= g.cols {
+		cx = g.cols - 1
+	}
+	if cy < 0 {
+		cy = 0
+	} else if cy >= g.rows {
+		cy = g.rows - 1
+	}
+	return cx, cy
+}
+
+func (g *Grid) add(e *entity) {
+	i := e.cy*g.cols + e.cx
+	if g.cells[i] == nil {
+		g.cells[i] = make(map[interface{}]*entity)
+	}
+	g.cells[i][e.id] = e
+}
+
+func (g *Grid) remove(e *entity) {
+	delete(g.cells[e.cy*g.cols+e.cx], e.id)
+}
+
+func (g *Grid) visible(a, b *entity) bool {
+	return abs(a.cx-b.cx) <= g.viewLen && abs(a.cy-b.cy) <= g.viewLen
+}
+
+func (g *Grid) around(cx, cy int, f func(e *entity)) {
+	for y := max(cy-g.viewLen, 0); y <= min(cy+g.viewLen, g.rows-1); y++ {
+		for x := max(cx-g.viewLen, 0); x <= min(cx+g.viewLen, g.cols-1); x++ {
+			for _, e := range g.cells[y*g.cols+x] {
+				f(e)
+			}
+		}
+	}
+}
+
+func (g *Grid) Enter(id interface{}, x, y float64) {
+	if _, ok := g.entities[id]; ok {
+		g.Move(id, x, y)
+		return
+	}
+
+	e := &entity{id: id, x: x, y: y}
+	e.cx, e.cy = g.cell(x, y)
+	g.around(e.cx, e.cy, func(o *entity) {
+		g.enter(e, o)
+	})
+	g.entities[id] = e
+	g.add(e)
+}
+
+func (g *Grid) Leave(id interface{}) {
+	e, ok := g.entities[id]
+	if !ok {
+		return
+	}
+
+	delete(g.entities, id)
+	g.remove(e)
+	g.around(e.cx, e.cy, func(o *entity) {
+		g.leave(e, o)
+	})
+}
+
+func (g *Grid) Move(id interface{}, x, y float64) {
+	e, ok := g.entities[id]
+	if !ok {
+		g.Enter(id, x, y)
+		return
+	}
+
+	old := *e
+	e.x, e.y = x, y
+	e.cx, e.cy = g.cell(x, y)
+	if old.cx == e.cx && old.cy == e.cy {
+		g.around(e.cx, e.cy, func(o *entity) {
+			if o != e && g.OnMove != nil {
+				g.OnMove(o.id, e.id, x, y)
+			}
+		})
+		return
+	}
+
+	g.remove(&old)
+	g.around(old.cx, old.cy, func(o *entity) {
+		if !g.visible(e, o) {
+			g.leave(e, o)
+		}
+	})
+	g.around(e.cx, e.cy, func(o *entity) {
+		if g.visible(&old, o) {
+			if g.OnMove != nil {
+				g.OnMove(o.id, e.id, x, y)
+			}
+		} else {
+			g.enter(e, o)
+		}
+	})
+	g.add(e)
+}
+
+func (g *Grid) enter(e, o *entity) {
+	if g.OnEnter != nil {
+		g.OnEnter(o.id, e.id)
+		g.OnEnter(e.id, o.id)
+	}
+}
+
+func (g *Grid) leave(e, o *entity) {
+	if g.OnLeave != nil {
+		g.OnLeave(o.id, e.id)
+		g.OnLeave(e.id, o.id)
+	}
+}
+
+// Neighbors returns the entities seen by id
+func (g *Grid) Neighbors(id interface{}) []interface{} {
+	e, ok := g.entities[id]
+	if !ok {
+		return nil
+	}
+
+	var r []interface{}
+	g.around(e.cx, e.cy, func(o *entity) {
+		if o != e {
+			r = append(r, o.id)
+		}
+	})
+	return r
+}
+
+func (g *Grid) Position(id interface{}) (x, y float64, ok bool) {
+	e, ok := g.entities[id]
+	if !ok {
+		return 0, 0, false
+	}
+	return e.x, e.y, true
+}
+
+func (g *Grid) Len() int {
+	return len(g.entities)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
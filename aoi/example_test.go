@@ -0,0 +1,33 @@
+package aoi_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/aoi"
+)
+
+func Example() {
+	g := aoi.NewGrid(1000, 1000, 100, 1)
+	g.OnEnter = func(watcher, target interface{}) {
+		fmt.Println(watcher, "sees", target)
+	}
+	g.OnLeave = func(watcher, target interface{}) {
+		fmt.Println(watcher, "lost", target)
+	}
+
+	g.Enter("a", 50, 50)
+	g.Enter("b", 150, 50)
+	g.Enter("c", 900, 900)
+	fmt.Println(g.Neighbors("c"))
+
+	g.Move("b", 850, 850)
+
+	// Output:
+	// a sees b
+	// b sees a
+	// []
+	// a lost b
+	// b lost a
+	// c sees b
+	// b sees c
+}
@@ -0,0 +1,228 @@
+// Package cache keeps the player records of a game module in memory and
+// writes them behind. The records are owned by the skeleton goroutine, the
+// handlers mutate them and call MarkDirty, the dirty records are copied and
+// saved in order by a background flusher
+package cache
+
+import (
+	"time"
+
+	g "github.com/czx-lab/leaf/go"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/timer"
+)
+
+// Store persists the records, e.g. on mongodb, redis or sql
+// must goroutine safe
+type Store[K comparable, V any] interface {
+	// ok is false if key has no record
+	Load(key K) (v V, ok bool, err error)
+	// saves a batch of records
+	Save(records map[K]V) error
+}
+
+// Cache is a write-behind cache, the methods must be called in the skeleton
+// goroutine
+type Cache[K comparable, V any] struct {
+	s     *module.Skeleton
+	store Store[K, V]
+	clone func(v V) V
+	// the saves are executed in order
+	linear *g.LinearContext
+	t      *timer.Timer
+
+	records map[K]V
+	dirty   map[K]struct{}
+	// the records evicted and not saved yet, they are revived by Load
+	evicted map[K]*evictedRecord[V]
+	loading map[K][]func(v V, ok bool, err error)
+}
+
+type evictedRecord[V any] struct {
+	v V
+	// retried by the next flush if not
+	saving bool
+}
+
+// New flushes the dirty records of s every interval, clone copies a record
+// to save in the skeleton goroutine, e.g. func(p *Player) *Player { c := *p;
+// return &c } if Player has no references. s needs GoLen and
+// TimerDispatcherLen
+func New[K comparable, V any](s *module.Skeleton, store Store[K, V], clone func(v V) V, interval time.Duration) *Cache[K, V] {
+	c := new(Cache[K, V])
+	c.s = s
+	c.store = store
+	c.clone = clone
+	c.linear = s.NewLinearContext()
+	c.records = make(map[K]V)
+	c.dirty = make(map[K]struct{})
+	c.evicted = make(map[K]*evictedRecord[V])
+	c.loading = make(map[K][]func(v V, ok bool, err error))
+
+	var flush func()
+	flush = func() {
+		c.Flush()
+		c.t = s.AfterFunc(interval, flush)
+	}
+	c.t = s.AfterFunc(interval, flush)
+	return c
+}
+
+// Get returns the record of key if cached
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	v, ok := c.records[key]
+	return v, ok
+}
+
+// Load calls cb with the record of key, it's loaded from the store in a
+// goroutine if not cached. ok is false if the store has no record
+func (c *Cache[K, V]) Load(key K, cb func(v V, ok bool, err error)) {
+	if v, ok := c.records[key]; ok {
+		cb(v, true, nil)
+		return
+	}
+	// not saved yet, the store is stale
+	if e := c.evicted[key]; e != nil {
+		delete(c.evicted, key)
+		c.records[key] = e.v
+		c.dirty[key] = struct{}{}
+		cb(e.v, true, nil)
+		return
+	}
+	if cbs, ok := c.loading[key]; ok {
+		c.loading[key] = append(cbs, cb)
+		return
+	}
+
+	c.loading[key] = []func(v V, ok bool, err error){cb}
+	var v V
+	var ok bool
+	var err error
+	c.s.Go(func() {
+		v, ok, err = c.store.Load(key)
+	}, func() {
+		cbs := c.loading[key]
+		delete(c.loading, key)
+		// put meanwhile
+		if cached, exist := c.records[key]; exist {
+			v, ok, err = cached, true, nil
+		} else if err == nil && ok {
+			c.records[key] = v
+		}
+		for _, cb := range cbs {
+			cb(v, ok, err)
+		}
+	})
+}
+
+// Put caches v as the record of key and marks it dirty, e.g. a new player
+func (c *Cache[K, V]) Put(key K, v V) {
+	delete(c.evicted, key)
+	c.records[key] = v
+	c.dirty[key] = struct{}{}
+}
+
+// MarkDirty schedules the record of key to be saved at the next flush
+func (c *Cache[K, V]) MarkDirty(key K) {
+	if _, ok := c.records[key]; ok {
+		c.dirty[key] = struct{}{}
+	}
+}
+
+// Evict saves the record of key if dirty and drops it, e.g. on logout
+func (c *Cache[K, V]) Evict(key K) {
+	v, ok := c.records[key]
+	if !ok {
+		return
+	}
+	delete(c.records, key)
+	if _, dirty := c.dirty[key]; !dirty {
+		return
+	}
+	delete(c.dirty, key)
+
+	e := &evictedRecord[V]{v: c.clone(v), saving: true}
+	c.evicted[key] = e
+	c.save(map[K]V{key: e.v}, map[K]*evictedRecord[V]{key: e})
+}
+
+// Len returns the number of records cached
+func (c *Cache[K, V]) Len() int {
+	return len(c.records)
+}
+
+// Flush saves the dirty records in a goroutine
+func (c *Cache[K, V]) Flush() {
+	batch := make(map[K]V, len(c.dirty))
+	for key := range c.dirty {
+		batch[key] = c.clone(c.records[key])
+	}
+	clear(c.dirty)
+	var evicted map[K]*evictedRecord[V]
+	for key, e := range c.evicted {
+		if e.saving {
+			continue
+		}
+		if evicted == nil {
+			evicted = make(map[K]*evictedRecord[V])
+		}
+		e.saving = true
+		evicted[key] = e
+		batch[key] = e.v
+	}
+	if len(batch) > 0 {
+		c.save(batch, evicted)
+	}
+}
+
+// save writes batch after the previous saves. The records failed are saved
+// again by the next flush
+func (c *Cache[K, V]) save(batch map[K]V, evicted map[K]*evictedRecord[V]) {
+	var err error
+	c.linear.Go(func() {
+		err = c.store.Save(batch)
+	}, func() {
+		if err == nil {
+			for key, e := range evicted {
+				if c.evicted[key] == e {
+					delete(c.evicted, key)
+				}
+			}
+			return
+		}
+
+		log.Error("cache: save %v records error: %v", len(batch), err)
+		for key, v := range batch {
+			if _, ok := c.records[key]; ok {
+				c.dirty[key] = struct{}{}
+			} else if e := c.evicted[key]; e != nil {
+				e.saving = false
+			} else {
+				// evicted clean while saving
+				c.evicted[key] = &evictedRecord[V]{v: v}
+			}
+		}
+	})
+}
+
+// Close stops the flushes and saves the dirty records and the evicted ones
+// not saved. Call it in OnDestroy, the saves started are done once the
+// skeleton is stopped
+func (c *Cache[K, V]) Close() error {
+	c.t.Stop()
+
+	batch := make(map[K]V, len(c.dirty)+len(c.evicted))
+	for key, e := range c.evicted {
+		batch[key] = e.v
+	}
+	for key := range c.dirty {
+		batch[key] = c.records[key]
+	}
+	clear(c.dirty)
+	clear(c.evicted)
+	if len(batch) == 0 {
+		return nil
+	}
+	return c.store.Save(batch)
+}
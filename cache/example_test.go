@@ -0,0 +1,88 @@
+package cache_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/cache"
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/module"
+)
+
+type Player struct {
+	Gold int
+}
+
+// memStore stands for a database
+type memStore struct {
+	sync.Mutex
+	records map[string]Player
+}
+
+func (s *memStore) Load(key string) (*Player, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	p, ok := s.records[key]
+	return &p, ok, nil
+}
+
+func (s *memStore) Save(records map[string]*Player) error {
+	s.Lock()
+	defer s.Unlock()
+	for key, p := range records {
+		s.records[key] = *p
+	}
+	return nil
+}
+
+func Example() {
+	s := &module.Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		ChanRPCServer:      chanrpc.NewServer(10),
+	}
+	s.Init()
+	store := &memStore{records: map[string]Player{"alice": {Gold: 10}}}
+	players := cache.New[string, *Player](s, store, func(p *Player) *Player {
+		c := *p
+		return &c
+	}, time.Minute)
+
+	// handlers
+	s.RegisterChanRPC("Login", func(args []interface{}) {
+		players.Load(args[0].(string), func(p *Player, ok bool, err error) {
+			fmt.Println("login", p.Gold, ok, err)
+		})
+	})
+	s.RegisterChanRPC("Reward", func(args []interface{}) {
+		if p, ok := players.Get(args[0].(string)); ok {
+			p.Gold += 5
+			players.MarkDirty(args[0].(string))
+		}
+	})
+	s.RegisterChanRPC("Logout", func(args []interface{}) {
+		players.Evict(args[0].(string))
+	})
+
+	closeSig := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		s.Run(closeSig)
+		close(done)
+	}()
+	s.ChanRPCServer.Call0("Login", "alice")
+	time.Sleep(100 * time.Millisecond)
+	s.ChanRPCServer.Call0("Reward", "alice")
+	s.ChanRPCServer.Call0("Logout", "alice")
+
+	// OnDestroy
+	closeSig <- true
+	<-done
+	players.Close()
+	fmt.Println("saved", store.records["alice"].Gold)
+
+	// Output:
+	// login 10 true <nil>
+	// saved 15
+}
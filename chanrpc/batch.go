@@ -0,0 +1,55 @@
+package chanrpc
+
+import "context"
+
+// Call is a call of GoBatch
+type Call struct {
+	ID   interface{}
+	Args []interface{}
+}
+
+// GoBatch queues calls as one, the server executes them in order once
+// received. The calls of unregistered functions are ignored as with Go. A
+// batch counts as one call in the queue length
+// goroutine safe
+func (s *Server) GoBatch(calls []Call) {
+	s.GoBatchContext(context.Background(), calls)
+}
+
+// GoBatchContext is GoBatch continuing ctx, its priority is the priority of
+// the batch
+// goroutine safe
+func (s *Server) GoBatchContext(ctx context.Context, calls []Call) {
+	batch := make([]CallInfo, 0, len(calls))
+	for _, c := range calls {
+		f := s.functions[c.ID]
+		if f == nil {
+			continue
+		}
+		batch = append(batch, CallInfo{
+			id:   c.ID,
+			f:    f,
+			args: c.Args,
+			ctx:  joinContext(ctx, c.Args),
+		})
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	defer func() {
+		recover()
+	}()
+
+	p := priorityOf(ctx)
+	s.lane(p) <- &CallInfo{
+		priority: p,
+		batch:    batch,
+	}
+}
+
+// GoBatch is GoBatch of the server attached
+// goroutine safe
+func (c *Client) GoBatch(calls []Call) {
+	c.s.GoBatch(calls)
+}
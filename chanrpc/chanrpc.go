@@ -1,12 +1,18 @@
 package chanrpc
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
 )
 
 // one server per goroutine (goroutine not safe)
@@ -18,15 +24,42 @@ type Server struct {
 	// func(args []interface{})
 	// func(args []interface{}) interface{}
 	// func(args []interface{}) []interface{}
+	// func(args []interface{}, yield func(ret interface{}))
 	functions map[interface{}]interface{}
 	ChanCall  chan *CallInfo
+
+	// the calls of PriorityHigh and PriorityLow, nil unless SetQueueLen is
+	// called for the priority, the calls go to ChanCall then
+	ChanCallHigh chan *CallInfo
+	ChanCallLow  chan *CallInfo
+
+	// the goroutine calling Exec and whether it's executing, used to run
+	// synchronous calls made from inside a handler inline, see Attach
+	goid      atomic.Int64
+	executing atomic.Bool
+	// the context of the call executing
+	ctx context.Context
+
+	// the module of the server, reported with the panics of its functions
+	Name string
+	// calls taking longer are logged, conf.SlowHandlerThreshold if zero
+	SlowThreshold time.Duration
+	// function id -> stats
+	stats    map[interface{}]*funcStats
+	executed atomic.Pointer[execution]
 }
 
 type CallInfo struct {
+	id      interface{}
 	f       interface{}
 	args    []interface{}
 	chanRet chan *RetInfo
 	cb      interface{}
+	ctx     context.Context
+
+	priority Priority
+	// the calls of GoBatch
+	batch []CallInfo
 }
 
 type RetInfo struct {
@@ -35,10 +68,13 @@ type RetInfo struct {
 	// []interface{}
 	ret interface{}
 	err error
+	// a yielded result, the call isn't finished
+	more bool
 	// callback:
 	// func(err error)
 	// func(ret interface{}, err error)
 	// func(ret []interface{}, err error)
+	// func(ret interface{}, more bool, err error)
 	cb interface{}
 }
 
@@ -52,6 +88,7 @@ type Client struct {
 func NewServer(l int) *Server {
 	s := new(Server)
 	s.functions = make(map[interface{}]interface{})
+	s.stats = make(map[interface{}]*funcStats)
 	s.ChanCall = make(chan *CallInfo, l)
 	return s
 }
@@ -70,6 +107,7 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	case func([]interface{}):
 	case func([]interface{}) interface{}:
 	case func([]interface{}) []interface{}:
+	case func([]interface{}, func(interface{})):
 	default:
 		panic(fmt.Sprintf("function id %v: definition of function is invalid", id))
 	}
@@ -79,6 +117,7 @@ func (s *Server) Register(id interface{}, f interface{}) {
 	}
 
 	s.functions[id] = f
+	s.stats[id] = new(funcStats)
 }
 
 func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
@@ -97,20 +136,104 @@ func (s *Server) ret(ci *CallInfo, ri *RetInfo) (err error) {
 	return
 }
 
+// stream delivers the results of a streaming function in order, the ones
+// the caller's channel can't take yet are sent by another goroutine
+type stream struct {
+	mutex   sync.Mutex
+	queue   []*RetInfo
+	sending bool
+}
+
+// ret is s.ret if st is nil
+func (st *stream) ret(s *Server, ci *CallInfo, ri *RetInfo) error {
+	if st == nil || ci.chanRet == nil {
+		return s.ret(ci, ri)
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if !st.sending {
+		if sent, err := s.tryRet(ci, ri); sent {
+			return err
+		}
+		st.sending = true
+		go st.send(s, ci)
+	}
+	st.queue = append(st.queue, ri)
+	return nil
+}
+
+func (st *stream) send(s *Server, ci *CallInfo) {
+	for {
+		st.mutex.Lock()
+		if len(st.queue) == 0 {
+			st.sending = false
+			st.mutex.Unlock()
+			return
+		}
+		ri := st.queue[0]
+		st.queue[0] = nil
+		st.queue = st.queue[1:]
+		st.mutex.Unlock()
+
+		if err := s.ret(ci, ri); err != nil {
+			log.Error("%v", err)
+		}
+	}
+}
+
+// tryRet is ret without blocking, sent is false if the channel is full
+func (s *Server) tryRet(ci *CallInfo, ri *RetInfo) (sent bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			sent, err = true, r.(error)
+		}
+	}()
+
+	ri.cb = ci.cb
+	select {
+	case ci.chanRet <- ri:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
 func (s *Server) exec(ci *CallInfo) (err error) {
+	// the results of a streaming function, they follow the yielded ones
+	var st *stream
+
+	// the caller gave up before the call was executed
+	if ci.ctx != nil && ci.ctx.Err() != nil {
+		if f := s.stats[ci.id]; f != nil {
+			f.canceled.Add(1)
+		}
+		return s.ret(ci, &RetInfo{err: ci.ctx.Err()})
+	}
+
+	ctx, span := s.span(ci)
+	prev := s.ctx
+	s.ctx = ctx
+	defer func() {
+		s.ctx = prev
+		span.End()
+	}()
 	defer func() {
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				err = fmt.Errorf("%v: %s", r, buf[:l])
-			} else {
-				err = fmt.Errorf("%v", r)
+			util.Recovered(r, util.PanicContext{
+				Source: "chanrpc",
+				Module: s.Name,
+				ID:     ci.id,
+				Args:   ci.args,
+			})
+			if f := s.stats[ci.id]; f != nil {
+				f.panics.Add(1)
 			}
 
-			s.ret(ci, &RetInfo{err: fmt.Errorf("%v", r)})
+			st.ret(s, ci, &RetInfo{err: fmt.Errorf("%v", r)})
 		}
 	}()
+	defer s.end(s.begin(ci))
 
 	// execute
 	switch ci.f.(type) {
@@ -123,13 +246,38 @@ func (s *Server) exec(ci *CallInfo) (err error) {
 	case func([]interface{}) []interface{}:
 		ret := ci.f.(func([]interface{}) []interface{})(ci.args)
 		return s.ret(ci, &RetInfo{ret: ret})
+	case func([]interface{}, func(interface{})):
+		// the results are queued while the caller's channel is full, the
+		// handler doesn't wait for the caller, which may be the server itself
+		st = new(stream)
+		ci.f.(func([]interface{}, func(interface{})))(ci.args, func(ret interface{}) {
+			st.ret(s, ci, &RetInfo{ret: ret, more: true})
+		})
+		return st.ret(s, ci, &RetInfo{})
 	}
 
 	panic("bug")
 }
 
+// Exec executes the calls of a higher priority queued before ci first
 func (s *Server) Exec(ci *CallInfo) {
+	if s.goid.Load() == 0 {
+		s.goid.Store(goid())
+	}
+	s.execHigher(ci.priority)
+	if ci.batch != nil {
+		for i := range ci.batch {
+			s.execOne(&ci.batch[i])
+		}
+		return
+	}
+	s.execOne(ci)
+}
+
+func (s *Server) execOne(ci *CallInfo) {
+	s.executing.Store(true)
 	err := s.exec(ci)
+	s.executing.Store(false)
 	if err != nil {
 		log.Error("%v", err)
 	}
@@ -137,6 +285,13 @@ func (s *Server) Exec(ci *CallInfo) {
 
 // goroutine safe
 func (s *Server) Go(id interface{}, args ...interface{}) {
+	s.GoContext(contextOf(args), id, args...)
+}
+
+// GoContext is Go continuing ctx, e.g. Context of the server executing the
+// caller
+// goroutine safe
+func (s *Server) GoContext(ctx context.Context, id interface{}, args ...interface{}) {
 	f := s.functions[id]
 	if f == nil {
 		return
@@ -146,9 +301,13 @@ func (s *Server) Go(id interface{}, args ...interface{}) {
 		recover()
 	}()
 
-	s.ChanCall <- &CallInfo{
-		f:    f,
-		args: args,
+	p := priorityOf(ctx)
+	s.lane(p) <- &CallInfo{
+		id:       id,
+		f:        f,
+		args:     args,
+		ctx:      ctx,
+		priority: p,
 	}
 }
 
@@ -167,13 +326,82 @@ func (s *Server) CallN(id interface{}, args ...interface{}) ([]interface{}, erro
 	return s.Open(0).CallN(id, args...)
 }
 
+// goroutine safe
+func (s *Server) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
+	return s.Open(0).Call0Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
+	return s.Open(0).Call1Context(ctx, id, args...)
+}
+
+// goroutine safe
+func (s *Server) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
+	return s.Open(0).CallNContext(ctx, id, args...)
+}
+
+// CallInline executes the function registered for id in the calling
+// goroutine, bypassing ChanCall. ret is nil, interface{} or []interface{}
+// depending on the function. Use it only from the goroutine executing the
+// server, synchronous calls made there are inlined automatically
+func (s *Server) CallInline(id interface{}, args ...interface{}) (ret interface{}, err error) {
+	f := s.functions[id]
+	if f == nil {
+		return nil, fmt.Errorf("function id %v: function not registered", id)
+	}
+	if _, ok := f.(func([]interface{}, func(interface{}))); ok {
+		return nil, fmt.Errorf("function id %v: streaming function can't be called inline", id)
+	}
+	ri := s.inline(&CallInfo{id: id, f: f, args: args, ctx: s.ctx})
+	return ri.ret, ri.err
+}
+
+// Attach makes the calling goroutine the one executing the server, e.g. a
+// skeleton starting or restarting. Exec attaches the first goroutine calling
+// it
+func (s *Server) Attach() {
+	s.goid.Store(goid())
+}
+
+func (s *Server) reentrant() bool {
+	return s.executing.Load() && s.goid.Load() == goid()
+}
+
+// inline executes ci and returns its result instead of sending it
+func (s *Server) inline(ci *CallInfo) *RetInfo {
+	ch := make(chan *RetInfo, 1)
+	ci.chanRet = ch
+	ci.cb = nil
+	if err := s.exec(ci); err != nil {
+		log.Error("%v", err)
+	}
+	return <-ch
+}
+
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
 func (s *Server) Close() {
-	close(s.ChanCall)
+	for _, ch := range []chan *CallInfo{s.ChanCallHigh, s.ChanCall, s.ChanCallLow} {
+		if ch == nil {
+			continue
+		}
+		close(ch)
 
-	for ci := range s.ChanCall {
-		s.ret(ci, &RetInfo{
-			err: errors.New("chanrpc server closed"),
-		})
+		for ci := range ch {
+			s.ret(ci, &RetInfo{
+				err: errors.New("chanrpc server closed"),
+			})
+		}
 	}
 }
 
@@ -203,10 +431,14 @@ func (c *Client) call(ci *CallInfo, block bool) (err error) {
 	}()
 
 	if block {
-		c.s.ChanCall <- ci
+		select {
+		case c.s.lane(ci.priority) <- ci:
+		case <-ci.ctx.Done():
+			err = ci.ctx.Err()
+		}
 	} else {
 		select {
-		case c.s.ChanCall <- ci:
+		case c.s.lane(ci.priority) <- ci:
 		default:
 			err = errors.New("chanrpc channel full")
 		}
@@ -234,6 +466,8 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 		_, ok = f.(func([]interface{}) interface{})
 	case 2:
 		_, ok = f.(func([]interface{}) []interface{})
+	case 3:
+		_, ok = f.(func([]interface{}, func(interface{})))
 	default:
 		panic("bug")
 	}
@@ -244,64 +478,94 @@ func (c *Client) f(id interface{}, n int) (f interface{}, err error) {
 	return
 }
 
+// callSync executes the call inline when made from the goroutine executing
+// the server, it would deadlock otherwise
+func (c *Client) callSync(ctx context.Context, id interface{}, f interface{}, args []interface{}) (*RetInfo, error) {
+	ci := &CallInfo{
+		id:      id,
+		f:       f,
+		args:    args,
+		chanRet: c.chanSyncRet,
+		ctx:     joinContext(ctx, args),
+	}
+	ci.priority = priorityOf(ci.ctx)
+	if c.s.reentrant() {
+		return c.s.inline(ci), nil
+	}
+
+	// the result of an abandoned call goes to a channel nobody reads, the
+	// server doesn't block on it and the next call doesn't receive it
+	if ci.ctx.Done() != nil {
+		ci.chanRet = make(chan *RetInfo, 1)
+	}
+
+	err := c.call(ci, true)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case ri := <-ci.chanRet:
+		return ri, nil
+	case <-ci.ctx.Done():
+		return nil, ci.ctx.Err()
+	}
+}
+
 func (c *Client) Call0(id interface{}, args ...interface{}) error {
+	return c.Call0Context(context.Background(), id, args...)
+}
+
+func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
+	return c.Call1Context(context.Background(), id, args...)
+}
+
+func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+	return c.CallNContext(context.Background(), id, args...)
+}
+
+// Call0Context is Call0 giving up when ctx is done, the server doesn't
+// execute the call if ctx is done by then. The function reads ctx with
+// Server.Context
+func (c *Client) Call0Context(ctx context.Context, id interface{}, args ...interface{}) error {
 	f, err := c.f(id, 0)
 	if err != nil {
 		return err
 	}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+	ri, err := c.callSync(ctx, id, f, args)
 	if err != nil {
 		return err
 	}
-
-	ri := <-c.chanSyncRet
 	return ri.err
 }
 
-func (c *Client) Call1(id interface{}, args ...interface{}) (interface{}, error) {
+func (c *Client) Call1Context(ctx context.Context, id interface{}, args ...interface{}) (interface{}, error) {
 	f, err := c.f(id, 1)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+	ri, err := c.callSync(ctx, id, f, args)
 	if err != nil {
 		return nil, err
 	}
-
-	ri := <-c.chanSyncRet
 	return ri.ret, ri.err
 }
 
-func (c *Client) CallN(id interface{}, args ...interface{}) ([]interface{}, error) {
+func (c *Client) CallNContext(ctx context.Context, id interface{}, args ...interface{}) ([]interface{}, error) {
 	f, err := c.f(id, 2)
 	if err != nil {
 		return nil, err
 	}
 
-	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.chanSyncRet,
-	}, true)
+	ri, err := c.callSync(ctx, id, f, args)
 	if err != nil {
 		return nil, err
 	}
-
-	ri := <-c.chanSyncRet
 	return assert(ri.ret), ri.err
 }
 
-func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n int) {
+func (c *Client) asynCall(ctx context.Context, id interface{}, args []interface{}, cb interface{}, n int) {
 	f, err := c.f(id, n)
 	if err != nil {
 		c.ChanAsynRet <- &RetInfo{err: err, cb: cb}
@@ -309,10 +573,13 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 	}
 
 	err = c.call(&CallInfo{
-		f:       f,
-		args:    args,
-		chanRet: c.ChanAsynRet,
-		cb:      cb,
+		id:       id,
+		f:        f,
+		args:     args,
+		chanRet:  c.ChanAsynRet,
+		cb:       cb,
+		ctx:      ctx,
+		priority: priorityOf(ctx),
 	}, false)
 	if err != nil {
 		c.ChanAsynRet <- &RetInfo{err: err, cb: cb}
@@ -321,6 +588,14 @@ func (c *Client) asynCall(id interface{}, args []interface{}, cb interface{}, n
 }
 
 func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
+	c.AsynCallContext(context.Background(), id, _args...)
+}
+
+// AsynCallContext is AsynCall continuing ctx, e.g. Context of the server
+// executing the caller. The call isn't executed if ctx is done by then, the
+// callback gets ctx.Err() instead. The trace of the args is continued if ctx
+// isn't traced
+func (c *Client) AsynCallContext(ctx context.Context, id interface{}, _args ...interface{}) {
 	if len(_args) < 1 {
 		panic("callback function not found")
 	}
@@ -336,6 +611,10 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 		n = 1
 	case func([]interface{}, error):
 		n = 2
+	case func(interface{}, bool, error):
+		// streaming, called with more set for every yielded result and
+		// once more without it when the call finishes
+		n = 3
 	default:
 		panic("definition of callback function is invalid")
 	}
@@ -346,20 +625,14 @@ func (c *Client) AsynCall(id interface{}, _args ...interface{}) {
 		return
 	}
 
-	c.asynCall(id, args, cb, n)
+	c.asynCall(joinContext(ctx, args), id, args, cb, n)
 	c.pendingAsynCall++
 }
 
 func execCb(ri *RetInfo) {
 	defer func() {
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
-			}
+			util.Recovered(r, util.PanicContext{Source: "callback"})
 		}
 	}()
 
@@ -371,6 +644,8 @@ func execCb(ri *RetInfo) {
 		ri.cb.(func(interface{}, error))(ri.ret, ri.err)
 	case func([]interface{}, error):
 		ri.cb.(func([]interface{}, error))(assert(ri.ret), ri.err)
+	case func(interface{}, bool, error):
+		ri.cb.(func(interface{}, bool, error))(ri.ret, ri.more, ri.err)
 	default:
 		panic("bug")
 	}
@@ -378,7 +653,9 @@ func execCb(ri *RetInfo) {
 }
 
 func (c *Client) Cb(ri *RetInfo) {
-	c.pendingAsynCall--
+	if !ri.more {
+		c.pendingAsynCall--
+	}
 	execCb(ri)
 }
 
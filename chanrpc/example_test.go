@@ -1,8 +1,10 @@
 package chanrpc_test
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
 )
@@ -126,3 +128,297 @@ func Example() {
 	// 1 2 3
 	// 3
 }
+
+func ExampleServer_Call1() {
+	s := chanrpc.NewServer(10)
+
+	s.Register("double", func(args []interface{}) interface{} {
+		return args[0].(int) * 2
+	})
+	// a synchronous call to the same server from inside a handler runs
+	// inline instead of deadlocking
+	s.Register("quadruple", func(args []interface{}) interface{} {
+		r, err := s.Call1("double", args[0])
+		if err != nil {
+			return err
+		}
+		r, _ = s.CallInline("double", r)
+		return r
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+		close(done)
+	}()
+
+	fmt.Println(s.Call1("quadruple", 3))
+	s.Close()
+	<-done
+
+	// Output:
+	// 12 <nil>
+}
+
+func ExampleServer_Attach() {
+	s := chanrpc.NewServer(10)
+
+	s.Register("double", func(args []interface{}) interface{} {
+		return args[0].(int) * 2
+	})
+	s.Register("quadruple", func(args []interface{}) interface{} {
+		r, err := s.Call1Context(s.Context(), "double", args[0])
+		if err != nil {
+			return err
+		}
+		r, err = s.Call1("double", r)
+		if err != nil {
+			return err
+		}
+		return r
+	})
+
+	// the server is executed by a new goroutine, e.g. after a restart
+	for i := 0; i < 2; i++ {
+		done := make(chan struct{})
+		go func() {
+			s.Attach()
+			ci := <-s.ChanCall
+			s.Exec(ci)
+			close(done)
+		}()
+
+		fmt.Println(s.Call1("quadruple", i+1))
+		<-done
+	}
+
+	// Output:
+	// 4 <nil>
+	// 8 <nil>
+}
+
+func ExampleClient_AsynCall() {
+	s := chanrpc.NewServer(10)
+
+	// yields the mails one by one instead of building a slice
+	s.Register("mails", func(args []interface{}, yield func(interface{})) {
+		for i := 0; i < args[0].(int); i++ {
+			yield(fmt.Sprintf("mail %v", i))
+		}
+	})
+
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	c := chanrpc.NewClient(10)
+	c.Attach(s)
+	c.AsynCall("mails", 3, func(ret interface{}, more bool, err error) {
+		if more {
+			fmt.Println(ret)
+		} else {
+			fmt.Println("done", err)
+		}
+	})
+	for !c.Idle() {
+		c.Cb(<-c.ChanAsynRet)
+	}
+	s.Close()
+
+	// Output:
+	// mail 0
+	// mail 1
+	// mail 2
+	// done <nil>
+}
+
+func ExampleClient_AsynCall_self() {
+	s := chanrpc.NewServer(10)
+	s.Register("mails", func(args []interface{}, yield func(interface{})) {
+		for i := 0; i < args[0].(int); i++ {
+			yield(fmt.Sprintf("mail %v", i))
+		}
+	})
+
+	// the server calls itself and yields more results than the client
+	// channel takes, e.g. a skeleton
+	c := s.Open(2)
+	c.AsynCall("mails", 5, func(ret interface{}, more bool, err error) {
+		if more {
+			fmt.Println(ret)
+		} else {
+			fmt.Println("done", err)
+		}
+	})
+	for !c.Idle() {
+		select {
+		case ci := <-s.ChanCall:
+			s.Exec(ci)
+		case ri := <-c.ChanAsynRet:
+			c.Cb(ri)
+		}
+	}
+	s.Close()
+
+	// Output:
+	// mail 0
+	// mail 1
+	// mail 2
+	// mail 3
+	// mail 4
+	// done <nil>
+}
+
+func ExampleClient_Call1Context() {
+	s := chanrpc.NewServer(10)
+
+	s.Register("load", func(args []interface{}) interface{} {
+		fmt.Println("load", args[0])
+		return args[0]
+	})
+
+	// the server is busy, the caller gives up
+	c := s.Open(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fmt.Println(c.Call1Context(ctx, "load", 1))
+
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	// the stale call isn't executed
+	fmt.Println(c.Call1Context(context.Background(), "load", 2))
+	s.Close()
+
+	// Output:
+	// <nil> context deadline exceeded
+	// load 2
+	// 2 <nil>
+}
+
+func ExampleRegister1() {
+	type Add struct{ A, B int }
+
+	s := chanrpc.NewServer(10)
+	chanrpc.Register1(s, "add", func(a Add) int {
+		return a.A + a.B
+	})
+
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+	}()
+
+	c := s.Open(10)
+	fmt.Println(chanrpc.Call1[Add, int](c, "add", Add{1, 2}))
+
+	// an untyped call with an argument of another type
+	_, err := c.Call1("add", 1)
+	fmt.Println(err)
+
+	chanrpc.AsynCall1(c, "add", Add{3, 4}, func(r int, err error) {
+		fmt.Println(r, err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+	s.Close()
+
+	// Output:
+	// 3 <nil>
+	// function id add: argument type int, want chanrpc_test.Add
+	// 7 <nil>
+}
+
+func ExampleServer_SetQueueLen() {
+	s := chanrpc.NewServer(10)
+	s.SetQueueLen(chanrpc.PriorityHigh, 10)
+	s.SetQueueLen(chanrpc.PriorityLow, 1)
+
+	s.Register("log", func(args []interface{}) {
+		fmt.Println("log", args[0])
+	})
+	s.Register("hit", func(args []interface{}) {
+		fmt.Println("hit", args[0])
+	})
+
+	low := chanrpc.WithPriority(context.Background(), chanrpc.PriorityLow)
+	high := chanrpc.WithPriority(context.Background(), chanrpc.PriorityHigh)
+	c := s.Open(10)
+	c.AsynCallContext(low, "log", 1, func(err error) {})
+	// the low queue is full
+	c.AsynCallContext(low, "log", 2, func(err error) {
+		fmt.Println(err)
+	})
+	c.Cb(<-c.ChanAsynRet)
+	s.GoContext(high, "hit", 3)
+
+	// the high call queued meanwhile goes first
+	s.Exec(<-s.ChanCallLow)
+
+	// Output:
+	// chanrpc channel full
+	// hit 3
+	// log 1
+}
+
+func ExampleServer_Stats() {
+	s := chanrpc.NewServer(10)
+	// logs the calls taking longer
+	s.SlowThreshold = 100 * time.Millisecond
+
+	s.Register("f", func(args []interface{}) {
+		if args[0] == nil {
+			panic("nil")
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for ci := range s.ChanCall {
+			s.Exec(ci)
+		}
+		close(done)
+	}()
+
+	s.Call0("f", 1)
+	s.Call0("f", nil)
+	s.Close()
+	<-done
+
+	f := s.Stats().Funcs["f"]
+	fmt.Println(f.Calls, f.Panics, f.Max >= f.Mean())
+
+	// Output:
+	// 2 1 true
+}
+
+func ExampleServer_GoBatch() {
+	s := chanrpc.NewServer(10)
+
+	s.Register("move", func(args []interface{}) {
+		fmt.Println("move", args[0], args[1])
+	})
+
+	// the updates of a tick, sent with one channel operation
+	var calls []chanrpc.Call
+	for id := 1; id <= 3; id++ {
+		calls = append(calls, chanrpc.Call{ID: "move", Args: []interface{}{id, id * 10}})
+	}
+	s.GoBatch(calls)
+
+	fmt.Println(len(s.ChanCall))
+	s.Exec(<-s.ChanCall)
+
+	// Output:
+	// 1
+	// move 1 10
+	// move 2 20
+	// move 3 30
+}
@@ -0,0 +1,107 @@
+package chanrpc
+
+import "context"
+
+// Priority of a call, a server executes the queued calls of a higher
+// priority first
+type Priority int
+
+const (
+	// e.g. combat
+	PriorityHigh Priority = iota
+	// the default
+	PriorityNormal
+	// e.g. analytics
+	PriorityLow
+
+	numPriority = 3
+)
+
+type priorityKey struct{}
+
+// WithPriority returns ctx calling with p, pass it to GoContext, the
+// Context calls or AsynCallContext. The calls made by the function called
+// continue with Server.Context, so with the same priority
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	if ctx == nil {
+		return PriorityNormal
+	}
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && p < numPriority {
+		return p
+	}
+	return PriorityNormal
+}
+
+// SetQueueLen gives the calls of p a queue of l calls instead of sharing
+// ChanCall, ChanCall is replaced for PriorityNormal. A full queue blocks Go
+// and the synchronous calls and fails the asynchronous calls of its priority
+// only. The loop executing the server must receive from ChanCallHigh and
+// ChanCallLow as well, see Skeleton
+// you must call the function before calling Open and Go
+func (s *Server) SetQueueLen(p Priority, l int) {
+	ch := make(chan *CallInfo, l)
+	switch p {
+	case PriorityHigh:
+		s.ChanCallHigh = ch
+	case PriorityLow:
+		s.ChanCallLow = ch
+	default:
+		s.ChanCall = ch
+	}
+}
+
+// lane is the queue of the calls of p
+func (s *Server) lane(p Priority) chan *CallInfo {
+	switch {
+	case p == PriorityHigh && s.ChanCallHigh != nil:
+		return s.ChanCallHigh
+	case p == PriorityLow && s.ChanCallLow != nil:
+		return s.ChanCallLow
+	}
+	return s.ChanCall
+}
+
+// execHigher executes the calls of a priority higher than p queued so far
+func (s *Server) execHigher(p Priority) {
+	if p == PriorityHigh {
+		return
+	}
+
+	own := s.lane(p)
+	for _, ch := range [...]chan *CallInfo{s.ChanCallHigh, s.ChanCall} {
+		if ch == own {
+			return
+		}
+		if ch == nil {
+			continue
+		}
+		for n := len(ch); n > 0; n-- {
+			select {
+			case ci, ok := <-ch:
+				if !ok {
+					return
+				}
+				s.execOne(ci)
+			default:
+				n = 0
+			}
+		}
+	}
+}
+
+// Len returns the number of calls queued
+// goroutine safe
+func (s *Server) Len() int {
+	n := len(s.ChanCall)
+	if s.ChanCallHigh != nil {
+		n += len(s.ChanCallHigh)
+	}
+	if s.ChanCallLow != nil {
+		n += len(s.ChanCallLow)
+	}
+	return n
+}
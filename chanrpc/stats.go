@@ -0,0 +1,110 @@
+package chanrpc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/util"
+)
+
+// ServerStats is a snapshot of a server, e.g. to find the function backing
+// up a skeleton
+type ServerStats struct {
+	// calls queued
+	Len int
+	// the id of the function executing and since when, nil if idle
+	Executing      interface{}
+	ExecutingSince time.Time
+	// function id -> stats
+	Funcs map[interface{}]FuncStats
+}
+
+// FuncStats counts the calls of a function since the server was created
+type FuncStats struct {
+	Calls uint64
+	// calls which panicked
+	Panics uint64
+	// calls not executed, the caller gave up before
+	Canceled uint64
+	// execution time
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean execution time
+func (f FuncStats) Mean() time.Duration {
+	if f.Calls == 0 {
+		return 0
+	}
+	return f.Total / time.Duration(f.Calls)
+}
+
+type funcStats struct {
+	calls    atomic.Uint64
+	panics   atomic.Uint64
+	canceled atomic.Uint64
+	total    atomic.Int64
+	max      atomic.Int64
+}
+
+func (f *funcStats) add(d time.Duration) {
+	f.calls.Add(1)
+	f.total.Add(int64(d))
+	for {
+		m := f.max.Load()
+		if int64(d) <= m || f.max.CompareAndSwap(m, int64(d)) {
+			return
+		}
+	}
+}
+
+// execution is the call executing, prev the call it was inlined in
+type execution struct {
+	id    interface{}
+	begin time.Time
+	prev  *execution
+}
+
+func (s *Server) begin(ci *CallInfo) *execution {
+	e := &execution{ci.id, time.Now(), s.executed.Load()}
+	s.executed.Store(e)
+	return e
+}
+
+func (s *Server) end(e *execution) {
+	s.executed.Store(e.prev)
+	d := time.Since(e.begin)
+	if f := s.stats[e.id]; f != nil {
+		f.add(d)
+	}
+
+	threshold := s.SlowThreshold
+	if threshold == 0 {
+		threshold = conf.SlowHandlerThreshold
+	}
+	util.CheckSlowThreshold(e.id, d, threshold)
+}
+
+// Stats returns the snapshot of the server
+// goroutine safe
+func (s *Server) Stats() ServerStats {
+	st := ServerStats{
+		Len:   s.Len(),
+		Funcs: make(map[interface{}]FuncStats, len(s.stats)),
+	}
+	if e := s.executed.Load(); e != nil {
+		st.Executing = e.id
+		st.ExecutingSince = e.begin
+	}
+	for id, f := range s.stats {
+		st.Funcs[id] = FuncStats{
+			Calls:    f.calls.Load(),
+			Panics:   f.panics.Load(),
+			Canceled: f.canceled.Load(),
+			Total:    time.Duration(f.total.Load()),
+			Max:      time.Duration(f.max.Load()),
+		}
+	}
+	return st
+}
@@ -0,0 +1,62 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/czx-lab/leaf/chanrpc")
+
+// contexter is implemented by the args carrying the context of a call, e.g.
+// the agent of a gate routing a traced message
+type contexter interface {
+	Context() context.Context
+}
+
+func contextOf(args []interface{}) context.Context {
+	for _, arg := range args {
+		if c, ok := arg.(contexter); ok {
+			return c.Context()
+		}
+	}
+	return nil
+}
+
+// joinContext is ctx carrying the span of the args if it isn't traced
+func joinContext(ctx context.Context, args []interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	if actx := contextOf(args); actx != nil {
+		if span := trace.SpanFromContext(actx); span.SpanContext().IsValid() {
+			return trace.ContextWithSpan(ctx, span)
+		}
+	}
+	return ctx
+}
+
+// Context returns the context of the call executing, with the deadline and
+// cancellation of the caller. A function passes it on with GoContext or
+// AsynCallContext so its calls join the trace, and to the I/O it does
+// goroutine not safe
+func (s *Server) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// span starts the span of ci if its caller is traced, it ends when the
+// function returns
+func (s *Server) span(ci *CallInfo) (context.Context, trace.Span) {
+	if ci.ctx == nil || !trace.SpanContextFromContext(ci.ctx).IsValid() {
+		return ci.ctx, trace.SpanFromContext(context.Background())
+	}
+	return tracer.Start(ci.ctx, fmt.Sprintf("chanrpc %v", ci.id))
+}
@@ -0,0 +1,84 @@
+package chanrpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// The typed functions wrap the untyped API, a function takes one argument of
+// type A (a struct for several values) and returns R. Calls made with the
+// untyped API still work, an argument of another type is a call error
+
+// arg returns the argument of a typed function
+func arg[A any](id interface{}, args []interface{}) A {
+	if len(args) != 1 {
+		panic(fmt.Errorf("function id %v: %v arguments, want 1", id, len(args)))
+	}
+	a, ok := args[0].(A)
+	if !ok && args[0] != nil {
+		panic(fmt.Errorf("function id %v: argument type %T, want %T", id, args[0], a))
+	}
+	return a
+}
+
+// ret converts the result of a typed function
+func ret[R any](id interface{}, v interface{}, err error) (R, error) {
+	r, ok := v.(R)
+	if !ok && v != nil && err == nil {
+		err = fmt.Errorf("function id %v: return type %T, want %T", id, v, r)
+	}
+	return r, err
+}
+
+// you must call the function before calling Open and Go
+func Register0[A any](s *Server, id interface{}, f func(A)) {
+	s.Register(id, func(args []interface{}) {
+		f(arg[A](id, args))
+	})
+}
+
+// you must call the function before calling Open and Go
+func Register1[A, R any](s *Server, id interface{}, f func(A) R) {
+	s.Register(id, func(args []interface{}) interface{} {
+		return f(arg[A](id, args))
+	})
+}
+
+// goroutine safe
+func Go[A any](s *Server, id interface{}, a A) {
+	s.Go(id, a)
+}
+
+func Call0[A any](c *Client, id interface{}, a A) error {
+	return c.Call0(id, a)
+}
+
+func Call1[A, R any](c *Client, id interface{}, a A) (R, error) {
+	v, err := c.Call1(id, a)
+	return ret[R](id, v, err)
+}
+
+func Call0Context[A any](ctx context.Context, c *Client, id interface{}, a A) error {
+	return c.Call0Context(ctx, id, a)
+}
+
+func Call1Context[A, R any](ctx context.Context, c *Client, id interface{}, a A) (R, error) {
+	v, err := c.Call1Context(ctx, id, a)
+	return ret[R](id, v, err)
+}
+
+func AsynCall0[A any](c *Client, id interface{}, a A, cb func(err error)) {
+	c.AsynCall(id, a, cb)
+}
+
+func AsynCall1[A, R any](c *Client, id interface{}, a A, cb func(r R, err error)) {
+	c.AsynCall(id, a, Callback1(id, cb))
+}
+
+// Callback1 is the untyped callback of a typed function, e.g. for
+// Skeleton.AsynCall
+func Callback1[R any](id interface{}, cb func(r R, err error)) func(interface{}, error) {
+	return func(v interface{}, err error) {
+		cb(ret[R](id, v, err))
+	}
+}
@@ -0,0 +1,273 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/room"
+)
+
+var (
+	ErrMuted        = errors.New("chat: player muted")
+	ErrRateLimited  = errors.New("chat: rate limited")
+	ErrFiltered     = errors.New("chat: content filtered")
+	ErrNotInChannel = errors.New("chat: not in channel")
+	ErrOffline      = errors.New("chat: player offline")
+)
+
+type Message struct {
+	// empty for private messages
+	Channel string
+	From    interface{}
+	// receiver of a private message
+	To   interface{}
+	Text string
+	Time time.Time
+}
+
+// at most Count messages per Interval
+type Limit struct {
+	Count    int
+	Interval time.Duration
+}
+
+// Chat is a module delivering messages to channels (e.g. "world",
+// "guild:42") and players. Channels are rooms of a room.Manager.
+type Chat struct {
+	// rate limits by channel name, "" for private messages
+	Limits       map[string]Limit
+	DefaultLimit Limit
+	// returns the text to deliver, ok is false to reject the message
+	Filter func(m *Message) (text string, ok bool)
+	// wraps a message into the one written to agents
+	NewMsg func(m *Message) interface{}
+	// called in the chat goroutine when a message is rejected
+	OnReject func(m *Message, err error)
+	// called in the chat goroutine for every message sent on this node, to
+	// forward it to other nodes which pass it to Deliver
+	Relay func(m *Message)
+
+	skeleton *module.Skeleton
+	rooms    *room.Manager
+	players  map[interface{}]*player
+}
+
+type player struct {
+	agent    gate.Agent
+	channels map[string]bool
+	muted    time.Time
+	sent     map[string][]time.Time
+}
+
+type channelHandler struct{}
+
+func (channelHandler) OnCreate(*room.Room)            {}
+func (channelHandler) OnJoin(*room.Room, gate.Agent)  {}
+func (channelHandler) OnLeave(*room.Room, gate.Agent) {}
+func (channelHandler) OnDestroy(*room.Room)           {}
+
+func (c *Chat) OnInit() {
+	c.rooms = &room.Manager{EmptyTimeout: -1}
+	c.players = make(map[interface{}]*player)
+	c.skeleton = &module.Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		AsynCallLen:        10,
+		ChanRPCServer:      chanrpc.NewServer(1000),
+	}
+	c.skeleton.Init()
+	c.skeleton.RegisterChanRPC("exec", func(args []interface{}) {
+		args[0].(func())()
+	})
+}
+
+func (c *Chat) Run(closeSig chan bool) {
+	c.skeleton.Run(closeSig)
+}
+
+func (c *Chat) OnDestroy() {
+	c.rooms.Close()
+}
+
+func (c *Chat) exec(f func()) {
+	c.skeleton.ChanRPCServer.Go("exec", f)
+}
+
+// goroutine safe
+func (c *Chat) Login(playerID interface{}, a gate.Agent) {
+	c.exec(func() {
+		c.logout(playerID)
+		c.players[playerID] = &player{
+			agent:    a,
+			channels: make(map[string]bool),
+			sent:     make(map[string][]time.Time),
+		}
+	})
+}
+
+// goroutine safe
+func (c *Chat) Logout(playerID interface{}) {
+	c.exec(func() {
+		c.logout(playerID)
+	})
+}
+
+func (c *Chat) logout(playerID interface{}) {
+	p, ok := c.players[playerID]
+	if !ok {
+		return
+	}
+	for channel := range p.channels {
+		c.leave(p, channel)
+	}
+	delete(c.players, playerID)
+}
+
+// goroutine safe
+func (c *Chat) Join(playerID interface{}, channel string) {
+	c.exec(func() {
+		p, ok := c.players[playerID]
+		if !ok || p.channels[channel] {
+			return
+		}
+		r := c.rooms.Get(channel)
+		if r == nil {
+			var err error
+			r, err = c.rooms.Create(channel, channelHandler{})
+			if err != nil {
+				return
+			}
+		}
+		p.channels[channel] = true
+		r.Join(p.agent)
+	})
+}
+
+// goroutine safe
+func (c *Chat) Leave(playerID interface{}, channel string) {
+	c.exec(func() {
+		if p, ok := c.players[playerID]; ok {
+			c.leave(p, channel)
+		}
+	})
+}
+
+func (c *Chat) leave(p *player, channel string) {
+	if !p.channels[channel] {
+		return
+	}
+	delete(p.channels, channel)
+	if r := c.rooms.Get(channel); r != nil {
+		r.Leave(p.agent)
+	}
+}
+
+// d <= 0 unmutes the player
+// goroutine safe
+func (c *Chat) Mute(playerID interface{}, d time.Duration) {
+	c.exec(func() {
+		if p, ok := c.players[playerID]; ok {
+			p.muted = time.Now().Add(d)
+		}
+	})
+}
+
+// goroutine safe
+func (c *Chat) Send(m *Message) {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	c.exec(func() {
+		if err := c.send(m); err != nil && c.OnReject != nil {
+			c.OnReject(m, err)
+		}
+	})
+}
+
+// Deliver delivers a message relayed from another node
+// goroutine safe
+func (c *Chat) Deliver(m *Message) {
+	c.exec(func() {
+		c.deliver(m)
+	})
+}
+
+func (c *Chat) send(m *Message) error {
+	p, ok := c.players[m.From]
+	if !ok {
+		return ErrOffline
+	}
+	if time.Now().Before(p.muted) {
+		return ErrMuted
+	}
+	if m.To == nil && !p.channels[m.Channel] {
+		return ErrNotInChannel
+	}
+	if !c.allow(p, m.Channel) {
+		return ErrRateLimited
+	}
+	if c.Filter != nil {
+		text, ok := c.Filter(m)
+		if !ok {
+			return ErrFiltered
+		}
+		m.Text = text
+	}
+
+	if !c.deliver(m) && c.Relay == nil {
+		return ErrOffline
+	}
+	if c.Relay != nil {
+		c.Relay(m)
+	}
+	return nil
+}
+
+func (c *Chat) allow(p *player, channel string) bool {
+	limit, ok := c.Limits[channel]
+	if !ok {
+		limit = c.DefaultLimit
+	}
+	if limit.Count <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	sent := p.sent[channel]
+	for len(sent) > 0 && now.Sub(sent[0]) >= limit.Interval {
+		sent = sent[1:]
+	}
+	if len(sent) >= limit.Count {
+		p.sent[channel] = sent
+		return false
+	}
+	p.sent[channel] = append(sent, now)
+	return true
+}
+
+// returns false if a private message has no local receiver
+func (c *Chat) deliver(m *Message) bool {
+	msg := interface{}(m)
+	if c.NewMsg != nil {
+		msg = c.NewMsg(m)
+	}
+
+	if m.To != nil {
+		p, ok := c.players[m.To]
+		if !ok {
+			return false
+		}
+		p.agent.WriteMsg(msg)
+		return true
+	}
+
+	if r := c.rooms.Get(m.Channel); r != nil {
+		r.Exec(func() {
+			r.Broadcast(msg)
+		})
+	}
+	return true
+}
@@ -2,16 +2,14 @@ package cluster
 
 import (
 	"math"
-	"time"
+	"sync"
 
 	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
 	"github.com/czx-lab/leaf/network"
 )
 
-var (
-	server  *network.TCPServer
-	clients []*network.TCPClient
-)
+var server *network.TCPServer
 
 func Init() {
 	if conf.ListenAddr != "" {
@@ -26,41 +24,129 @@ func Init() {
 		server.Start()
 	}
 
+	if registry != nil {
+		initDiscovery()
+		return
+	}
+	// the peers are named by their addresses
+	mutexNodes.Lock()
+	peers = make(map[string]*peer)
 	for _, addr := range conf.ConnAddrs {
-		client := new(network.TCPClient)
-		client.Addr = addr
-		client.ConnNum = 1
-		client.ConnectInterval = 3 * time.Second
-		client.PendingWriteNum = conf.PendingWriteNum
-		client.LenMsgLen = 4
-		client.MaxMsgLen = math.MaxUint32
-		client.NewAgent = newAgent
-
-		client.Start()
-		clients = append(clients, client)
+		peers[addr] = connect(addr, false)
 	}
+	mutexNodes.Unlock()
 }
 
 func Destroy() {
+	if registry != nil {
+		destroyDiscovery()
+	}
 	if server != nil {
 		server.Close()
 	}
 
-	for _, client := range clients {
-		client.Close()
+	mutexNodes.Lock()
+	closing := peers
+	peers = nil
+	mutexNodes.Unlock()
+	for _, p := range closing {
+		p.client.Close()
 	}
 }
 
+// Agent is a connection to a peer, the calls are written to the connections
+// made to the peers and the replies to the connections accepted
 type Agent struct {
 	conn *network.TCPConn
+
+	mutexPending sync.Mutex
+	// nil once closed
+	pending map[uint64]chan *envelope
+	onClose func()
 }
 
 func newAgent(conn *network.TCPConn) network.Agent {
 	a := new(Agent)
 	a.conn = conn
+	a.pending = make(map[uint64]chan *envelope)
 	return a
 }
 
-func (a *Agent) Run() {}
+func (a *Agent) Run() {
+	for {
+		data, err := a.conn.ReadMsg()
+		if err != nil {
+			log.Debug("read message: %v", err)
+			break
+		}
+
+		e := new(envelope)
+		if err := e.unmarshal(data); err != nil {
+			log.Error("cluster: %v", err)
+			break
+		}
+		switch e.kind {
+		case kindReply:
+			a.mutexPending.Lock()
+			ch := a.pending[e.id]
+			a.mutexPending.Unlock()
+			if ch != nil {
+				ch <- e
+			}
+		case kindGo:
+			// in order
+			a.serve(e)
+		case kindRaw:
+			if f := rawHandlers[e.fn]; f != nil {
+				f(a, e.payload)
+			} else {
+				log.Error("cluster: %v not handled", e.fn)
+			}
+		case kindPublish:
+			deliver(e.fn, e.typ, e.payload)
+		default:
+			go a.serve(e)
+		}
+	}
+}
+
+// OnClose fails the calls waiting for a reply
+func (a *Agent) OnClose() {
+	if a.onClose != nil {
+		a.onClose()
+	}
+	for _, f := range closeHandlers {
+		f(a)
+	}
 
-func (a *Agent) OnClose() {}
+	a.mutexPending.Lock()
+	defer a.mutexPending.Unlock()
+	for _, ch := range a.pending {
+		close(ch)
+	}
+	a.pending = nil
+}
+
+// await returns the channel of the reply of the call id, closed if the
+// connection is lost
+func (a *Agent) await(id uint64) chan *envelope {
+	ch := make(chan *envelope, 1)
+	a.mutexPending.Lock()
+	defer a.mutexPending.Unlock()
+	if a.pending == nil {
+		close(ch)
+	} else {
+		a.pending[id] = ch
+	}
+	return ch
+}
+
+func (a *Agent) forget(id uint64) {
+	a.mutexPending.Lock()
+	defer a.mutexPending.Unlock()
+	delete(a.pending, id)
+}
+
+func (a *Agent) write(e *envelope) error {
+	return a.conn.WriteMsg(e.marshal())
+}
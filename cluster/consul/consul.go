@@ -0,0 +1,127 @@
+// Package consul keeps the members of the cluster in consul, see
+// cluster.SetRegistry
+package consul
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/czx-lab/leaf/cluster"
+	"github.com/czx-lab/leaf/log"
+	"github.com/hashicorp/consul/api"
+)
+
+// Registry registers the nodes as instances of Service with a TTL check,
+// the check passes while the process runs. Consul deregisters an instance
+// critical for a minute
+type Registry struct {
+	Client *api.Client
+	// "leaf" if empty
+	Service string
+
+	id     string
+	cancel context.CancelFunc
+}
+
+func (r *Registry) service() string {
+	if r.Service == "" {
+		return "leaf"
+	}
+	return r.Service
+}
+
+func (r *Registry) Register(node cluster.Node, ttl time.Duration) error {
+	checkID := "service:" + node.Name
+	err := r.Client.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:   node.Name,
+		Name: r.service(),
+		Tags: []string{node.Role},
+		Meta: map[string]string{"role": node.Role, "addr": node.Addr},
+		Check: &api.AgentServiceCheck{
+			CheckID:                        checkID,
+			TTL:                            ttl.String(),
+			DeregisterCriticalServiceAfter: time.Minute.String(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Agent().UpdateTTL(checkID, "", api.HealthPassing); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Client.Agent().UpdateTTL(checkID, "", api.HealthPassing); err != nil {
+					log.Error("consul: check of node %v: %v", node.Name, err)
+				}
+			}
+		}
+	}()
+
+	r.id = node.Name
+	r.cancel = cancel
+	return nil
+}
+
+func (r *Registry) Deregister() error {
+	if r.cancel == nil {
+		return errors.New("not registered")
+	}
+	r.cancel()
+	return r.Client.Agent().ServiceDeregister(r.id)
+}
+
+func (r *Registry) Watch(stop <-chan struct{}, f func(nodes []cluster.Node)) error {
+	entries, meta, err := r.Client.Health().Service(r.service(), "", true, nil)
+	if err != nil {
+		return err
+	}
+	f(nodes(entries))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		index := meta.LastIndex
+		for ctx.Err() == nil {
+			q := (&api.QueryOptions{WaitIndex: index}).WithContext(ctx)
+			entries, meta, err := r.Client.Health().Service(r.service(), "", true, q)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error("consul: watch nodes: %v", err)
+					time.Sleep(time.Second)
+				}
+				continue
+			}
+			if meta.LastIndex == index {
+				continue
+			}
+			index = meta.LastIndex
+			f(nodes(entries))
+		}
+	}()
+	return nil
+}
+
+func nodes(entries []*api.ServiceEntry) []cluster.Node {
+	ns := make([]cluster.Node, 0, len(entries))
+	for _, e := range entries {
+		ns = append(ns, cluster.Node{
+			Name: e.Service.ID,
+			Role: e.Service.Meta["role"],
+			Addr: e.Service.Meta["addr"],
+		})
+	}
+	return ns
+}
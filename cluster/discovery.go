@@ -0,0 +1,201 @@
+package cluster
+
+import (
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+)
+
+// Node is a server process of the cluster
+type Node struct {
+	Name string
+	// e.g. "gate" or "game"
+	Role string
+	// the address the peers connect to
+	Addr string
+}
+
+// Registry keeps the members of the cluster, e.g. etcd.Registry or
+// consul.Registry
+type Registry interface {
+	// Register announces node, it's dropped ttl after the process is gone
+	Register(node Node, ttl time.Duration) error
+	Deregister() error
+	// Watch calls f with the members at once and every time they change,
+	// until stop is closed
+	Watch(stop <-chan struct{}, f func(nodes []Node)) error
+}
+
+var (
+	registry  Registry
+	self      Node
+	stopWatch chan struct{}
+
+	mutexNodes sync.Mutex
+	nodes      map[string]Node
+	peers      map[string]*peer
+)
+
+// peer is the connection made to a node
+type peer struct {
+	client *network.TCPClient
+	agent  atomic.Pointer[Agent]
+}
+
+// peerAgent returns the agent connected to node, nil if not connected
+func peerAgent(node string) *Agent {
+	mutexNodes.Lock()
+	p := peers[node]
+	mutexNodes.Unlock()
+	if p == nil {
+		return nil
+	}
+	return p.agent.Load()
+}
+
+// SetRegistry makes the node a member of the cluster of registry, it
+// connects to the other members instead of conf.ConnAddrs
+// you must call the function before calling leaf.Run
+func SetRegistry(r Registry) {
+	registry = r
+}
+
+// Self is the node of the process
+func Self() Node {
+	return self
+}
+
+// Nodes returns the members of the cluster sorted by name
+// goroutine safe
+func Nodes() []Node {
+	mutexNodes.Lock()
+	defer mutexNodes.Unlock()
+
+	ns := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(i, j int) bool {
+		return ns[i].Name < ns[j].Name
+	})
+	return ns
+}
+
+// NodesByRole returns the members of the role sorted by name
+// goroutine safe
+func NodesByRole(role string) []Node {
+	var ns []Node
+	for _, n := range Nodes() {
+		if n.Role == role {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+func initDiscovery() {
+	self.Name = conf.NodeName
+	if self.Name == "" {
+		hostname, _ := os.Hostname()
+		self.Name = hostname + "/" + conf.ListenAddr
+	}
+	self.Role = conf.NodeRole
+	self.Addr = conf.AdvertiseAddr
+	if self.Addr == "" {
+		self.Addr = conf.ListenAddr
+	}
+
+	ttl := conf.NodeTTL
+	if ttl <= 0 {
+		ttl = 10 * time.Second
+		log.Release("invalid NodeTTL, reset to %v", ttl)
+	}
+	if err := registry.Register(self, ttl); err != nil {
+		log.Fatal("register node %v: %v", self.Name, err)
+	}
+
+	stopWatch = make(chan struct{})
+	if err := registry.Watch(stopWatch, updateNodes); err != nil {
+		log.Fatal("watch nodes: %v", err)
+	}
+}
+
+func destroyDiscovery() {
+	close(stopWatch)
+	if err := registry.Deregister(); err != nil {
+		log.Error("deregister node %v: %v", self.Name, err)
+	}
+}
+
+// updateNodes connects to the nodes joined and disconnects from the nodes
+// left or moved
+func updateNodes(members []Node) {
+	mutexNodes.Lock()
+	defer mutexNodes.Unlock()
+	select {
+	case <-stopWatch:
+		return
+	default:
+	}
+
+	prev := nodes
+	nodes = make(map[string]Node, len(members))
+	for _, n := range members {
+		nodes[n.Name] = n
+	}
+	if peers == nil {
+		peers = make(map[string]*peer)
+	}
+
+	for name, n := range prev {
+		if cur, ok := nodes[name]; ok && cur == n {
+			continue
+		}
+		if p := peers[name]; p != nil {
+			// may wait for a dial
+			go p.client.Close()
+			delete(peers, name)
+		}
+		log.Release("node %v left", name)
+	}
+	for name, n := range nodes {
+		if old, ok := prev[name]; ok && old == n {
+			continue
+		}
+		log.Release("node %v (%v) joined at %v", name, n.Role, n.Addr)
+		if name != self.Name {
+			peers[name] = connect(n.Addr, true)
+		}
+	}
+}
+
+// connect reconnects until closed if reconnect, a peer may restart
+func connect(addr string, reconnect bool) *peer {
+	p := new(peer)
+	p.client = new(network.TCPClient)
+	p.client.Addr = addr
+	p.client.ConnNum = 1
+	p.client.ConnectInterval = 3 * time.Second
+	p.client.PendingWriteNum = conf.PendingWriteNum
+	p.client.LenMsgLen = 4
+	p.client.MaxMsgLen = math.MaxUint32
+	p.client.AutoReconnect = reconnect
+	p.client.NewAgent = func(conn *network.TCPConn) network.Agent {
+		a := newAgent(conn).(*Agent)
+		p.agent.Store(a)
+		a.onClose = func() {
+			p.agent.CompareAndSwap(a, nil)
+		}
+		return a
+	}
+
+	p.client.Start()
+	return p
+}
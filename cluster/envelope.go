@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// envelope is a frame between the nodes, encoded as the protobuf message
+//
+//	message Envelope {
+//		uint64 id = 1;       // correlates a reply with its call
+//		uint32 kind = 2;     // call, go, reply, raw or publish
+//		string fn = 3;       // e.g. "game.Join", the topic of a publish
+//		string type = 4;     // full name of the message in payload
+//		bytes payload = 5;
+//		string error = 6;    // of the reply
+//		uint64 timeout = 7;  // of the call in milliseconds
+//	}
+type envelope struct {
+	id      uint64
+	kind    uint32
+	fn      string
+	typ     string
+	payload []byte
+	err     string
+	timeout uint64
+}
+
+const (
+	kindCall = iota + 1
+	kindGo
+	kindReply
+	kindRaw
+	kindPublish
+)
+
+func (e *envelope) marshal() []byte {
+	var b []byte
+	if e.id != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.id)
+	}
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.kind))
+	if e.fn != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, e.fn)
+	}
+	if e.typ != "" {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, e.typ)
+	}
+	if len(e.payload) > 0 {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.payload)
+	}
+	if e.err != "" {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, e.err)
+	}
+	if e.timeout != 0 {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.timeout)
+	}
+	return b
+}
+
+func (e *envelope) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var s []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 1:
+			e.id = v
+		case 2:
+			e.kind = uint32(v)
+		case 3:
+			e.fn = string(s)
+		case 4:
+			e.typ = string(s)
+		case 5:
+			e.payload = s
+		case 6:
+			e.err = string(s)
+		case 7:
+			e.timeout = v
+		}
+	}
+	if e.kind < kindCall || e.kind > kindPublish {
+		return errors.New("invalid envelope kind")
+	}
+	return nil
+}
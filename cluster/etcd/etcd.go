@@ -0,0 +1,137 @@
+// Package etcd keeps the members of the cluster in etcd, see
+// cluster.SetRegistry
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/czx-lab/leaf/cluster"
+	"github.com/czx-lab/leaf/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Registry puts the nodes under Prefix with a lease, the lease is kept alive
+// while the process runs
+type Registry struct {
+	Client *clientv3.Client
+	// "/leaf/nodes/" if empty
+	Prefix string
+
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc
+}
+
+func (r *Registry) prefix() string {
+	if r.Prefix == "" {
+		return "/leaf/nodes/"
+	}
+	return r.Prefix
+}
+
+func (r *Registry) Register(node cluster.Node, ttl time.Duration) error {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lease, err := r.Client.Grant(ctx, int64((ttl+time.Second-1)/time.Second))
+	if err != nil {
+		cancel()
+		return err
+	}
+	if _, err := r.Client.Put(ctx, r.prefix()+node.Name, string(data), clientv3.WithLease(lease.ID)); err != nil {
+		cancel()
+		return err
+	}
+	ch, err := r.Client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		for range ch {
+		}
+		if ctx.Err() == nil {
+			log.Error("etcd: lease of node %v lost", node.Name)
+		}
+	}()
+
+	r.lease = lease.ID
+	r.cancel = cancel
+	return nil
+}
+
+func (r *Registry) Deregister() error {
+	if r.cancel == nil {
+		return errors.New("not registered")
+	}
+	r.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := r.Client.Revoke(ctx, r.lease)
+	return err
+}
+
+func (r *Registry) Watch(stop <-chan struct{}, f func(nodes []cluster.Node)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := r.Client.Get(ctx, r.prefix(), clientv3.WithPrefix())
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	nodes := make(map[string]cluster.Node)
+	for _, kv := range resp.Kvs {
+		put(nodes, kv.Key, kv.Value)
+	}
+	f(values(nodes))
+
+	wch := r.Client.Watch(ctx, r.prefix(), clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-stop:
+				return
+			case wr, ok := <-wch:
+				if !ok {
+					return
+				}
+				if err := wr.Err(); err != nil {
+					log.Error("etcd: watch nodes: %v", err)
+					continue
+				}
+				for _, ev := range wr.Events {
+					if ev.Type == clientv3.EventTypeDelete {
+						delete(nodes, string(ev.Kv.Key))
+					} else {
+						put(nodes, ev.Kv.Key, ev.Kv.Value)
+					}
+				}
+				f(values(nodes))
+			}
+		}
+	}()
+	return nil
+}
+
+func put(nodes map[string]cluster.Node, key []byte, value []byte) {
+	var n cluster.Node
+	if err := json.Unmarshal(value, &n); err != nil {
+		log.Error("etcd: node %s: %v", key, err)
+		return
+	}
+	nodes[string(key)] = n
+}
+
+func values(nodes map[string]cluster.Node) []cluster.Node {
+	ns := make([]cluster.Node, 0, len(nodes))
+	for _, n := range nodes {
+		ns = append(ns, n)
+	}
+	return ns
+}
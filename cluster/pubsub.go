@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// topicID is the chanrpc id of a topic subscribed by Subscribe
+type topicID string
+
+var (
+	mutexTopics sync.Mutex
+	topics      = make(map[string][]*chanrpc.Server)
+)
+
+// Subscribe calls f in the goroutine of s with the messages published to
+// topic, e.g. "boss.Spawned", on any node. A server subscribes to a topic
+// once, a message of another type than M is a call error
+// you must call the function before calling Open and Go of s
+func Subscribe[M proto.Message](topic string, s *chanrpc.Server, f func(msg M)) {
+	chanrpc.Register0(s, topicID(topic), f)
+
+	mutexTopics.Lock()
+	topics[topic] = append(topics[topic], s)
+	mutexTopics.Unlock()
+}
+
+// Publish sends msg to the subscribers of topic on this node and on the
+// nodes connected to. With a registry every node is connected to, with
+// ConnAddrs only the nodes listed
+// goroutine safe
+func Publish(topic string, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	e := &envelope{
+		kind:    kindPublish,
+		fn:      topic,
+		typ:     string(msg.ProtoReflect().Descriptor().FullName()),
+		payload: payload,
+	}
+
+	mutexNodes.Lock()
+	agents := make([]*Agent, 0, len(peers))
+	for _, p := range peers {
+		if a := p.agent.Load(); a != nil {
+			agents = append(agents, a)
+		}
+	}
+	mutexNodes.Unlock()
+	for _, a := range agents {
+		if err := a.write(e); err != nil {
+			log.Error("cluster: publish %v: %v", topic, err)
+		}
+	}
+
+	deliver(e.fn, e.typ, e.payload)
+	return nil
+}
+
+// deliver sends a message published to the subscribers on this node, each
+// one gets its own copy
+func deliver(topic string, typ string, payload []byte) {
+	mutexTopics.Lock()
+	subscribers := topics[topic]
+	mutexTopics.Unlock()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(typ))
+	if err != nil {
+		log.Error("cluster: topic %v: message %v: %v", topic, typ, err)
+		return
+	}
+	for _, s := range subscribers {
+		msg := mt.New().Interface()
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			log.Error("cluster: topic %v: %v", topic, err)
+			return
+		}
+		s.Go(topicID(topic), msg)
+	}
+}
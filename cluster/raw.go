@@ -0,0 +1,36 @@
+package cluster
+
+var (
+	rawHandlers   = make(map[string]func(a *Agent, payload []byte))
+	closeHandlers []func(a *Agent)
+)
+
+// HandleRaw calls f with the payloads of fn written by WriteRaw, in the
+// goroutine reading the connection in the order they are written. f must
+// not block
+// you must call the function before calling leaf.Run
+func HandleRaw(fn string, f func(a *Agent, payload []byte)) {
+	rawHandlers[fn] = f
+}
+
+// OnClose calls f once a connection to a peer is closed
+// you must call the function before calling leaf.Run
+func OnClose(f func(a *Agent)) {
+	closeHandlers = append(closeHandlers, f)
+}
+
+// WriteRaw writes payload to the handler of fn on node, see HandleRaw
+// goroutine safe
+func WriteRaw(node string, fn string, payload []byte) error {
+	a := peerAgent(node)
+	if a == nil {
+		return ErrNoNode
+	}
+	return a.WriteRaw(fn, payload)
+}
+
+// WriteRaw writes payload to the handler of fn on the peer
+// goroutine safe
+func (a *Agent) WriteRaw(fn string, payload []byte) error {
+	return a.write(&envelope{kind: kindRaw, fn: fn, payload: payload})
+}
@@ -0,0 +1,232 @@
+package relay
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/cluster"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// agent is the gate.Agent of a session forwarded by a gate node, the
+// messages written are sent to the client through the gate. It isn't an
+// agent of a gate, e.g. Gate.Login panics with it
+type agent struct {
+	conn       *cluster.Agent
+	session    uint64
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	begin      time.Time
+	closed     atomic.Bool
+
+	msgsIn    atomic.Uint64
+	msgsOut   atomic.Uint64
+	lastRead  atomic.Int64
+	lastWrite atomic.Int64
+
+	mutex    sync.Mutex
+	userID   string
+	userData interface{}
+	state    map[string]json.RawMessage
+	gate.Values
+}
+
+var _ gate.Agent = (*agent)(nil)
+
+// addr is the address of a client as reported by its gate
+type addr string
+
+func (a addr) Network() string {
+	return "tcp"
+}
+
+func (a addr) String() string {
+	return string(a)
+}
+
+func newAgent(conn *cluster.Agent, f *frame) *agent {
+	a := new(agent)
+	a.conn = conn
+	a.session = f.session
+	if f.remoteAddr != "" {
+		a.remoteAddr = addr(f.remoteAddr)
+	}
+	if f.localAddr != "" {
+		a.localAddr = addr(f.localAddr)
+	}
+	a.begin = time.Now()
+	return a
+}
+
+// UserID is the user id of a session on its gate, "" if not logged in
+// goroutine safe
+func UserID(a gate.Agent) string {
+	if a, ok := a.(*agent); ok {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		return a.userID
+	}
+	return ""
+}
+
+func (a *agent) read(userID string) {
+	a.mutex.Lock()
+	a.userID = userID
+	a.mutex.Unlock()
+	a.msgsIn.Add(1)
+	a.lastRead.Store(time.Now().UnixNano())
+}
+
+func (a *agent) release() {
+	a.closed.Store(true)
+}
+
+func (a *agent) WriteMsg(msg interface{}) {
+	a.WriteMsgPriority(network.PriorityNormal, msg)
+}
+
+func (a *agent) WriteMsgPriority(p network.Priority, msg interface{}) {
+	if a.closed.Load() {
+		return
+	}
+	m, ok := msg.(proto.Message)
+	if !ok {
+		log.Error("relay: %T is not a protobuf message", msg)
+		return
+	}
+	f := frame{session: a.session, priority: uint32(p)}
+	if err := f.setMsg(m); err != nil {
+		log.Error("relay: marshal message %T error: %v", msg, err)
+		return
+	}
+	if err := a.conn.WriteRaw(fnOut, f.marshal()); err != nil {
+		log.Error("relay: write message %T error: %v", msg, err)
+		return
+	}
+	a.msgsOut.Add(1)
+	a.lastWrite.Store(time.Now().UnixNano())
+}
+
+func (a *agent) WriteMsgAfter(d time.Duration, msg interface{}) *gate.Scheduled {
+	return gate.AfterFunc(d, func() { a.WriteMsg(msg) })
+}
+
+// the acks aren't relayed, onFail is called once msg is written
+func (a *agent) WriteMsgAck(msg interface{}, timeout time.Duration, onAck func(), onFail func()) {
+	a.WriteMsg(msg)
+	if onFail != nil {
+		onFail()
+	}
+}
+
+func (a *agent) LocalAddr() net.Addr {
+	return a.localAddr
+}
+
+func (a *agent) RemoteAddr() net.Addr {
+	return a.remoteAddr
+}
+
+func (a *agent) UpgradeRequest() *http.Request {
+	return nil
+}
+
+func (a *agent) Subprotocol() string {
+	return ""
+}
+
+func (a *agent) SetWriteRate(rate int, burst int) {}
+
+func (a *agent) WriteRate() (rate int, burst int) {
+	return 0, 0
+}
+
+// Close closes the connection of the client on its gate
+func (a *agent) Close() {
+	if a.closed.Load() {
+		return
+	}
+	f := frame{session: a.session}
+	if err := a.conn.WriteRaw(fnKick, f.marshal()); err != nil {
+		log.Debug("relay: close session %v: %v", a.session, err)
+	}
+}
+
+func (a *agent) Destroy() {
+	a.Close()
+}
+
+func (a *agent) Namespace() string {
+	return ""
+}
+
+func (a *agent) Stats() gate.AgentStats {
+	return gate.AgentStats{
+		ConnectTime: a.begin,
+		MsgsIn:      a.msgsIn.Load(),
+		MsgsOut:     a.msgsOut.Load(),
+		LastRead:    unixTime(a.lastRead.Load()),
+		LastWrite:   unixTime(a.lastWrite.Load()),
+	}
+}
+
+func (a *agent) LastActivity() time.Time {
+	if t := a.lastRead.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return a.begin
+}
+
+func unixTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+func (a *agent) UserData() interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.userData
+}
+
+func (a *agent) SetUserData(data interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.userData = data
+}
+
+func (a *agent) SetState(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.state == nil {
+		a.state = make(map[string]json.RawMessage)
+	}
+	a.state[key] = data
+	return nil
+}
+
+func (a *agent) State(key string, v interface{}) bool {
+	a.mutex.Lock()
+	data, ok := a.state[key]
+	a.mutex.Unlock()
+	return ok && json.Unmarshal(data, v) == nil
+}
+
+func (a *agent) DelState(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.state, key)
+}
@@ -0,0 +1,135 @@
+// Package relay splits the gates and the game servers across the nodes of a
+// cluster. A Gateway on the gate node forwards the messages of a session to
+// the game node owning it, a Server on the game node routes them to its
+// handlers with an agent writing back through the gate
+package relay
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// the raw cluster functions, see cluster.HandleRaw
+const (
+	// a message of a client, gate to game
+	fnIn = "relay.in"
+	// a session closed on the gate, gate to game
+	fnClose = "relay.close"
+	// a message to a client, game to gate
+	fnOut = "relay.out"
+	// a session closed on the game node, game to gate
+	fnKick = "relay.kick"
+)
+
+// frame is the payload of the raw functions, encoded as the protobuf message
+//
+//	message Frame {
+//		uint64 session = 1;
+//		string type = 2;         // full name of the message in payload
+//		bytes payload = 3;
+//		string remote_addr = 4;  // of the client
+//		string local_addr = 5;
+//		string user_id = 6;
+//		uint32 priority = 7;     // of a message to a client
+//	}
+type frame struct {
+	session    uint64
+	typ        string
+	payload    []byte
+	remoteAddr string
+	localAddr  string
+	userID     string
+	priority   uint32
+}
+
+func (f *frame) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, f.session)
+	b = appendString(b, 2, f.typ)
+	if len(f.payload) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, f.payload)
+	}
+	b = appendString(b, 4, f.remoteAddr)
+	b = appendString(b, 5, f.localAddr)
+	b = appendString(b, 6, f.userID)
+	if f.priority != 0 {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(f.priority))
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func (f *frame) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v uint64
+		var s []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 1:
+			f.session = v
+		case 2:
+			f.typ = string(s)
+		case 3:
+			f.payload = s
+		case 4:
+			f.remoteAddr = string(s)
+		case 5:
+			f.localAddr = string(s)
+		case 6:
+			f.userID = string(s)
+		case 7:
+			f.priority = uint32(v)
+		}
+	}
+	return nil
+}
+
+// setMsg puts msg in the frame
+func (f *frame) setMsg(msg proto.Message) (err error) {
+	f.typ = string(msg.ProtoReflect().Descriptor().FullName())
+	f.payload, err = proto.Marshal(msg)
+	return
+}
+
+// msg decodes the message in the frame
+func (f *frame) msg() (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(f.typ))
+	if err != nil {
+		return nil, err
+	}
+	msg := mt.New().Interface()
+	if err := proto.Unmarshal(f.payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
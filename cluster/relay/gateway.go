@@ -0,0 +1,283 @@
+package relay
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/czx-lab/leaf/cluster"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// Gateway is the processor of a gate node. The messages of a session
+// assigned to a game node are forwarded to it, the other ones are routed by
+// Processor on the gate, e.g. the login
+//
+//	gateway := relay.NewGateway(processor, "game")
+//	gateway.UserID = gateModule.UserID
+//	gateModule.Processor = gateway
+//
+// A logged in session is assigned to a node of Role by the hash of its user
+// id, the assignment holds until the session closes. The messages forwarded
+// are protobuf messages
+type Gateway struct {
+	Processor network.Processor
+	// the role of the game nodes, see cluster.NodesByRole
+	Role string
+	// the user id of an agent, e.g. Gate.UserID, "" if not logged in. Only
+	// the sessions assigned with Assign are forwarded if nil
+	UserID func(a gate.Agent) string
+
+	mutexSessions sync.Mutex
+	sessions      map[gate.Agent]*session
+	byID          map[uint64]*session
+	lastID        uint64
+}
+
+type session struct {
+	id    uint64
+	agent gate.Agent
+	node  string
+}
+
+var (
+	_ network.StatefulProcessor = (*Gateway)(nil)
+	_ network.FrameTyper        = (*Gateway)(nil)
+	_ network.SharedMarshaler   = (*Gateway)(nil)
+)
+
+// NewGateway serves the messages written by the game nodes, a process has
+// one gateway
+// you must call the function before calling leaf.Run
+func NewGateway(processor network.Processor, role string) *Gateway {
+	g := new(Gateway)
+	g.Processor = processor
+	g.Role = role
+	g.sessions = make(map[gate.Agent]*session)
+	g.byID = make(map[uint64]*session)
+
+	cluster.HandleRaw(fnOut, g.out)
+	cluster.HandleRaw(fnKick, g.kick)
+	return g
+}
+
+// Assign forwards the messages of a to node, or routes them on the gate if
+// node is "". The session is closed on the node previously assigned
+// goroutine safe
+func (g *Gateway) Assign(a gate.Agent, node string) {
+	g.mutexSessions.Lock()
+	s := g.sessions[a]
+	if s != nil && s.node == node {
+		g.mutexSessions.Unlock()
+		return
+	}
+	if s != nil {
+		g.remove(s)
+	}
+	if node != "" {
+		g.add(a, node)
+	}
+	g.mutexSessions.Unlock()
+
+	if s != nil {
+		closeSession(s)
+	}
+}
+
+// Owner returns the node the messages of a are forwarded to, "" if they are
+// routed on the gate
+// goroutine safe
+func (g *Gateway) Owner(a gate.Agent) string {
+	if s := g.session(a); s != nil {
+		return s.node
+	}
+	return ""
+}
+
+// session returns the session of a, it's assigned by the user id if
+// logged in. nil if routed on the gate
+func (g *Gateway) session(a gate.Agent) *session {
+	g.mutexSessions.Lock()
+	defer g.mutexSessions.Unlock()
+	if s := g.sessions[a]; s != nil {
+		return s
+	}
+	if g.UserID == nil {
+		return nil
+	}
+	userID := g.UserID(a)
+	if userID == "" {
+		return nil
+	}
+	nodes := cluster.NodesByRole(g.Role)
+	if len(nodes) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return g.add(a, nodes[h.Sum32()%uint32(len(nodes))].Name)
+}
+
+func (g *Gateway) add(a gate.Agent, node string) *session {
+	g.lastID++
+	s := &session{id: g.lastID, agent: a, node: node}
+	g.sessions[a] = s
+	g.byID[s.id] = s
+	return s
+}
+
+func (g *Gateway) remove(s *session) {
+	delete(g.sessions, s.agent)
+	delete(g.byID, s.id)
+}
+
+func closeSession(s *session) {
+	f := frame{session: s.id}
+	if err := cluster.WriteRaw(s.node, fnClose, f.marshal()); err != nil {
+		log.Debug("relay: close session %v on %v: %v", s.id, s.node, err)
+	}
+}
+
+// goroutine safe
+func (g *Gateway) Route(msg interface{}, userData interface{}) error {
+	a, ok := userData.(gate.Agent)
+	if !ok {
+		return g.Processor.Route(msg, userData)
+	}
+	s := g.session(a)
+	if s == nil {
+		return g.Processor.Route(msg, userData)
+	}
+
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return fmt.Errorf("relay: %T is not a protobuf message", msg)
+	}
+	f := frame{session: s.id}
+	if err := f.setMsg(m); err != nil {
+		return err
+	}
+	if addr := a.RemoteAddr(); addr != nil {
+		f.remoteAddr = addr.String()
+	}
+	if addr := a.LocalAddr(); addr != nil {
+		f.localAddr = addr.String()
+	}
+	if g.UserID != nil {
+		f.userID = g.UserID(a)
+	}
+	if err := cluster.WriteRaw(s.node, fnIn, f.marshal()); err != nil {
+		return fmt.Errorf("relay to %v: %w", s.node, err)
+	}
+	return nil
+}
+
+// out writes a message of a game node to its client
+func (g *Gateway) out(_ *cluster.Agent, payload []byte) {
+	var f frame
+	if err := f.unmarshal(payload); err != nil {
+		log.Error("relay: %v", err)
+		return
+	}
+	g.mutexSessions.Lock()
+	s := g.byID[f.session]
+	g.mutexSessions.Unlock()
+	if s == nil {
+		return
+	}
+	msg, err := f.msg()
+	if err != nil {
+		log.Error("relay: message %v: %v", f.typ, err)
+		return
+	}
+	s.agent.WriteMsgPriority(network.Priority(f.priority), msg)
+}
+
+// kick closes a session closed by its game node
+func (g *Gateway) kick(_ *cluster.Agent, payload []byte) {
+	var f frame
+	if err := f.unmarshal(payload); err != nil {
+		log.Error("relay: %v", err)
+		return
+	}
+	g.mutexSessions.Lock()
+	s := g.byID[f.session]
+	g.mutexSessions.Unlock()
+	if s != nil {
+		s.agent.Close()
+	}
+}
+
+// goroutine safe
+func (g *Gateway) Unmarshal(data []byte) (interface{}, error) {
+	return g.Processor.Unmarshal(data)
+}
+
+// goroutine safe
+func (g *Gateway) Marshal(msg interface{}) ([][]byte, error) {
+	return g.Processor.Marshal(msg)
+}
+
+// goroutine safe
+func (g *Gateway) UnmarshalFrom(userData interface{}, data []byte) (interface{}, error) {
+	if sp, ok := g.Processor.(network.StatefulProcessor); ok {
+		return sp.UnmarshalFrom(userData, data)
+	}
+	return g.Processor.Unmarshal(data)
+}
+
+// goroutine safe
+func (g *Gateway) MarshalTo(userData interface{}, msg interface{}) ([][]byte, error) {
+	if sp, ok := g.Processor.(network.StatefulProcessor); ok {
+		return sp.MarshalTo(userData, msg)
+	}
+	return g.Processor.Marshal(msg)
+}
+
+// Release closes the session on its game node
+// goroutine safe
+func (g *Gateway) Release(userData interface{}) {
+	if sp, ok := g.Processor.(network.StatefulProcessor); ok {
+		sp.Release(userData)
+	}
+	a, ok := userData.(gate.Agent)
+	if !ok {
+		return
+	}
+	g.mutexSessions.Lock()
+	s := g.sessions[a]
+	if s != nil {
+		g.remove(s)
+	}
+	g.mutexSessions.Unlock()
+	if s != nil {
+		closeSession(s)
+	}
+}
+
+// goroutine safe
+func (g *Gateway) Shared(msg interface{}) bool {
+	return network.Shared(g.Processor, msg)
+}
+
+// goroutine safe
+func (g *Gateway) FrameType(msg interface{}) network.FrameType {
+	if ft, ok := g.Processor.(network.FrameTyper); ok {
+		return ft.FrameType(msg)
+	}
+	return network.FrameDefault
+}
+
+// ID is the id of msg in Processor, for the gate traces
+// goroutine safe
+func (g *Gateway) ID(msg interface{}) (uint16, bool) {
+	if ip, ok := g.Processor.(interface {
+		ID(msg interface{}) (uint16, bool)
+	}); ok {
+		return ip.ID(msg)
+	}
+	return 0, false
+}
@@ -0,0 +1,119 @@
+package relay
+
+import (
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/cluster"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+)
+
+// Server routes the messages forwarded by the gate nodes with Processor, the
+// handlers get an agent writing back through the gate. AgentChanRPC is
+// notified of the sessions as by a gate, with "NewAgent" and "CloseAgent"
+type Server struct {
+	Processor    network.Processor
+	AgentChanRPC *chanrpc.Server
+
+	mutexSessions sync.Mutex
+	sessions      map[sessionKey]*agent
+}
+
+// sessionKey is a session of the gate connected with conn
+type sessionKey struct {
+	conn *cluster.Agent
+	id   uint64
+}
+
+// NewServer serves the sessions forwarded to the node, a process has one
+// server
+// you must call the function before calling leaf.Run
+func NewServer(processor network.Processor, agentChanRPC *chanrpc.Server) *Server {
+	s := new(Server)
+	s.Processor = processor
+	s.AgentChanRPC = agentChanRPC
+	s.sessions = make(map[sessionKey]*agent)
+
+	cluster.HandleRaw(fnIn, s.in)
+	cluster.HandleRaw(fnClose, s.close)
+	cluster.OnClose(s.closeConn)
+	return s
+}
+
+// in routes a message of a client, the session opens with its first message
+func (s *Server) in(conn *cluster.Agent, payload []byte) {
+	var f frame
+	if err := f.unmarshal(payload); err != nil {
+		log.Error("relay: %v", err)
+		return
+	}
+
+	key := sessionKey{conn, f.session}
+	s.mutexSessions.Lock()
+	a := s.sessions[key]
+	opened := a == nil
+	if opened {
+		a = newAgent(conn, &f)
+		s.sessions[key] = a
+	}
+	s.mutexSessions.Unlock()
+	if opened && s.AgentChanRPC != nil {
+		s.AgentChanRPC.Go("NewAgent", a)
+	}
+	a.read(f.userID)
+
+	msg, err := f.msg()
+	if err != nil {
+		log.Error("relay: message %v: %v", f.typ, err)
+		return
+	}
+	if err := s.Processor.Route(msg, a); err != nil {
+		log.Debug("relay: route message error: %v", err)
+	}
+}
+
+// close closes a session closed on its gate
+func (s *Server) close(conn *cluster.Agent, payload []byte) {
+	var f frame
+	if err := f.unmarshal(payload); err != nil {
+		log.Error("relay: %v", err)
+		return
+	}
+
+	key := sessionKey{conn, f.session}
+	s.mutexSessions.Lock()
+	a := s.sessions[key]
+	delete(s.sessions, key)
+	s.mutexSessions.Unlock()
+	if a != nil {
+		s.closeAgent(a)
+	}
+}
+
+// closeConn closes the sessions of a gate disconnected
+func (s *Server) closeConn(conn *cluster.Agent) {
+	var closed []*agent
+	s.mutexSessions.Lock()
+	for key, a := range s.sessions {
+		if key.conn == conn {
+			delete(s.sessions, key)
+			closed = append(closed, a)
+		}
+	}
+	s.mutexSessions.Unlock()
+
+	for _, a := range closed {
+		s.closeAgent(a)
+	}
+}
+
+func (s *Server) closeAgent(a *agent) {
+	a.release()
+	if sp, ok := s.Processor.(network.StatefulProcessor); ok {
+		sp.Release(a)
+	}
+	if s.AgentChanRPC != nil {
+		s.AgentChanRPC.Go("CloseAgent", a)
+	}
+}
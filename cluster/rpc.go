@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var (
+	ErrNoNode   = errors.New("cluster: node not connected")
+	ErrTimeout  = errors.New("cluster: call timed out")
+	ErrConnLost = errors.New("cluster: connection lost")
+)
+
+// remoteID is the chanrpc id of a function served by Handle
+type remoteID string
+
+type result struct {
+	resp proto.Message
+	err  error
+}
+
+var (
+	mutexServers sync.Mutex
+	servers      = make(map[string]*chanrpc.Server)
+	lastCallID   atomic.Uint64
+)
+
+// Handle serves the calls of fn, e.g. "game.Join", with f in the goroutine
+// of s. The module of fn, "game", is served by s on this node
+// you must call the function before calling Open and Go of s
+func Handle[Req, Resp proto.Message](s *chanrpc.Server, fn string, f func(req Req) (Resp, error)) {
+	module, _, ok := strings.Cut(fn, ".")
+	if !ok {
+		log.Fatal("cluster function %v: module.Func expected", fn)
+	}
+
+	mutexServers.Lock()
+	if other := servers[module]; other != nil && other != s {
+		mutexServers.Unlock()
+		log.Fatal("cluster module %v: already served", module)
+	}
+	servers[module] = s
+	mutexServers.Unlock()
+
+	chanrpc.Register1(s, remoteID(fn), func(req Req) result {
+		resp, err := f(req)
+		return result{resp, err}
+	})
+}
+
+// Call calls fn, e.g. "game.Join", on node and unmarshals the reply into
+// resp, it blocks up to timeout. An error returned by the function is
+// returned with its message
+// goroutine safe
+func Call(node string, fn string, req proto.Message, resp proto.Message, timeout time.Duration) error {
+	a := peerAgent(node)
+	if a == nil {
+		return ErrNoNode
+	}
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	id := lastCallID.Add(1)
+	ch := a.await(id)
+	defer a.forget(id)
+	err = a.write(&envelope{
+		id:      id,
+		kind:    kindCall,
+		fn:      fn,
+		typ:     string(req.ProtoReflect().Descriptor().FullName()),
+		payload: payload,
+		timeout: uint64(timeout / time.Millisecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case e := <-ch:
+		if e == nil {
+			return ErrConnLost
+		}
+		if e.err != "" {
+			return fmt.Errorf("%v on %v: %v", fn, node, e.err)
+		}
+		return proto.Unmarshal(e.payload, resp)
+	case <-t.C:
+		return ErrTimeout
+	}
+}
+
+// Go calls fn on node without waiting for it
+// goroutine safe
+func Go(node string, fn string, req proto.Message) error {
+	a := peerAgent(node)
+	if a == nil {
+		return ErrNoNode
+	}
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return a.write(&envelope{
+		kind:    kindGo,
+		fn:      fn,
+		typ:     string(req.ProtoReflect().Descriptor().FullName()),
+		payload: payload,
+	})
+}
+
+// serve executes a call received from a peer and writes the reply back
+func (a *Agent) serve(e *envelope) {
+	module, _, _ := strings.Cut(e.fn, ".")
+	mutexServers.Lock()
+	s := servers[module]
+	mutexServers.Unlock()
+
+	reply := &envelope{id: e.id, kind: kindReply}
+	defer func() {
+		if e.kind == kindCall {
+			a.write(reply)
+		} else if reply.err != "" {
+			log.Error("cluster: %v: %v", e.fn, reply.err)
+		}
+	}()
+	if s == nil {
+		reply.err = fmt.Sprintf("module %v not served", module)
+		return
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(e.typ))
+	if err != nil {
+		reply.err = fmt.Sprintf("message %v: %v", e.typ, err)
+		return
+	}
+	req := mt.New().Interface()
+	if err := proto.Unmarshal(e.payload, req); err != nil {
+		reply.err = err.Error()
+		return
+	}
+
+	if e.kind == kindGo {
+		s.Go(remoteID(e.fn), req)
+		return
+	}
+
+	ctx := context.Background()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(e.timeout)*time.Millisecond)
+		defer cancel()
+	}
+	r, err := s.Call1Context(ctx, remoteID(e.fn), req)
+	if err != nil {
+		reply.err = err.Error()
+		return
+	}
+	res := r.(result)
+	if res.err != nil {
+		reply.err = res.err.Error()
+		return
+	}
+	if reply.payload, err = proto.Marshal(res.resp); err != nil {
+		reply.err = err.Error()
+	}
+}
@@ -0,0 +1,98 @@
+// protoc-gen-leaf generates the message id constants and a RegisterAll
+// function for the messages with a (leaf.msg_id) option, one file per Go
+// package:
+//
+//	//go:generate protoc -I . -I $LEAF/network/protobuf/leafpb --go_out=. --leaf_out=. game.proto
+//
+// where $LEAF is the directory of the leaf module. The options of protoc-gen-go,
+// such as paths=source_relative, are accepted
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/czx-lab/leaf/network/protobuf/leafpb"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const extendPackage = protogen.GoImportPath("github.com/czx-lab/leaf/network/protobuf/extend")
+
+type msgID struct {
+	id  uint32
+	msg *protogen.Message
+}
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+
+		var packages []protogen.GoImportPath
+		files := make(map[protogen.GoImportPath][]*protogen.File)
+		for _, f := range gen.Files {
+			if !f.Generate {
+				continue
+			}
+			if _, ok := files[f.GoImportPath]; !ok {
+				packages = append(packages, f.GoImportPath)
+			}
+			files[f.GoImportPath] = append(files[f.GoImportPath], f)
+		}
+
+		for _, pkg := range packages {
+			if err := generate(gen, files[pkg]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func generate(gen *protogen.Plugin, files []*protogen.File) error {
+	var ids []msgID
+	used := make(map[uint32]*protogen.Message)
+	for _, f := range files {
+		for _, m := range f.Messages {
+			opts := m.Desc.Options()
+			if !proto.HasExtension(opts, leafpb.E_MsgId) {
+				continue
+			}
+			id := proto.GetExtension(opts, leafpb.E_MsgId).(uint32)
+			if other, ok := used[id]; ok {
+				return fmt.Errorf("msg_id %v is used by %v and %v", id, other.Desc.FullName(), m.Desc.FullName())
+			}
+			used[id] = m
+			ids = append(ids, msgID{id, m})
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].id < ids[j].id
+	})
+
+	f := files[0]
+	filename := path.Join(path.Dir(f.GeneratedFilenamePrefix), "msgid_leaf.pb.go")
+	g := gen.NewGeneratedFile(filename, f.GoImportPath)
+	g.P("// Code generated by protoc-gen-leaf. DO NOT EDIT.")
+	g.P()
+	g.P("package ", f.GoPackageName)
+	g.P()
+	g.P("const (")
+	for _, m := range ids {
+		g.P("MsgID_", m.msg.GoIdent.GoName, " uint32 = ", m.id)
+	}
+	g.P(")")
+	g.P()
+	g.P("// RegisterAll registers the messages with a (leaf.msg_id) option")
+	g.P("func RegisterAll(p *", extendPackage.Ident("Processor"), ") {")
+	for _, m := range ids {
+		g.P("p.Register(MsgID_", m.msg.GoIdent.GoName, ", &", m.msg.GoIdent, "{})")
+	}
+	g.P("}")
+	return nil
+}
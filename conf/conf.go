@@ -1,5 +1,7 @@
 package conf
 
+import "time"
+
 var (
 	LenStackBuf = 4096
 
@@ -7,14 +9,78 @@ var (
 	LogLevel string
 	LogPath  string
 	LogFlag  int
+	// "console" or "json", see log.Logger.SetFormat
+	LogFormat string
+	// the log files in LogPath are rotated every LogRotate or at LogMaxSize
+	// bytes, see log.Rotation
+	LogRotate     time.Duration
+	LogMaxSize    int64
+	LogMaxBackups int
+	LogMaxAge     time.Duration
+	LogCompress   bool
+	// buffered lines written asynchronously if not zero, dropped when full
+	// with LogAsyncDrop, see log.Logger.SetAsync
+	LogAsync     int
+	LogAsyncDrop bool
+	// per module log files in LogPath, see log.Module. The errors of every
+	// log also go to error.log
+	LogModules map[string]LogModule
+
+	// slow handler
+	SlowHandlerThreshold   time.Duration
+	SlowHandlerLogInterval = 10 * time.Second
+
+	// upgrade, how long the old process keeps serving its connections
+	DrainTimeout = 30 * time.Second
+	// shutdown, how long the gates wait for their connections to be closed
+	// and the modules for their pending chanrpc calls
+	ShutdownTimeout = 10 * time.Second
+
+	// watchdog, checks the modules every WatchdogInterval if not zero, see
+	// module.HealthChecker. WatchdogRestart restarts the unhealthy modules
+	// with a factory, see module.RegisterFactory
+	WatchdogInterval time.Duration
+	WatchdogTimeout  = 5 * time.Second
+	WatchdogRestart  bool
 
 	// console
 	ConsolePort   int
 	ConsolePrompt string = "Leaf# "
 	ProfilePath   string
 
+	// the console listens on ConsoleHost, localhost if empty. Remote users
+	// authenticate with ConsolePassword or a token, see console.SetACL, and
+	// are locked out for ConsoleLockout after ConsoleMaxFailures failures.
+	// tls is enabled by ConsoleCertFile and ConsoleKeyFile
+	ConsoleHost        string
+	ConsolePassword    string
+	ConsoleMaxFailures = 5
+	ConsoleLockout     = 5 * time.Minute
+	ConsoleCertFile    string
+	ConsoleKeyFile     string
+
+	// the console negotiates the character mode with telnet clients for
+	// line editing and tab completion, the clients like nc print the
+	// negotiation. Line mode clients complete a line ending with a tab
+	ConsoleTelnet bool
+
 	// cluster
 	ListenAddr      string
 	ConnAddrs       []string
 	PendingWriteNum int
+	// discovery, see cluster.SetRegistry. The peers connect to AdvertiseAddr,
+	// ListenAddr if empty
+	NodeName      string
+	NodeRole      string
+	AdvertiseAddr string
+	NodeTTL       = 10 * time.Second
 )
+
+type LogModule struct {
+	// LogLevel if empty
+	Level string
+	// zero never rotates the log file
+	Rotate time.Duration
+	// zero never rotates the log file by size
+	MaxSize int64
+}
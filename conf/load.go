@@ -0,0 +1,509 @@
+package conf
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile selects the overrides of the file loaded, e.g. server.prod.yaml
+// for server.yaml, LEAF_PROFILE if empty
+var Profile string
+
+// the variables of the package set by the leaf section of a file
+var globals = map[string]interface{}{
+	"LenStackBuf":            &LenStackBuf,
+	"LogLevel":               &LogLevel,
+	"LogPath":                &LogPath,
+	"LogFlag":                &LogFlag,
+	"LogFormat":              &LogFormat,
+	"LogRotate":              &LogRotate,
+	"LogMaxSize":             &LogMaxSize,
+	"LogMaxBackups":          &LogMaxBackups,
+	"LogMaxAge":              &LogMaxAge,
+	"LogCompress":            &LogCompress,
+	"LogAsync":               &LogAsync,
+	"LogAsyncDrop":           &LogAsyncDrop,
+	"LogModules":             &LogModules,
+	"SlowHandlerThreshold":   &SlowHandlerThreshold,
+	"SlowHandlerLogInterval": &SlowHandlerLogInterval,
+	"DrainTimeout":           &DrainTimeout,
+	"ShutdownTimeout":        &ShutdownTimeout,
+	"WatchdogInterval":       &WatchdogInterval,
+	"WatchdogTimeout":        &WatchdogTimeout,
+	"WatchdogRestart":        &WatchdogRestart,
+	"ConsolePort":            &ConsolePort,
+	"ConsolePrompt":          &ConsolePrompt,
+	"ProfilePath":            &ProfilePath,
+	"ConsoleHost":            &ConsoleHost,
+	"ConsolePassword":        &ConsolePassword,
+	"ConsoleMaxFailures":     &ConsoleMaxFailures,
+	"ConsoleLockout":         &ConsoleLockout,
+	"ConsoleCertFile":        &ConsoleCertFile,
+	"ConsoleKeyFile":         &ConsoleKeyFile,
+	"ConsoleTelnet":          &ConsoleTelnet,
+	"ListenAddr":             &ListenAddr,
+	"ConnAddrs":              &ConnAddrs,
+	"PendingWriteNum":        &PendingWriteNum,
+	"NodeName":               &NodeName,
+	"NodeRole":               &NodeRole,
+	"AdvertiseAddr":          &AdvertiseAddr,
+	"NodeTTL":                &NodeTTL,
+}
+
+// Validator is implemented by the configurations checked by Load
+type Validator interface {
+	Validate() error
+}
+
+// Load reads the json or yaml file name into the variables of the package
+// and v, v may be nil. The file of Profile overrides name, the environment
+// overrides both:
+//
+//	leaf:
+//	  LogLevel: release
+//	  ConsolePort: 3333
+//	  NodeTTL: 10s
+//	gate:
+//	  addr: 0.0.0.0:3563
+//	  max_conn: 10000
+//
+// The leaf section sets the variables by their names, the environment by
+// LEAF_ and their names in upper snake case, e.g. LEAF_CONSOLE_PORT. The
+// fields of v are matched by their json tags or names, case and underscores
+// ignored, the environment sets the fields tagged by env:
+//
+//	type Config struct {
+//		Gate struct {
+//			Addr    string `env:"GATE_ADDR"`
+//			MaxConn int    `json:"max_conn"`
+//		}
+//	}
+//
+// The durations are strings as "10s" or nanoseconds, v is validated if it
+// is a Validator
+// you must call the function before calling leaf.Run
+func Load(name string, v interface{}) error {
+	m, err := readFile(name)
+	if err != nil {
+		return err
+	}
+
+	profile := Profile
+	if profile == "" {
+		profile = os.Getenv("LEAF_PROFILE")
+	}
+	if profile != "" {
+		ext := filepath.Ext(name)
+		pm, err := readFile(strings.TrimSuffix(name, ext) + "." + profile + ext)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		merge(m, pm)
+	}
+
+	if leaf, ok := lookup(m, "leaf"); ok {
+		section, ok := leaf.(map[string]interface{})
+		if !ok {
+			return errors.New("leaf: not a mapping")
+		}
+		for key, value := range section {
+			p, ok := global(key)
+			if !ok {
+				return fmt.Errorf("leaf.%v: unknown", key)
+			}
+			if err := decode(value, reflect.ValueOf(p).Elem()); err != nil {
+				return fmt.Errorf("leaf.%v: %v", key, err)
+			}
+		}
+	}
+	for name, p := range globals {
+		if err := setEnv("LEAF_"+snake(name), reflect.ValueOf(p).Elem()); err != nil {
+			return err
+		}
+	}
+	if err := validate(); err != nil {
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("conf: v must be a non-nil pointer")
+	}
+	if err := decode(m, rv.Elem()); err != nil {
+		return err
+	}
+	if err := envFields(rv.Elem()); err != nil {
+		return err
+	}
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func readFile(name string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		return nil, fmt.Errorf("%v: unknown format %v", name, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return m, nil
+}
+
+// merge merges src into dst, the mappings recursively
+func merge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if sm, ok := value.(map[string]interface{}); ok {
+			if dm, ok := dst[key].(map[string]interface{}); ok {
+				merge(dm, sm)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// normalize ignores case, underscores and dashes
+func normalize(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, key)
+}
+
+func lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	key = normalize(key)
+	for k, v := range m {
+		if normalize(k) == key {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func global(key string) (interface{}, bool) {
+	key = normalize(key)
+	for name, p := range globals {
+		if normalize(name) == key {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// snake returns name in upper snake case, e.g. CONSOLE_PORT for ConsolePort
+func snake(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+var (
+	typeDuration        = reflect.TypeOf(time.Duration(0))
+	typeTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decode sets rv to the value v of a json or yaml document
+func decode(v interface{}, rv reflect.Value) error {
+	if v == nil {
+		rv.SetZero()
+		return nil
+	}
+	if rv.Type() == typeDuration {
+		return decodeDuration(v, rv)
+	}
+	if s, ok := v.(string); ok && rv.Addr().Type().Implements(typeTextUnmarshaler) {
+		return rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decode(v, rv.Elem())
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a mapping", v)
+		}
+		return decodeStruct(m, rv)
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a mapping", v)
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for key, value := range m {
+			k := reflect.New(rv.Type().Key()).Elem()
+			if err := decode(key, k); err != nil {
+				return err
+			}
+			e := reflect.New(rv.Type().Elem()).Elem()
+			if old := rv.MapIndex(k); old.IsValid() {
+				e.Set(old)
+			}
+			if err := decode(value, e); err != nil {
+				return fmt.Errorf("%v: %v", key, err)
+			}
+			rv.SetMapIndex(k, e)
+		}
+		return nil
+	case reflect.Slice:
+		s, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a sequence", v)
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(s), len(s))
+		for i, value := range s {
+			if err := decode(value, slice.Index(i)); err != nil {
+				return fmt.Errorf("[%v]: %v", i, err)
+			}
+		}
+		rv.Set(slice)
+		return nil
+	case reflect.Interface:
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	if s, ok := v.(string); ok {
+		return parse(s, rv)
+	}
+	value := reflect.ValueOf(v)
+	// the numbers of json are float64
+	if value.CanConvert(rv.Type()) && isNumber(value.Kind()) == isNumber(rv.Kind()) {
+		if f, ok := v.(float64); ok && isInt(rv.Kind()) && f != float64(int64(f)) {
+			return fmt.Errorf("%v is not an integer", v)
+		}
+		rv.Set(value.Convert(rv.Type()))
+		return nil
+	}
+	return fmt.Errorf("cannot set %v to %v", rv.Type(), v)
+}
+
+func decodeDuration(v interface{}, rv reflect.Value) error {
+	switch v := v.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		rv.SetInt(int64(d))
+	case int:
+		rv.SetInt(int64(v))
+	case float64:
+		rv.SetInt(int64(v))
+	default:
+		return fmt.Errorf("invalid duration %v", v)
+	}
+	return nil
+}
+
+func decodeStruct(m map[string]interface{}, rv reflect.Value) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := decodeStruct(m, rv.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := f.Name
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		value, ok := lookup(m, name)
+		if !ok {
+			continue
+		}
+		if err := decode(value, rv.Field(i)); err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uintptr
+}
+
+func isNumber(k reflect.Kind) bool {
+	return isInt(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// parse sets rv to the string s, the elements of a slice are separated by
+// commas
+func parse(s string, rv reflect.Value) error {
+	if rv.Type() == typeDuration {
+		return decodeDuration(s, rv)
+	}
+	if rv.Addr().Type().Implements(typeTextUnmarshaler) {
+		return rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 0, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		rv.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := parse(strings.TrimSpace(part), slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return parse(s, rv.Elem())
+	default:
+		return fmt.Errorf("cannot set %v to %v", rv.Type(), s)
+	}
+	return nil
+}
+
+func setEnv(key string, rv reflect.Value) error {
+	s, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	if err := parse(s, rv); err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	}
+	return nil
+}
+
+// envFields sets the fields tagged by env
+func envFields(rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil
+		}
+		return envFields(rv.Elem())
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if key := f.Tag.Get("env"); key != "" {
+			if err := setEnv(key, rv.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := envFields(rv.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the variables of the package
+func validate() error {
+	switch strings.ToLower(LogLevel) {
+	case "", "debug", "release", "error", "fatal":
+	default:
+		return errors.New("leaf.LogLevel: unknown level " + LogLevel)
+	}
+	switch strings.ToLower(LogFormat) {
+	case "", "console", "json":
+	default:
+		return errors.New("leaf.LogFormat: unknown format " + LogFormat)
+	}
+	if ConsolePort < 0 || ConsolePort > 65535 {
+		return fmt.Errorf("leaf.ConsolePort: invalid port %v", ConsolePort)
+	}
+	if (ConsoleCertFile == "") != (ConsoleKeyFile == "") {
+		return errors.New("leaf.ConsoleCertFile and ConsoleKeyFile must be set together")
+	}
+	if LenStackBuf < 0 || PendingWriteNum < 0 || LogMaxSize < 0 || LogMaxBackups < 0 || LogAsync < 0 {
+		return errors.New("leaf: negative size")
+	}
+	for name, m := range LogModules {
+		if m.MaxSize < 0 || m.Rotate < 0 {
+			return fmt.Errorf("leaf.LogModules.%v: negative rotation", name)
+		}
+	}
+	return nil
+}
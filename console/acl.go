@@ -0,0 +1,258 @@
+package console
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+)
+
+// ACL makes console users authenticate with a token, the roles of the user
+// decide the commands it may run
+type ACL struct {
+	// token -> user
+	Users map[string]*User
+	// roles allowed to run the commands without Require, empty allows every
+	// user
+	DefaultRoles []string
+	// called for every invocation in addition to the audit log
+	OnAudit func(r *AuditRecord)
+}
+
+type User struct {
+	Name  string
+	Roles []string
+}
+
+type AuditRecord struct {
+	Time    time.Time
+	User    string
+	Addr    string
+	Command string
+	Args    []string
+	Allowed bool
+}
+
+var (
+	acl      *ACL
+	required = make(map[string][]string)
+	mutexACL sync.RWMutex
+)
+
+// SetACL enables authentication, nil disables it
+// goroutine safe
+func SetACL(a *ACL) {
+	mutexACL.Lock()
+	acl = a
+	mutexACL.Unlock()
+}
+
+// Require restricts command name to the users having one of roles
+// goroutine safe
+func Require(name string, roles ...string) {
+	mutexACL.Lock()
+	required[name] = roles
+	mutexACL.Unlock()
+}
+
+// Authenticate returns the user of token, it's nil if the token is unknown.
+// Without ACL the token is ConsolePassword, every token is accepted if it's
+// empty
+// goroutine safe
+func Authenticate(token string) *User {
+	mutexACL.RLock()
+	defer mutexACL.RUnlock()
+
+	if acl != nil {
+		return acl.Users[token]
+	}
+	if conf.ConsolePassword == "" {
+		return &User{}
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(conf.ConsolePassword)) == 1 {
+		return &User{Name: "password"}
+	}
+	return nil
+}
+
+func authRequired() bool {
+	return hasACL() || conf.ConsolePassword != ""
+}
+
+func hasACL() bool {
+	mutexACL.RLock()
+	defer mutexACL.RUnlock()
+	return acl != nil
+}
+
+// failures of a host authenticating
+type failures struct {
+	n      int
+	locked time.Time
+}
+
+var (
+	authFailures      = make(map[string]*failures)
+	mutexAuthFailures sync.Mutex
+)
+
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// locked reports whether the host of addr is locked out
+func locked(addr string) bool {
+	mutexAuthFailures.Lock()
+	defer mutexAuthFailures.Unlock()
+
+	h := host(addr)
+	f := authFailures[h]
+	if f == nil {
+		return false
+	}
+	if time.Now().Before(f.locked) {
+		return true
+	}
+	if f.n == 0 {
+		delete(authFailures, h)
+	}
+	return false
+}
+
+// authenticate is Authenticate counting the failures of addr
+func authenticate(addr string, token string) *User {
+	if locked(addr) {
+		return nil
+	}
+	u := Authenticate(token)
+
+	mutexAuthFailures.Lock()
+	defer mutexAuthFailures.Unlock()
+	h := host(addr)
+	if u != nil {
+		delete(authFailures, h)
+		return u
+	}
+
+	f := authFailures[h]
+	if f == nil {
+		f = new(failures)
+		authFailures[h] = f
+	}
+	f.n++
+	log.Module("console").Release("console: authentication failed from %v (%v)", addr, f.n)
+	if conf.ConsoleMaxFailures > 0 && f.n >= conf.ConsoleMaxFailures {
+		f.n = 0
+		f.locked = time.Now().Add(conf.ConsoleLockout)
+		log.Module("console").Error("console: %v locked out for %v", h, conf.ConsoleLockout)
+	}
+	return nil
+}
+
+// allowed reports whether u may run command name
+func allowed(u *User, name string) bool {
+	mutexACL.RLock()
+	defer mutexACL.RUnlock()
+
+	if acl == nil || name == "help" {
+		return true
+	}
+	roles, ok := required[name]
+	if !ok {
+		roles = acl.DefaultRoles
+	}
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		for _, r := range u.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// audit logs the commands to the console module log, see log.Module
+func audit(u *User, addr string, name string, args []string, ok bool) {
+	mutexACL.RLock()
+	a := acl
+	mutexACL.RUnlock()
+
+	user := u.Name
+	if user == "" {
+		user = "-"
+	}
+	if ok {
+		log.Module("console").Release("console: %v (%v) ran %v %v", user, addr, name, strings.Join(args, " "))
+	} else {
+		log.Module("console").Release("console: %v (%v) denied %v %v", user, addr, name, strings.Join(args, " "))
+	}
+	if a != nil && a.OnAudit != nil {
+		a.OnAudit(&AuditRecord{
+			Time:    time.Now(),
+			User:    u.Name,
+			Addr:    addr,
+			Command: name,
+			Args:    args,
+			Allowed: ok,
+		})
+	}
+}
+
+// Exec authenticates token and runs a command line for an admin interface
+// other than the console port, e.g. HTTP. addr is audit-logged. It refuses
+// every command unless there is an ACL or a ConsolePassword
+// goroutine safe
+func Exec(token string, addr string, line string) (string, error) {
+	if !authRequired() {
+		return "", errors.New("no ACL or console password")
+	}
+	if locked(addr) {
+		return "", errors.New("too many failures, try again later")
+	}
+	u := authenticate(addr, token)
+	if u == nil {
+		return "", errors.New("invalid token")
+	}
+	return exec(u, addr, line)
+}
+
+func exec(u *User, addr string, line string) (string, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	c := command(args[0])
+	if c == nil {
+		return "", errors.New("command not found, try `help` for help")
+	}
+	ok := allowed(u, args[0])
+	audit(u, addr, args[0], args[1:], ok)
+	if !ok {
+		return "", errors.New("permission denied")
+	}
+	if c, ok := c.(*CommandHelp); ok {
+		return c.list(u, args[1:]), nil
+	}
+	return c.run(args[1:]), nil
+}
+
+func command(name string) Command {
+	for _, c := range commands {
+		if c.name() == name {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+)
+
+type ArgType int
+
+const (
+	String ArgType = iota
+	Int
+	Float
+	Bool
+	Duration
+)
+
+func (t ArgType) String() string {
+	switch t {
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case Bool:
+		return "bool"
+	case Duration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// Arg is a flag, given as -name value or -name=value, or a positional
+// argument of a Cmd. A bool flag without value is true
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Help     string
+	Default  string
+	Required bool
+	// the values allowed if not empty, also completed on tab
+	Choices []string
+}
+
+func (arg *Arg) parse(s string) (interface{}, error) {
+	if len(arg.Choices) > 0 {
+		found := false
+		for _, c := range arg.Choices {
+			if c == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%v must be one of %v", arg.Name, strings.Join(arg.Choices, ", "))
+		}
+	}
+
+	var v interface{}
+	var err error
+	switch arg.Type {
+	case Int:
+		v, err = strconv.Atoi(s)
+	case Float:
+		v, err = strconv.ParseFloat(s, 64)
+	case Bool:
+		v, err = strconv.ParseBool(s)
+	case Duration:
+		v, err = time.ParseDuration(s)
+	default:
+		v = s
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %v: %v", arg.Type, arg.Name, s)
+	}
+	return v, nil
+}
+
+// Cmd is a console command declaring its flags and arguments, they are
+// parsed before Run is called and listed by help <name>:
+//
+//	console.RegisterCmd(&console.Cmd{
+//		Name: "kick",
+//		Help: "disconnects a user",
+//		Args: []console.Arg{{Name: "user", Type: console.Int, Required: true}},
+//		Flags: []console.Arg{{Name: "reason", Default: "gm"}},
+//		Run: func(args *console.Args) string {
+//			...
+//		},
+//	}, skeleton.ChanRPCServer)
+type Cmd struct {
+	Name  string
+	Help  string
+	Flags []Arg
+	Args  []Arg
+	Run   func(args *Args) string
+
+	server   *chanrpc.Server
+	defaults map[string]interface{}
+}
+
+// Args are the values of the flags and arguments of a Cmd, the default
+// values if not given
+type Args struct {
+	values map[string]interface{}
+	given  map[string]bool
+	// the arguments after the declared ones
+	Rest []string
+}
+
+func (args *Args) value(name string) interface{} {
+	v, ok := args.values[name]
+	if !ok {
+		panic("console: undeclared argument " + name)
+	}
+	return v
+}
+
+// Has reports whether name was given
+func (args *Args) Has(name string) bool {
+	return args.given[name]
+}
+
+func (args *Args) String(name string) string {
+	v, _ := args.value(name).(string)
+	return v
+}
+
+func (args *Args) Int(name string) int {
+	v, _ := args.value(name).(int)
+	return v
+}
+
+func (args *Args) Float(name string) float64 {
+	v, _ := args.value(name).(float64)
+	return v
+}
+
+func (args *Args) Bool(name string) bool {
+	v, _ := args.value(name).(bool)
+	return v
+}
+
+func (args *Args) Duration(name string) time.Duration {
+	v, _ := args.value(name).(time.Duration)
+	return v
+}
+
+// RegisterCmd registers c, Run is called in the goroutine of server or the
+// console goroutine if server is nil
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterCmd(c *Cmd, server *chanrpc.Server) {
+	for _, cmd := range commands {
+		if cmd.name() == c.Name {
+			log.Fatal("command %v is already registered", c.Name)
+		}
+	}
+	if c.Run == nil {
+		log.Fatal("command %v: Run must not be nil", c.Name)
+	}
+
+	c.defaults = make(map[string]interface{})
+	for _, args := range [][]Arg{c.Flags, c.Args} {
+		for i := range args {
+			arg := &args[i]
+			if _, ok := c.defaults[arg.Name]; ok {
+				log.Fatal("command %v: argument %v is declared twice", c.Name, arg.Name)
+			}
+			if arg.Default == "" {
+				c.defaults[arg.Name] = zero(arg.Type)
+				continue
+			}
+			v, err := arg.parse(arg.Default)
+			if err != nil {
+				log.Fatal("command %v: default %v", c.Name, err)
+			}
+			c.defaults[arg.Name] = v
+		}
+	}
+
+	if server != nil {
+		server.Register(c.Name, func(args []interface{}) interface{} {
+			return c.Run(args[0].(*Args))
+		})
+		c.server = server
+	}
+	commands = append(commands, c)
+}
+
+func zero(t ArgType) interface{} {
+	switch t {
+	case Int:
+		return 0
+	case Float:
+		return 0.0
+	case Bool:
+		return false
+	case Duration:
+		return time.Duration(0)
+	default:
+		return ""
+	}
+}
+
+func (c *Cmd) name() string {
+	return c.Name
+}
+
+func (c *Cmd) help() string {
+	return c.Help
+}
+
+func (c *Cmd) run(fields []string) string {
+	args, err := c.parse(fields)
+	if err != nil {
+		return err.Error() + "\r\n" + c.usage()
+	}
+	if c.server == nil {
+		return c.Run(args)
+	}
+
+	ret, err := c.server.Call1(c.Name, args)
+	if err != nil {
+		return err.Error()
+	}
+	output, _ := ret.(string)
+	return output
+}
+
+func (c *Cmd) flag(name string) *Arg {
+	for i := range c.Flags {
+		if c.Flags[i].Name == name {
+			return &c.Flags[i]
+		}
+	}
+	return nil
+}
+
+func (c *Cmd) parse(fields []string) (*Args, error) {
+	args := &Args{values: make(map[string]interface{}), given: make(map[string]bool)}
+	for name, v := range c.defaults {
+		args.values[name] = v
+	}
+
+	var positional []string
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if field == "--" {
+			positional = append(positional, fields[i+1:]...)
+			break
+		}
+		if len(field) < 2 || field[0] != '-' {
+			positional = append(positional, field)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(field[1:], "=")
+		arg := c.flag(name)
+		if arg == nil {
+			// a negative number
+			if _, err := strconv.ParseFloat(field, 64); err == nil {
+				positional = append(positional, field)
+				continue
+			}
+			return nil, errors.New("unknown flag -" + name)
+		}
+		if !hasValue {
+			if arg.Type == Bool {
+				value = "true"
+			} else if i+1 < len(fields) {
+				i++
+				value = fields[i]
+			} else {
+				return nil, errors.New("flag -" + name + " needs a value")
+			}
+		}
+		v, err := arg.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		args.values[name] = v
+		args.given[name] = true
+	}
+
+	for i := range c.Args {
+		arg := &c.Args[i]
+		if i >= len(positional) {
+			if arg.Required {
+				return nil, errors.New("missing " + arg.Name)
+			}
+			break
+		}
+		v, err := arg.parse(positional[i])
+		if err != nil {
+			return nil, err
+		}
+		args.values[arg.Name] = v
+		args.given[arg.Name] = true
+	}
+	if len(positional) > len(c.Args) {
+		args.Rest = positional[len(c.Args):]
+	}
+	for i := range c.Flags {
+		if c.Flags[i].Required && !args.given[c.Flags[i].Name] {
+			return nil, errors.New("missing flag -" + c.Flags[i].Name)
+		}
+	}
+	return args, nil
+}
+
+func (c *Cmd) usage() string {
+	var b strings.Builder
+	b.WriteString("Usage: " + c.Name)
+	for _, arg := range c.Flags {
+		s := "-" + arg.Name
+		if arg.Type != Bool {
+			s += " " + arg.Type.String()
+		}
+		if !arg.Required {
+			s = "[" + s + "]"
+		}
+		b.WriteString(" " + s)
+	}
+	for _, arg := range c.Args {
+		if arg.Required {
+			b.WriteString(" <" + arg.Name + ">")
+		} else {
+			b.WriteString(" [" + arg.Name + "]")
+		}
+	}
+	if c.Help != "" {
+		b.WriteString("\r\n" + c.Help)
+	}
+
+	line := func(name string, arg *Arg) {
+		var notes []string
+		if arg.Help != "" {
+			notes = append(notes, arg.Help)
+		}
+		if len(arg.Choices) > 0 {
+			notes = append(notes, "("+strings.Join(arg.Choices, "|")+")")
+		}
+		if arg.Default != "" {
+			notes = append(notes, "(default "+arg.Default+")")
+		}
+		s := fmt.Sprintf("  %-12v %-8v %v", name, arg.Type, strings.Join(notes, " "))
+		b.WriteString("\r\n" + strings.TrimRight(s, " "))
+	}
+	for i := range c.Flags {
+		line("-"+c.Flags[i].Name, &c.Flags[i])
+	}
+	for i := range c.Args {
+		line(c.Args[i].Name, &c.Args[i])
+	}
+	return b.String()
+}
+
+// complete returns the completions of the last word of line, a word not
+// ended by a space
+func complete(u *User, line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasSuffix(line, " ") {
+		fields = append(fields, "")
+	}
+	word := fields[len(fields)-1]
+
+	var candidates []string
+	if len(fields) == 1 || len(fields) == 2 && fields[0] == "help" {
+		for _, c := range commands {
+			if allowed(u, c.name()) {
+				candidates = append(candidates, c.name())
+			}
+		}
+		if len(fields) == 1 {
+			candidates = append(candidates, "quit")
+		}
+	} else if c, ok := command(fields[0]).(*Cmd); ok {
+		prev := fields[len(fields)-2]
+		if arg := c.flag(strings.TrimPrefix(prev, "-")); strings.HasPrefix(prev, "-") && arg != nil && arg.Type != Bool {
+			candidates = arg.Choices
+		} else if strings.HasPrefix(word, "-") {
+			for _, arg := range c.Flags {
+				candidates = append(candidates, "-"+arg.Name)
+			}
+		} else if i := c.position(fields[1 : len(fields)-1]); i < len(c.Args) {
+			candidates = c.Args[i].Choices
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// position returns the index of the positional argument after fields
+func (c *Cmd) position(fields []string) int {
+	n := 0
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "-") {
+			n++
+			continue
+		}
+		name, _, hasValue := strings.Cut(fields[i][1:], "=")
+		if arg := c.flag(name); arg != nil && arg.Type != Bool && !hasValue {
+			i++
+		}
+	}
+	return n
+}
@@ -4,18 +4,26 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
 	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/recordfile"
 )
 
 var commands = []Command{
 	new(CommandHelp),
 	new(CommandCPUProf),
 	new(CommandProf),
+	new(CommandGoroutines),
+	new(CommandGC),
+	new(CommandStats),
+	new(CommandTable),
 }
 
 type Command interface {
@@ -77,6 +85,41 @@ func Register(name string, help string, f interface{}, server *chanrpc.Server) {
 	commands = append(commands, c)
 }
 
+type FuncCommand struct {
+	_name string
+	_help string
+	f     func(args []string) string
+}
+
+func (c *FuncCommand) name() string {
+	return c._name
+}
+
+func (c *FuncCommand) help() string {
+	return c._help
+}
+
+func (c *FuncCommand) run(args []string) string {
+	return c.f(args)
+}
+
+// f is run on the console goroutine and must be goroutine safe
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterFunc(name string, help string, f func(args []string) string) {
+	for _, c := range commands {
+		if c.name() == name {
+			log.Fatal("command %v is already registered", name)
+		}
+	}
+
+	c := new(FuncCommand)
+	c._name = name
+	c._help = help
+	c.f = f
+	commands = append(commands, c)
+}
+
 // help
 type CommandHelp struct{}
 
@@ -85,15 +128,39 @@ func (c *CommandHelp) name() string {
 }
 
 func (c *CommandHelp) help() string {
-	return "this help text"
+	return "this help text, or the usage of a command"
+}
+
+func (c *CommandHelp) run(args []string) string {
+	return c.list(&User{}, args)
 }
 
-func (c *CommandHelp) run([]string) string {
+// usager is a command explaining its arguments for help <name>
+type usager interface {
+	usage() string
+}
+
+// list lists the commands u may run, or the usage of the command args[0]
+func (c *CommandHelp) list(u *User, args []string) string {
+	if len(args) > 0 {
+		cmd := command(args[0])
+		if cmd == nil || !allowed(u, args[0]) {
+			return "command " + args[0] + " not found"
+		}
+		if cmd, ok := cmd.(usager); ok {
+			return cmd.usage()
+		}
+		return cmd.name() + " - " + cmd.help()
+	}
+
 	output := "Commands:\r\n"
 	for _, c := range commands {
-		output += c.name() + " - " + c.help() + "\r\n"
+		if allowed(u, c.name()) {
+			output += c.name() + " - " + c.help() + "\r\n"
+		}
 	}
-	output += "quit - exit console"
+	output += "quit - exit console\r\n"
+	output += "help <command> for the usage of a command"
 
 	return output
 }
@@ -143,7 +210,11 @@ func (c *CommandCPUProf) run(args []string) string {
 	}
 }
 
+// profileName returns the name of a file in ProfilePath, created if missing
 func profileName() string {
+	if conf.ProfilePath != "" {
+		os.MkdirAll(conf.ProfilePath, 0755)
+	}
 	now := time.Now()
 	return path.Join(conf.ProfilePath,
 		fmt.Sprintf("%d%02d%02d_%02d_%02d_%02d",
@@ -169,11 +240,14 @@ func (c *CommandProf) help() string {
 func (c *CommandProf) usage() string {
 	return "prof writes runtime profiling data in the format expected by \r\n" +
 		"the pprof visualization tool\r\n\r\n" +
-		"Usage: prof goroutine|heap|thread|block\r\n" +
+		"Usage: prof cpu [duration]|goroutine|heap|allocs|thread|block|mutex\r\n" +
+		"  cpu       - CPU profile of duration, 30s by default\r\n" +
 		"  goroutine - stack traces of all current goroutines\r\n" +
 		"  heap      - a sampling of all heap allocations\r\n" +
+		"  allocs    - a sampling of all past memory allocations\r\n" +
 		"  thread    - stack traces that led to the creation of new OS threads\r\n" +
-		"  block     - stack traces that led to blocking on synchronization primitives"
+		"  block     - stack traces that led to blocking on synchronization primitives\r\n" +
+		"  mutex     - stack traces of holders of contended mutexes"
 }
 
 func (c *CommandProf) run(args []string) string {
@@ -186,18 +260,34 @@ func (c *CommandProf) run(args []string) string {
 		fn string
 	)
 	switch args[0] {
+	case "cpu":
+		d := 30 * time.Second
+		if len(args) > 1 {
+			var err error
+			d, err = time.ParseDuration(args[1])
+			if err != nil || d <= 0 {
+				return c.usage()
+			}
+		}
+		return cpuProfile(d)
 	case "goroutine":
 		p = pprof.Lookup("goroutine")
 		fn = profileName() + ".gprof"
 	case "heap":
 		p = pprof.Lookup("heap")
 		fn = profileName() + ".hprof"
+	case "allocs":
+		p = pprof.Lookup("allocs")
+		fn = profileName() + ".aprof"
 	case "thread":
 		p = pprof.Lookup("threadcreate")
 		fn = profileName() + ".tprof"
 	case "block":
 		p = pprof.Lookup("block")
 		fn = profileName() + ".bprof"
+	case "mutex":
+		p = pprof.Lookup("mutex")
+		fn = profileName() + ".mprof"
 	default:
 		return c.usage()
 	}
@@ -214,3 +304,184 @@ func (c *CommandProf) run(args []string) string {
 
 	return fn
 }
+
+// cpuProfile profiles the CPU for d, the console waits for it
+func cpuProfile(d time.Duration) string {
+	fn := profileName() + ".cpuprof"
+	f, err := os.Create(fn)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return err.Error()
+	}
+	time.Sleep(d)
+	pprof.StopCPUProfile()
+	return fn
+}
+
+// goroutines
+type CommandGoroutines struct{}
+
+func (c *CommandGoroutines) name() string {
+	return "goroutines"
+}
+
+func (c *CommandGoroutines) help() string {
+	return "counts the goroutines and dumps their stack traces"
+}
+
+func (c *CommandGoroutines) run([]string) string {
+	fn := profileName() + ".goroutines"
+	f, err := os.Create(fn)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%v goroutines, %v", runtime.NumGoroutine(), fn)
+}
+
+// gc
+type CommandGC struct{}
+
+func (c *CommandGC) name() string {
+	return "gc"
+}
+
+func (c *CommandGC) help() string {
+	return "runs a garbage collection and returns memory to the OS"
+}
+
+func (c *CommandGC) run([]string) string {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	t := time.Now()
+	debug.FreeOSMemory()
+	d := time.Since(t)
+	runtime.ReadMemStats(&after)
+
+	return fmt.Sprintf("heap %v -> %v, released %v, took %v",
+		bytes(before.HeapAlloc), bytes(after.HeapAlloc), bytes(after.HeapReleased), d)
+}
+
+func bytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%vB", n)
+	}
+}
+
+// stats
+type CommandStats struct{}
+
+var statsFuncs []func() string
+
+// RegisterStats adds the output of f to the stats command, e.g. the
+// connections of the gates and the queues of the modules
+// you must call the function before calling console.Init
+// goroutine not safe
+func RegisterStats(f func() string) {
+	statsFuncs = append(statsFuncs, f)
+}
+
+func (c *CommandStats) name() string {
+	return "stats"
+}
+
+func (c *CommandStats) help() string {
+	return "runtime, connection and queue statistics"
+}
+
+func (c *CommandStats) run([]string) string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var pause time.Duration
+	if m.NumGC > 0 {
+		pause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+	}
+
+	output := fmt.Sprintf("goroutines: %v, heap: %v, sys: %v, gc: %v (last pause %v)",
+		runtime.NumGoroutine(), bytes(m.HeapAlloc), bytes(m.Sys), m.NumGC, pause)
+	for _, f := range statsFuncs {
+		if s := f(); s != "" {
+			output += "\r\n" + s
+		}
+	}
+	return output
+}
+
+// table
+type CommandTable struct{}
+
+func (c *CommandTable) name() string {
+	return "table"
+}
+
+func (c *CommandTable) help() string {
+	return "exports or reloads the loaded record files"
+}
+
+func (c *CommandTable) usage() string {
+	return "table writes the records currently loaded by a registered\r\n" +
+		"record file\r\n\r\n" +
+		"Usage: table list|export name [csv|json]|reload name\r\n" +
+		"  list   - names of the registered record files\r\n" +
+		"  export - writes the records of name, csv by default\r\n" +
+		"  reload - reads the file of name again"
+}
+
+func (c *CommandTable) run(args []string) string {
+	if len(args) == 0 {
+		return c.usage()
+	}
+
+	switch args[0] {
+	case "list":
+		return strings.Join(recordfile.Tables(), "\r\n")
+	case "export":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		rf := recordfile.Lookup(args[1])
+		if rf == nil {
+			return "table " + args[1] + " not registered"
+		}
+		format := "csv"
+		if len(args) > 2 {
+			format = args[2]
+		}
+		if format != "csv" && format != "json" {
+			return c.usage()
+		}
+
+		fn := profileName() + "_" + path.Base(args[1]) + "." + format
+		if err := rf.Export(fn); err != nil {
+			return err.Error()
+		}
+		return fn
+	case "reload":
+		if len(args) < 2 {
+			return c.usage()
+		}
+		rf := recordfile.Lookup(args[1])
+		if rf == nil {
+			return "table " + args[1] + " not registered"
+		}
+		if err := rf.Reload(); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("%v records", rf.NumRecord())
+	default:
+		return c.usage()
+	}
+}
@@ -3,10 +3,12 @@ package console
 import (
 	"bufio"
 	"math"
+	"net"
 	"strconv"
 	"strings"
 
 	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
 	"github.com/czx-lab/leaf/network"
 )
 
@@ -17,8 +19,18 @@ func Init() {
 		return
 	}
 
+	host := conf.ConsoleHost
+	if host == "" {
+		host = "localhost"
+	}
+	if host != "localhost" && !authRequired() {
+		log.Release("console: listening on %v without ConsolePassword or ACL", host)
+	}
+
 	server = new(network.TCPServer)
-	server.Addr = "localhost:" + strconv.Itoa(conf.ConsolePort)
+	server.Addr = net.JoinHostPort(host, strconv.Itoa(conf.ConsolePort))
+	server.CertFile = conf.ConsoleCertFile
+	server.KeyFile = conf.ConsoleKeyFile
 	server.MaxConnNum = int(math.MaxInt32)
 	server.PendingWriteNum = 100
 	server.NewAgent = newAgent
@@ -35,6 +47,9 @@ func Destroy() {
 type Agent struct {
 	conn   *network.TCPConn
 	reader *bufio.Reader
+	// character mode, see ConsoleTelnet
+	telnet bool
+	cr     bool
 }
 
 func newAgent(conn *network.TCPConn) network.Agent {
@@ -45,16 +60,49 @@ func newAgent(conn *network.TCPConn) network.Agent {
 }
 
 func (a *Agent) Run() {
+	addr := a.conn.RemoteAddr().String()
+	if conf.ConsoleTelnet {
+		a.negotiate()
+	}
+
+	u := &User{}
+	if authRequired() {
+		if locked(addr) {
+			a.conn.Write([]byte("too many failures, try again later\r\n"))
+			return
+		}
+		if hasACL() {
+			a.conn.Write([]byte("Token: "))
+		} else {
+			a.conn.Write([]byte("Password: "))
+		}
+		line, err := a.readLine(u, false)
+		if err != nil {
+			return
+		}
+		u = authenticate(addr, strings.TrimSpace(line))
+		if u == nil {
+			a.conn.Write([]byte("authentication failed\r\n"))
+			return
+		}
+	}
+
 	for {
 		if conf.ConsolePrompt != "" {
 			a.conn.Write([]byte(conf.ConsolePrompt))
 		}
 
-		line, err := a.reader.ReadString('\n')
+		line, err := a.readLine(u, true)
 		if err != nil {
 			break
 		}
-		line = strings.TrimSuffix(line[:len(line)-1], "\r")
+		// line mode clients complete by a tab at the end
+		if strings.HasSuffix(line, "\t") {
+			if matches := complete(u, strings.TrimSuffix(line, "\t")); len(matches) > 0 {
+				a.conn.Write([]byte(strings.Join(matches, "  ") + "\r\n"))
+			}
+			continue
+		}
 
 		args := strings.Fields(line)
 		if len(args) == 0 {
@@ -63,18 +111,10 @@ func (a *Agent) Run() {
 		if args[0] == "quit" {
 			break
 		}
-		var c Command
-		for _, _c := range commands {
-			if _c.name() == args[0] {
-				c = _c
-				break
-			}
-		}
-		if c == nil {
-			a.conn.Write([]byte("command not found, try `help` for help\r\n"))
-			continue
+		output, err := exec(u, addr, line)
+		if err != nil {
+			output = err.Error()
 		}
-		output := c.run(args[1:])
 		if output != "" {
 			a.conn.Write([]byte(output + "\r\n"))
 		}
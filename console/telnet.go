@@ -0,0 +1,152 @@
+package console
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/czx-lab/leaf/conf"
+)
+
+// telnet commands and options
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWill = 251
+	telnetWont = 252
+	telnetDo   = 253
+	telnetDont = 254
+	telnetIAC  = 255
+
+	telnetEcho = 1
+	telnetSGA  = 3
+)
+
+// negotiate makes a telnet client send the characters as typed and leave
+// the echo to the console
+func (a *Agent) negotiate() {
+	a.conn.Write([]byte{telnetIAC, telnetWill, telnetEcho, telnetIAC, telnetWill, telnetSGA})
+	a.telnet = true
+}
+
+// readLine reads a line, edited and completed for u in the character mode
+func (a *Agent) readLine(u *User, echo bool) (string, error) {
+	if !a.telnet {
+		line, err := a.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+	}
+
+	var line []byte
+	for {
+		b, err := a.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		// the LF or NUL after a CR
+		if a.cr {
+			a.cr = false
+			if b == '\n' || b == 0 {
+				continue
+			}
+		}
+
+		switch {
+		case b == telnetIAC:
+			if err := a.skipCommand(); err != nil {
+				return "", err
+			}
+		case b == '\r' || b == '\n':
+			a.cr = b == '\r'
+			a.conn.Write([]byte("\r\n"))
+			return string(line), nil
+		case b == 0x7f || b == '\b':
+			if len(line) > 0 {
+				_, n := utf8.DecodeLastRune(line)
+				line = line[:len(line)-n]
+				if echo {
+					a.conn.Write([]byte("\b \b"))
+				}
+			}
+		case b == 0x03: // ctrl-c
+			a.conn.Write([]byte("^C\r\n"))
+			return "", nil
+		case b == 0x04: // ctrl-d
+			if len(line) == 0 {
+				return "quit", nil
+			}
+		case b == 0x1b: // escape sequences, e.g. the arrows
+			if next, err := a.reader.ReadByte(); err == nil && next == '[' {
+				for {
+					c, err := a.reader.ReadByte()
+					if err != nil || c >= 0x40 && c <= 0x7e {
+						break
+					}
+				}
+			}
+		case b == '\t':
+			if echo {
+				line = a.complete(u, line)
+			}
+		case b >= 0x20:
+			line = append(line, b)
+			if echo {
+				a.conn.Write([]byte{b})
+			}
+		}
+	}
+}
+
+// skipCommand skips a telnet command after IAC
+func (a *Agent) skipCommand() error {
+	b, err := a.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case telnetWill, telnetWont, telnetDo, telnetDont:
+		_, err = a.reader.ReadByte()
+	case telnetSB:
+		for err == nil {
+			if b, err = a.reader.ReadByte(); err == nil && b == telnetIAC {
+				if b, err = a.reader.ReadByte(); err == nil && b == telnetSE {
+					break
+				}
+			}
+		}
+	}
+	return err
+}
+
+// complete completes the last word of line, or lists the candidates
+func (a *Agent) complete(u *User, line []byte) []byte {
+	matches := complete(u, string(line))
+	if len(matches) == 0 {
+		return line
+	}
+
+	fields := strings.Fields(string(line))
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(string(line), " ") {
+		word = fields[len(fields)-1]
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	suffix := prefix[len(word):]
+	if len(matches) == 1 {
+		suffix += " "
+	}
+	if suffix != "" {
+		a.conn.Write([]byte(suffix))
+		return append(line, suffix...)
+	}
+
+	a.conn.Write([]byte("\r\n" + strings.Join(matches, "  ") + "\r\n" + conf.ConsolePrompt + string(line)))
+	return line
+}
@@ -1,37 +1,37 @@
 package mongodb_test
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/czx-lab/leaf/db/mongodb"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func Example() {
-	c, err := mongodb.Dial("localhost", 10)
+	c, err := mongodb.Dial("mongodb://localhost", 10)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	defer c.Close()
+	ctx := context.Background()
 
-	// session
-	s := c.Ref()
-	defer c.UnRef(s)
-	err = s.DB("test").C("counters").RemoveId("test")
-	if err != nil && err != mgo.ErrNotFound {
+	// collection
+	_, err = c.C("test", "counters").DeleteOne(ctx, bson.M{"_id": "test"})
+	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
 	// auto increment
-	err = c.EnsureCounter("test", "counters", "test")
+	err = c.EnsureCounter(ctx, "test", "counters", "test")
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	for i := 0; i < 3; i++ {
-		id, err := c.NextSeq("test", "counters", "test")
+		id, err := c.NextSeq(ctx, "test", "counters", "test")
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -40,7 +40,7 @@ func Example() {
 	}
 
 	// index
-	c.EnsureUniqueIndex("test", "counters", []string{"key1"})
+	c.EnsureUniqueIndex(ctx, "test", "counters", []string{"key1"})
 
 	// Output:
 	// 1
@@ -1,55 +1,22 @@
 package mongodb
 
 import (
-	"container/heap"
-	"sync"
+	"context"
+	"strings"
 	"time"
 
+	g "github.com/czx-lab/leaf/go"
 	"github.com/czx-lab/leaf/log"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// session
-type Session struct {
-	*mgo.Session
-	ref   int
-	index int
-}
-
-// session heap
-type SessionHeap []*Session
-
-func (h SessionHeap) Len() int {
-	return len(h)
-}
-
-func (h SessionHeap) Less(i, j int) bool {
-	return h[i].ref < h[j].ref
-}
-
-func (h SessionHeap) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-	h[i].index = i
-	h[j].index = j
-}
-
-func (h *SessionHeap) Push(s interface{}) {
-	s.(*Session).index = len(*h)
-	*h = append(*h, s.(*Session))
-}
-
-func (h *SessionHeap) Pop() interface{} {
-	l := len(*h)
-	s := (*h)[l-1]
-	s.index = -1
-	*h = (*h)[:l-1]
-	return s
-}
-
+// DialContext is a pool of connections to a deployment, the operations take
+// a context and block, call them in a goroutine, e.g. with Skeleton.Go, or
+// use Async
 type DialContext struct {
-	sync.Mutex
-	sessions SessionHeap
+	Client *mongo.Client
 }
 
 // goroutine safe
@@ -58,6 +25,8 @@ func Dial(url string, sessionNum int) (*DialContext, error) {
 	return c, err
 }
 
+// DialWithTimeout keeps up to sessionNum connections, timeout bounds the
+// operations without a deadline
 // goroutine safe
 func DialWithTimeout(url string, sessionNum int, dialTimeout time.Duration, timeout time.Duration) (*DialContext, error) {
 	if sessionNum <= 0 {
@@ -65,70 +34,96 @@ func DialWithTimeout(url string, sessionNum int, dialTimeout time.Duration, time
 		log.Release("invalid sessionNum, reset to %v", sessionNum)
 	}
 
-	s, err := mgo.DialWithTimeout(url, dialTimeout)
+	// e.g. "localhost" as with mgo
+	if !strings.Contains(url, "://") {
+		url = "mongodb://" + url
+	}
+	opts := options.Client().
+		ApplyURI(url).
+		SetMaxPoolSize(uint64(sessionNum)).
+		SetConnectTimeout(dialTimeout).
+		SetServerSelectionTimeout(dialTimeout).
+		SetTimeout(timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return DialWithOptions(ctx, opts)
+}
+
+// DialWithOptions connects with opts, the deployment is pinged
+// goroutine safe
+func DialWithOptions(ctx context.Context, opts *options.ClientOptions) (*DialContext, error) {
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
-	s.SetSyncTimeout(timeout)
-	s.SetSocketTimeout(timeout)
-
-	c := new(DialContext)
-
-	// sessions
-	c.sessions = make(SessionHeap, sessionNum)
-	c.sessions[0] = &Session{s, 0, 0}
-	for i := 1; i < sessionNum; i++ {
-		c.sessions[i] = &Session{s.New(), 0, i}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
 	}
-	heap.Init(&c.sessions)
-
-	return c, nil
+	return &DialContext{Client: client}, nil
 }
 
 // goroutine safe
 func (c *DialContext) Close() {
-	c.Lock()
-	for _, s := range c.sessions {
-		s.Close()
-		if s.ref != 0 {
-			log.Error("session ref = %v", s.ref)
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Client.Disconnect(ctx); err != nil {
+		log.Error("mongodb: disconnect: %v", err)
 	}
-	c.Unlock()
 }
 
 // goroutine safe
-func (c *DialContext) Ref() *Session {
-	c.Lock()
-	s := c.sessions[0]
-	if s.ref == 0 {
-		s.Refresh()
-	}
-	s.ref++
-	heap.Fix(&c.sessions, 0)
-	c.Unlock()
-
-	return s
+func (c *DialContext) C(db string, collection string) *mongo.Collection {
+	return c.Client.Database(db).Collection(collection)
 }
 
+// Async calls f in a goroutine of g and cb with its error in the goroutine
+// of g, the results are passed by the closures
+//
+//	var doc Player
+//	c.Async(skeleton, func(ctx context.Context) error {
+//		return c.C("game", "players").FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+//	}, func(err error) {
+//		// in the skeleton goroutine
+//	})
+//
 // goroutine safe
-func (c *DialContext) UnRef(s *Session) {
-	c.Lock()
-	s.ref--
-	heap.Fix(&c.sessions, s.index)
-	c.Unlock()
+func (c *DialContext) Async(g g.Goer, f func(ctx context.Context) error, cb func(err error)) {
+	var err error
+	g.Go(func() {
+		err = f(context.Background())
+	}, func() {
+		if cb != nil {
+			cb(err)
+		}
+	})
 }
 
+// WithTransaction calls f in a transaction, committed if f returns nil and
+// retried on the transient errors, so f may be called more than once. The
+// operations of the transaction take the context passed to f. A replica
+// set is required
 // goroutine safe
-func (c *DialContext) EnsureCounter(db string, collection string, id string) error {
-	s := c.Ref()
-	defer c.UnRef(s)
+func (c *DialContext) WithTransaction(ctx context.Context, f func(ctx mongo.SessionContext) error) error {
+	session, err := c.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
 
-	err := s.DB(db).C(collection).Insert(bson.M{
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, f(sc)
+	})
+	return err
+}
+
+// goroutine safe
+func (c *DialContext) EnsureCounter(ctx context.Context, db string, collection string, id string) error {
+	_, err := c.C(db, collection).InsertOne(ctx, bson.M{
 		"_id": id,
 		"seq": 0,
 	})
-	if mgo.IsDup(err) {
+	if mongo.IsDuplicateKeyError(err) {
 		return nil
 	} else {
 		return err
@@ -136,41 +131,147 @@ func (c *DialContext) EnsureCounter(db string, collection string, id string) err
 }
 
 // goroutine safe
-func (c *DialContext) NextSeq(db string, collection string, id string) (int, error) {
-	s := c.Ref()
-	defer c.UnRef(s)
-
+func (c *DialContext) NextSeq(ctx context.Context, db string, collection string, id string) (int, error) {
 	var res struct {
 		Seq int
 	}
-	_, err := s.DB(db).C(collection).FindId(id).Apply(mgo.Change{
-		Update:    bson.M{"$inc": bson.M{"seq": 1}},
-		ReturnNew: true,
-	}, &res)
+	err := c.C(db, collection).FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&res)
 
 	return res.Seq, err
 }
 
+// indexKeys converts the keys, a key prefixed with "-" is descending
+func indexKeys(key []string) bson.D {
+	keys := make(bson.D, 0, len(key))
+	for _, k := range key {
+		if name, ok := strings.CutPrefix(k, "-"); ok {
+			keys = append(keys, bson.E{Key: name, Value: -1})
+		} else {
+			keys = append(keys, bson.E{Key: k, Value: 1})
+		}
+	}
+	return keys
+}
+
 // goroutine safe
-func (c *DialContext) EnsureIndex(db string, collection string, key []string) error {
-	s := c.Ref()
-	defer c.UnRef(s)
+func (c *DialContext) EnsureIndex(ctx context.Context, db string, collection string, key []string) error {
+	_, err := c.C(db, collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    indexKeys(key),
+		Options: options.Index().SetUnique(false).SetSparse(true),
+	})
+	return err
+}
 
-	return s.DB(db).C(collection).EnsureIndex(mgo.Index{
-		Key:    key,
-		Unique: false,
-		Sparse: true,
+// goroutine safe
+func (c *DialContext) EnsureUniqueIndex(ctx context.Context, db string, collection string, key []string) error {
+	_, err := c.C(db, collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    indexKeys(key),
+		Options: options.Index().SetUnique(true).SetSparse(true),
 	})
+	return err
+}
+
+// KVStore is a key-value store on a collection, e.g. a gate.StateStore.
+// Call EnsureTTLIndex once to have expired entries removed by the server.
+type KVStore struct {
+	Dial       *DialContext
+	DB         string
+	Collection string
+}
+
+type kvEntry struct {
+	Key     string    `bson:"_id"`
+	Data    []byte    `bson:"data"`
+	Expires time.Time `bson:"expires"`
 }
 
 // goroutine safe
-func (c *DialContext) EnsureUniqueIndex(db string, collection string, key []string) error {
-	s := c.Ref()
-	defer c.UnRef(s)
+func (s *KVStore) Save(key string, data []byte, ttl time.Duration) error {
+	_, err := s.Dial.C(s.DB, s.Collection).ReplaceOne(context.Background(), bson.M{"_id": key}, &kvEntry{
+		Key:     key,
+		Data:    data,
+		Expires: time.Now().Add(ttl),
+	}, options.Replace().SetUpsert(true))
+	return err
+}
 
-	return s.DB(db).C(collection).EnsureIndex(mgo.Index{
-		Key:    key,
-		Unique: true,
-		Sparse: true,
+// goroutine safe
+func (s *KVStore) Load(key string) ([]byte, error) {
+	var e kvEntry
+	err := s.Dial.C(s.DB, s.Collection).FindOne(context.Background(), bson.M{"_id": key}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(e.Expires) {
+		return nil, nil
+	}
+	return e.Data, nil
+}
+
+// goroutine safe
+func (s *KVStore) Delete(key string) error {
+	_, err := s.Dial.C(s.DB, s.Collection).DeleteOne(context.Background(), bson.M{"_id": key})
+	return err
+}
+
+// goroutine safe
+func (s *KVStore) EnsureTTLIndex() error {
+	_, err := s.Dial.C(s.DB, s.Collection).Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(1),
 	})
+	return err
+}
+
+// TimerStore records the persistent timers on a collection, a
+// timer.TimerStore
+type TimerStore struct {
+	Dial       *DialContext
+	DB         string
+	Collection string
+}
+
+type timerEntry struct {
+	Key string    `bson:"_id"`
+	At  time.Time `bson:"at"`
+}
+
+// goroutine safe
+func (s *TimerStore) Save(key string, at time.Time) error {
+	_, err := s.Dial.C(s.DB, s.Collection).ReplaceOne(context.Background(), bson.M{"_id": key}, &timerEntry{
+		Key: key,
+		At:  at,
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// goroutine safe
+func (s *TimerStore) Delete(key string) error {
+	_, err := s.Dial.C(s.DB, s.Collection).DeleteOne(context.Background(), bson.M{"_id": key})
+	return err
+}
+
+// goroutine safe
+func (s *TimerStore) LoadAll() (map[string]time.Time, error) {
+	ctx := context.Background()
+	cursor, err := s.Dial.C(s.DB, s.Collection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var entries []timerEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	records := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		records[e.Key] = e.At
+	}
+	return records, nil
 }
@@ -0,0 +1,122 @@
+package mongodb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeEvent is a change stream event, FullDocument is set for inserts,
+// replaces and, with the update lookup, updates.
+type ChangeEvent struct {
+	ResumeToken       bson.Raw `bson:"_id"`
+	OperationType     string   `bson:"operationType"`
+	DocumentKey       bson.M   `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription *struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+type ChangeStream struct {
+	coll     *mongo.Collection
+	pipeline []bson.M
+	server   *chanrpc.Server
+	id       interface{}
+	token    bson.Raw
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// Watch subscribes to the change stream of a collection (a replica set is
+// required) and delivers every event to server as a call of id with the
+// *ChangeEvent as the only argument. pipeline filters the events, it may be
+// nil. ctx bounds the opening, the stream resumes after errors until it's
+// closed
+// goroutine safe
+func (c *DialContext) Watch(ctx context.Context, db string, collection string, pipeline []bson.M, server *chanrpc.Server, id interface{}) (*ChangeStream, error) {
+	cs := &ChangeStream{
+		coll:     c.C(db, collection),
+		pipeline: pipeline,
+		server:   server,
+		id:       id,
+	}
+	if cs.pipeline == nil {
+		cs.pipeline = []bson.M{}
+	}
+
+	// fail early on a standalone server or a bad pipeline
+	stream, err := cs.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var runCtx context.Context
+	runCtx, cs.cancel = context.WithCancel(context.Background())
+	cs.wg.Add(1)
+	go cs.run(runCtx, stream)
+	return cs, nil
+}
+
+func (cs *ChangeStream) open(ctx context.Context) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if cs.token != nil {
+		opts.SetResumeAfter(cs.token)
+	}
+	return cs.coll.Watch(ctx, cs.pipeline, opts)
+}
+
+func (cs *ChangeStream) run(ctx context.Context, stream *mongo.ChangeStream) {
+	defer cs.wg.Done()
+
+	for {
+		if stream == nil {
+			var err error
+			stream, err = cs.open(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Error("watch %v: %v", cs.coll.Name(), err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+		}
+
+		// the driver resumes after the resumable errors
+		for stream.Next(ctx) {
+			e := new(ChangeEvent)
+			if err := stream.Decode(e); err != nil {
+				log.Error("watch %v: %v", cs.coll.Name(), err)
+				continue
+			}
+			cs.token = e.ResumeToken
+			cs.server.Go(cs.id, e)
+		}
+		err := stream.Err()
+		stream.Close(context.Background())
+		stream = nil
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error("watch %v: %v", cs.coll.Name(), err)
+	}
+}
+
+// Close stops the stream, no event is delivered after it returns
+// goroutine safe
+func (cs *ChangeStream) Close() {
+	cs.cancel()
+	cs.wg.Wait()
+}
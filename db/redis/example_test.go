@@ -0,0 +1,43 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/czx-lab/leaf/db/redis"
+)
+
+func Example() {
+	c, err := redis.Dial("localhost:6379", 10)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	// ranking
+	r := &redis.Ranking{Client: c, Key: "test:ranking"}
+	r.Set(ctx, "alice", 300)
+	r.Set(ctx, "bob", 200)
+	r.Incr(ctx, "bob", 200)
+	top, err := r.Range(ctx, 0, 9)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, e := range top {
+		fmt.Println(e.Rank, e.Member, e.Score)
+	}
+
+	// lock
+	l, err := c.Lock(ctx, "test:lock", 10*time.Second)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = c.Lock(ctx, "test:lock", 10*time.Second)
+	fmt.Println(err)
+	l.Unlock(ctx)
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Message is a message received on a channel
+type Message struct {
+	Channel string
+	Payload string
+}
+
+type Subscription struct {
+	pubsub    *goredis.PubSub
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Watch delivers the messages published to channels to server as calls
+// of id with the *Message as the only argument. A channel may be a pattern,
+// e.g. "chat.*", if pattern
+// goroutine safe
+func (c *Client) Watch(server *chanrpc.Server, id interface{}, pattern bool, channels ...string) (*Subscription, error) {
+	ctx := context.Background()
+	var pubsub *goredis.PubSub
+	if pattern {
+		pubsub = c.PSubscribe(ctx, channels...)
+	} else {
+		pubsub = c.Subscribe(ctx, channels...)
+	}
+	// fail early if not connected
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	s := &Subscription{pubsub: pubsub}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// reconnects until closed
+		for m := range pubsub.Channel() {
+			server.Go(id, &Message{Channel: m.Channel, Payload: m.Payload})
+		}
+	}()
+	return s, nil
+}
+
+// Close stops the subscription, no message is delivered after it returns
+// goroutine safe
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.pubsub.Close()
+		s.wg.Wait()
+	})
+}
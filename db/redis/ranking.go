@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Ranking is a leaderboard on the sorted set Key, the highest score ranks
+// first
+type Ranking struct {
+	Client *Client
+	Key    string
+}
+
+type RankEntry struct {
+	Member string
+	Score  float64
+	// from 0
+	Rank int64
+}
+
+// goroutine safe
+func (r *Ranking) Set(ctx context.Context, member string, score float64) error {
+	return r.Client.ZAdd(ctx, r.Key, goredis.Z{Score: score, Member: member}).Err()
+}
+
+// Incr adds delta to the score of member and returns the new score
+// goroutine safe
+func (r *Ranking) Incr(ctx context.Context, member string, delta float64) (float64, error) {
+	return r.Client.ZIncrBy(ctx, r.Key, delta, member).Result()
+}
+
+// goroutine safe
+func (r *Ranking) Remove(ctx context.Context, member string) error {
+	return r.Client.ZRem(ctx, r.Key, member).Err()
+}
+
+// Get returns the rank and the score of member, false if not ranked
+// goroutine safe
+func (r *Ranking) Get(ctx context.Context, member string) (RankEntry, bool, error) {
+	pipe := r.Client.Pipeline()
+	rank := pipe.ZRevRank(ctx, r.Key, member)
+	score := pipe.ZScore(ctx, r.Key, member)
+	if _, err := pipe.Exec(ctx); err == goredis.Nil {
+		return RankEntry{}, false, nil
+	} else if err != nil {
+		return RankEntry{}, false, err
+	}
+	return RankEntry{Member: member, Score: score.Val(), Rank: rank.Val()}, true, nil
+}
+
+// Range returns the entries ranked from start to stop inclusive, e.g. 0
+// and 9 for the top 10
+// goroutine safe
+func (r *Ranking) Range(ctx context.Context, start int64, stop int64) ([]RankEntry, error) {
+	zs, err := r.Client.ZRevRangeWithScores(ctx, r.Key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]RankEntry, len(zs))
+	for i, z := range zs {
+		entries[i] = RankEntry{
+			Member: z.Member.(string),
+			Score:  z.Score,
+			Rank:   start + int64(i),
+		}
+	}
+	return entries, nil
+}
+
+// Around returns the entries ranked within n of member, nil if not ranked
+// goroutine safe
+func (r *Ranking) Around(ctx context.Context, member string, n int64) ([]RankEntry, error) {
+	rank, err := r.Client.ZRevRank(ctx, r.Key, member).Result()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Range(ctx, max(rank-n, 0), rank+n)
+}
+
+// goroutine safe
+func (r *Ranking) Len(ctx context.Context) (int64, error) {
+	return r.Client.ZCard(ctx, r.Key).Result()
+}
@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	g "github.com/czx-lab/leaf/go"
+	"github.com/czx-lab/leaf/log"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Client is a pooled client. The commands of the embedded client block,
+// call them in a goroutine, e.g. with Skeleton.Go, or use Async
+type Client struct {
+	*goredis.Client
+}
+
+// goroutine safe
+func Dial(addr string, poolSize int) (*Client, error) {
+	if poolSize <= 0 {
+		poolSize = 100
+		log.Release("invalid poolSize, reset to %v", poolSize)
+	}
+	return DialWithOptions(&goredis.Options{
+		Addr:     addr,
+		PoolSize: poolSize,
+	})
+}
+
+// DialWithOptions connects with opt, the server is pinged
+// goroutine safe
+func DialWithOptions(opt *goredis.Options) (*Client, error) {
+	c := &Client{goredis.NewClient(opt)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Ping(ctx).Err(); err != nil {
+		c.Client.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// goroutine safe
+func (c *Client) Close() {
+	if err := c.Client.Close(); err != nil {
+		log.Error("redis: close: %v", err)
+	}
+}
+
+// Async calls f in a goroutine of g and cb with its error in the goroutine
+// of g, the results are passed by the closures
+//
+//	var score float64
+//	c.Async(skeleton, func(ctx context.Context) (err error) {
+//		score, err = c.ZScore(ctx, "ranking", userID).Result()
+//		return
+//	}, func(err error) {
+//		// in the skeleton goroutine
+//	})
+//
+// goroutine safe
+func (c *Client) Async(g g.Goer, f func(ctx context.Context) error, cb func(err error)) {
+	var err error
+	g.Go(func() {
+		err = f(context.Background())
+	}, func() {
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+var ErrLocked = errors.New("redis: locked")
+
+// Lock is a distributed lock held until Unlock or its ttl expires
+type Lock struct {
+	c     *Client
+	key   string
+	token string
+}
+
+var (
+	unlockScript = goredis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+	refreshScript = goredis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Lock acquires the lock of key for ttl, ErrLocked if held by another
+// goroutine safe
+func (c *Client) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+
+	ok, err := c.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+	return &Lock{c: c, key: key, token: token}, nil
+}
+
+// Refresh extends the lock to ttl from now, ErrLocked if lost
+// goroutine safe
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	n, err := refreshScript.Run(ctx, l.c, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLocked
+	}
+	return nil
+}
+
+// Unlock releases the lock, nothing happens if it's lost
+// goroutine safe
+func (l *Lock) Unlock(ctx context.Context) error {
+	return unlockScript.Run(ctx, l.c, []string{l.key}, l.token).Err()
+}
@@ -0,0 +1,33 @@
+package sql_test
+
+import (
+	stdsql "database/sql"
+	"fmt"
+
+	"github.com/czx-lab/leaf/db/sql"
+	g "github.com/czx-lab/leaf/go"
+)
+
+func Example() {
+	// with a driver imported, e.g. _ "github.com/go-sql-driver/mysql"
+	db, err := sql.Open("mysql", "root@tcp(localhost:3306)/test", 10)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+
+	// the callbacks are called by Cb, e.g. in the skeleton goroutine
+	d := g.New(10)
+	var name string
+	db.Query(d, "SELECT name FROM players WHERE id = ?", []interface{}{1},
+		func(rows *stdsql.Rows) error {
+			if rows.Next() {
+				return rows.Scan(&name)
+			}
+			return nil
+		}, func(err error) {
+			fmt.Println(name, err)
+		})
+	d.Cb(<-d.ChanCb)
+}
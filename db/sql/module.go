@@ -0,0 +1,41 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// Module opens DB when the modules are initialized and closes it when they
+// are destroyed, register it in leaf.Run before the modules using DB
+type Module struct {
+	Driver string
+	DSN    string
+	// 100 if zero
+	MaxOpen         int
+	ConnMaxLifetime time.Duration
+	SlowThreshold   time.Duration
+
+	DB *DB
+}
+
+func (m *Module) OnInit() {
+	if m.MaxOpen == 0 {
+		m.MaxOpen = 100
+	}
+	db, err := Open(m.Driver, m.DSN, m.MaxOpen)
+	if err != nil {
+		log.Fatal("sql: open %v: %v", m.Driver, err)
+	}
+	db.SQL.SetConnMaxLifetime(m.ConnMaxLifetime)
+	db.SlowThreshold = m.SlowThreshold
+	m.DB = db
+}
+
+func (m *Module) Run(closeSig chan bool) {
+	<-closeSig
+}
+
+func (m *Module) OnDestroy() {
+	m.DB.Close()
+}
@@ -0,0 +1,203 @@
+// Package sql wraps database/sql for the modules: the statements are
+// prepared once, the slow ones are logged and the async helpers call back in
+// the goroutine of a skeleton. Import a driver, e.g. go-sql-driver/mysql
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	g "github.com/czx-lab/leaf/go"
+	"github.com/czx-lab/leaf/log"
+)
+
+// DB is a pool of connections. The statements run with the helpers are
+// prepared once and cached, the ones slower than SlowThreshold are logged
+type DB struct {
+	SQL *sql.DB
+	// zero disables the slow query log
+	SlowThreshold time.Duration
+
+	mutexStmts sync.Mutex
+	stmts      map[string]*sql.Stmt
+}
+
+// Open opens a pool of up to maxOpen connections, the database is pinged
+// goroutine safe
+func Open(driverName string, dsn string, maxOpen int) (*DB, error) {
+	if maxOpen <= 0 {
+		maxOpen = 100
+		log.Release("invalid maxOpen, reset to %v", maxOpen)
+	}
+
+	sqlDB, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxOpen)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return &DB{SQL: sqlDB, stmts: make(map[string]*sql.Stmt)}, nil
+}
+
+// Close closes the statements and the connections
+// goroutine safe
+func (db *DB) Close() {
+	db.mutexStmts.Lock()
+	for _, stmt := range db.stmts {
+		stmt.Close()
+	}
+	db.stmts = nil
+	db.mutexStmts.Unlock()
+
+	if err := db.SQL.Close(); err != nil {
+		log.Error("sql: close: %v", err)
+	}
+}
+
+// Stmt returns the statement of query, prepared at the first call
+// goroutine safe
+func (db *DB) Stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	db.mutexStmts.Lock()
+	stmt := db.stmts[query]
+	db.mutexStmts.Unlock()
+	if stmt != nil {
+		return stmt, nil
+	}
+
+	stmt, err := db.SQL.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	db.mutexStmts.Lock()
+	defer db.mutexStmts.Unlock()
+	if db.stmts == nil {
+		stmt.Close()
+		return nil, sql.ErrConnDone
+	}
+	// prepared concurrently
+	if other := db.stmts[query]; other != nil {
+		stmt.Close()
+		return other, nil
+	}
+	db.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (db *DB) slow(query string, begin time.Time) {
+	if db.SlowThreshold <= 0 {
+		return
+	}
+	if d := time.Since(begin); d >= db.SlowThreshold {
+		log.Release("sql: slow query (%v): %v", d, query)
+	}
+}
+
+// QueryContext runs the statement of query and returns its rows
+// goroutine safe
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := db.Stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer db.slow(query, time.Now())
+	return stmt.QueryContext(ctx, args...)
+}
+
+// ExecContext runs the statement of query
+// goroutine safe
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := db.Stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer db.slow(query, time.Now())
+	return stmt.ExecContext(ctx, args...)
+}
+
+// Query runs query in a goroutine of g, scan reads the rows there and cb is
+// called with the error in the goroutine of g
+//
+//	var name string
+//	db.Query(skeleton, "SELECT name FROM players WHERE id = ?", []interface{}{id},
+//		func(rows *sql.Rows) error {
+//			if rows.Next() {
+//				return rows.Scan(&name)
+//			}
+//			return nil
+//		}, func(err error) {
+//			// in the skeleton goroutine
+//		})
+//
+// goroutine safe
+func (db *DB) Query(g g.Goer, query string, args []interface{}, scan func(rows *sql.Rows) error, cb func(err error)) {
+	var err error
+	g.Go(func() {
+		var rows *sql.Rows
+		rows, err = db.QueryContext(context.Background(), query, args...)
+		if err != nil {
+			return
+		}
+		defer rows.Close()
+		if scan != nil {
+			if err = scan(rows); err != nil {
+				return
+			}
+		}
+		err = rows.Err()
+	}, func() {
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+// Exec runs query in a goroutine of g and calls cb with its result in the
+// goroutine of g
+// goroutine safe
+func (db *DB) Exec(g g.Goer, query string, args []interface{}, cb func(res sql.Result, err error)) {
+	var res sql.Result
+	var err error
+	g.Go(func() {
+		res, err = db.ExecContext(context.Background(), query, args...)
+	}, func() {
+		if cb != nil {
+			cb(res, err)
+		}
+	})
+}
+
+// Tx calls f in a transaction in a goroutine of g, committed if f returns
+// nil, and cb with the error in the goroutine of g. The statements of f
+// aren't cached
+// goroutine safe
+func (db *DB) Tx(g g.Goer, f func(tx *sql.Tx) error, cb func(err error)) {
+	var err error
+	g.Go(func() {
+		err = db.tx(context.Background(), f)
+	}, func() {
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+func (db *DB) tx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := db.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,105 @@
+package event
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+)
+
+// Bus delivers published events to subscribers through their chanrpc server,
+// so a subscriber always handles events in its own goroutine (skeleton).
+// goroutine safe
+type Bus struct {
+	sync.RWMutex
+	subs    map[reflect.Type][]*Subscription
+	lastSub int
+}
+
+type Subscription struct {
+	bus    *Bus
+	t      reflect.Type
+	server *chanrpc.Server
+	id     subID
+}
+
+type subID struct {
+	bus *Bus
+	n   int
+}
+
+func NewBus() *Bus {
+	b := new(Bus)
+	b.subs = make(map[reflect.Type][]*Subscription)
+	return b
+}
+
+func (b *Bus) subscribe(t reflect.Type, server *chanrpc.Server, f func(interface{})) *Subscription {
+	b.Lock()
+	b.lastSub++
+	sub := &Subscription{bus: b, t: t, server: server, id: subID{b, b.lastSub}}
+	b.subs[t] = append(b.subs[t], sub)
+	b.Unlock()
+
+	server.Register(sub.id, func(args []interface{}) {
+		f(args[0])
+	})
+	return sub
+}
+
+// the event type is the dynamic type of event
+// the handler is registered on server, so you must call the function before
+// calling Open and Go on server (e.g. in OnInit)
+func (b *Bus) Subscribe(event interface{}, server *chanrpc.Server, f func(event interface{})) *Subscription {
+	t := reflect.TypeOf(event)
+	if t == nil {
+		panic("invalid event type")
+	}
+	return b.subscribe(t, server, f)
+}
+
+func (b *Bus) Publish(event interface{}) {
+	t := reflect.TypeOf(event)
+
+	b.RLock()
+	subs := b.subs[t]
+	b.RUnlock()
+
+	for _, sub := range subs {
+		sub.server.Go(sub.id, event)
+	}
+}
+
+func (sub *Subscription) Unsubscribe() {
+	b := sub.bus
+	b.Lock()
+	defer b.Unlock()
+
+	subs := b.subs[sub.t]
+	for i, s := range subs {
+		if s == sub {
+			// copy on write, Publish may still range over the old slice
+			n := make([]*Subscription, 0, len(subs)-1)
+			n = append(n, subs[:i]...)
+			b.subs[sub.t] = append(n, subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// typed subscription, T is the event type
+func Subscribe[T any](b *Bus, server *chanrpc.Server, f func(event T)) *Subscription {
+	return b.subscribe(reflect.TypeFor[T](), server, func(event interface{}) {
+		f(event.(T))
+	})
+}
+
+var defaultBus = NewBus()
+
+func Default() *Bus {
+	return defaultBus
+}
+
+func Publish(event interface{}) {
+	defaultBus.Publish(event)
+}
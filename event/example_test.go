@@ -0,0 +1,30 @@
+package event_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/event"
+)
+
+type PlayerLogin struct {
+	Name string
+}
+
+func Example() {
+	bus := event.NewBus()
+	s := chanrpc.NewServer(10)
+
+	event.Subscribe(bus, s, func(ev *PlayerLogin) {
+		fmt.Println(ev.Name, "logged in")
+	})
+
+	bus.Publish(&PlayerLogin{Name: "Leaf"})
+	bus.Publish("not subscribed")
+
+	// the subscriber goroutine
+	s.Exec(<-s.ChanCall)
+
+	// Output:
+	// Leaf logged in
+}
@@ -0,0 +1,279 @@
+package gate
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/console"
+)
+
+// AccessList checks the IPs of the connections when they are accepted. The
+// IPs and CIDRs of Allow and Deny are parsed by Run, a connection matching
+// Deny or a ban is refused, so is one not matching a non empty Allow
+type AccessList struct {
+	Allow []string
+	Deny  []string
+	// the connections of an IP at most, zero is unlimited
+	MaxConnsPerIP int
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	mutex sync.Mutex
+	// the zero time never expires
+	bans  map[netip.Prefix]time.Time
+	conns map[netip.Addr]int
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+func (l *AccessList) init() error {
+	allow, err := parsePrefixes(l.Allow)
+	if err != nil {
+		return fmt.Errorf("allow: %v", err)
+	}
+	deny, err := parsePrefixes(l.Deny)
+	if err != nil {
+		return fmt.Errorf("deny: %v", err)
+	}
+
+	l.mutex.Lock()
+	l.allow = allow
+	l.deny = deny
+	if l.bans == nil {
+		l.bans = make(map[netip.Prefix]time.Time)
+	}
+	l.conns = make(map[netip.Addr]int)
+	l.mutex.Unlock()
+	return nil
+}
+
+func contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(remoteIP(addr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// acquire reports whether the connection of ip is accepted and counts it
+func (l *AccessList) acquire(ip netip.Addr) (bool, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.allow) > 0 && !contains(l.allow, ip) {
+		return false, "not allowed"
+	}
+	if contains(l.deny, ip) {
+		return false, "denied"
+	}
+	now := time.Now()
+	for p, expire := range l.bans {
+		if !expire.IsZero() && now.After(expire) {
+			delete(l.bans, p)
+			continue
+		}
+		if p.Contains(ip) {
+			return false, "banned"
+		}
+	}
+	if l.MaxConnsPerIP > 0 && l.conns[ip] >= l.MaxConnsPerIP {
+		return false, "too many connections"
+	}
+	l.conns[ip]++
+	return true, ""
+}
+
+func (l *AccessList) release(ip netip.Addr) {
+	l.mutex.Lock()
+	if n := l.conns[ip]; n > 1 {
+		l.conns[ip] = n - 1
+	} else {
+		delete(l.conns, ip)
+	}
+	l.mutex.Unlock()
+}
+
+// Ban refuses the connections of an IP or CIDR for ttl, zero never expires.
+// The connections already accepted aren't closed. Flood bans here too
+// goroutine safe
+func (l *AccessList) Ban(cidr string, ttl time.Duration) error {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	l.mutex.Lock()
+	if l.bans == nil {
+		l.bans = make(map[netip.Prefix]time.Time)
+	}
+	l.bans[p] = expire
+	l.mutex.Unlock()
+	return nil
+}
+
+// Unban reports whether the IP or CIDR was banned
+// goroutine safe
+func (l *AccessList) Unban(cidr string) (bool, error) {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return false, err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, ok := l.bans[p]
+	delete(l.bans, p)
+	return ok, nil
+}
+
+// Banned reports whether ip is banned
+// goroutine safe
+func (l *AccessList) Banned(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	for p, expire := range l.bans {
+		if (expire.IsZero() || now.Before(expire)) && p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops the expired bans
+func (l *AccessList) prune(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for p, expire := range l.bans {
+		if !expire.IsZero() && now.After(expire) {
+			delete(l.bans, p)
+		}
+	}
+}
+
+// Bans returns the bans not expired and their expiration, zero if never
+// goroutine safe
+func (l *AccessList) Bans() map[string]time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	bans := make(map[string]time.Time, len(l.bans))
+	for p, expire := range l.bans {
+		if expire.IsZero() || now.Before(expire) {
+			bans[p.String()] = expire
+		}
+	}
+	return bans
+}
+
+// RegisterAccessCommand adds a console command banning, unbanning or
+// listing the banned addresses of the Access list
+// you must call the function before calling console.Init
+func (gate *Gate) RegisterAccessCommand(name string) {
+	console.RegisterFunc(name, "bans or unbans IPs and CIDRs", func(args []string) string {
+		usage := "Usage: " + name + " ban <ip|cidr> [ttl] | unban <ip|cidr> | list"
+		l := gate.Access
+		if l == nil {
+			return "no access list"
+		}
+		if len(args) == 0 {
+			return usage
+		}
+
+		switch args[0] {
+		case "ban":
+			if len(args) != 2 && len(args) != 3 {
+				return usage
+			}
+			var ttl time.Duration
+			if len(args) == 3 {
+				var err error
+				if ttl, err = time.ParseDuration(args[2]); err != nil {
+					return err.Error()
+				}
+			}
+			if err := l.Ban(args[1], ttl); err != nil {
+				return err.Error()
+			}
+			return "banned"
+		case "unban":
+			if len(args) != 2 {
+				return usage
+			}
+			ok, err := l.Unban(args[1])
+			if err != nil {
+				return err.Error()
+			}
+			if !ok {
+				return args[1] + " not banned"
+			}
+			return "unbanned"
+		case "list":
+			bans := l.Bans()
+			lines := make([]string, 0, len(bans))
+			for cidr, expire := range bans {
+				if expire.IsZero() {
+					lines = append(lines, cidr)
+				} else {
+					lines = append(lines, fmt.Sprintf("%v until %v", cidr, expire.Format(time.DateTime)))
+				}
+			}
+			sort.Strings(lines)
+			return strings.Join(lines, "\r\n")
+		default:
+			return usage
+		}
+	})
+}
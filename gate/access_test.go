@@ -0,0 +1,73 @@
+package gate
+
+import (
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	l := &AccessList{MaxConnsPerIP: 1}
+	tg := startGate(t, &Gate{Access: l})
+
+	c := tg.dial(t)
+	c.echo(1)
+	if n, err := c.readEcho(); err != nil || n != 1 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+
+	// over MaxConnsPerIP
+	c2 := tg.dial(t)
+	if !c2.closed() {
+		t.Fatal("second connection accepted")
+	}
+
+	// the slot is released with the connection
+	c.Close()
+	<-tg.CloseAgent
+	c = tg.dial(t)
+	c.echo(2)
+	if n, err := c.readEcho(); err != nil || n != 2 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+	c.Close()
+	<-tg.CloseAgent
+
+	// banned, the connections already accepted aren't closed
+	c = tg.dial(t)
+	c.echo(3)
+	if n, err := c.readEcho(); err != nil || n != 3 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+	if err := l.Ban("127.0.0.0/8", 0); err != nil {
+		t.Fatal(err)
+	}
+	c.echo(4)
+	if n, err := c.readEcho(); err != nil || n != 4 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+	c.Close()
+	<-tg.CloseAgent
+	if c := tg.dial(t); !c.closed() {
+		t.Fatal("banned connection accepted")
+	}
+
+	if ok, err := l.Unban("127.0.0.0/8"); !ok || err != nil {
+		t.Fatalf("unban: %v %v", ok, err)
+	}
+	c = tg.dial(t)
+	c.echo(5)
+	if n, err := c.readEcho(); err != nil || n != 5 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+}
+
+func TestAccessListDeny(t *testing.T) {
+	tg := startGate(t, &Gate{Access: &AccessList{Allow: []string{"10.0.0.0/8"}}})
+	if c := tg.dial(t); !c.closed() {
+		t.Fatal("connection not allowed accepted")
+	}
+
+	tg = startGate(t, &Gate{Access: &AccessList{Deny: []string{"127.0.0.1"}}})
+	if c := tg.dial(t); !c.closed() {
+		t.Fatal("denied connection accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package gate
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Ack enables WriteMsgAck. A pushed message is stamped with a sequence
+// number by SetSeq and resent every timeout until the client answers with a
+// message recognized by Seq or Retries resends are exhausted. Acks are
+// consumed by the gate and never routed.
+type Ack struct {
+	Retries int
+	SetSeq  func(msg interface{}, seq uint32)
+	Seq     func(msg interface{}) (seq uint32, ok bool)
+}
+
+type pendingAck struct {
+	msg     interface{}
+	timeout time.Duration
+	retries int
+	timer   *time.Timer
+	onAck   func()
+	onFail  func()
+}
+
+type ackState struct {
+	sync.Mutex
+	lastSeq atomic.Uint32
+	pending map[uint32]*pendingAck
+}
+
+// WriteMsgAck writes msg and resends it until the client acks it. onAck is
+// called in the agent goroutine, onFail in a timer goroutine or when the
+// agent closes. Both may be nil
+func (a *agent) WriteMsgAck(msg interface{}, timeout time.Duration, onAck func(), onFail func()) {
+	ack := a.gate.Ack
+	if ack == nil || ack.SetSeq == nil {
+		logger().Error("write message %v error: gate Ack not configured", reflect.TypeOf(msg))
+		if onFail != nil {
+			onFail()
+		}
+		return
+	}
+
+	seq := a.ack.lastSeq.Add(1)
+	ack.SetSeq(msg, seq)
+
+	p := &pendingAck{msg: msg, timeout: timeout, onAck: onAck, onFail: onFail}
+	a.ack.Lock()
+	if a.ack.pending == nil {
+		a.ack.pending = make(map[uint32]*pendingAck)
+	}
+	a.ack.pending[seq] = p
+	p.timer = time.AfterFunc(timeout, func() {
+		a.retryAck(seq)
+	})
+	a.ack.Unlock()
+
+	a.WriteMsgPriority(network.PriorityControl, msg)
+}
+
+func (a *agent) retryAck(seq uint32) {
+	a.ack.Lock()
+	p, ok := a.ack.pending[seq]
+	if !ok {
+		a.ack.Unlock()
+		return
+	}
+	if p.retries >= a.gate.Ack.Retries {
+		delete(a.ack.pending, seq)
+		a.ack.Unlock()
+
+		logger().Debug("message %v not acked by %v", reflect.TypeOf(p.msg), a.RemoteAddr())
+		if p.onFail != nil {
+			p.onFail()
+		}
+		return
+	}
+	p.retries++
+	p.timer.Reset(p.timeout)
+	a.ack.Unlock()
+
+	a.WriteMsgPriority(network.PriorityControl, p.msg)
+}
+
+// acked reports whether msg is an ack and consumes it
+func (a *agent) acked(msg interface{}) bool {
+	ack := a.gate.Ack
+	if ack == nil || ack.Seq == nil {
+		return false
+	}
+	seq, ok := ack.Seq(msg)
+	if !ok {
+		return false
+	}
+
+	a.ack.Lock()
+	p, ok := a.ack.pending[seq]
+	if ok {
+		delete(a.ack.pending, seq)
+		p.timer.Stop()
+	}
+	a.ack.Unlock()
+
+	if ok && p.onAck != nil {
+		p.onAck()
+	}
+	return true
+}
+
+// failAcks fails every pending message when the agent closes
+func (a *agent) failAcks() {
+	a.ack.Lock()
+	pending := a.ack.pending
+	a.ack.pending = nil
+	a.ack.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		if p.onFail != nil {
+			p.onFail()
+		}
+	}
+}
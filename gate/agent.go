@@ -2,14 +2,46 @@ package gate
 
 import (
 	"net"
+	"net/http"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
 )
 
 type Agent interface {
 	WriteMsg(msg interface{})
+	// messages of a higher priority class are sent first when the
+	// connection is congested
+	WriteMsgPriority(p network.Priority, msg interface{})
+	// writes msg after d, see Scheduled
+	WriteMsgAfter(d time.Duration, msg interface{}) *Scheduled
+	// needs Gate.Ack
+	WriteMsgAck(msg interface{}, timeout time.Duration, onAck func(), onFail func())
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
+	// the websocket upgrade request and subprotocol, nil and "" over the
+	// other transports
+	UpgradeRequest() *http.Request
+	Subprotocol() string
+	// paces the writes to rate bytes per second with bursts of burst bytes,
+	// zero is unlimited. Ignored over the transports without it
+	SetWriteRate(rate int, burst int)
+	WriteRate() (rate int, burst int)
 	Close()
 	Destroy()
+	Namespace() string
+	Stats() AgentStats
+	// when the last message was received
+	LastActivity() time.Time
+	// Deprecated: use Set and Value, modules may share the agent
 	UserData() interface{}
+	// Deprecated: use Set and Value, modules may share the agent
 	SetUserData(data interface{})
+	// attributes of the connection, dropped when it closes. See Value
+	Set(key string, v interface{})
+	Get(key string) (interface{}, bool)
+	Del(key string)
+	SetState(key string, v interface{}) error
+	State(key string, v interface{}) bool
+	DelState(key string)
 }
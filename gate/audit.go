@@ -0,0 +1,111 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/console"
+	"github.com/czx-lab/leaf/util"
+)
+
+type AuditRecord struct {
+	Time    time.Time
+	Inbound bool
+	MsgID   string
+	Size    int
+	Body    []byte
+}
+
+func (r *AuditRecord) String() string {
+	dir := "out"
+	if r.Inbound {
+		dir = "in "
+	}
+	s := fmt.Sprintf("%v %v %v %v bytes",
+		r.Time.Format("2006-01-02 15:04:05.000"), dir, r.MsgID, r.Size)
+	if r.Body != nil {
+		s += fmt.Sprintf(" %x", r.Body)
+	}
+	return s
+}
+
+// bounded ring buffer of the last messages of an agent
+type audit struct {
+	sync.Mutex
+	records *util.Ring[AuditRecord]
+	body    bool
+}
+
+func newAudit(n int, body bool) *audit {
+	a := new(audit)
+	a.records = util.NewRing[AuditRecord](n, true)
+	a.body = body
+	return a
+}
+
+func (a *audit) record(inbound bool, msgID string, data [][]byte) {
+	r := AuditRecord{
+		Time:    time.Now(),
+		Inbound: inbound,
+		MsgID:   msgID,
+	}
+	for _, b := range data {
+		r.Size += len(b)
+	}
+	if a.body {
+		r.Body = make([]byte, 0, r.Size)
+		for _, b := range data {
+			r.Body = append(r.Body, b...)
+		}
+	}
+
+	a.Lock()
+	a.records.Push(r)
+	a.Unlock()
+}
+
+// oldest first
+func (a *audit) dump() []AuditRecord {
+	a.Lock()
+	defer a.Unlock()
+	return a.records.Slice()
+}
+
+// goroutine safe
+func (gate *Gate) AuditRecords(remoteAddr string) ([]AuditRecord, bool) {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	for a := range gate.agents {
+		if a.RemoteAddr().String() == remoteAddr {
+			if a.audit == nil {
+				return nil, true
+			}
+			return a.audit.dump(), true
+		}
+	}
+	return nil, false
+}
+
+// RegisterAuditCommand adds a console command dumping the audit records of
+// the agent with the given remote address.
+// you must call the function before calling console.Init
+func (gate *Gate) RegisterAuditCommand(name string) {
+	console.RegisterFunc(name, "dump recent messages of an agent", func(args []string) string {
+		if len(args) != 1 {
+			return "Usage: " + name + " <remote address>"
+		}
+		records, ok := gate.AuditRecords(args[0])
+		if !ok {
+			return "agent not found"
+		}
+
+		lines := make([]string, len(records))
+		for i := range records {
+			lines[i] = records[i].String()
+		}
+		return strings.Join(lines, "\r\n")
+	})
+}
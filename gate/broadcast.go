@@ -0,0 +1,96 @@
+package gate
+
+import (
+	"reflect"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Broadcast writes msg to every agent
+// goroutine safe
+func (gate *Gate) Broadcast(msg interface{}) {
+	gate.BroadcastPriority(network.PriorityNormal, msg)
+}
+
+// goroutine safe
+func (gate *Gate) BroadcastPriority(p network.Priority, msg interface{}) {
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.agents))
+	for a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	multicast(p, agents, msg)
+}
+
+// Multicast writes msg to the agents
+// goroutine safe
+func (gate *Gate) Multicast(agents []Agent, msg interface{}) {
+	as := make([]*agent, 0, len(agents))
+	for _, a := range agents {
+		as = append(as, a.(*agent))
+	}
+	multicast(network.PriorityNormal, as, msg)
+}
+
+// MulticastAgents writes msg to agents of any gate, msg is marshaled once
+// per processor as by Multicast. The agents not of a gate, e.g. of
+// leafhttp, are written one by one
+// goroutine safe
+func MulticastAgents(agents []Agent, msg interface{}) {
+	as := make([]*agent, 0, len(agents))
+	for _, a := range agents {
+		if a, ok := a.(*agent); ok {
+			as = append(as, a)
+			continue
+		}
+		a.WriteMsg(msg)
+	}
+	multicast(network.PriorityNormal, as, msg)
+}
+
+// MulticastUsers writes msg to the agents of the logged in users, see Login
+// goroutine safe
+func (gate *Gate) MulticastUsers(userIDs []string, msg interface{}) {
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if a, ok := gate.users[userID]; ok {
+			agents = append(agents, a)
+		}
+	}
+	gate.mutexAgents.Unlock()
+
+	multicast(network.PriorityNormal, agents, msg)
+}
+
+// multicast marshals msg once per processor, unless it's marshaled per
+// connection
+func multicast(p network.Priority, agents []*agent, msg interface{}) {
+	encoded := make(map[network.Processor][][]byte)
+	for _, a := range agents {
+		if a.processor == nil {
+			continue
+		}
+		if !network.Shared(a.processor, msg) {
+			a.WriteMsgPriority(p, msg)
+			continue
+		}
+
+		data, ok := encoded[a.processor]
+		if !ok {
+			var err error
+			data, err = a.processor.Marshal(msg)
+			if err != nil {
+				logger().Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+			}
+			encoded[a.processor] = data
+		}
+		if data == nil {
+			a.stats.drops.Add(1)
+			continue
+		}
+		a.send(p, msg, data)
+	}
+}
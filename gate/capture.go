@@ -0,0 +1,115 @@
+package gate
+
+import (
+	"strings"
+
+	"github.com/czx-lab/leaf/console"
+	"github.com/czx-lab/leaf/network/capture"
+)
+
+type gateCapture struct {
+	w      *capture.Writer
+	filter func(Agent) bool
+}
+
+// StartCapture records the frames of every agent accepted by filter (nil
+// means all agents) to the named file, see the capture package for replay.
+// goroutine safe
+func (gate *Gate) StartCapture(name string, filter func(Agent) bool) error {
+	w, err := capture.Create(name)
+	if err != nil {
+		return err
+	}
+
+	old := gate.capture.Swap(&gateCapture{w: w, filter: filter})
+	if old != nil {
+		old.w.Close()
+	}
+	return nil
+}
+
+// goroutine safe
+func (gate *Gate) StopCapture() error {
+	old := gate.capture.Swap(nil)
+	if old == nil {
+		return nil
+	}
+	return old.w.Close()
+}
+
+func (a *agent) capture(inbound bool, data ...[]byte) {
+	c := a.gate.capture.Load()
+	if c == nil {
+		return
+	}
+	if c.filter != nil && !c.filter(a) {
+		return
+	}
+
+	if err := c.w.Write(a.id, inbound, data...); err != nil {
+		logger().Debug("capture error: %v", err)
+	}
+}
+
+// captureEvent records that the agent connected if open, or closed
+func (a *agent) captureEvent(open bool) {
+	c := a.gate.capture.Load()
+	if c == nil {
+		return
+	}
+	if c.filter != nil && !c.filter(a) {
+		return
+	}
+
+	kind := capture.KindClose
+	var data []byte
+	if open {
+		kind = capture.KindOpen
+		data = []byte(a.RemoteAddr().String())
+	}
+	if err := c.w.WriteEvent(a.id, kind, data); err != nil {
+		logger().Debug("capture error: %v", err)
+	}
+}
+
+// RegisterCaptureCommand adds a console command starting or stopping the
+// capture of the agents, all or the ones of the given remote addresses
+// you must call the function before calling console.Init
+func (gate *Gate) RegisterCaptureCommand(name string) {
+	console.RegisterFunc(name, "records the frames of the agents", func(args []string) string {
+		usage := "Usage: " + name + " start <file> [remote address...] | stop"
+		if len(args) == 0 {
+			return usage
+		}
+
+		switch args[0] {
+		case "start":
+			if len(args) < 2 {
+				return usage
+			}
+			var filter func(Agent) bool
+			if addrs := args[2:]; len(addrs) > 0 {
+				filter = func(a Agent) bool {
+					remote := a.RemoteAddr().String()
+					for _, addr := range addrs {
+						if remote == addr || strings.HasPrefix(remote, addr+":") {
+							return true
+						}
+					}
+					return false
+				}
+			}
+			if err := gate.StartCapture(args[1], filter); err != nil {
+				return err.Error()
+			}
+			return "capturing to " + args[1]
+		case "stop":
+			if err := gate.StopCapture(); err != nil {
+				return err.Error()
+			}
+			return "stopped"
+		default:
+			return usage
+		}
+	})
+}
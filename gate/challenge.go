@@ -0,0 +1,119 @@
+package gate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Challenge is a pre-auth stage. The gate sends a challenge as the first
+// message of a connection and admits the agent, including the NewAgent call,
+// only after a valid answer. See Solve
+//
+// challenge: | 16 random bytes | difficulty (1 byte) |
+// answer:    | HMAC-SHA256 (if Key is set) | nonce |
+type Challenge struct {
+	// the client proves it knows Key, e.g. issued by the login service
+	Key []byte
+	// proof-of-work, SHA-256 of the challenge and the nonce must start with
+	// Difficulty zero bits
+	Difficulty int
+	// zero never times out
+	Timeout time.Duration
+}
+
+const lenChallengeNonce = 16
+
+// challenge sends a challenge and checks the answer
+func (a *agent) challenge() bool {
+	c := a.gate.Challenge
+	msg := make([]byte, lenChallengeNonce+1)
+	if _, err := rand.Read(msg[:lenChallengeNonce]); err != nil {
+		logger().Error("challenge error: %v", err)
+		return false
+	}
+	msg[lenChallengeNonce] = byte(c.Difficulty)
+
+	if c.Timeout > 0 {
+		t := time.AfterFunc(c.Timeout, a.conn.Close)
+		defer t.Stop()
+	}
+	if err := a.conn.WriteMsgPriority(network.PriorityControl, msg); err != nil {
+		logger().Debug("write challenge: %v", err)
+		return false
+	}
+	a.capture(false, msg)
+
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		logger().Debug("read message: %v", err)
+		return false
+	}
+	a.capture(true, data)
+
+	if !c.verify(msg, data) {
+		logger().Debug("challenge failed by %v", a.conn.RemoteAddr())
+		a.stats.drops.Add(1)
+		return false
+	}
+	return true
+}
+
+func (c *Challenge) verify(challenge []byte, answer []byte) bool {
+	if c.Key != nil {
+		if len(answer) < sha256.Size {
+			return false
+		}
+		mac := hmac.New(sha256.New, c.Key)
+		mac.Write(challenge)
+		if !hmac.Equal(mac.Sum(nil), answer[:sha256.Size]) {
+			return false
+		}
+		answer = answer[sha256.Size:]
+	}
+	return c.Difficulty <= 0 || leadingZeros(challenge, answer) >= c.Difficulty
+}
+
+func leadingZeros(challenge []byte, nonce []byte) int {
+	h := sha256.New()
+	h.Write(challenge)
+	h.Write(nonce)
+	sum := h.Sum(nil)
+
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Solve answers a challenge on the client side, key is nil if the gate has no
+// Key. The work is about 2^difficulty hashes
+func Solve(key []byte, challenge []byte) []byte {
+	var answer []byte
+	if key != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(challenge)
+		answer = mac.Sum(nil)
+	}
+	if len(challenge) <= lenChallengeNonce || challenge[lenChallengeNonce] == 0 {
+		return answer
+	}
+
+	difficulty := int(challenge[lenChallengeNonce])
+	nonce := make([]byte, 8)
+	for i := uint64(0); ; i++ {
+		binary.BigEndian.PutUint64(nonce, i)
+		if leadingZeros(challenge, nonce) >= difficulty {
+			return append(answer, nonce...)
+		}
+	}
+}
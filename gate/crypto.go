@@ -0,0 +1,27 @@
+package gate
+
+import "github.com/czx-lab/leaf/network"
+
+// keyExchange answers the public key sent by the client as the first message,
+// the messages after it are encrypted
+func (a *agent) keyExchange() bool {
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		logger().Debug("read message: %v", err)
+		return false
+	}
+	a.capture(true, data)
+
+	public, err := a.gate.Encryption.Accept(a, data)
+	if err != nil {
+		logger().Debug("key exchange with %v: %v", a.conn.RemoteAddr(), err)
+		a.stats.drops.Add(1)
+		return false
+	}
+	if err := a.conn.WriteMsgPriority(network.PriorityControl, public); err != nil {
+		logger().Debug("write key exchange: %v", err)
+		return false
+	}
+	a.capture(false, public)
+	return true
+}
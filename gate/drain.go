@@ -0,0 +1,74 @@
+package gate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/network"
+)
+
+type server interface {
+	Start()
+	Drain(timeout time.Duration)
+	Close()
+}
+
+// OnDrain stops accepting, writes ShutdownNotice to every agent and closes
+// them, waiting up to ShutdownTimeout for their connections to be closed.
+// leaf.Run calls it before destroying the modules. After network.Upgrade the
+// agents are left to close by themselves within DrainTimeout instead
+func (gate *Gate) OnDrain() {
+	if network.Upgraded() {
+		timeout := gate.DrainTimeout
+		if timeout == 0 {
+			timeout = conf.DrainTimeout
+		}
+		gate.drain(timeout)
+		return
+	}
+
+	timeout := gate.ShutdownTimeout
+	if timeout == 0 {
+		timeout = conf.ShutdownTimeout
+	}
+	done := make(chan struct{})
+	go func() {
+		gate.drain(timeout)
+		close(done)
+	}()
+
+	gate.mutexAgents.Lock()
+	agents := make([]*agent, 0, len(gate.agents))
+	for a := range gate.agents {
+		agents = append(agents, a)
+	}
+	gate.mutexAgents.Unlock()
+
+	for _, a := range agents {
+		if gate.ShutdownNotice != nil {
+			a.WriteMsgPriority(network.PriorityControl, gate.ShutdownNotice)
+		}
+		a.Close()
+	}
+	<-done
+}
+
+// drain stops accepting and waits up to timeout for the connections to be
+// closed
+func (gate *Gate) drain(timeout time.Duration) {
+	gate.mutexServers.Lock()
+	servers := gate.servers
+	gate.servers = nil
+	gate.mutexServers.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			s.Drain(timeout)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,193 @@
+package gate
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Flood limits what a single agent may send per Window. Every window in
+// which a limit is exceeded counts as a violation and the response escalates
+// with the number of violations: warn, throttle, kick and finally a
+// temporary ban of the agent's IP, made in the Access list of the gate. The
+// violations are counted per IP, so a kicked client reconnecting goes on to
+// the ban. Zero disables a limit or a step.
+type Flood struct {
+	Window      time.Duration
+	MaxMsgs     int
+	MaxBytes    int
+	MaxUnknowns int
+
+	WarnAfter     int
+	ThrottleAfter int
+	KickAfter     int
+	BanAfter      int
+
+	ThrottleDelay time.Duration
+	BanDuration   time.Duration
+	// how long the violations of an IP are remembered after the last one,
+	// BanDuration if zero
+	ViolationTTL time.Duration
+	// called in the agent goroutine on every warning
+	OnWarn func(a Agent, violations int)
+
+	violations map[string]*floodViolations
+	mutex      sync.Mutex
+}
+
+type floodViolations struct {
+	n    int
+	last time.Time
+}
+
+type floodCounter struct {
+	start    time.Time
+	msgs     int
+	bytes    int
+	unknowns int
+	violated bool
+	// of the IP when the window was violated
+	violations int
+}
+
+func (f *Flood) init() {
+	if f.Window <= 0 {
+		f.Window = time.Second
+		logger().Release("invalid Window, reset to %v", f.Window)
+	}
+	if f.ThrottleDelay <= 0 {
+		f.ThrottleDelay = 100 * time.Millisecond
+	}
+	if f.BanDuration <= 0 {
+		f.BanDuration = 10 * time.Minute
+	}
+	if f.ViolationTTL <= 0 {
+		f.ViolationTTL = f.BanDuration
+	}
+}
+
+// violate counts a violation of ip and returns its violations
+func (f *Flood) violate(ip string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.violations == nil {
+		f.violations = make(map[string]*floodViolations)
+	}
+	now := time.Now()
+	v := f.violations[ip]
+	if v == nil || now.Sub(v.last) >= f.ViolationTTL {
+		v = new(floodViolations)
+		f.violations[ip] = v
+	}
+	v.n++
+	v.last = now
+	return v.n
+}
+
+// forget drops the violations of ip, e.g. banned
+func (f *Flood) forget(ip string) {
+	f.mutex.Lock()
+	delete(f.violations, ip)
+	f.mutex.Unlock()
+}
+
+// prune drops the expired violations
+func (f *Flood) prune(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for ip, v := range f.violations {
+		if now.Sub(v.last) >= f.ViolationTTL {
+			delete(f.violations, ip)
+		}
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// the expired bans and counts of Access, Flood and Malformed are dropped
+// every pruneInterval
+const pruneInterval = time.Minute
+
+func (gate *Gate) prune() {
+	gate.After(pruneInterval, func() {
+		now := time.Now()
+		if gate.Access != nil {
+			gate.Access.prune(now)
+		}
+		if gate.Flood != nil {
+			gate.Flood.prune(now)
+		}
+		if gate.Malformed != nil {
+			gate.Malformed.prune(now)
+		}
+		gate.prune()
+	})
+}
+
+// returns false if the agent must be closed
+func (f *Flood) check(a *agent, c *floodCounter, size int, unknown bool) bool {
+	now := time.Now()
+	if now.Sub(c.start) >= f.Window {
+		c.start = now
+		c.msgs, c.bytes, c.unknowns = 0, 0, 0
+		c.violated = false
+	}
+
+	c.msgs++
+	c.bytes += size
+	if unknown {
+		c.unknowns++
+	}
+
+	if !c.violated &&
+		(f.MaxMsgs > 0 && c.msgs > f.MaxMsgs ||
+			f.MaxBytes > 0 && c.bytes > f.MaxBytes ||
+			f.MaxUnknowns > 0 && c.unknowns > f.MaxUnknowns) {
+		c.violated = true
+		ip := remoteIP(a.RemoteAddr())
+		c.violations = f.violate(ip)
+
+		switch {
+		case f.BanAfter > 0 && c.violations >= f.BanAfter:
+			logger().Release("flood: ban %v for %v", ip, f.BanDuration)
+			if err := a.gate.Access.Ban(ip, f.BanDuration); err != nil {
+				logger().Error("flood: ban %v: %v", ip, err)
+			}
+			f.forget(ip)
+			return false
+		case f.KickAfter > 0 && c.violations >= f.KickAfter:
+			logger().Release("flood: kick %v", a.RemoteAddr())
+			return false
+		case f.WarnAfter > 0 && c.violations >= f.WarnAfter:
+			logger().Release("flood: %v violations from %v", c.violations, a.RemoteAddr())
+			if f.OnWarn != nil {
+				f.OnWarn(a, c.violations)
+			}
+		}
+	}
+
+	if f.ThrottleAfter > 0 && c.violations >= f.ThrottleAfter && c.violated {
+		time.Sleep(f.ThrottleDelay)
+	}
+	return true
+}
+
+// rejected connections
+type closedAgent struct{}
+
+func (closedAgent) Run()     {}
+func (closedAgent) OnClose() {}
@@ -0,0 +1,62 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFloodBanAfterReconnect(t *testing.T) {
+	f := &Flood{
+		Window:    time.Minute,
+		MaxMsgs:   2,
+		KickAfter: 1,
+		BanAfter:  2,
+	}
+	tg := startGate(t, &Gate{Flood: f})
+
+	// kicked on the first violation
+	c := tg.dial(t)
+	for i := 0; i < 3; i++ {
+		c.echo(i)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := c.readEcho(); err != nil || n != i {
+			t.Fatalf("echo %v: %v %v", i, n, err)
+		}
+	}
+	if !c.closed() {
+		t.Fatal("not kicked")
+	}
+	if tg.Access.Banned("127.0.0.1") {
+		t.Fatal("banned on the first violation")
+	}
+
+	// the violations go on with a new connection
+	c = tg.dial(t)
+	for i := 0; i < 3; i++ {
+		c.echo(i)
+	}
+	if !c.closed() {
+		t.Fatal("not kicked")
+	}
+	if !tg.Access.Banned("127.0.0.1") {
+		t.Fatal("not banned on the second violation")
+	}
+
+	c = tg.dial(t)
+	c.echo(0)
+	if !c.closed() {
+		t.Fatal("banned IP accepted")
+	}
+
+	// listed and lifted with the bans of the Access list
+	if _, ok := tg.Access.Bans()["127.0.0.1/32"]; !ok {
+		t.Fatalf("flood ban not listed: %v", tg.Access.Bans())
+	}
+	if ok, err := tg.Access.Unban("127.0.0.1"); !ok || err != nil {
+		t.Fatalf("unban: %v %v", ok, err)
+	}
+	c = tg.dial(t)
+	c.echo(0)
+	c.expectEcho(0)
+}
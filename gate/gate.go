@@ -1,13 +1,21 @@
 package gate
 
 import (
+	"context"
 	"net"
+	"net/http"
+	"net/netip"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/log"
 	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/network/kcp"
+	"github.com/quic-go/quic-go"
 )
 
 type Gate struct {
@@ -17,19 +25,201 @@ type Gate struct {
 	Processor       network.Processor
 	AgentChanRPC    *chanrpc.Server
 
-	// websocket
+	// names the gate in Gates and metrics, defaults to its first address
+	Name string
+
+	// websocket, shares the port with tcp if WSAddr equals TCPAddr
 	WSAddr      string
 	HTTPTimeout time.Duration
 	CertFile    string
 	KeyFile     string
+	// reloadable certificate and session resumption, CertFile and KeyFile
+	// are ignored
+	TLS *network.TLSConfig
+	// text frames by default, a FrameTyper processor decides per message
+	WSTextFrames bool
+	// permessage-deflate, off if nil
+	WSCompression *network.WSCompression
+	// any origin is accepted if nil
+	WSCheckOrigin func(r *http.Request) bool
+	// picks one of the subprotocols asked by the client, see
+	// Agent.Subprotocol
+	WSSelectSubprotocol func(protocols []string) string
 
-	// tcp
+	// tcp, host:port or unix:///path/to.sock
 	TCPAddr      string
 	LenMsgLen    int
 	LittleEndian bool
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+	// tls over tcp with the websocket certificate
+	TCPTLS bool
+	// queued frames coalesced into one writev, zero disables it
+	TCPWriteBatch int
+
+	// kcp, uses the tcp msg parser settings
+	KCPAddr string
+	KCP     kcp.Config
+
+	// quic, uses the tcp msg parser settings and the websocket certificate
+	QUICAddr string
+	QUIC     *quic.Config
+
+	// PROXY protocol on the websocket and tcp listeners
+	ProxyProtocol *network.ProxyProtocol
+
+	// payload compression in front of the processors, clients must use
+	// the same framing
+	Compression *network.Compression
+
+	// per agent AES-GCM, the first message of a connection is the key
+	// exchange, see network.Encryption
+	Encryption *network.Encryption
+
+	// audit
+	AuditLen  int
+	AuditBody bool
+
+	// anti-flood, unknown messages are counted instead of closing the agent
+	Flood *Flood
+
+	// the messages which can't be unmarshaled, closing the agent on the
+	// first one if nil and there is no Flood
+	Malformed *Malformed
+
+	// IP allow and deny lists, bans and connections per IP. The bans of
+	// Flood are made there, an empty list is made if nil
+	Access *AccessList
+
+	// token buckets per agent and message type
+	RateLimit *RateLimit
+
+	// ping and idle kick
+	Heartbeat *Heartbeat
+
+	// reconnection to the agent of a lost connection
+	Resume *Resume
+
+	// written to every agent by OnDrain
+	ShutdownNotice interface{}
+
+	// write pacing in bytes per second, see Agent.SetWriteRate
+	WriteRate  int
+	WriteBurst int
+
+	// what an agent does when its PendingWriteNum queue is full, closed if
+	// nil. OnOverflow is called with the queue stats in the goroutine writing
+	Overflow   *network.Overflow
+	OnOverflow func(a Agent, stats network.QueueStats)
+
+	// counts the messages of every type in Agent.Stats
+	MsgStats bool
+
+	// an OpenTelemetry span per message routed, see chanrpc.Server.Context
+	Tracing bool
+
+	// called in the agent goroutine for a message which can't be unmarshaled
+	// or routed, msgID is the one of a network.RouteError or nil. The agent
+	// may be closed or sent an error message
+	OnRouteError func(a Agent, msgID interface{}, err error)
+
+	// session state
+	StateStore StateStore
+	StateTTL   time.Duration
+
+	// reliable push
+	Ack *Ack
+
+	// pre-auth challenge, see Solve
+	Challenge *Challenge
+
+	// protocol version handshake, before Resume and Challenge
+	Version *Version
+
+	// multi-tenant, the first message of a connection names its namespace
+	// and Processor and AgentChanRPC are ignored
+	Namespaces map[string]*Namespace
+
+	// called before the agent of a user is closed by a new login, e.g. to
+	// notify it
+	OnDuplicateLogin func(old Agent, a Agent)
+
+	// conf.DrainTimeout and conf.ShutdownTimeout if zero
+	DrainTimeout    time.Duration
+	ShutdownTimeout time.Duration
+
+	agents      map[*agent]struct{}
+	users       map[string]*agent
+	sessions    map[string]*agent
+	pipelines   sync.Map
+	mutexAgents sync.Mutex
+	lastAgentID atomic.Uint32
+	capture     atomic.Pointer[gateCapture]
+	// the gates sharing the logins
+	group *MultiGate
+
+	// nil once drained or closed
+	servers      []server
+	mutexServers sync.Mutex
+	// the messages written later
+	schedule schedule
+}
+
+// logger is the "gate" module logger
+func logger() *log.Logger {
+	return log.Module("gate")
 }
 
 func (gate *Gate) Run(closeSig chan bool) {
+	if gate.Name == "" {
+		for _, addr := range []string{gate.TCPAddr, gate.WSAddr, gate.KCPAddr, gate.QUICAddr} {
+			if addr != "" {
+				gate.Name = addr
+				break
+			}
+		}
+	}
+	mutexGates.Lock()
+	gates[gate] = struct{}{}
+	mutexGates.Unlock()
+	defer func() {
+		mutexGates.Lock()
+		delete(gates, gate)
+		mutexGates.Unlock()
+	}()
+
+	if gate.Flood != nil {
+		gate.Flood.init()
+		if gate.Access == nil {
+			gate.Access = new(AccessList)
+		}
+	}
+	if gate.Malformed != nil {
+		gate.Malformed.init()
+	}
+	if gate.Access != nil {
+		if err := gate.Access.init(); err != nil {
+			logger().Fatal("access list: %v", err)
+		}
+	}
+	if gate.RateLimit != nil {
+		gate.RateLimit.init()
+	}
+	if gate.Resume != nil {
+		gate.Resume.init(gate)
+	}
+	if gate.Overflow != nil {
+		initOverflow(gate.Overflow)
+	}
+	if gate.Heartbeat != nil {
+		gate.Heartbeat.init()
+		closeHeartbeat := make(chan struct{})
+		defer close(closeHeartbeat)
+		go gate.Heartbeat.run(gate, closeHeartbeat)
+	}
+
 	var wsServer *network.WSServer
 	if gate.WSAddr != "" {
 		wsServer = new(network.WSServer)
@@ -40,12 +230,14 @@ func (gate *Gate) Run(closeSig chan bool) {
 		wsServer.HTTPTimeout = gate.HTTPTimeout
 		wsServer.CertFile = gate.CertFile
 		wsServer.KeyFile = gate.KeyFile
+		wsServer.TLS = gate.TLS
+		wsServer.TextFrames = gate.WSTextFrames
+		wsServer.ProxyProtocol = gate.ProxyProtocol
+		wsServer.Compression = gate.WSCompression
+		wsServer.CheckOrigin = gate.WSCheckOrigin
+		wsServer.SelectSubprotocol = gate.WSSelectSubprotocol
 		wsServer.NewAgent = func(conn *network.WSConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.accept(conn)
 		}
 	}
 
@@ -58,55 +250,374 @@ func (gate *Gate) Run(closeSig chan bool) {
 		tcpServer.LenMsgLen = gate.LenMsgLen
 		tcpServer.MaxMsgLen = gate.MaxMsgLen
 		tcpServer.LittleEndian = gate.LittleEndian
+		tcpServer.MaxFragmentedLen = gate.MaxFragmentedLen
+		tcpServer.LenIncludesHeader = gate.LenIncludesHeader
+		tcpServer.ProxyProtocol = gate.ProxyProtocol
+		tcpServer.WriteBatch = gate.TCPWriteBatch
+		if gate.TCPTLS {
+			tcpServer.CertFile = gate.CertFile
+			tcpServer.KeyFile = gate.KeyFile
+			tcpServer.TLS = gate.TLS
+		}
 		tcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
-			a := &agent{conn: conn, gate: gate}
-			if gate.AgentChanRPC != nil {
-				gate.AgentChanRPC.Go("NewAgent", a)
-			}
-			return a
+			return gate.accept(conn)
 		}
 	}
 
-	if wsServer != nil {
-		wsServer.Start()
+	var kcpServer *network.KCPServer
+	if gate.KCPAddr != "" {
+		kcpServer = new(network.KCPServer)
+		kcpServer.Addr = gate.KCPAddr
+		kcpServer.MaxConnNum = gate.MaxConnNum
+		kcpServer.PendingWriteNum = gate.PendingWriteNum
+		kcpServer.KCP = gate.KCP
+		kcpServer.LenMsgLen = gate.LenMsgLen
+		kcpServer.MaxMsgLen = gate.MaxMsgLen
+		kcpServer.LittleEndian = gate.LittleEndian
+		kcpServer.MaxFragmentedLen = gate.MaxFragmentedLen
+		kcpServer.LenIncludesHeader = gate.LenIncludesHeader
+		kcpServer.NewAgent = func(conn *network.TCPConn) network.Agent {
+			return gate.accept(conn)
+		}
 	}
-	if tcpServer != nil {
-		tcpServer.Start()
+
+	var quicServer *network.QUICServer
+	if gate.QUICAddr != "" {
+		quicServer = new(network.QUICServer)
+		quicServer.Addr = gate.QUICAddr
+		quicServer.MaxConnNum = gate.MaxConnNum
+		quicServer.PendingWriteNum = gate.PendingWriteNum
+		quicServer.CertFile = gate.CertFile
+		quicServer.KeyFile = gate.KeyFile
+		quicServer.TLS = gate.TLS
+		quicServer.QUIC = gate.QUIC
+		quicServer.LenMsgLen = gate.LenMsgLen
+		quicServer.MaxMsgLen = gate.MaxMsgLen
+		quicServer.LittleEndian = gate.LittleEndian
+		quicServer.MaxFragmentedLen = gate.MaxFragmentedLen
+		quicServer.LenIncludesHeader = gate.LenIncludesHeader
+		quicServer.NewAgent = func(conn *network.TCPConn) network.Agent {
+			return gate.accept(conn)
+		}
 	}
-	<-closeSig
-	if wsServer != nil {
-		wsServer.Close()
+
+	var servers []server
+	if wsServer != nil && tcpServer != nil && gate.WSAddr == gate.TCPAddr {
+		muxServer := new(network.MuxServer)
+		muxServer.Addr = gate.TCPAddr
+		muxServer.TCP = tcpServer
+		muxServer.WS = wsServer
+		servers = append(servers, muxServer)
+	} else {
+		if wsServer != nil {
+			servers = append(servers, wsServer)
+		}
+		if tcpServer != nil {
+			servers = append(servers, tcpServer)
+		}
+	}
+	if kcpServer != nil {
+		servers = append(servers, kcpServer)
+	}
+	if quicServer != nil {
+		servers = append(servers, quicServer)
+	}
+	for _, s := range servers {
+		s.Start()
 	}
-	if tcpServer != nil {
-		tcpServer.Close()
+	gate.mutexServers.Lock()
+	gate.servers = servers
+	gate.mutexServers.Unlock()
+
+	disp := gate.timers()
+	defer disp.Close()
+	if gate.Access != nil || gate.Malformed != nil {
+		gate.prune()
+	}
+	for running := true; running; {
+		select {
+		case <-closeSig:
+			running = false
+		case t := <-disp.ChanTimer:
+			t.Cb()
+		}
 	}
+	if network.Upgraded() {
+		timeout := gate.DrainTimeout
+		if timeout == 0 {
+			timeout = conf.DrainTimeout
+		}
+		gate.drain(timeout)
+	} else {
+		gate.mutexServers.Lock()
+		servers, gate.servers = gate.servers, nil
+		gate.mutexServers.Unlock()
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+	gate.StopCapture()
 }
 
 func (gate *Gate) OnDestroy() {}
 
+func (gate *Gate) accept(conn network.Conn) network.Agent {
+	if gate.Access == nil {
+		return gate.newAgent(conn)
+	}
+
+	ip, ok := addrIP(conn.RemoteAddr())
+	if !ok {
+		return gate.newAgent(conn)
+	}
+	if ok, reason := gate.Access.acquire(ip); !ok {
+		logger().Debug("%v: %v", reason, conn.RemoteAddr())
+		return closedAgent{}
+	}
+	a := gate.newAgent(conn)
+	a.accessIP = ip
+	return a
+}
+
+func (gate *Gate) newAgent(conn network.Conn) *agent {
+	if gate.Resume != nil {
+		conn = &resumableConn{conn: conn}
+	}
+	a := &agent{conn: conn, gate: gate, id: gate.lastAgentID.Add(1)}
+	a.stats.connectTime = time.Now()
+	a.heartbeat.lastActivity.Store(a.stats.connectTime.UnixNano())
+	if gate.MsgStats {
+		a.stats.msgs = make(map[string]*MsgStats)
+	}
+	if gate.WriteRate > 0 {
+		a.SetWriteRate(gate.WriteRate, gate.WriteBurst)
+	}
+	a.setOverflow()
+	if gate.AuditLen > 0 {
+		a.audit = newAudit(gate.AuditLen, gate.AuditBody)
+	}
+
+	if gate.Namespaces == nil && gate.Challenge == nil && gate.Resume == nil && gate.Version == nil {
+		gate.bind(a, "", &Namespace{gate.Processor, gate.AgentChanRPC})
+	}
+	return a
+}
+
+// pipeline adds the gate stages to p
+func (gate *Gate) pipeline(p network.Processor) network.Processor {
+	var stages []network.Stage
+	if gate.Encryption != nil {
+		stages = append(stages, gate.Encryption)
+	}
+	if gate.Compression != nil {
+		stages = append(stages, gate.Compression)
+	}
+	if p == nil || len(stages) == 0 {
+		return p
+	}
+	// shared by the agents, see Broadcast
+	if pl, ok := gate.pipelines.Load(p); ok {
+		return pl.(*network.Pipeline)
+	}
+	pl, _ := gate.pipelines.LoadOrStore(p, network.NewPipeline(p, stages...))
+	return pl.(*network.Pipeline)
+}
+
+func (gate *Gate) bind(a *agent, name string, ns *Namespace) {
+	a.ns = name
+	a.processor = gate.pipeline(ns.Processor)
+	a.chanRPC = ns.AgentChanRPC
+
+	gate.mutexAgents.Lock()
+	if gate.agents == nil {
+		gate.agents = make(map[*agent]struct{})
+	}
+	gate.agents[a] = struct{}{}
+	gate.mutexAgents.Unlock()
+
+	if a.chanRPC != nil {
+		a.chanRPC.Go("NewAgent", a)
+	}
+}
+
 type agent struct {
-	conn     network.Conn
-	gate     *Gate
-	userData interface{}
+	id        uint32
+	conn      network.Conn
+	gate      *Gate
+	ns        string
+	processor network.Processor
+	chanRPC   *chanrpc.Server
+	userData  interface{}
+	Values
+	// guarded by gate.mutexAgents
+	userID    string
+	audit     *audit
+	flood     floodCounter
+	malformed int
+	rate      rateState
+	heartbeat heartbeatState
+	state     sessionState
+	stats     agentStats
+	ack       ackState
+	resume    resumeState
+	// the agent resumed on the connection of this one
+	resumed *agent
+	// closed by the server, not resumable
+	closing atomic.Bool
+	// held while writing with a StatefulProcessor
+	writeMutex sync.Mutex
+	// the span of the message being routed
+	traceCtx atomic.Pointer[context.Context]
+	// counted by the Access list
+	accessIP netip.Addr
 }
 
 func (a *agent) Run() {
+	if a.gate.Encryption != nil && !a.keyExchange() {
+		return
+	}
+	if a.gate.Version != nil && !a.versionHandshake() {
+		return
+	}
+	if a.gate.Resume != nil {
+		old, ok := a.resumeHandshake()
+		if !ok {
+			return
+		}
+		if old != nil {
+			if v, ok := VersionOf(a); ok {
+				old.Set(versionKey, v)
+			}
+			a.resumed = old
+			old.resumeOn(a)
+			old.loop()
+			return
+		}
+	}
+	if a.gate.Challenge != nil && !a.challenge() {
+		return
+	}
+	if a.gate.Namespaces != nil {
+		if !a.handshake() {
+			return
+		}
+	} else if a.gate.Challenge != nil || a.gate.Resume != nil || a.gate.Version != nil {
+		a.gate.bind(a, "", &Namespace{a.gate.Processor, a.gate.AgentChanRPC})
+	}
+
+	a.loop()
+}
+
+func (a *agent) loop() {
+	a.captureEvent(true)
+
+	// zero copy, the audit bodies and the malformed messages are used after
+	// unmarshaling
+	bu, ok := a.processor.(network.BufferUnmarshaler)
+	br, _ := a.conn.(network.BufferReader)
+	if !ok || br == nil || !bu.ZeroCopy() || a.audit != nil && a.audit.body ||
+		a.gate.Malformed != nil && a.gate.Malformed.OnMalformed != nil {
+		bu = nil
+	}
+
 	for {
-		data, err := a.conn.ReadMsg()
+		var data []byte
+		var buf *network.Buffer
+		var err error
+		if bu != nil {
+			buf, err = br.ReadBuffer()
+			if err == nil {
+				data = buf.Bytes()
+			}
+		} else {
+			data, err = a.conn.ReadMsg()
+		}
 		if err != nil {
-			log.Debug("read message: %v", err)
+			logger().Debug("read message: %v", err)
 			break
 		}
 
-		if a.gate.Processor != nil {
-			msg, err := a.gate.Processor.Unmarshal(data)
+		a.capture(true, data)
+		a.stats.in(len(data))
+		a.heartbeat.lastActivity.Store(time.Now().UnixNano())
+
+		if a.processor != nil {
+			if rl := a.gate.RateLimit; rl != nil {
+				ok, closed := rl.checkConn(a)
+				if closed {
+					buf.Release()
+					logger().Debug("rate limit: close %v", a.conn.RemoteAddr())
+					break
+				}
+				if !ok {
+					buf.Release()
+					a.stats.drops.Add(1)
+					continue
+				}
+			}
+
+			var msg interface{}
+			if buf != nil {
+				msg, err = bu.UnmarshalBuffer(a, buf)
+			} else if sp, ok := a.processor.(network.StatefulProcessor); ok {
+				msg, err = sp.UnmarshalFrom(a, data)
+			} else {
+				msg, err = a.processor.Unmarshal(data)
+			}
+			if a.audit != nil {
+				a.audit.record(true, msgName(msg), [][]byte{data})
+			}
+			malformed := err != nil
+			if err == nil {
+				a.stats.count(msg, true)
+				a.malformed = 0
+			}
+			if hb := a.gate.Heartbeat; hb != nil && err == nil && hb.handle(a, msg) {
+				continue
+			}
+			if rl := a.gate.RateLimit; rl != nil && err == nil {
+				ok, closed := rl.checkMsg(a, msg)
+				if closed {
+					logger().Debug("rate limit: close %v", a.conn.RemoteAddr())
+					break
+				}
+				if !ok {
+					a.stats.drops.Add(1)
+					continue
+				}
+			}
+			if err == nil {
+				if !a.acked(msg) {
+					span := a.startSpan(msg)
+					err = a.processor.Route(msg, a)
+					if span != nil {
+						a.endSpan(span, err)
+					}
+					if err != nil {
+						logger().Debug("route message error: %v", err)
+						observe(func(o Observer) { o.Error(a.gate, "route", msg, err) })
+					} else {
+						observe(func(o Observer) { o.Routed(a.gate, msg) })
+					}
+				}
+			} else {
+				logger().Debug("unmarshal message error: %v", err)
+				observe(func(o Observer) { o.Error(a.gate, "unmarshal", nil, err) })
+			}
 			if err != nil {
-				log.Debug("unmarshal message error: %v", err)
+				a.stats.drops.Add(1)
+				if h := a.gate.OnRouteError; h != nil {
+					h(a, network.MsgID(err), err)
+				}
+			}
+			if m := a.gate.Malformed; m != nil && malformed {
+				if !m.check(a, &a.malformed, data, err) {
+					break
+				}
+			} else if err != nil && a.gate.Flood == nil {
 				break
 			}
-			err = a.gate.Processor.Route(msg, a)
-			if err != nil {
-				log.Debug("route message error: %v", err)
+			if flood := a.gate.Flood; flood != nil && !flood.check(a, &a.flood, len(data), err != nil) {
 				break
 			}
 		}
@@ -114,26 +625,123 @@ func (a *agent) Run() {
 }
 
 func (a *agent) OnClose() {
-	if a.gate.AgentChanRPC != nil {
-		err := a.gate.AgentChanRPC.Call0("CloseAgent", a)
+	// once per connection, the agent resumed on one is closed again
+	if a.accessIP.IsValid() {
+		a.gate.Access.release(a.accessIP)
+		a.accessIP = netip.Addr{}
+	}
+	if a.resumed != nil {
+		a.resumed.OnClose()
+		return
+	}
+	if a.gate.Resume != nil && a.park() {
+		return
+	}
+	a.close()
+}
+
+func (a *agent) close() {
+	a.captureEvent(false)
+	a.gate.mutexAgents.Lock()
+	delete(a.gate.agents, a)
+	a.gate.logout(a)
+	if a.resume.token != "" {
+		delete(a.gate.sessions, a.resume.token)
+	}
+	a.gate.mutexAgents.Unlock()
+	a.saveState()
+	a.failAcks()
+	if sp, ok := a.processor.(network.StatefulProcessor); ok {
+		sp.Release(a)
+	}
+	if a.gate.Encryption != nil {
+		a.gate.Encryption.Release(a)
+	}
+
+	if a.chanRPC != nil {
+		err := a.chanRPC.Call0("CloseAgent", a)
 		if err != nil {
-			log.Error("chanrpc error: %v", err)
+			logger().Error("chanrpc error: %v", err)
 		}
 	}
+	a.Clear()
 }
 
 func (a *agent) WriteMsg(msg interface{}) {
-	if a.gate.Processor != nil {
-		data, err := a.gate.Processor.Marshal(msg)
+	a.WriteMsgPriority(network.PriorityNormal, msg)
+}
+
+func (a *agent) WriteMsgPriority(p network.Priority, msg interface{}) {
+	if a.processor != nil {
+		if _, ok := a.processor.(network.StatefulProcessor); ok {
+			// keep the marshaling and the writing order the same
+			a.writeMutex.Lock()
+			defer a.writeMutex.Unlock()
+		}
+		data, err := a.marshal(msg)
 		if err != nil {
-			log.Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
 			return
 		}
-		err = a.conn.WriteMsg(data...)
-		if err != nil {
-			log.Error("write message %v error: %v", reflect.TypeOf(msg), err)
+		a.send(p, msg, data)
+	}
+}
+
+func (a *agent) marshal(msg interface{}) ([][]byte, error) {
+	var data [][]byte
+	var err error
+	if sp, ok := a.processor.(network.StatefulProcessor); ok {
+		data, err = sp.MarshalTo(a, msg)
+	} else {
+		data, err = a.processor.Marshal(msg)
+	}
+	if err != nil {
+		a.stats.drops.Add(1)
+		logger().Error("marshal message %v error: %v", reflect.TypeOf(msg), err)
+		observe(func(o Observer) { o.Error(a.gate, "marshal", msg, err) })
+	}
+	return data, err
+}
+
+// send writes the marshaled msg
+func (a *agent) send(p network.Priority, msg interface{}, data [][]byte) {
+	if r := a.gate.Resume; r != nil {
+		a.resume.Lock()
+		defer a.resume.Unlock()
+		a.resume.record(r.BufferLen, msg)
+		p = network.PriorityNormal
+	}
+
+	if a.audit != nil {
+		a.audit.record(false, msgName(msg), data)
+	}
+	a.capture(false, data...)
+	err := a.write(p, msg, data)
+	if err != nil {
+		a.stats.drops.Add(1)
+		logger().Error("write message %v error: %v", reflect.TypeOf(msg), err)
+		observe(func(o Observer) { o.Error(a.gate, "write", msg, err) })
+		return
+	}
+	a.stats.out(data)
+	a.stats.count(msg, false)
+	observe(func(o Observer) { o.Written(a.gate, msg) })
+}
+
+func (a *agent) write(p network.Priority, msg interface{}, data [][]byte) error {
+	if ft, ok := a.processor.(network.FrameTyper); ok {
+		if wsConn, ok := unwrapConn(a.conn).(*network.WSConn); ok {
+			return wsConn.WriteMsgFrame(ft.FrameType(msg), p, data...)
 		}
 	}
+	return a.conn.WriteMsgPriority(p, data...)
+}
+
+func msgName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return "<nil>"
+	}
+	return t.String()
 }
 
 func (a *agent) LocalAddr() net.Addr {
@@ -144,11 +752,45 @@ func (a *agent) RemoteAddr() net.Addr {
 	return a.conn.RemoteAddr()
 }
 
+func (a *agent) UpgradeRequest() *http.Request {
+	if wsConn, ok := unwrapConn(a.conn).(*network.WSConn); ok {
+		return wsConn.Request()
+	}
+	return nil
+}
+
+func (a *agent) Subprotocol() string {
+	if wsConn, ok := unwrapConn(a.conn).(*network.WSConn); ok {
+		return wsConn.Subprotocol()
+	}
+	return ""
+}
+
+type writeRater interface {
+	SetWriteRate(rate int, burst int)
+	WriteRate() (rate int, burst int)
+}
+
+func (a *agent) SetWriteRate(rate int, burst int) {
+	if wr, ok := unwrapConn(a.conn).(writeRater); ok {
+		wr.SetWriteRate(rate, burst)
+	}
+}
+
+func (a *agent) WriteRate() (rate int, burst int) {
+	if wr, ok := unwrapConn(a.conn).(writeRater); ok {
+		return wr.WriteRate()
+	}
+	return 0, 0
+}
+
 func (a *agent) Close() {
+	a.closing.Store(true)
 	a.conn.Close()
 }
 
 func (a *agent) Destroy() {
+	a.closing.Store(true)
 	a.conn.Destroy()
 }
 
@@ -0,0 +1,195 @@
+package gate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	leafjson "github.com/czx-lab/leaf/network/json"
+)
+
+// Echo is written back by the gates of the tests
+type Echo struct {
+	N int
+}
+
+// testGate is a gate on a loopback TCP port echoing Echo, its agent events
+// go to NewAgent and CloseAgent
+type testGate struct {
+	*Gate
+	Addr       string
+	NewAgent   chan Agent
+	CloseAgent chan Agent
+}
+
+// startGate runs g with the test settings not set, it's closed with the test
+func startGate(t *testing.T, g *Gate) *testGate {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.TCPAddr = ln.Addr().String()
+	ln.Close()
+
+	if g.MaxConnNum == 0 {
+		g.MaxConnNum = 100
+	}
+	if g.PendingWriteNum == 0 {
+		g.PendingWriteNum = 100
+	}
+	if g.MaxMsgLen == 0 {
+		g.MaxMsgLen = 4096
+	}
+	if g.LenMsgLen == 0 {
+		g.LenMsgLen = 2
+	}
+	if g.Processor == nil {
+		p := leafjson.NewProcessor()
+		p.Register(&Echo{})
+		p.SetHandler(&Echo{}, func(args []interface{}) {
+			args[1].(Agent).WriteMsg(args[0])
+		})
+		g.Processor = p
+	}
+
+	tg := &testGate{
+		Gate:       g,
+		Addr:       g.TCPAddr,
+		NewAgent:   make(chan Agent, 100),
+		CloseAgent: make(chan Agent, 100),
+	}
+	if g.AgentChanRPC == nil {
+		s := chanrpc.NewServer(100)
+		s.Register("NewAgent", func(args []interface{}) {
+			tg.NewAgent <- args[0].(Agent)
+		})
+		s.Register("CloseAgent", func(args []interface{}) {
+			tg.CloseAgent <- args[0].(Agent)
+		})
+		g.AgentChanRPC = s
+
+		done := make(chan struct{})
+		go func() {
+			for ci := range s.ChanCall {
+				s.Exec(ci)
+			}
+			close(done)
+		}()
+		t.Cleanup(func() {
+			s.Close()
+			<-done
+		})
+	}
+
+	closeSig := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		g.Run(closeSig)
+		wg.Done()
+	}()
+	t.Cleanup(func() {
+		close(closeSig)
+		wg.Wait()
+	})
+
+	// listening once the servers are started
+	for i := 0; ; i++ {
+		g.mutexServers.Lock()
+		started := g.servers != nil
+		g.mutexServers.Unlock()
+		if started {
+			break
+		}
+		if i == 100 {
+			t.Fatal("gate not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return tg
+}
+
+// testConn is a client connection to a testGate
+type testConn struct {
+	t *testing.T
+	net.Conn
+}
+
+func (tg *testGate) dial(t *testing.T) *testConn {
+	t.Helper()
+	c, err := net.Dial("tcp", tg.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &testConn{t, c}
+}
+
+// write writes a frame with a 2 bytes length
+func (c *testConn) write(data []byte) {
+	c.t.Helper()
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	if _, err := c.Write(append(frame, data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read reads a frame, the error is io.EOF if the gate closed the connection
+func (c *testConn) read() ([]byte, error) {
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(c, header[:]); err != nil {
+		return nil, closedErr(err)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(c, data); err != nil {
+		return nil, closedErr(err)
+	}
+	return data, nil
+}
+
+// closedErr is io.EOF for a connection reset by the gate
+func closedErr(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return err
+	}
+	return io.EOF
+}
+
+func (c *testConn) echo(n int) {
+	c.t.Helper()
+	data, err := json.Marshal(map[string]*Echo{"Echo": {n}})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.write(data)
+}
+
+// readEcho reads the Echo written back
+func (c *testConn) readEcho() (int, error) {
+	data, err := c.read()
+	if err != nil {
+		return 0, err
+	}
+	var m map[string]*Echo
+	if err := json.Unmarshal(data, &m); err != nil || m["Echo"] == nil {
+		c.t.Fatalf("not an Echo: %q", data)
+	}
+	return m["Echo"].N, nil
+}
+
+// closed waits for the gate to close the connection
+func (c *testConn) closed() bool {
+	for {
+		if _, err := c.read(); err != nil {
+			return err == io.EOF
+		}
+	}
+}
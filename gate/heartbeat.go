@@ -0,0 +1,102 @@
+package gate
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Heartbeat pings the agents every Interval and closes the ones which sent
+// nothing for IdleTimeout. Ping and Pong are messages of the processor, e.g.
+// &msg.Ping{} and &msg.Pong{}. A received Pong sets the RTT of the agent, a
+// received Ping is answered with Pong, neither is routed
+type Heartbeat struct {
+	Interval    time.Duration
+	IdleTimeout time.Duration
+	// sent by the gate, nil only answers the pings of the clients
+	Ping interface{}
+	Pong interface{}
+
+	pingType reflect.Type
+	pongType reflect.Type
+}
+
+type heartbeatState struct {
+	lastActivity atomic.Int64
+	pingTime     atomic.Int64
+}
+
+func (h *Heartbeat) init() {
+	if h.Interval <= 0 {
+		h.Interval = 10 * time.Second
+		logger().Release("invalid Interval, reset to %v", h.Interval)
+	}
+	if h.IdleTimeout <= 0 {
+		h.IdleTimeout = 3 * h.Interval
+		logger().Release("invalid IdleTimeout, reset to %v", h.IdleTimeout)
+	}
+	if h.Ping != nil {
+		h.pingType = reflect.TypeOf(h.Ping)
+	}
+	if h.Pong != nil {
+		h.pongType = reflect.TypeOf(h.Pong)
+	}
+}
+
+func (h *Heartbeat) run(gate *Gate, closeSig chan struct{}) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closeSig:
+			return
+		case now := <-ticker.C:
+			gate.mutexAgents.Lock()
+			agents := make([]*agent, 0, len(gate.agents))
+			for a := range gate.agents {
+				agents = append(agents, a)
+			}
+			gate.mutexAgents.Unlock()
+
+			for _, a := range agents {
+				if now.Sub(a.LastActivity()) > h.IdleTimeout {
+					logger().Debug("heartbeat: idle %v", a.RemoteAddr())
+					// resumable
+					a.conn.Close()
+					continue
+				}
+				if h.Ping != nil {
+					a.heartbeat.pingTime.Store(now.UnixNano())
+					a.WriteMsgPriority(network.PriorityControl, h.Ping)
+				}
+			}
+		}
+	}
+}
+
+// handle returns true if msg is a heartbeat message
+func (h *Heartbeat) handle(a *agent, msg interface{}) bool {
+	switch reflect.TypeOf(msg) {
+	case nil:
+		return false
+	case h.pongType:
+		if t := a.heartbeat.pingTime.Swap(0); t != 0 {
+			a.stats.setRTT(time.Since(time.Unix(0, t)))
+		}
+		return true
+	case h.pingType:
+		if h.Pong != nil {
+			a.WriteMsgPriority(network.PriorityControl, h.Pong)
+		}
+		return true
+	}
+	return false
+}
+
+// goroutine safe
+func (a *agent) LastActivity() time.Time {
+	return time.Unix(0, a.heartbeat.lastActivity.Load())
+}
@@ -0,0 +1,183 @@
+package gate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/console"
+)
+
+// Malformed is what an agent does with the messages which can't be
+// unmarshaled, instead of closing on the first one. The errors are counted
+// per IP to spot the scanners and the broken clients
+type Malformed struct {
+	// the agent is kept whatever the errors
+	Ignore bool
+	// consecutive errors closing the agent, 1 if zero
+	MaxErrors int
+	// called in the agent goroutine on every error with the raw message,
+	// e.g. to log it. data is not zero copy and may be retained
+	OnMalformed func(a Agent, data []byte, err error)
+	// the count of an IP is dropped TTL after its last error, 1h if zero.
+	// Once MaxIPs are counted the errors of the others are counted as
+	// OtherIPs, 10000 if zero
+	TTL    time.Duration
+	MaxIPs int
+
+	mutex sync.Mutex
+	ips   map[string]*malformedCount
+}
+
+// OtherIPs counts the errors of the IPs over MaxIPs
+const OtherIPs = "other"
+
+type malformedCount struct {
+	n    uint64
+	last time.Time
+}
+
+func (m *Malformed) init() {
+	if m.MaxErrors <= 0 {
+		m.MaxErrors = 1
+	}
+	if m.TTL <= 0 {
+		m.TTL = time.Hour
+	}
+	if m.MaxIPs <= 0 {
+		m.MaxIPs = 10000
+	}
+}
+
+func (m *Malformed) count(ip string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.ips == nil {
+		m.ips = make(map[string]*malformedCount)
+	}
+	c := m.ips[ip]
+	if c == nil {
+		if len(m.ips) >= m.MaxIPs {
+			ip = OtherIPs
+			c = m.ips[ip]
+		}
+		if c == nil {
+			c = new(malformedCount)
+			m.ips[ip] = c
+		}
+	}
+	c.n++
+	c.last = time.Now()
+}
+
+// prune drops the counts expired
+func (m *Malformed) prune(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for ip, c := range m.ips {
+		if now.Sub(c.last) >= m.TTL {
+			delete(m.ips, ip)
+		}
+	}
+}
+
+// returns false if the agent must be closed
+func (m *Malformed) check(a *agent, errs *int, data []byte, err error) bool {
+	m.count(remoteIP(a.RemoteAddr()))
+
+	if m.OnMalformed != nil {
+		m.OnMalformed(a, data, err)
+	}
+
+	*errs++
+	if m.Ignore || *errs < m.MaxErrors {
+		return true
+	}
+	logger().Debug("malformed: close %v after %v errors", a.RemoteAddr(), *errs)
+	return false
+}
+
+// Counts returns the errors per IP since the last Reset, the IPs without
+// errors for TTL are left out
+// goroutine safe
+func (m *Malformed) Counts() map[string]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counts := make(map[string]uint64, len(m.ips))
+	for ip, c := range m.ips {
+		counts[ip] = c.n
+	}
+	return counts
+}
+
+// goroutine safe
+func (m *Malformed) Reset() {
+	m.mutex.Lock()
+	m.ips = nil
+	m.mutex.Unlock()
+}
+
+// RegisterMalformedCommand adds a console command listing the IPs sending
+// malformed messages, the most errors first, or resetting the counts
+// you must call the function before calling console.Init
+func (gate *Gate) RegisterMalformedCommand(name string) {
+	console.RegisterFunc(name, "lists the IPs sending malformed messages", func(args []string) string {
+		usage := "Usage: " + name + " list [n] | reset"
+		m := gate.Malformed
+		if m == nil {
+			return "no malformed policy"
+		}
+		if len(args) == 0 {
+			return usage
+		}
+
+		switch args[0] {
+		case "list":
+			if len(args) > 2 {
+				return usage
+			}
+			n := 20
+			if len(args) == 2 {
+				var err error
+				if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+					return usage
+				}
+			}
+
+			counts := m.Counts()
+			ips := make([]string, 0, len(counts))
+			for ip := range counts {
+				ips = append(ips, ip)
+			}
+			sort.Slice(ips, func(i, j int) bool {
+				if counts[ips[i]] != counts[ips[j]] {
+					return counts[ips[i]] > counts[ips[j]]
+				}
+				return ips[i] < ips[j]
+			})
+			if len(ips) > n {
+				ips = ips[:n]
+			}
+
+			lines := make([]string, 0, len(ips))
+			for _, ip := range ips {
+				lines = append(lines, fmt.Sprintf("%v %v", ip, counts[ip]))
+			}
+			return strings.Join(lines, "\r\n")
+		case "reset":
+			if len(args) != 1 {
+				return usage
+			}
+			m.Reset()
+			return "reset"
+		default:
+			return usage
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package gate
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMalformedMaxErrors(t *testing.T) {
+	var calls atomic.Int32
+	m := &Malformed{
+		MaxErrors: 3,
+		OnMalformed: func(a Agent, data []byte, err error) {
+			if string(data) != "garbage" {
+				t.Errorf("data %q", data)
+			}
+			calls.Add(1)
+		},
+	}
+	tg := startGate(t, &Gate{Malformed: m})
+
+	// a valid message resets the consecutive errors
+	c := tg.dial(t)
+	c.write([]byte("garbage"))
+	c.write([]byte("garbage"))
+	c.echo(1)
+	if n, err := c.readEcho(); err != nil || n != 1 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+	for i := 0; i < 3; i++ {
+		c.write([]byte("garbage"))
+	}
+	if !c.closed() {
+		t.Fatal("not closed after MaxErrors")
+	}
+	if n := calls.Load(); n != 5 {
+		t.Fatalf("OnMalformed called %v times", n)
+	}
+	if n := m.Counts()["127.0.0.1"]; n != 5 {
+		t.Fatalf("counted %v errors", n)
+	}
+}
+
+func TestMalformedIgnore(t *testing.T) {
+	tg := startGate(t, &Gate{Malformed: &Malformed{Ignore: true}})
+
+	c := tg.dial(t)
+	for i := 0; i < 10; i++ {
+		c.write([]byte("garbage"))
+	}
+	c.echo(1)
+	if n, err := c.readEcho(); err != nil || n != 1 {
+		t.Fatalf("echo: %v %v", n, err)
+	}
+}
+
+func TestMalformedCounts(t *testing.T) {
+	m := &Malformed{MaxIPs: 2, TTL: time.Minute}
+	m.init()
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.1"} {
+		m.count(ip)
+	}
+	counts := m.Counts()
+	if len(counts) != 3 || counts["10.0.0.1"] != 2 || counts[OtherIPs] != 2 {
+		t.Fatalf("counts %v", counts)
+	}
+
+	m.prune(time.Now().Add(time.Minute))
+	if counts := m.Counts(); len(counts) != 0 {
+		t.Fatalf("counts %v after TTL", counts)
+	}
+}
@@ -0,0 +1,115 @@
+package gate
+
+import (
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+)
+
+// MultiGate runs gates of different transports and processors as one
+// module, e.g. a json websocket gate for the web clients and a protobuf tcp
+// gate for the native ones. A user logged in on one gate is kicked from the
+// others, see Login
+type MultiGate struct {
+	Gates []*Gate
+	// set on the gates without one
+	AgentChanRPC *chanrpc.Server
+}
+
+func (m *MultiGate) Run(closeSig chan bool) {
+	var wg sync.WaitGroup
+	sigs := make([]chan bool, len(m.Gates))
+	for i, gate := range m.Gates {
+		if gate.AgentChanRPC == nil {
+			gate.AgentChanRPC = m.AgentChanRPC
+		}
+		gate.group = m
+
+		sigs[i] = make(chan bool, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gate.Run(sigs[i])
+		}()
+	}
+
+	<-closeSig
+	for _, sig := range sigs {
+		sig <- true
+	}
+	wg.Wait()
+}
+
+// OnDrain drains the gates at the same time
+func (m *MultiGate) OnDrain() {
+	var wg sync.WaitGroup
+	for _, gate := range m.Gates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gate.OnDrain()
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *MultiGate) OnDestroy() {
+	for _, gate := range m.Gates {
+		gate.OnDestroy()
+	}
+}
+
+// Login binds the agent to the user on the gate of the agent
+// goroutine safe
+func (m *MultiGate) Login(ag Agent, userID string) (old Agent) {
+	return ag.(*agent).gate.Login(ag, userID)
+}
+
+// goroutine safe
+func (m *MultiGate) Logout(ag Agent) {
+	ag.(*agent).gate.Logout(ag)
+}
+
+// goroutine safe
+func (m *MultiGate) AgentOf(userID string) (Agent, bool) {
+	for _, gate := range m.Gates {
+		if a, ok := gate.AgentOf(userID); ok {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// goroutine safe
+func (m *MultiGate) OnlineCount() int {
+	n := 0
+	for _, gate := range m.Gates {
+		n += gate.OnlineCount()
+	}
+	return n
+}
+
+// GateOf returns the gate the agent is connected to
+func (m *MultiGate) GateOf(ag Agent) *Gate {
+	return ag.(*agent).gate
+}
+
+// unbind returns the agent of the user on the gates other than gate
+func (m *MultiGate) unbind(gate *Gate, userID string) *agent {
+	for _, other := range m.Gates {
+		if other == gate {
+			continue
+		}
+		other.mutexAgents.Lock()
+		a := other.users[userID]
+		if a != nil {
+			delete(other.users, userID)
+			a.userID = ""
+		}
+		other.mutexAgents.Unlock()
+		if a != nil {
+			return a
+		}
+	}
+	return nil
+}
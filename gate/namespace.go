@@ -0,0 +1,39 @@
+package gate
+
+import (
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/network"
+)
+
+// Namespace is one of the logical games sharing a gate, its module set
+// receives the NewAgent and CloseAgent calls of the agents bound to it
+type Namespace struct {
+	Processor    network.Processor
+	AgentChanRPC *chanrpc.Server
+}
+
+// handshake reads the namespace name sent by the client as the first
+// message and binds the agent to it
+func (a *agent) handshake() bool {
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		logger().Debug("read message: %v", err)
+		return false
+	}
+	a.capture(true, data)
+
+	name := string(data)
+	ns, ok := a.gate.Namespaces[name]
+	if !ok || ns == nil {
+		logger().Debug("unknown namespace %q from %v", name, a.conn.RemoteAddr())
+		return false
+	}
+	a.gate.bind(a, name, ns)
+	return true
+}
+
+// Namespace returns the namespace the agent is bound to, it's empty if the
+// gate is not multi-tenant
+func (a *agent) Namespace() string {
+	return a.ns
+}
@@ -0,0 +1,86 @@
+package gate
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Observer is told about the messages of every gate, e.g. to count them.
+// The methods are called in the agent goroutines
+type Observer interface {
+	Routed(gate *Gate, msg interface{})
+	Written(gate *Gate, msg interface{})
+	// op is "unmarshal", "route", "marshal" or "write", msg is nil if it
+	// couldn't be unmarshaled
+	Error(gate *Gate, op string, msg interface{}, err error)
+}
+
+var (
+	observers      atomic.Pointer[[]Observer]
+	mutexObservers sync.Mutex
+
+	gates      = make(map[*Gate]struct{})
+	mutexGates sync.Mutex
+)
+
+// goroutine safe
+func AddObserver(o Observer) {
+	mutexObservers.Lock()
+	defer mutexObservers.Unlock()
+
+	var obs []Observer
+	if p := observers.Load(); p != nil {
+		obs = slices.Clone(*p)
+	}
+	obs = append(obs, o)
+	observers.Store(&obs)
+}
+
+// goroutine safe
+func RemoveObserver(o Observer) {
+	mutexObservers.Lock()
+	defer mutexObservers.Unlock()
+
+	p := observers.Load()
+	if p == nil {
+		return
+	}
+	obs := slices.DeleteFunc(slices.Clone(*p), func(e Observer) bool {
+		return e == o
+	})
+	observers.Store(&obs)
+}
+
+func observe(f func(o Observer)) {
+	if p := observers.Load(); p != nil {
+		for _, o := range *p {
+			f(o)
+		}
+	}
+}
+
+// Gates returns the gates running
+// goroutine safe
+func Gates() []*Gate {
+	mutexGates.Lock()
+	defer mutexGates.Unlock()
+
+	gs := make([]*Gate, 0, len(gates))
+	for gate := range gates {
+		gs = append(gs, gate)
+	}
+	slices.SortFunc(gs, func(a, b *Gate) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return gs
+}
+
+// AgentCount returns the number of agents connected
+// goroutine safe
+func (gate *Gate) AgentCount() int {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	return len(gate.agents)
+}
@@ -0,0 +1,41 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+type overflowSetter interface {
+	SetOverflow(o *network.Overflow)
+}
+
+func initOverflow(o *network.Overflow) {
+	if o.Policy == network.OverflowBlock && o.Timeout <= 0 {
+		o.Timeout = time.Second
+		logger().Release("invalid Overflow Timeout, reset to %v", o.Timeout)
+	}
+}
+
+// setOverflow applies the gate overflow policy to the connection of a
+func (a *agent) setOverflow() {
+	gate := a.gate
+	if gate.Overflow == nil && gate.OnOverflow == nil {
+		return
+	}
+	setter, ok := unwrapConn(a.conn).(overflowSetter)
+	if !ok {
+		return
+	}
+
+	var o network.Overflow
+	if gate.Overflow != nil {
+		o = *gate.Overflow
+	}
+	if gate.OnOverflow != nil {
+		o.OnOverflow = func(stats network.QueueStats) {
+			gate.OnOverflow(a, stats)
+		}
+	}
+	setter.SetOverflow(&o)
+}
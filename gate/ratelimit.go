@@ -0,0 +1,129 @@
+package gate
+
+import (
+	"math"
+	"reflect"
+	"time"
+)
+
+// RatePolicy is what happens to a message over a RateLimit
+type RatePolicy int
+
+const (
+	// the message is dropped. With Encryption the frames after it fail to
+	// decrypt, so the agent limit must not use it
+	RateDrop RatePolicy = iota
+	// reading from the agent waits for a token
+	RateDelay
+	// the agent is closed
+	RateClose
+)
+
+// RateLimit is a token bucket per agent, checked before the message is
+// unmarshaled, and optionally one per message type of an agent, checked
+// before it is routed. Rates are messages per second, a zero Rate only limits
+// the message types set by SetMsg
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Policy RatePolicy
+
+	msgs map[reflect.Type]rate
+}
+
+type rate struct {
+	rate  float64
+	burst int
+}
+
+type rateState struct {
+	conn tokenBucket
+	msgs map[reflect.Type]*tokenBucket
+}
+
+func (r *RateLimit) init() {
+	if r.Rate > 0 && r.Burst <= 0 {
+		r.Burst = max(1, int(min(r.Rate, math.MaxInt32)))
+		logger().Release("invalid Burst, reset to %v", r.Burst)
+	}
+}
+
+// SetMsg limits msg, e.g. &msg.Chat{}, per agent on top of the agent limit
+// It's dangerous to call the method on running the gate
+func (r *RateLimit) SetMsg(msg interface{}, perSecond float64, burst int) {
+	if r.msgs == nil {
+		r.msgs = make(map[reflect.Type]rate)
+	}
+	if perSecond <= 0 {
+		logger().Fatal("invalid rate %v of %v", perSecond, reflect.TypeOf(msg))
+	}
+	r.msgs[reflect.TypeOf(msg)] = rate{perSecond, max(1, burst)}
+}
+
+// allow applies the policy to the message, it returns false if the message
+// is dropped and closed if the agent must be closed
+func (r *RateLimit) allow(b *tokenBucket, perSecond float64, burst int) (ok bool, closed bool) {
+	wait := b.take(perSecond, burst, time.Now())
+	if wait == 0 {
+		return true, false
+	}
+
+	switch r.Policy {
+	case RateDelay:
+		time.Sleep(wait)
+		return true, false
+	case RateClose:
+		return false, true
+	default:
+		b.tokens++
+		return false, false
+	}
+}
+
+// checkConn is called before unmarshaling
+func (r *RateLimit) checkConn(a *agent) (ok bool, closed bool) {
+	if r.Rate <= 0 {
+		return true, false
+	}
+	return r.allow(&a.rate.conn, r.Rate, r.Burst)
+}
+
+// checkMsg is called before routing
+func (r *RateLimit) checkMsg(a *agent, msg interface{}) (ok bool, closed bool) {
+	msgType := reflect.TypeOf(msg)
+	spec, limited := r.msgs[msgType]
+	if !limited {
+		return true, false
+	}
+	if a.rate.msgs == nil {
+		a.rate.msgs = make(map[reflect.Type]*tokenBucket)
+	}
+	b, exists := a.rate.msgs[msgType]
+	if !exists {
+		b = new(tokenBucket)
+		a.rate.msgs[msgType] = b
+	}
+	return r.allow(b, spec.rate, spec.burst)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token and returns how long it takes for the bucket to have
+// one, zero if it has one
+func (b *tokenBucket) take(perSecond float64, burst int, now time.Time) time.Duration {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / perSecond * float64(time.Second))
+}
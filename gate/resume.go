@@ -0,0 +1,268 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Resume keeps the agent of a lost connection for Timeout, so the client
+// reconnects to it with a token and gets the messages it missed. The first
+// message of a connection asks for a new session or resumes one
+//
+// client: | 0 (1 byte) | or | token (16 bytes) | messages received (uint64) |
+// server: | token (16 bytes) | resumed (1 byte) |
+//
+// A resumed agent gets a ResumeAgent chanrpc call instead of CloseAgent and
+// NewAgent. Messages are written in order regardless of their priority and
+// are marshaled again when they are replayed, so they must not be changed
+// after being written. Resume doesn't work with Encryption
+type Resume struct {
+	Timeout time.Duration
+	// messages kept for the replay per agent
+	BufferLen int
+}
+
+const (
+	lenResumeToken   = 16
+	lenResumeRequest = lenResumeToken + 8
+)
+
+type resumeState struct {
+	sync.Mutex
+	token   string
+	sent    uint64
+	entries []interface{}
+	// guarded by gate.mutexAgents
+	parked bool
+	timer  *time.Timer
+	// closed when the agent is parked, made again when it's resumed
+	parkedSig chan struct{}
+}
+
+func (r *Resume) init(gate *Gate) {
+	if gate.Encryption != nil {
+		logger().Fatal("gate Resume doesn't work with Encryption")
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = 30 * time.Second
+		logger().Release("invalid Timeout, reset to %v", r.Timeout)
+	}
+	if r.BufferLen <= 0 {
+		r.BufferLen = 256
+		logger().Release("invalid BufferLen, reset to %v", r.BufferLen)
+	}
+}
+
+// resumeHandshake returns the agent to resume, nil for a new session
+func (a *agent) resumeHandshake() (old *agent, ok bool) {
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		logger().Debug("read message: %v", err)
+		return nil, false
+	}
+	a.capture(true, data)
+
+	var reply []byte
+	if len(data) == lenResumeRequest {
+		old = a.gate.takeover(string(data[:lenResumeToken]), binary.BigEndian.Uint64(data[lenResumeToken:]))
+	} else if len(data) != 1 || data[0] != 0 {
+		logger().Debug("invalid resume request from %v", a.conn.RemoteAddr())
+		a.stats.drops.Add(1)
+		return nil, false
+	}
+	if old != nil {
+		reply = append([]byte(old.resume.token), 1)
+	} else {
+		token := make([]byte, lenResumeToken)
+		if _, err := rand.Read(token); err != nil {
+			logger().Error("resume token error: %v", err)
+			return nil, false
+		}
+		a.gate.mutexAgents.Lock()
+		if a.gate.sessions == nil {
+			a.gate.sessions = make(map[string]*agent)
+		}
+		a.gate.sessions[string(token)] = a
+		a.gate.mutexAgents.Unlock()
+		a.resume.token = string(token)
+		a.resume.parkedSig = make(chan struct{})
+		reply = append(token, 0)
+	}
+
+	if err := a.conn.WriteMsgPriority(network.PriorityControl, reply); err != nil {
+		logger().Debug("write resume: %v", err)
+		return nil, false
+	}
+	a.capture(false, reply)
+	return old, true
+}
+
+// takeover unparks the agent of token if it can replay the messages after
+// received, an agent still connected is disconnected first
+func (gate *Gate) takeover(token string, received uint64) *agent {
+	gate.mutexAgents.Lock()
+	a := gate.sessions[token]
+	gate.mutexAgents.Unlock()
+	if a == nil {
+		return nil
+	}
+
+	gate.mutexAgents.Lock()
+	parked := a.resume.parked
+	parkedSig := a.resume.parkedSig
+	gate.mutexAgents.Unlock()
+	if !parked {
+		a.conn.Destroy()
+		select {
+		case <-parkedSig:
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	a.resume.Lock()
+	defer a.resume.Unlock()
+	missed := a.resume.sent - received
+	if !a.resume.parked || received > a.resume.sent || missed > uint64(len(a.resume.entries)) {
+		return nil
+	}
+	a.resume.parked = false
+	a.resume.parkedSig = make(chan struct{})
+	a.resume.timer.Stop()
+	// the replay starts after received
+	a.resume.sent = received
+	a.resume.entries = a.resume.entries[len(a.resume.entries)-int(missed):]
+	return a
+}
+
+// resumeOn continues the agent on the connection of n
+func (a *agent) resumeOn(n *agent) {
+	a.heartbeat.lastActivity.Store(time.Now().UnixNano())
+
+	// the lock order of WriteMsgPriority, no message is written before the
+	// replay
+	if sp, ok := a.processor.(network.StatefulProcessor); ok {
+		a.writeMutex.Lock()
+		defer a.writeMutex.Unlock()
+		sp.Release(a)
+	}
+	a.resume.Lock()
+	rate, burst := a.WriteRate()
+	a.conn.(*resumableConn).set(n.conn.(*resumableConn).get())
+	a.SetWriteRate(rate, burst)
+	a.setOverflow()
+	entries := a.resume.entries
+	a.resume.entries = nil
+	for _, msg := range entries {
+		data, err := a.marshal(msg)
+		if err != nil {
+			continue
+		}
+		a.resume.record(a.gate.Resume.BufferLen, msg)
+		a.write(network.PriorityNormal, msg, data)
+	}
+	a.resume.Unlock()
+
+	logger().Debug("resume %v with %v messages", a.RemoteAddr(), len(entries))
+	if a.chanRPC != nil {
+		a.chanRPC.Go("ResumeAgent", a)
+	}
+}
+
+// record counts msg and keeps it for the replay, the caller writes it while
+// holding the lock
+func (r *resumeState) record(bufferLen int, msg interface{}) {
+	r.sent++
+	r.entries = append(r.entries, msg)
+	if len(r.entries) > bufferLen {
+		r.entries = r.entries[len(r.entries)-bufferLen:]
+	}
+}
+
+// park keeps the agent for a resume, it returns false if the agent must be
+// closed
+func (a *agent) park() bool {
+	if a.closing.Load() || a.resume.token == "" {
+		return false
+	}
+
+	a.gate.mutexAgents.Lock()
+	if _, ok := a.gate.agents[a]; !ok {
+		a.gate.mutexAgents.Unlock()
+		return false
+	}
+	a.resume.parked = true
+	close(a.resume.parkedSig)
+	a.resume.timer = time.AfterFunc(a.gate.Resume.Timeout, func() {
+		a.gate.mutexAgents.Lock()
+		parked := a.resume.parked
+		a.resume.parked = false
+		a.gate.mutexAgents.Unlock()
+		if parked {
+			a.close()
+		}
+	})
+	a.gate.mutexAgents.Unlock()
+	return true
+}
+
+// resumableConn lets a resumed agent continue on another connection
+type resumableConn struct {
+	sync.RWMutex
+	conn network.Conn
+}
+
+func (c *resumableConn) get() network.Conn {
+	c.RLock()
+	defer c.RUnlock()
+	return c.conn
+}
+
+func (c *resumableConn) set(conn network.Conn) {
+	c.Lock()
+	c.conn = conn
+	c.Unlock()
+}
+
+func (c *resumableConn) ReadMsg() ([]byte, error) {
+	return c.get().ReadMsg()
+}
+
+func (c *resumableConn) WriteMsg(args ...[]byte) error {
+	return c.get().WriteMsg(args...)
+}
+
+func (c *resumableConn) WriteMsgPriority(p network.Priority, args ...[]byte) error {
+	return c.get().WriteMsgPriority(p, args...)
+}
+
+func (c *resumableConn) LocalAddr() net.Addr {
+	return c.get().LocalAddr()
+}
+
+func (c *resumableConn) RemoteAddr() net.Addr {
+	return c.get().RemoteAddr()
+}
+
+func (c *resumableConn) Close() {
+	c.get().Close()
+}
+
+func (c *resumableConn) Destroy() {
+	c.get().Destroy()
+}
+
+// unwrapConn returns the connection of the network package under conn
+func unwrapConn(conn network.Conn) network.Conn {
+	if c, ok := conn.(*resumableConn); ok {
+		return c.get()
+	}
+	return conn
+}
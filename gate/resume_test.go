@@ -0,0 +1,198 @@
+package gate
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// newSession asks for a new session and returns its token
+func (c *testConn) newSession() []byte {
+	c.t.Helper()
+	c.write([]byte{0})
+	token, resumed := c.readResume()
+	if resumed {
+		c.t.Fatal("new session resumed")
+	}
+	return token
+}
+
+// resume resumes the session of token having received messages
+func (c *testConn) resume(token []byte, received uint64) ([]byte, bool) {
+	c.t.Helper()
+	c.write(binary.BigEndian.AppendUint64(append([]byte(nil), token...), received))
+	return c.readResume()
+}
+
+func (c *testConn) readResume() ([]byte, bool) {
+	c.t.Helper()
+	data, err := c.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if len(data) != lenResumeToken+1 {
+		c.t.Fatalf("resume reply %q", data)
+	}
+	return data[:lenResumeToken], data[lenResumeToken] == 1
+}
+
+func (c *testConn) expectEcho(n int) {
+	c.t.Helper()
+	if m, err := c.readEcho(); err != nil || m != n {
+		c.t.Fatalf("echo %v: %v %v", n, m, err)
+	}
+}
+
+func (tg *testGate) waitParked(t *testing.T, a *agent) {
+	t.Helper()
+	for i := 0; ; i++ {
+		tg.mutexAgents.Lock()
+		parked := a.resume.parked
+		tg.mutexAgents.Unlock()
+		if parked {
+			return
+		}
+		if i == 100 {
+			t.Fatal("agent not parked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResumeReplay(t *testing.T) {
+	tg := startGate(t, &Gate{Resume: &Resume{Timeout: time.Minute, BufferLen: 8}})
+
+	c := tg.dial(t)
+	token := c.newSession()
+	a := <-tg.NewAgent
+	for i := 1; i <= 3; i++ {
+		c.echo(i)
+	}
+	c.expectEcho(1)
+	c.expectEcho(2)
+	c.expectEcho(3)
+	c.Close()
+
+	// echo 2 and 3 lost
+	c = tg.dial(t)
+	if _, resumed := c.resume(token, 1); !resumed {
+		t.Fatal("not resumed")
+	}
+	c.expectEcho(2)
+	c.expectEcho(3)
+	c.echo(4)
+	c.expectEcho(4)
+
+	select {
+	case <-tg.CloseAgent:
+		t.Fatal("resumed agent closed")
+	case a2 := <-tg.NewAgent:
+		t.Fatalf("new agent %v instead of %v", a2, a)
+	default:
+	}
+}
+
+func TestResumeTimeout(t *testing.T) {
+	tg := startGate(t, &Gate{Resume: &Resume{Timeout: 50 * time.Millisecond, BufferLen: 8}})
+
+	c := tg.dial(t)
+	token := c.newSession()
+	a := <-tg.NewAgent
+	c.Close()
+	if closed := <-tg.CloseAgent; closed != a {
+		t.Fatal("other agent closed")
+	}
+
+	c = tg.dial(t)
+	newToken, resumed := c.resume(token, 0)
+	if resumed || string(newToken) == string(token) {
+		t.Fatal("resumed after the timeout")
+	}
+	<-tg.NewAgent
+	c.echo(1)
+	c.expectEcho(1)
+}
+
+func TestResumeBufferOverrun(t *testing.T) {
+	tg := startGate(t, &Gate{Resume: &Resume{Timeout: time.Minute, BufferLen: 2}})
+
+	c := tg.dial(t)
+	token := c.newSession()
+	<-tg.NewAgent
+	for i := 1; i <= 4; i++ {
+		c.echo(i)
+	}
+	for i := 1; i <= 4; i++ {
+		c.expectEcho(i)
+	}
+	c.Close()
+
+	// 3 messages missed, only 2 kept
+	c = tg.dial(t)
+	if _, resumed := c.resume(token, 1); resumed {
+		t.Fatal("resumed without the messages missed")
+	}
+	<-tg.NewAgent
+
+	// the session is still there for a client having received more
+	c = tg.dial(t)
+	if _, resumed := c.resume(token, 3); !resumed {
+		t.Fatal("not resumed")
+	}
+	c.expectEcho(4)
+}
+
+func TestResumeTakeover(t *testing.T) {
+	tg := startGate(t, &Gate{Resume: &Resume{Timeout: time.Minute, BufferLen: 8}})
+
+	c1 := tg.dial(t)
+	token := c1.newSession()
+	a := (<-tg.NewAgent).(*agent)
+	c1.echo(1)
+	c1.expectEcho(1)
+
+	// the session is taken over while c1 is still connected
+	c2 := tg.dial(t)
+	if _, resumed := c2.resume(token, 1); !resumed {
+		t.Fatal("not resumed")
+	}
+	if !c1.closed() {
+		t.Fatal("old connection not closed")
+	}
+	c2.echo(2)
+	c2.expectEcho(2)
+
+	// and again
+	c3 := tg.dial(t)
+	if _, resumed := c3.resume(token, 2); !resumed {
+		t.Fatal("not resumed twice")
+	}
+	if !c2.closed() {
+		t.Fatal("second connection not closed")
+	}
+	c3.echo(3)
+	c3.expectEcho(3)
+
+	// resumed once parked, then taken over
+	c3.Close()
+	tg.waitParked(t, a)
+	c4 := tg.dial(t)
+	if _, resumed := c4.resume(token, 3); !resumed {
+		t.Fatal("parked agent not resumed")
+	}
+	c5 := tg.dial(t)
+	if _, resumed := c5.resume(token, 3); !resumed {
+		t.Fatal("not resumed after a resume of the parked agent")
+	}
+	if !c4.closed() {
+		t.Fatal("fourth connection not closed")
+	}
+	c5.echo(4)
+	c5.expectEcho(4)
+
+	select {
+	case <-tg.CloseAgent:
+		t.Fatal("agent closed by the takeover")
+	default:
+	}
+}
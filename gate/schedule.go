@@ -0,0 +1,92 @@
+package gate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/timer"
+)
+
+// the timing wheel of the scheduled messages
+const (
+	scheduleTick = 10 * time.Millisecond
+	scheduleLen  = 1000
+)
+
+const (
+	schedulePending int32 = iota
+	scheduleDone
+	scheduleCanceled
+)
+
+// Scheduled is a message written later, see WriteMsgAfter
+type Scheduled struct {
+	state atomic.Int32
+	f     func()
+}
+
+func (s *Scheduled) run() {
+	if s.state.CompareAndSwap(schedulePending, scheduleDone) {
+		s.f()
+	}
+}
+
+// Cancel stops the message from being written, false if it's already
+// written or canceled
+// goroutine safe
+func (s *Scheduled) Cancel() bool {
+	return s.state.CompareAndSwap(schedulePending, scheduleCanceled)
+}
+
+// AfterFunc calls f in its own goroutine after d unless canceled, for the
+// agents not of a gate to implement WriteMsgAfter
+func AfterFunc(d time.Duration, f func()) *Scheduled {
+	s := &Scheduled{f: f}
+	time.AfterFunc(d, s.run)
+	return s
+}
+
+type schedule struct {
+	once sync.Once
+	disp *timer.Dispatcher
+}
+
+// timers returns the dispatcher firing the scheduled messages in the gate
+// goroutine
+func (gate *Gate) timers() *timer.Dispatcher {
+	gate.schedule.once.Do(func() {
+		gate.schedule.disp = timer.NewWheelDispatcher(scheduleLen, scheduleTick)
+		gate.schedule.disp.Name = "gate"
+	})
+	return gate.schedule.disp
+}
+
+// After calls f in the gate goroutine after d unless canceled. The calls
+// pending when the gate closes are dropped
+// goroutine safe
+func (gate *Gate) After(d time.Duration, f func()) *Scheduled {
+	s := &Scheduled{f: f}
+	gate.timers().AfterFunc(d, s.run)
+	return s
+}
+
+// BroadcastAfter writes msg to every agent after d, e.g. a maintenance
+// notice. The agents are the ones connected when msg is written
+// goroutine safe
+func (gate *Gate) BroadcastAfter(d time.Duration, msg interface{}) *Scheduled {
+	return gate.After(d, func() { gate.Broadcast(msg) })
+}
+
+// MulticastAfter writes msg to the agents after d, calls with growing d
+// send waves of messages
+// goroutine safe
+func (gate *Gate) MulticastAfter(d time.Duration, agents []Agent, msg interface{}) *Scheduled {
+	return gate.After(d, func() { gate.Multicast(agents, msg) })
+}
+
+// WriteMsgAfter writes msg after d, unless the agent is closed by then
+// goroutine safe
+func (a *agent) WriteMsgAfter(d time.Duration, msg interface{}) *Scheduled {
+	return a.gate.After(d, func() { a.WriteMsg(msg) })
+}
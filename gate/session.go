@@ -0,0 +1,106 @@
+package gate
+
+import "sort"
+
+// Login binds the agent to an authenticated user, see AgentOf. A user has a
+// single session: the agent bound to it before is closed after
+// OnDuplicateLogin and returned, on any gate of a MultiGate. A closed agent
+// isn't bound
+// goroutine safe
+func (gate *Gate) Login(ag Agent, userID string) (old Agent) {
+	a := ag.(*agent)
+
+	gate.mutexAgents.Lock()
+	if _, ok := gate.agents[a]; !ok {
+		gate.mutexAgents.Unlock()
+		return nil
+	}
+	if gate.users == nil {
+		gate.users = make(map[string]*agent)
+	}
+	if a.userID != "" && gate.users[a.userID] == a {
+		delete(gate.users, a.userID)
+	}
+	prev := gate.users[userID]
+	if prev == a {
+		prev = nil
+	}
+	if prev != nil {
+		prev.userID = ""
+	}
+	gate.users[userID] = a
+	a.userID = userID
+	gate.mutexAgents.Unlock()
+
+	if prev == nil && gate.group != nil {
+		prev = gate.group.unbind(gate, userID)
+	}
+
+	if prev == nil {
+		return nil
+	}
+	logger().Debug("duplicate login of %v, kick %v", userID, prev.RemoteAddr())
+	if gate.OnDuplicateLogin != nil {
+		gate.OnDuplicateLogin(prev, a)
+	}
+	prev.Close()
+	return prev
+}
+
+// Logout unbinds the agent from its user, it's done when the agent closes
+// goroutine safe
+func (gate *Gate) Logout(ag Agent) {
+	gate.mutexAgents.Lock()
+	gate.logout(ag.(*agent))
+	gate.mutexAgents.Unlock()
+}
+
+// the caller must hold mutexAgents
+func (gate *Gate) logout(a *agent) {
+	if a.userID != "" && gate.users[a.userID] == a {
+		delete(gate.users, a.userID)
+	}
+	a.userID = ""
+}
+
+// goroutine safe
+func (gate *Gate) AgentOf(userID string) (Agent, bool) {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+
+	a, ok := gate.users[userID]
+	if !ok {
+		return nil, false
+	}
+	return a, true
+}
+
+// UserID is the user the agent is bound to by Login, it's empty if there is
+// none
+// goroutine safe
+func (gate *Gate) UserID(ag Agent) string {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	return ag.(*agent).userID
+}
+
+// OnlineUsers returns the logged in users sorted
+// goroutine safe
+func (gate *Gate) OnlineUsers() []string {
+	gate.mutexAgents.Lock()
+	userIDs := make([]string, 0, len(gate.users))
+	for userID := range gate.users {
+		userIDs = append(userIDs, userID)
+	}
+	gate.mutexAgents.Unlock()
+
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+// goroutine safe
+func (gate *Gate) OnlineCount() int {
+	gate.mutexAgents.Lock()
+	defer gate.mutexAgents.Unlock()
+	return len(gate.users)
+}
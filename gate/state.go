@@ -0,0 +1,161 @@
+package gate
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// StateStore persists the session state of disconnected agents
+// must goroutine safe
+type StateStore interface {
+	Save(key string, data []byte, ttl time.Duration) error
+	// nil data if the key doesn't exist or expired
+	Load(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+type sessionState struct {
+	sync.Mutex
+	sessionID string
+	values    map[string]json.RawMessage
+}
+
+// SetState attaches a JSON serializable value to the agent, it is persisted
+// on disconnect if the agent is bound to a session
+func (a *agent) SetState(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	a.state.Lock()
+	if a.state.values == nil {
+		a.state.values = make(map[string]json.RawMessage)
+	}
+	a.state.values[key] = data
+	a.state.Unlock()
+	return nil
+}
+
+// State decodes the value attached with key into v
+func (a *agent) State(key string, v interface{}) bool {
+	a.state.Lock()
+	data, ok := a.state.values[key]
+	a.state.Unlock()
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		logger().Error("state %v: %v", key, err)
+		return false
+	}
+	return true
+}
+
+func (a *agent) DelState(key string) {
+	a.state.Lock()
+	delete(a.state.values, key)
+	a.state.Unlock()
+}
+
+// BindSession binds the agent to a session (e.g. the user ID after login).
+// The state left by a previous connection of the session within StateTTL is
+// restored and the state of the agent is saved when it closes.
+// goroutine safe
+func (gate *Gate) BindSession(ag Agent, sessionID string) (restored bool, err error) {
+	a := ag.(*agent)
+	if gate.StateStore == nil {
+		return false, nil
+	}
+
+	data, err := gate.StateStore.Load(sessionID)
+	if err != nil {
+		return false, err
+	}
+	var values map[string]json.RawMessage
+	if data != nil {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return false, err
+		}
+		gate.StateStore.Delete(sessionID)
+	}
+
+	a.state.Lock()
+	a.state.sessionID = sessionID
+	if values != nil {
+		// values set before binding win
+		for k, v := range a.state.values {
+			values[k] = v
+		}
+		a.state.values = values
+	}
+	a.state.Unlock()
+	return values != nil, nil
+}
+
+func (a *agent) saveState() {
+	store := a.gate.StateStore
+	if store == nil {
+		return
+	}
+
+	a.state.Lock()
+	sessionID := a.state.sessionID
+	data, err := json.Marshal(a.state.values)
+	a.state.Unlock()
+	if sessionID == "" || err != nil {
+		return
+	}
+
+	if err := store.Save(sessionID, data, a.gate.StateTTL); err != nil {
+		logger().Error("save state of session %v error: %v", sessionID, err)
+	}
+}
+
+// in-memory StateStore
+type MemoryStateStore struct {
+	sync.Mutex
+	m map[string]memoryState
+}
+
+type memoryState struct {
+	data    []byte
+	expires time.Time
+}
+
+func (s *MemoryStateStore) Save(key string, data []byte, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.m == nil {
+		s.m = make(map[string]memoryState)
+	}
+	now := time.Now()
+	for k, v := range s.m {
+		if now.After(v.expires) {
+			delete(s.m, k)
+		}
+	}
+	s.m[key] = memoryState{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStateStore) Load(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.m[key]
+	if !ok || time.Now().After(v.expires) {
+		return nil, nil
+	}
+	return v.data, nil
+}
+
+func (s *MemoryStateStore) Delete(key string) error {
+	s.Lock()
+	delete(s.m, key)
+	s.Unlock()
+	return nil
+}
@@ -0,0 +1,123 @@
+package gate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AgentStats struct {
+	ConnectTime time.Time
+	BytesIn     uint64
+	BytesOut    uint64
+	MsgsIn      uint64
+	MsgsOut     uint64
+	// messages that failed to unmarshal, marshal or write
+	Drops uint64
+	// round trip time of the last heartbeat, zero if unknown
+	RTT time.Duration
+	// zero if no message was read or written
+	LastRead  time.Time
+	LastWrite time.Time
+	// per message type, nil unless Gate.MsgStats is set
+	Msgs map[string]MsgStats
+}
+
+type MsgStats struct {
+	In  uint64
+	Out uint64
+}
+
+type agentStats struct {
+	connectTime time.Time
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	msgsIn      atomic.Uint64
+	msgsOut     atomic.Uint64
+	drops       atomic.Uint64
+	rtt         atomic.Int64
+	lastRead    atomic.Int64
+	lastWrite   atomic.Int64
+
+	// nil unless Gate.MsgStats is set
+	msgs      map[string]*MsgStats
+	mutexMsgs sync.Mutex
+}
+
+func (s *agentStats) in(n int) {
+	s.msgsIn.Add(1)
+	s.bytesIn.Add(uint64(n))
+	s.lastRead.Store(time.Now().UnixNano())
+}
+
+func (s *agentStats) out(data [][]byte) {
+	n := 0
+	for _, b := range data {
+		n += len(b)
+	}
+	s.msgsOut.Add(1)
+	s.bytesOut.Add(uint64(n))
+	s.lastWrite.Store(time.Now().UnixNano())
+}
+
+// count counts msg by type if Gate.MsgStats is set
+func (s *agentStats) count(msg interface{}, in bool) {
+	if s.msgs == nil {
+		return
+	}
+
+	name := msgName(msg)
+	s.mutexMsgs.Lock()
+	m := s.msgs[name]
+	if m == nil {
+		m = new(MsgStats)
+		s.msgs[name] = m
+	}
+	if in {
+		m.In++
+	} else {
+		m.Out++
+	}
+	s.mutexMsgs.Unlock()
+}
+
+func (s *agentStats) setRTT(d time.Duration) {
+	s.rtt.Store(int64(d))
+}
+
+// goroutine safe
+func (a *agent) Stats() AgentStats {
+	return AgentStats{
+		ConnectTime: a.stats.connectTime,
+		BytesIn:     a.stats.bytesIn.Load(),
+		BytesOut:    a.stats.bytesOut.Load(),
+		MsgsIn:      a.stats.msgsIn.Load(),
+		MsgsOut:     a.stats.msgsOut.Load(),
+		Drops:       a.stats.drops.Load(),
+		RTT:         time.Duration(a.stats.rtt.Load()),
+		LastRead:    unixTime(a.stats.lastRead.Load()),
+		LastWrite:   unixTime(a.stats.lastWrite.Load()),
+		Msgs:        a.stats.msgStats(),
+	}
+}
+
+func (s *agentStats) msgStats() map[string]MsgStats {
+	if s.msgs == nil {
+		return nil
+	}
+
+	s.mutexMsgs.Lock()
+	defer s.mutexMsgs.Unlock()
+	msgs := make(map[string]MsgStats, len(s.msgs))
+	for name, m := range s.msgs {
+		msgs[name] = *m
+	}
+	return msgs
+}
+
+func unixTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,65 @@
+package gate
+
+import (
+	"context"
+
+	"github.com/czx-lab/leaf/network"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/czx-lab/leaf/gate")
+
+type idProcessor interface {
+	ID(msg interface{}) (uint16, bool)
+}
+
+// startSpan starts the span of routing msg, nil if the gate isn't traced.
+// The chanrpc calls made with the agent while routing it are its children
+func (a *agent) startSpan(msg interface{}) trace.Span {
+	if !a.gate.Tracing {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("leaf.gate", a.gate.Name),
+		attribute.String("leaf.msg.type", msgName(msg)),
+		attribute.String("network.peer.address", a.RemoteAddr().String()),
+	}
+	p := a.processor
+	if pl, ok := p.(*network.Pipeline); ok {
+		p = pl.Processor
+	}
+	if ip, ok := p.(idProcessor); ok {
+		if id, ok := ip.ID(msg); ok {
+			attrs = append(attrs, attribute.Int("leaf.msg.id", int(id)))
+		}
+	}
+
+	ctx, span := tracer.Start(context.Background(), "gate "+msgName(msg),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+	a.traceCtx.Store(&ctx)
+	return span
+}
+
+func (a *agent) endSpan(span trace.Span, err error) {
+	a.traceCtx.Store(nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Context is the context of the message being routed, chanrpc passes it to
+// the functions called with the agent
+// goroutine safe
+func (a *agent) Context() context.Context {
+	if ctx := a.traceCtx.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,70 @@
+package gate
+
+import (
+	"io"
+	"sync"
+)
+
+// Values are the attributes of an agent, so that modules attach their state
+// to a connection under their own keys:
+//
+//	a.Set("player", p)
+//	p, ok := gate.Value[*Player](a, "player")
+//
+// The agents of other packages embed it to implement the methods of Agent
+// goroutine safe
+type Values struct {
+	mutex sync.Mutex
+	m     map[string]interface{}
+}
+
+func (v *Values) Set(key string, value interface{}) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.m == nil {
+		v.m = make(map[string]interface{})
+	}
+	v.m[key] = value
+}
+
+func (v *Values) Get(key string) (interface{}, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	value, ok := v.m[key]
+	return value, ok
+}
+
+func (v *Values) Del(key string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.m, key)
+}
+
+// Clear drops the values, those implementing io.Closer are closed
+func (v *Values) Clear() {
+	v.mutex.Lock()
+	m := v.m
+	v.m = nil
+	v.mutex.Unlock()
+
+	for key, value := range m {
+		if c, ok := value.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				logger().Error("close value %v error: %v", key, err)
+			}
+		}
+	}
+}
+
+// Value returns the value attached to a with key, false if there is none or
+// it isn't a T
+// goroutine safe
+func Value[T any](a Agent, key string) (T, bool) {
+	value, ok := a.Get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := value.(T)
+	return t, ok
+}
@@ -0,0 +1,134 @@
+package gate
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// Version is a handshake before routing. The first message of a connection
+// is the version of the client, the gate answers it and admits the agent,
+// including the NewAgent call, only if it's accepted. See EncodeVersion
+//
+// hello:  | protocol (2 bytes) | build |
+// answer: | VersionResult (1 byte) | message |
+type Version struct {
+	// the protocols accepted, an older client is asked to upgrade and a
+	// newer one rejected. Zero MaxProtocol is unlimited
+	MinProtocol uint16
+	MaxProtocol uint16
+	// the message answered with VersionUpgrade, e.g. the download url
+	UpgradeMessage string
+	// replaces the protocol range if not nil, e.g. to reject some builds
+	Policy func(v ClientVersion) (result VersionResult, message string)
+	// zero never times out
+	Timeout time.Duration
+}
+
+type ClientVersion struct {
+	Protocol uint16
+	Build    string
+}
+
+type VersionResult byte
+
+const (
+	VersionAccept VersionResult = iota
+	VersionUpgrade
+	VersionReject
+)
+
+func (r VersionResult) String() string {
+	switch r {
+	case VersionAccept:
+		return "accept"
+	case VersionUpgrade:
+		return "upgrade required"
+	default:
+		return "reject"
+	}
+}
+
+// the key of the client version in the values of an agent
+const versionKey = "leaf/version"
+
+func (v *Version) check(cv ClientVersion) (VersionResult, string) {
+	if v.Policy != nil {
+		return v.Policy(cv)
+	}
+	if cv.Protocol < v.MinProtocol {
+		return VersionUpgrade, v.UpgradeMessage
+	}
+	if v.MaxProtocol != 0 && cv.Protocol > v.MaxProtocol {
+		return VersionReject, ""
+	}
+	return VersionAccept, ""
+}
+
+// versionHandshake reads the version of the client and answers it
+func (a *agent) versionHandshake() bool {
+	v := a.gate.Version
+	if v.Timeout > 0 {
+		t := time.AfterFunc(v.Timeout, a.conn.Close)
+		defer t.Stop()
+	}
+
+	data, err := a.conn.ReadMsg()
+	if err != nil {
+		logger().Debug("read message: %v", err)
+		return false
+	}
+	a.capture(true, data)
+
+	cv, err := DecodeVersion(data)
+	if err != nil {
+		logger().Debug("version from %v: %v", a.conn.RemoteAddr(), err)
+		return false
+	}
+	result, message := v.check(cv)
+
+	answer := append([]byte{byte(result)}, message...)
+	if err := a.conn.WriteMsgPriority(network.PriorityControl, answer); err != nil {
+		logger().Debug("write version answer: %v", err)
+		return false
+	}
+	a.capture(false, answer)
+
+	if result != VersionAccept {
+		logger().Debug("version %v/%v of %v: %v", cv.Protocol, cv.Build, a.conn.RemoteAddr(), result)
+		return false
+	}
+	a.Set(versionKey, cv)
+	return true
+}
+
+// VersionOf returns the version of the client of the agent, false if the
+// gate has no Version
+// goroutine safe
+func VersionOf(a Agent) (ClientVersion, bool) {
+	return Value[ClientVersion](a, versionKey)
+}
+
+// EncodeVersion returns the first message of a client to a gate with
+// Version
+func EncodeVersion(v ClientVersion) []byte {
+	data := binary.BigEndian.AppendUint16(nil, v.Protocol)
+	return append(data, v.Build...)
+}
+
+func DecodeVersion(data []byte) (ClientVersion, error) {
+	if len(data) < 2 {
+		return ClientVersion{}, errors.New("version too short")
+	}
+	return ClientVersion{binary.BigEndian.Uint16(data), string(data[2:])}, nil
+}
+
+// DecodeVersionAnswer decodes the answer of the gate on the client side
+func DecodeVersionAnswer(data []byte) (VersionResult, string, error) {
+	if len(data) < 1 || data[0] > byte(VersionReject) {
+		return 0, "", errors.New("invalid version answer")
+	}
+	return VersionResult(data[0]), string(data[1:]), nil
+}
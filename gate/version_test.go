@@ -0,0 +1,83 @@
+package gate
+
+import (
+	"testing"
+)
+
+func (c *testConn) hello(v ClientVersion) (VersionResult, string) {
+	c.t.Helper()
+	c.write(EncodeVersion(v))
+	data, err := c.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	result, message, err := DecodeVersionAnswer(data)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return result, message
+}
+
+func TestVersion(t *testing.T) {
+	tg := startGate(t, &Gate{Version: &Version{
+		MinProtocol:    2,
+		MaxProtocol:    3,
+		UpgradeMessage: "https://example.com/download",
+	}})
+
+	c := tg.dial(t)
+	if result, message := c.hello(ClientVersion{1, "old"}); result != VersionUpgrade || message != "https://example.com/download" {
+		t.Fatalf("protocol 1: %v %q", result, message)
+	}
+	if !c.closed() {
+		t.Fatal("old client not closed")
+	}
+
+	c = tg.dial(t)
+	if result, _ := c.hello(ClientVersion{4, "new"}); result != VersionReject {
+		t.Fatalf("protocol 4: %v", result)
+	}
+	if !c.closed() {
+		t.Fatal("new client not closed")
+	}
+
+	select {
+	case <-tg.NewAgent:
+		t.Fatal("agent of a client not accepted")
+	default:
+	}
+
+	c = tg.dial(t)
+	if result, _ := c.hello(ClientVersion{2, "1.0.0"}); result != VersionAccept {
+		t.Fatalf("protocol 2: %v", result)
+	}
+	a := <-tg.NewAgent
+	if v, ok := VersionOf(a); !ok || v != (ClientVersion{2, "1.0.0"}) {
+		t.Fatalf("VersionOf: %v %v", v, ok)
+	}
+	c.echo(1)
+	c.expectEcho(1)
+}
+
+func TestVersionPolicy(t *testing.T) {
+	tg := startGate(t, &Gate{Version: &Version{
+		Policy: func(v ClientVersion) (VersionResult, string) {
+			if v.Build == "broken" {
+				return VersionReject, "build recalled"
+			}
+			return VersionAccept, ""
+		},
+	}})
+
+	c := tg.dial(t)
+	if result, message := c.hello(ClientVersion{1, "broken"}); result != VersionReject || message != "build recalled" {
+		t.Fatalf("broken: %v %q", result, message)
+	}
+
+	c = tg.dial(t)
+	if result, _ := c.hello(ClientVersion{1, "fixed"}); result != VersionAccept {
+		t.Fatalf("fixed: %v", result)
+	}
+	c.echo(1)
+	c.expectEcho(1)
+}
@@ -0,0 +1,158 @@
+package gm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+)
+
+type Record struct {
+	Time     time.Time
+	Operator interface{}
+	Addr     string
+	Command  string
+	Args     []string
+	Result   string
+	Err      error
+}
+
+type command struct {
+	name   string
+	level  int
+	help   string
+	server *chanrpc.Server
+}
+
+// Router parses GM command lines sent by clients and runs them on the
+// chanrpc server of the module owning the command.
+type Router struct {
+	// returns the identity and permission level of the sender,
+	// ok is false if the sender is no operator
+	Operator func(a gate.Agent) (id interface{}, level int, ok bool)
+	// sends the result of a command back to the operator
+	Reply func(a gate.Agent, text string)
+	// called for every invocation in addition to the audit log
+	OnAudit func(r *Record)
+
+	commands map[string]*command
+	mutex    sync.RWMutex
+}
+
+// f is called on server with args [operator id, agent, arg1, arg2...] and
+// must be func([]interface{}) interface{} returning the result text
+// you must call the function before calling Open and Go on server
+func (r *Router) Register(name string, level int, help string, f interface{}, server *chanrpc.Server) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.commands == nil {
+		r.commands = make(map[string]*command)
+	}
+	if _, ok := r.commands[name]; ok {
+		log.Fatal("gm command %v is already registered", name)
+	}
+	server.Register("gm."+name, f)
+	r.commands[name] = &command{name: name, level: level, help: help, server: server}
+}
+
+// Handler returns a processor message handler for the designated GM
+// message, line returns the command line carried by the message
+func (r *Router) Handler(line func(msg interface{}) string) func([]interface{}) {
+	return func(args []interface{}) {
+		r.Handle(args[1].(gate.Agent), line(args[0]))
+	}
+}
+
+// Handle runs a command line, e.g. "additem 1001 10"
+// goroutine safe
+func (r *Router) Handle(a gate.Agent, line string) {
+	if r.Operator == nil {
+		return
+	}
+	id, level, ok := r.Operator(a)
+	if !ok {
+		log.Release("gm: rejected %q from non-operator %v", line, a.RemoteAddr())
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	rec := &Record{
+		Time:     time.Now(),
+		Operator: id,
+		Addr:     fmt.Sprint(a.RemoteAddr()),
+		Command:  fields[0],
+		Args:     fields[1:],
+	}
+	rec.Result, rec.Err = r.run(a, id, level, rec)
+
+	if rec.Err != nil {
+		log.Release("gm: operator %v (%v) ran %q: %v", rec.Operator, rec.Addr, line, rec.Err)
+	} else {
+		log.Release("gm: operator %v (%v) ran %q", rec.Operator, rec.Addr, line)
+	}
+	if r.OnAudit != nil {
+		r.OnAudit(rec)
+	}
+
+	if r.Reply != nil {
+		if rec.Err != nil {
+			r.Reply(a, rec.Err.Error())
+		} else {
+			r.Reply(a, rec.Result)
+		}
+	}
+}
+
+func (r *Router) run(a gate.Agent, id interface{}, level int, rec *Record) (string, error) {
+	if rec.Command == "help" {
+		return r.help(level), nil
+	}
+
+	r.mutex.RLock()
+	c, ok := r.commands[rec.Command]
+	r.mutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("command %v not found", rec.Command)
+	}
+	if level < c.level {
+		return "", fmt.Errorf("command %v requires level %v", c.name, c.level)
+	}
+
+	args := make([]interface{}, 0, len(rec.Args)+2)
+	args = append(args, id, a)
+	for _, arg := range rec.Args {
+		args = append(args, arg)
+	}
+	ret, err := c.server.Call1("gm."+c.name, args...)
+	if err != nil {
+		return "", err
+	}
+	output, ok := ret.(string)
+	if !ok {
+		return "", fmt.Errorf("command %v: invalid output type", c.name)
+	}
+	return output, nil
+}
+
+func (r *Router) help(level int) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var lines []string
+	for _, c := range r.commands {
+		if level >= c.level {
+			lines = append(lines, c.name+" - "+c.help)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -69,3 +69,31 @@ func ExampleLinearContext() {
 	// 1
 	// 2
 }
+
+func ExamplePool() {
+	d := g.New(10)
+
+	p := d.NewPool(1, 1)
+	p.Policy = g.PoolReject
+
+	release := make(chan struct{})
+	p.Go(func() {
+		<-release
+	}, func() {
+		fmt.Println("1 done")
+	})
+	// queued once the worker took the first one
+	for p.Go(func() {}, func() { fmt.Println("2 done") }) != nil {
+		time.Sleep(time.Millisecond)
+	}
+	fmt.Println(p.Go(func() {}, nil))
+
+	close(release)
+	p.Close()
+	d.Close()
+
+	// Output:
+	// pool full
+	// 1 done
+	// 2 done
+}
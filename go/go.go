@@ -2,17 +2,24 @@ package g
 
 import (
 	"container/list"
-	"runtime"
 	"sync"
+	"sync/atomic"
 
-	"github.com/czx-lab/leaf/conf"
-	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
 )
 
+// Goer runs f in another goroutine and cb in its own goroutine, e.g. a
+// module.Skeleton, a Go or a LinearContext
+type Goer interface {
+	Go(f func(), cb func())
+}
+
 // one Go per goroutine (goroutine not safe)
 type Go struct {
 	ChanCb    chan func()
-	pendingGo int
+	pendingGo atomic.Int64
+	// the module, reported with the panics recovered
+	Name string
 }
 
 type LinearGo struct {
@@ -34,19 +41,13 @@ func New(l int) *Go {
 }
 
 func (g *Go) Go(f func(), cb func()) {
-	g.pendingGo++
+	g.pendingGo.Add(1)
 
 	go func() {
 		defer func() {
 			g.ChanCb <- cb
 			if r := recover(); r != nil {
-				if conf.LenStackBuf > 0 {
-					buf := make([]byte, conf.LenStackBuf)
-					l := runtime.Stack(buf, false)
-					log.Error("%v: %s", r, buf[:l])
-				} else {
-					log.Error("%v", r)
-				}
+				util.Recovered(r, util.PanicContext{Source: "go", Module: g.Name})
 			}
 		}()
 
@@ -56,15 +57,9 @@ func (g *Go) Go(f func(), cb func()) {
 
 func (g *Go) Cb(cb func()) {
 	defer func() {
-		g.pendingGo--
+		g.pendingGo.Add(-1)
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
-			}
+			util.Recovered(r, util.PanicContext{Source: "go", Module: g.Name})
 		}
 	}()
 
@@ -74,13 +69,19 @@ func (g *Go) Cb(cb func()) {
 }
 
 func (g *Go) Close() {
-	for g.pendingGo > 0 {
+	for g.pendingGo.Load() > 0 {
 		g.Cb(<-g.ChanCb)
 	}
 }
 
 func (g *Go) Idle() bool {
-	return g.pendingGo == 0
+	return g.pendingGo.Load() == 0
+}
+
+// Pending returns the number of f not called back yet
+// goroutine safe
+func (g *Go) Pending() int {
+	return int(g.pendingGo.Load())
 }
 
 func (g *Go) NewLinearContext() *LinearContext {
@@ -91,7 +92,7 @@ func (g *Go) NewLinearContext() *LinearContext {
 }
 
 func (c *LinearContext) Go(f func(), cb func()) {
-	c.g.pendingGo++
+	c.g.pendingGo.Add(1)
 
 	c.mutexLinearGo.Lock()
 	c.linearGo.PushBack(&LinearGo{f: f, cb: cb})
@@ -108,13 +109,7 @@ func (c *LinearContext) Go(f func(), cb func()) {
 		defer func() {
 			c.g.ChanCb <- e.cb
 			if r := recover(); r != nil {
-				if conf.LenStackBuf > 0 {
-					buf := make([]byte, conf.LenStackBuf)
-					l := runtime.Stack(buf, false)
-					log.Error("%v: %s", r, buf[:l])
-				} else {
-					log.Error("%v", r)
-				}
+				util.Recovered(r, util.PanicContext{Source: "go", Module: c.g.Name})
 			}
 		}()
 
@@ -0,0 +1,117 @@
+package g
+
+import (
+	"errors"
+
+	"github.com/czx-lab/leaf/util"
+)
+
+// PoolPolicy is what Pool.Go does when the queue of the pool is full
+type PoolPolicy int
+
+const (
+	// wait for room, the default
+	PoolBlock PoolPolicy = iota
+	// return ErrPoolFull
+	PoolReject
+)
+
+var (
+	ErrPoolFull   = errors.New("pool full")
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// Pool executes the functions with a fixed set of goroutines instead of one
+// goroutine per function, the callbacks are still called by Go.Cb
+type Pool struct {
+	g      *Go
+	tasks  chan *LinearGo
+	closed bool
+
+	Policy PoolPolicy
+}
+
+// NewPool starts workers goroutines executing up to queueSize queued
+// functions
+func (g *Go) NewPool(workers int, queueSize int) *Pool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := new(Pool)
+	p.g = g
+	p.tasks = make(chan *LinearGo, queueSize)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	for t := range p.tasks {
+		p.exec(t)
+	}
+}
+
+func (p *Pool) exec(t *LinearGo) {
+	defer func() {
+		p.g.ChanCb <- t.cb
+		if r := recover(); r != nil {
+			util.Recovered(r, util.PanicContext{Source: "go", Module: p.g.Name})
+		}
+	}()
+
+	t.f()
+}
+
+// Go queues f, cb is called by Go.Cb once f returns. Under PoolReject neither
+// is called when ErrPoolFull is returned, under PoolBlock the callbacks of
+// the functions done are called while waiting
+// goroutine not safe, call it from the goroutine calling Go.Cb
+func (p *Pool) Go(f func(), cb func()) error {
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	t := &LinearGo{f: f, cb: cb}
+	p.g.pendingGo.Add(1)
+	for {
+		select {
+		case p.tasks <- t:
+			return nil
+		default:
+		}
+
+		if p.Policy == PoolReject {
+			p.g.pendingGo.Add(-1)
+			return ErrPoolFull
+		}
+		// the workers may be waiting for ChanCb
+		select {
+		case p.tasks <- t:
+			return nil
+		case cb := <-p.g.ChanCb:
+			p.g.Cb(cb)
+		}
+	}
+}
+
+// Len returns the number of functions queued
+// goroutine safe
+func (p *Pool) Len() int {
+	return len(p.tasks)
+}
+
+// Close stops the workers once the queued functions are executed, it
+// returns without waiting for them, Go.Close does
+// goroutine not safe
+func (p *Pool) Close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.tasks)
+}
@@ -3,27 +3,56 @@ package leaf
 import (
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/czx-lab/leaf/cluster"
 	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/console"
 	"github.com/czx-lab/leaf/log"
 	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/plugin"
 )
 
 func Run(mods ...module.Module) {
 	// logger
 	if conf.LogLevel != "" {
-		logger, err := log.New(conf.LogLevel, conf.LogPath, conf.LogFlag)
+		var logger *log.Logger
+		var err error
+		if conf.LogPath != "" && (conf.LogRotate > 0 || conf.LogMaxSize > 0) {
+			logger, err = log.NewWithRotation(conf.LogLevel, conf.LogPath, conf.LogFlag, logRotation(conf.LogRotate, conf.LogMaxSize))
+		} else {
+			logger, err = log.New(conf.LogLevel, conf.LogPath, conf.LogFlag)
+		}
 		if err != nil {
 			panic(err)
 		}
+		if err := logger.SetFormat(conf.LogFormat); err != nil {
+			panic(err)
+		}
+		if conf.LogAsync > 0 {
+			logger.SetAsync(conf.LogAsync, conf.LogAsyncDrop)
+		}
 		log.Export(logger)
 		defer logger.Close()
 	}
+	if len(conf.LogModules) > 0 {
+		initModuleLogs()
+		defer log.CloseModules()
+	}
 
 	log.Release("Leaf %v starting up", version)
 
+	// plugin
+	pluginMods, err := plugin.Install()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	for _, name := range plugin.Plugins() {
+		log.Release("plugin %v installed", name)
+	}
+	mods = append(pluginMods, mods...)
+
 	// module
 	for i := 0; i < len(mods); i++ {
 		module.Register(mods[i])
@@ -34,14 +63,83 @@ func Run(mods ...module.Module) {
 	cluster.Init()
 
 	// console
+	console.RegisterFunc("module", "start, stop or list the modules", module.Command)
+	console.RegisterFunc("log", "list or set the log levels", log.Command)
+	console.RegisterStats(stats)
 	console.Init()
 
 	// close
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
-	sig := <-c
-	log.Release("Leaf closing down (signal: %v)", sig)
+	if upgradeSignal != nil {
+		signal.Notify(c, upgradeSignal)
+	}
+	for {
+		sig := <-c
+		if sig != upgradeSignal {
+			log.Release("Leaf closing down (signal: %v)", sig)
+			module.Drain()
+			break
+		}
+
+		p, err := network.Upgrade()
+		if err != nil {
+			log.Error("upgrade error: %v", err)
+			continue
+		}
+		log.Release("Leaf upgraded to process %v, draining", p.Pid)
+		module.Drain()
+		break
+	}
 	console.Destroy()
 	cluster.Destroy()
 	module.Destroy()
 }
+
+func initModuleLogs() {
+	// module logs share stdout without LogPath
+	if conf.LogPath != "" {
+		errLogger, err := log.NewModuleWithRotation("error", "error", conf.LogPath, conf.LogFlag, logRotation(conf.LogRotate, conf.LogMaxSize))
+		if err != nil {
+			panic(err)
+		}
+		if err := errLogger.SetFormat(conf.LogFormat); err != nil {
+			panic(err)
+		}
+		if conf.LogAsync > 0 {
+			errLogger.SetAsync(conf.LogAsync, conf.LogAsyncDrop)
+		}
+		log.ExportError(errLogger)
+	}
+
+	for name, m := range conf.LogModules {
+		level := m.Level
+		if level == "" {
+			level = conf.LogLevel
+		}
+		if level == "" {
+			level = "debug"
+		}
+		logger, err := log.NewModuleWithRotation(name, level, conf.LogPath, conf.LogFlag, logRotation(m.Rotate, m.MaxSize))
+		if err != nil {
+			panic(err)
+		}
+		if err := logger.SetFormat(conf.LogFormat); err != nil {
+			panic(err)
+		}
+		if conf.LogAsync > 0 {
+			logger.SetAsync(conf.LogAsync, conf.LogAsyncDrop)
+		}
+		log.ExportModule(name, logger)
+	}
+}
+
+func logRotation(interval time.Duration, maxSize int64) log.Rotation {
+	return log.Rotation{
+		Interval:   interval,
+		MaxSize:    maxSize,
+		MaxBackups: conf.LogMaxBackups,
+		MaxAge:     conf.LogMaxAge,
+		Compress:   conf.LogCompress,
+	}
+}
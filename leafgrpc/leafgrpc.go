@@ -0,0 +1,129 @@
+// Package leafgrpc serves gRPC to the web backends and the GM tools, register
+// its Module in leaf.Run
+package leafgrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Module serves the services registered by Register. The unary calls of
+// the services and methods in Routes are executed in the goroutine of a
+// chanrpc server, e.g. the skeleton of the game module, the handlers may use
+// its state as chanrpc functions do. The other calls are executed in the
+// goroutines of gRPC
+type Module struct {
+	// host:port or unix:///path/to.sock
+	Addr string
+	// e.g. grpc.Creds
+	Options []grpc.ServerOption
+	// registers the services, e.g. pb.RegisterAdminServer(s, admin)
+	Register func(s *grpc.Server)
+	// keyed by service, e.g. "gm.Admin", or full method, e.g.
+	// "/gm.Admin/Kick"
+	Routes map[string]*chanrpc.Server
+
+	server *grpc.Server
+}
+
+// bridgeID is the chanrpc function executing the handlers of m
+type bridgeID struct {
+	m *Module
+}
+
+type call struct {
+	ctx     context.Context
+	req     interface{}
+	handler grpc.UnaryHandler
+}
+
+type reply struct {
+	resp interface{}
+	err  error
+}
+
+func (m *Module) OnInit() {
+	registered := make(map[*chanrpc.Server]bool)
+	for _, s := range m.Routes {
+		if registered[s] {
+			continue
+		}
+		registered[s] = true
+		chanrpc.Register1(s, bridgeID{m}, func(c *call) reply {
+			resp, err := c.handler(c.ctx, c.req)
+			return reply{resp, err}
+		})
+	}
+
+	opts := append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(m.bridge)}, m.Options...)
+	m.server = grpc.NewServer(opts...)
+	if m.Register != nil {
+		m.Register(m.server)
+	}
+}
+
+func (m *Module) Run(closeSig chan bool) {
+	ln, err := network.Listen(m.Addr)
+	if err != nil {
+		log.Error("leafgrpc: %v", err)
+		<-closeSig
+		return
+	}
+	go func() {
+		if err := m.server.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			log.Error("leafgrpc: %v", err)
+		}
+	}()
+
+	<-closeSig
+	done := make(chan struct{})
+	go func() {
+		m.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(conf.ShutdownTimeout):
+		m.server.Stop()
+	}
+}
+
+func (m *Module) OnDestroy() {}
+
+// route returns the server of the method, nil if it isn't routed
+func (m *Module) route(method string) *chanrpc.Server {
+	if s := m.Routes[method]; s != nil {
+		return s
+	}
+	// /service/method
+	if i := strings.LastIndexByte(method, '/'); i > 0 {
+		return m.Routes[method[1:i]]
+	}
+	return nil
+}
+
+func (m *Module) bridge(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s := m.route(info.FullMethod)
+	if s == nil {
+		return handler(ctx, req)
+	}
+
+	r, err := chanrpc.Call1Context[*call, reply](ctx, s.Open(0), bridgeID{m}, &call{ctx, req, handler})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, status.FromContextError(err).Err()
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return r.resp, r.err
+}
@@ -0,0 +1,156 @@
+package leafhttp
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+)
+
+// agent is the gate.Agent of a request, the first message written is the
+// response. It isn't an agent of a gate, e.g. Gate.Login panics with it
+type agent struct {
+	r         *http.Request
+	begin     time.Time
+	reply     chan interface{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mutex    sync.Mutex
+	userData interface{}
+	state    map[string]json.RawMessage
+	gate.Values
+}
+
+var _ gate.Agent = (*agent)(nil)
+
+func newAgent(r *http.Request) *agent {
+	a := new(agent)
+	a.r = r
+	a.begin = time.Now()
+	a.reply = make(chan interface{}, 1)
+	a.closed = make(chan struct{})
+	return a
+}
+
+func (a *agent) WriteMsg(msg interface{}) {
+	select {
+	case a.reply <- msg:
+	default:
+		log.Debug("leafhttp: %v: response already written, %T dropped", a.r.URL.Path, msg)
+	}
+}
+
+func (a *agent) WriteMsgPriority(p network.Priority, msg interface{}) {
+	a.WriteMsg(msg)
+}
+
+// msg is the response if none is written by then and the request is still
+// waiting for it
+func (a *agent) WriteMsgAfter(d time.Duration, msg interface{}) *gate.Scheduled {
+	return gate.AfterFunc(d, func() { a.WriteMsg(msg) })
+}
+
+// the response is acknowledged once written
+func (a *agent) WriteMsgAck(msg interface{}, timeout time.Duration, onAck func(), onFail func()) {
+	a.WriteMsg(msg)
+	if onAck != nil {
+		onAck()
+	}
+}
+
+func (a *agent) LocalAddr() net.Addr {
+	addr, _ := a.r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+func (a *agent) RemoteAddr() net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", a.r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
+
+// UpgradeRequest is the http request
+func (a *agent) UpgradeRequest() *http.Request {
+	return a.r
+}
+
+func (a *agent) Subprotocol() string {
+	return ""
+}
+
+func (a *agent) SetWriteRate(rate int, burst int) {}
+
+func (a *agent) WriteRate() (rate int, burst int) {
+	return 0, 0
+}
+
+// Close responds with no content if no message was written
+func (a *agent) Close() {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+	})
+}
+
+func (a *agent) Destroy() {
+	a.Close()
+}
+
+func (a *agent) Namespace() string {
+	return ""
+}
+
+func (a *agent) Stats() gate.AgentStats {
+	return gate.AgentStats{ConnectTime: a.begin}
+}
+
+func (a *agent) LastActivity() time.Time {
+	return a.begin
+}
+
+func (a *agent) UserData() interface{} {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.userData
+}
+
+func (a *agent) SetUserData(data interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.userData = data
+}
+
+func (a *agent) SetState(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.state == nil {
+		a.state = make(map[string]json.RawMessage)
+	}
+	a.state[key] = data
+	return nil
+}
+
+func (a *agent) State(key string, v interface{}) bool {
+	a.mutex.Lock()
+	data, ok := a.state[key]
+	a.mutex.Unlock()
+	return ok && json.Unmarshal(data, v) == nil
+}
+
+func (a *agent) DelState(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.state, key)
+}
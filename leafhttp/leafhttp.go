@@ -0,0 +1,162 @@
+// Package leafhttp routes http requests to the message handlers, e.g. for
+// webhooks and tools, register its Module in leaf.Run
+package leafhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Processor is protobuf.Processor or protobuf.ConcurrentProcessor
+type Processor interface {
+	Route(msg interface{}, userData interface{}) error
+	ID(msg interface{}) (uint16, bool)
+	Range(f func(id uint16, t reflect.Type))
+}
+
+// Module serves POST /msg/{id}: the json body is unmarshaled into the
+// message of id with protojson and routed as if read by a gate, the agent
+// is a gate.Agent of the request. The first message the handler writes to
+// the agent is the json response, with its id in the Leaf-Msg-Id header. A
+// handler closing the agent responds with no content. The agents don't get
+// NewAgent and CloseAgent
+type Module struct {
+	// host:port or unix:///path/to.sock
+	Addr      string
+	Processor Processor
+	// how long the handler has to respond, 5s if zero
+	Timeout time.Duration
+	// 1MB if zero
+	MaxBodyLen int64
+	// the request is forbidden if not nil, e.g. checking a token
+	Authorize func(r *http.Request) error
+
+	server *http.Server
+}
+
+func (m *Module) OnInit() {
+	if m.Timeout <= 0 {
+		m.Timeout = 5 * time.Second
+	}
+	if m.MaxBodyLen <= 0 {
+		m.MaxBodyLen = 1 << 20
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /msg/{id}", m.serveMsg)
+	m.server = &http.Server{Handler: mux}
+}
+
+func (m *Module) Run(closeSig chan bool) {
+	ln, err := network.Listen(m.Addr)
+	if err != nil {
+		log.Error("leafhttp: %v", err)
+		<-closeSig
+		return
+	}
+	go func() {
+		if err := m.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("leafhttp: %v", err)
+		}
+	}()
+
+	<-closeSig
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	m.server.Shutdown(ctx)
+}
+
+func (m *Module) OnDestroy() {}
+
+// msgType returns the type of the message of id, nil if not registered
+func (m *Module) msgType(id uint16) (t reflect.Type) {
+	m.Processor.Range(func(_id uint16, _t reflect.Type) {
+		if _id == id {
+			t = _t
+		}
+	})
+	return
+}
+
+func (m *Module) serveMsg(w http.ResponseWriter, r *http.Request) {
+	if m.Authorize != nil {
+		if err := m.Authorize(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 16)
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusNotFound)
+		return
+	}
+	t := m.msgType(uint16(id))
+	if t == nil {
+		http.Error(w, "message id not registered", http.StatusNotFound)
+		return
+	}
+	msg, ok := reflect.New(t.Elem()).Interface().(proto.Message)
+	if !ok {
+		http.Error(w, "not a protobuf message", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, m.MaxBodyLen))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := protojson.Unmarshal(body, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a := newAgent(r)
+	if err := m.Processor.Route(msg, a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	timer := time.NewTimer(m.Timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-a.reply:
+		m.write(w, resp)
+	case <-a.closed:
+		w.WriteHeader(http.StatusNoContent)
+	case <-timer.C:
+		http.Error(w, "no response", http.StatusGatewayTimeout)
+	case <-r.Context().Done():
+	}
+}
+
+func (m *Module) write(w http.ResponseWriter, resp interface{}) {
+	pm, ok := resp.(proto.Message)
+	if !ok {
+		http.Error(w, "response not a protobuf message", http.StatusInternalServerError)
+		return
+	}
+	data, err := protojson.Marshal(pm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id, ok := m.Processor.ID(resp); ok {
+		w.Header().Set("Leaf-Msg-Id", strconv.Itoa(int(id)))
+	}
+	w.Write(data)
+}
@@ -0,0 +1,250 @@
+// Package leafmetrics exposes the metrics of the gates and modules to
+// Prometheus, register its Module in leaf.Run
+package leafmetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Module serves /metrics with the collectors of the gates and the modules:
+//
+//	leaf_gate_agents{gate}                       agents connected
+//	leaf_gate_online_users{gate}                 users logged in
+//	leaf_gate_messages_routed_total{gate,msg}    messages received and routed
+//	leaf_gate_messages_written_total{gate,msg}   messages written
+//	leaf_gate_errors_total{gate,op}              unmarshal, route, marshal and write errors
+//	leaf_module_chanrpc_queue_length{module}     chanrpc calls queued
+//	leaf_module_timers{module}                   timers pending
+//	leaf_module_pending_go{module}               Skeleton.Go calls not called back
+//	leaf_module_executing_seconds{module}        how long the chanrpc function executing has run
+//	leaf_module_healthy{module}                  0 if the watchdog reported the module unhealthy
+//	leaf_chanrpc_calls_total{module,func}        chanrpc calls executed
+//	leaf_chanrpc_panics_total{module,func}       chanrpc calls which panicked
+//	leaf_chanrpc_canceled_total{module,func}     chanrpc calls given up by the caller
+//	leaf_chanrpc_call_seconds_total{module,func} execution time of the chanrpc calls
+//	leaf_chanrpc_call_max_seconds{module,func}   longest chanrpc call
+//
+// The module metrics but leaf_module_healthy are of the modules embedding a
+// module.Skeleton
+type Module struct {
+	// host:port
+	Addr string
+	// /metrics if empty
+	Path string
+	// prometheus.DefaultRegisterer and prometheus.DefaultGatherer if nil
+	Registry *prometheus.Registry
+
+	registerer prometheus.Registerer
+	collectors []prometheus.Collector
+	observer   *observer
+	server     *http.Server
+}
+
+func (m *Module) OnInit() {
+	if m.Path == "" {
+		m.Path = "/metrics"
+	}
+
+	var gatherer prometheus.Gatherer
+	if m.Registry != nil {
+		m.registerer, gatherer = m.Registry, m.Registry
+	} else {
+		m.registerer, gatherer = prometheus.DefaultRegisterer, prometheus.DefaultGatherer
+	}
+
+	m.observer = newObserver()
+	m.collectors = []prometheus.Collector{
+		m.observer.routed,
+		m.observer.written,
+		m.observer.errors,
+		collector{},
+	}
+	for _, c := range m.collectors {
+		if err := m.registerer.Register(c); err != nil {
+			log.Fatal("leafmetrics: %v", err)
+		}
+	}
+	gate.AddObserver(m.observer)
+
+	mux := http.NewServeMux()
+	mux.Handle(m.Path, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Addr: m.Addr, Handler: mux}
+}
+
+func (m *Module) Run(closeSig chan bool) {
+	go func() {
+		if err := m.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("leafmetrics: %v", err)
+		}
+	}()
+
+	<-closeSig
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m.server.Shutdown(ctx)
+}
+
+func (m *Module) OnDestroy() {
+	gate.RemoveObserver(m.observer)
+	for _, c := range m.collectors {
+		m.registerer.Unregister(c)
+	}
+}
+
+// observer counts the messages of the gates
+type observer struct {
+	routed  *prometheus.CounterVec
+	written *prometheus.CounterVec
+	errors  *prometheus.CounterVec
+}
+
+func newObserver() *observer {
+	o := new(observer)
+	o.routed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "leaf_gate_messages_routed_total",
+		Help: "Messages received and routed by the gate.",
+	}, []string{"gate", "msg"})
+	o.written = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "leaf_gate_messages_written_total",
+		Help: "Messages written by the gate.",
+	}, []string{"gate", "msg"})
+	o.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "leaf_gate_errors_total",
+		Help: "Messages which failed to unmarshal, route, marshal or write.",
+	}, []string{"gate", "op"})
+	return o
+}
+
+func (o *observer) Routed(g *gate.Gate, msg interface{}) {
+	o.routed.WithLabelValues(g.Name, msgName(msg)).Inc()
+}
+
+func (o *observer) Written(g *gate.Gate, msg interface{}) {
+	o.written.WithLabelValues(g.Name, msgName(msg)).Inc()
+}
+
+func (o *observer) Error(g *gate.Gate, op string, msg interface{}, err error) {
+	o.errors.WithLabelValues(g.Name, op).Inc()
+}
+
+func msgName(msg interface{}) string {
+	t := reflect.TypeOf(msg)
+	if t == nil {
+		return "<nil>"
+	}
+	return t.String()
+}
+
+var (
+	descAgents = prometheus.NewDesc("leaf_gate_agents",
+		"Agents connected to the gate.", []string{"gate"}, nil)
+	descOnlineUsers = prometheus.NewDesc("leaf_gate_online_users",
+		"Users logged in to the gate.", []string{"gate"}, nil)
+	descChanRPCLen = prometheus.NewDesc("leaf_module_chanrpc_queue_length",
+		"Chanrpc calls queued for the module.", []string{"module"}, nil)
+	descTimers = prometheus.NewDesc("leaf_module_timers",
+		"Timers of the module not fired or stopped.", []string{"module"}, nil)
+	descPendingGo = prometheus.NewDesc("leaf_module_pending_go",
+		"Functions started by Skeleton.Go not called back.", []string{"module"}, nil)
+	descExecuting = prometheus.NewDesc("leaf_module_executing_seconds",
+		"How long the chanrpc function executing by the module has run, 0 if idle.", []string{"module"}, nil)
+	descHealthy = prometheus.NewDesc("leaf_module_healthy",
+		"0 if the watchdog reported the module unhealthy, 1 otherwise.", []string{"module"}, nil)
+	descCalls = prometheus.NewDesc("leaf_chanrpc_calls_total",
+		"Chanrpc calls executed.", []string{"module", "func"}, nil)
+	descPanics = prometheus.NewDesc("leaf_chanrpc_panics_total",
+		"Chanrpc calls which panicked.", []string{"module", "func"}, nil)
+	descCanceled = prometheus.NewDesc("leaf_chanrpc_canceled_total",
+		"Chanrpc calls not executed as the caller gave up.", []string{"module", "func"}, nil)
+	descCallSeconds = prometheus.NewDesc("leaf_chanrpc_call_seconds_total",
+		"Execution time of the chanrpc calls.", []string{"module", "func"}, nil)
+	descCallMax = prometheus.NewDesc("leaf_chanrpc_call_max_seconds",
+		"Longest execution time of a chanrpc call.", []string{"module", "func"}, nil)
+)
+
+// collector reads the gauges when scraped
+type collector struct{}
+
+type skeleton interface {
+	Stats() module.SkeletonStats
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descAgents
+	ch <- descOnlineUsers
+	ch <- descChanRPCLen
+	ch <- descTimers
+	ch <- descPendingGo
+	ch <- descExecuting
+	ch <- descHealthy
+	ch <- descCalls
+	ch <- descPanics
+	ch <- descCanceled
+	ch <- descCallSeconds
+	ch <- descCallMax
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	gates := make(map[string]int)
+	for _, g := range gate.Gates() {
+		name := unique(gates, g.Name)
+		ch <- prometheus.MustNewConstMetric(descAgents, prometheus.GaugeValue, float64(g.AgentCount()), name)
+		ch <- prometheus.MustNewConstMetric(descOnlineUsers, prometheus.GaugeValue, float64(g.OnlineCount()), name)
+	}
+
+	modules := make(map[string]int)
+	module.Range(func(mi module.Module) {
+		name := unique(modules, module.Name(mi))
+		healthy := 1.0
+		if module.Health(module.Name(mi)) != nil {
+			healthy = 0
+		}
+		ch <- prometheus.MustNewConstMetric(descHealthy, prometheus.GaugeValue, healthy, name)
+
+		s, ok := mi.(skeleton)
+		if !ok {
+			return
+		}
+		stats := s.Stats()
+		ch <- prometheus.MustNewConstMetric(descChanRPCLen, prometheus.GaugeValue, float64(stats.ChanRPCLen), name)
+		ch <- prometheus.MustNewConstMetric(descTimers, prometheus.GaugeValue, float64(stats.Timers), name)
+		ch <- prometheus.MustNewConstMetric(descPendingGo, prometheus.GaugeValue, float64(stats.PendingGo), name)
+
+		rpc := stats.ChanRPC
+		var executing time.Duration
+		if rpc.Executing != nil {
+			executing = time.Since(rpc.ExecutingSince)
+		}
+		ch <- prometheus.MustNewConstMetric(descExecuting, prometheus.GaugeValue, executing.Seconds(), name)
+		funcs := make(map[string]int)
+		for id, f := range rpc.Funcs {
+			fn := unique(funcs, fmt.Sprint(id))
+			ch <- prometheus.MustNewConstMetric(descCalls, prometheus.CounterValue, float64(f.Calls), name, fn)
+			ch <- prometheus.MustNewConstMetric(descPanics, prometheus.CounterValue, float64(f.Panics), name, fn)
+			ch <- prometheus.MustNewConstMetric(descCanceled, prometheus.CounterValue, float64(f.Canceled), name, fn)
+			ch <- prometheus.MustNewConstMetric(descCallSeconds, prometheus.CounterValue, f.Total.Seconds(), name, fn)
+			ch <- prometheus.MustNewConstMetric(descCallMax, prometheus.GaugeValue, f.Max.Seconds(), name, fn)
+		}
+	})
+}
+
+// unique numbers the names seen before, e.g. the modules of the same type
+func unique(names map[string]int, name string) string {
+	n := names[name]
+	names[name]++
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v#%v", name, n+1)
+}
@@ -0,0 +1,117 @@
+package lockstep
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/room"
+	"github.com/czx-lab/leaf/timer"
+)
+
+type Input struct {
+	PlayerID interface{}
+	Data     interface{}
+}
+
+type Frame struct {
+	Index  uint32
+	Inputs []Input
+}
+
+// Sync seals the inputs collected during a tick into a frame and broadcasts
+// it to the members of the room. All methods must be called in the room
+// goroutine (one Sync per room).
+type Sync struct {
+	Interval time.Duration
+	// frames per catch-up message
+	CatchUpLen int
+	// NewMsg builds the message sent to clients for consecutive frames
+	NewMsg func(frames []*Frame) interface{}
+
+	room    *room.Room
+	frames  []*Frame
+	pending []Input
+	start   time.Time
+	t       *timer.Timer
+}
+
+func New(r *room.Room, interval time.Duration, newMsg func(frames []*Frame) interface{}) *Sync {
+	s := new(Sync)
+	s.Interval = interval
+	s.CatchUpLen = 100
+	s.NewMsg = newMsg
+	s.room = r
+	return s
+}
+
+func (s *Sync) Start() {
+	if s.Interval <= 0 {
+		s.Interval = 66 * time.Millisecond
+		log.Release("invalid Interval, reset to %v", s.Interval)
+	}
+	if s.CatchUpLen <= 0 {
+		s.CatchUpLen = 100
+		log.Release("invalid CatchUpLen, reset to %v", s.CatchUpLen)
+	}
+	s.start = time.Now()
+	s.schedule()
+}
+
+func (s *Sync) Stop() {
+	if s.t != nil {
+		s.t.Stop()
+		s.t = nil
+	}
+}
+
+// the next frame index
+func (s *Sync) Index() uint32 {
+	return uint32(len(s.frames))
+}
+
+// Input adds an input to the next frame
+func (s *Sync) Input(playerID interface{}, data interface{}) {
+	s.pending = append(s.pending, Input{PlayerID: playerID, Data: data})
+}
+
+// Frames returns the sealed frames from index on
+func (s *Sync) Frames(from uint32) []*Frame {
+	if int(from) >= len(s.frames) {
+		return nil
+	}
+	return s.frames[from:]
+}
+
+// CatchUp sends the frames from index on to a (late) joining agent
+func (s *Sync) CatchUp(a gate.Agent, from uint32) {
+	frames := s.Frames(from)
+	for len(frames) > 0 {
+		n := s.CatchUpLen
+		if n > len(frames) {
+			n = len(frames)
+		}
+		a.WriteMsg(s.NewMsg(frames[:n]))
+		frames = frames[n:]
+	}
+}
+
+func (s *Sync) schedule() {
+	// ticks are aligned to the start time, so delays don't accumulate
+	next := s.start.Add(time.Duration(len(s.frames)+1) * s.Interval)
+	d := time.Until(next)
+	if d < 0 {
+		d = 0
+	}
+	s.t = s.room.Skeleton().AfterFunc(d, s.tick)
+}
+
+func (s *Sync) tick() {
+	f := &Frame{Index: uint32(len(s.frames)), Inputs: s.pending}
+	s.pending = nil
+	s.frames = append(s.frames, f)
+	s.room.SetTick(f.Index)
+
+	s.room.Broadcast(s.NewMsg([]*Frame{f}))
+	s.schedule()
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncWriter writes to w on its own goroutine, the writes are buffered in
+// a ring of lines. Full, it drops the lines if drop, or blocks the writers
+// goroutine safe
+type AsyncWriter struct {
+	w       io.Writer
+	drop    bool
+	lines   chan asyncLine
+	dropped atomic.Uint64
+	wg      sync.WaitGroup
+	mutex   sync.RWMutex
+	closed  bool
+}
+
+type asyncLine struct {
+	data []byte
+	// Flush
+	done chan struct{}
+}
+
+func NewAsyncWriter(w io.Writer, size int, drop bool) *AsyncWriter {
+	if size <= 0 {
+		size = 1024
+	}
+	aw := &AsyncWriter{w: w, drop: drop, lines: make(chan asyncLine, size)}
+	aw.wg.Add(1)
+	go aw.run()
+	return aw
+}
+
+func (aw *AsyncWriter) run() {
+	defer aw.wg.Done()
+	for line := range aw.lines {
+		if line.done != nil {
+			close(line.done)
+			continue
+		}
+		aw.w.Write(line.data)
+	}
+}
+
+// Write never fails, p is copied
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	aw.mutex.RLock()
+	defer aw.mutex.RUnlock()
+	if aw.closed {
+		return aw.w.Write(p)
+	}
+
+	line := asyncLine{data: append([]byte(nil), p...)}
+	if !aw.drop {
+		aw.lines <- line
+		return len(p), nil
+	}
+	select {
+	case aw.lines <- line:
+	default:
+		aw.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of the lines dropped
+func (aw *AsyncWriter) Dropped() uint64 {
+	return aw.dropped.Load()
+}
+
+// Flush waits for the lines written before to be written to w
+func (aw *AsyncWriter) Flush() {
+	aw.mutex.RLock()
+	defer aw.mutex.RUnlock()
+	if aw.closed {
+		return
+	}
+
+	done := make(chan struct{})
+	aw.lines <- asyncLine{done: done}
+	<-done
+}
+
+// Close flushes the lines, the later writes go to w directly
+func (aw *AsyncWriter) Close() error {
+	aw.mutex.Lock()
+	if aw.closed {
+		aw.mutex.Unlock()
+		return nil
+	}
+	aw.closed = true
+	close(aw.lines)
+	aw.mutex.Unlock()
+
+	aw.wg.Wait()
+	return nil
+}
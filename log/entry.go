@@ -0,0 +1,138 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a logger with fields, key-value pairs appended to its lines:
+//
+//	log.With("agent", a.RemoteAddr(), "msgID", id).Error("unknown message")
+//
+// goroutine safe
+type Entry struct {
+	logger *Logger
+	fields []interface{}
+}
+
+// With returns an entry of logger with the fields kv
+func (logger *Logger) With(kv ...interface{}) *Entry {
+	return &Entry{logger: logger, fields: kv}
+}
+
+// With returns an entry of the global logger with the fields kv
+func With(kv ...interface{}) *Entry {
+	return gLogger.With(kv...)
+}
+
+// With returns an entry with the fields kv appended
+func (e *Entry) With(kv ...interface{}) *Entry {
+	fields := make([]interface{}, 0, len(e.fields)+len(kv))
+	fields = append(fields, e.fields...)
+	fields = append(fields, kv...)
+	return &Entry{logger: e.logger, fields: fields}
+}
+
+func (e *Entry) Debug(format string, a ...interface{}) {
+	e.logger.doPrintf(debugLevel, e.fields, format, a...)
+}
+
+func (e *Entry) Release(format string, a ...interface{}) {
+	e.logger.doPrintf(releaseLevel, e.fields, format, a...)
+}
+
+func (e *Entry) Error(format string, a ...interface{}) {
+	e.logger.doPrintf(errorLevel, e.fields, format, a...)
+}
+
+func (e *Entry) Fatal(format string, a ...interface{}) {
+	e.logger.doPrintf(fatalLevel, e.fields, format, a...)
+}
+
+// fieldKey returns the key i of fields, a value without key is keyed by
+// "!BADKEY"
+func fieldKey(fields []interface{}, i int) string {
+	if i+1 == len(fields) {
+		return "!BADKEY"
+	}
+	if key, ok := fields[i].(string); ok {
+		return key
+	}
+	return fmt.Sprint(fields[i])
+}
+
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i+1 == len(fields) {
+		return fields[i]
+	}
+	return fields[i+1]
+}
+
+// encodeFields returns the fields as " key=value"
+func encodeFields(fields []interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fieldKey(fields, i))
+		b.WriteByte('=')
+		v := fmt.Sprint(fieldValue(fields, i))
+		if strings.ContainsAny(v, " =\"") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// encodeJSON returns a json line
+func encodeJSON(flag int, module string, level int, fields []interface{}, s string) []byte {
+	b := make([]byte, 0, 128)
+	b = append(b, '{')
+	if flag&(log.Ldate|log.Ltime) != 0 {
+		now := time.Now()
+		if flag&log.LUTC != 0 {
+			now = now.UTC()
+		}
+		b = append(b, `"time":"`...)
+		b = now.AppendFormat(b, "2006-01-02T15:04:05.000Z07:00")
+		b = append(b, `",`...)
+	}
+	b = append(b, `"level":"`...)
+	b = append(b, levelNames[level]...)
+	b = append(b, '"')
+	if module != "" {
+		b = append(b, `,"module":`...)
+		b = strconv.AppendQuote(b, module)
+	}
+	b = append(b, `,"msg":`...)
+	b = appendJSON(b, s)
+	for i := 0; i < len(fields); i += 2 {
+		b = append(b, ',')
+		b = appendJSON(b, fieldKey(fields, i))
+		b = append(b, ':')
+		b = appendJSON(b, fieldValue(fields, i))
+	}
+	return append(b, '}', '\n')
+}
+
+func appendJSON(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case error:
+		return appendJSON(b, v.Error())
+	case fmt.Stringer:
+		return appendJSON(b, v.String())
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return append(b, data...)
+}
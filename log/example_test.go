@@ -1,7 +1,13 @@
 package log_test
 
 import (
+	"compress/gzip"
+	"fmt"
+	"io"
 	l "log"
+	"log/slog"
+	"os"
+	"path/filepath"
 
 	"github.com/czx-lab/leaf/log"
 )
@@ -28,3 +34,109 @@ func Example() {
 	log.Debug("will not print")
 	log.Release("My name is %v", name)
 }
+
+func ExampleModule() {
+	logger, err := log.NewModule("gate", "release", "", 0, 0)
+	if err != nil {
+		return
+	}
+	log.ExportModule("gate", logger)
+	defer log.CloseModules()
+
+	log.Module("gate").Debug("will not print")
+	log.Module("gate").Release("My name is %v", "Leaf")
+
+	// Output:
+	// [gate] [release] My name is Leaf
+}
+
+func ExampleWith() {
+	logger, err := log.New("debug", "", 0)
+	if err != nil {
+		return
+	}
+	defer logger.Close()
+
+	entry := logger.With("agent", "127.0.0.1:3563")
+	entry.With("msgID", 7).Error("unknown message")
+
+	logger.SetFormat("json")
+	entry.Release("closed: %v", "timeout")
+
+	logger.SetLevel("error")
+	entry.Release("will not print")
+
+	// Output:
+	// [error  ] unknown message agent=127.0.0.1:3563 msgID=7
+	// {"level":"release","msg":"closed: timeout","agent":"127.0.0.1:3563"}
+}
+
+func ExampleNewHandler() {
+	logger, err := log.New("release", "", 0)
+	if err != nil {
+		return
+	}
+	defer logger.Close()
+
+	l := slog.New(log.NewHandler(logger)).WithGroup("db")
+	l.Debug("will not print")
+	l.Warn("slow query", "ms", 120)
+
+	// Output:
+	// [release] slow query db.ms=120
+}
+
+func ExampleRotation() {
+	dir, err := os.MkdirTemp("", "leaf_log")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := log.NewModuleWithRotation("gate", "release", dir, 0, log.Rotation{
+		MaxSize:    20,
+		MaxBackups: 1,
+		Compress:   true,
+	})
+	if err != nil {
+		return
+	}
+	for i := 1; i <= 3; i++ {
+		logger.Release("line %v", i)
+	}
+	logger.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "gate_*.log.gz"))
+	fmt.Println(len(backups))
+	f, err := os.Open(backups[0])
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return
+	}
+	io.Copy(os.Stdout, zr)
+	data, _ := os.ReadFile(filepath.Join(dir, "gate.log"))
+	fmt.Print(string(data))
+
+	// Output:
+	// 1
+	// [release] line 2
+	// [release] line 3
+}
+
+func ExampleLogger_SetAsync() {
+	logger, err := log.New("release", "", 0)
+	if err != nil {
+		return
+	}
+	logger.SetAsync(16, false)
+
+	logger.Release("My name is %v", "Leaf")
+	logger.Close()
+
+	// Output:
+	// [release] My name is Leaf
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,26 +26,44 @@ const (
 	printFatalLevel   = "[fatal  ] "
 )
 
+var (
+	printLevels = [...]string{printDebugLevel, printReleaseLevel, printErrorLevel, printFatalLevel}
+	levelNames  = [...]string{"debug", "release", "error", "fatal"}
+)
+
 type Logger struct {
-	level      int
+	level      atomic.Int32
 	baseLogger *log.Logger
 	baseFile   *os.File
+	// module logger
+	name   string
+	rotate *rotation
+	// the line format, see SetFormat
+	json atomic.Bool
+	// see SetAsync
+	async *AsyncWriter
 }
 
-func New(strLevel string, pathname string, flag int) (*Logger, error) {
-	// level
-	var level int
+func parseLevel(strLevel string) (int, error) {
 	switch strings.ToLower(strLevel) {
 	case "debug":
-		level = debugLevel
+		return debugLevel, nil
 	case "release":
-		level = releaseLevel
+		return releaseLevel, nil
 	case "error":
-		level = errorLevel
+		return errorLevel, nil
 	case "fatal":
-		level = fatalLevel
+		return fatalLevel, nil
 	default:
-		return nil, errors.New("unknown level: " + strLevel)
+		return 0, errors.New("unknown level: " + strLevel)
+	}
+}
+
+func New(strLevel string, pathname string, flag int) (*Logger, error) {
+	// level
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return nil, err
 	}
 
 	// logger
@@ -74,15 +93,51 @@ func New(strLevel string, pathname string, flag int) (*Logger, error) {
 
 	// new
 	logger := new(Logger)
-	logger.level = level
+	logger.level.Store(int32(level))
 	logger.baseLogger = baseLogger
 	logger.baseFile = baseFile
 
 	return logger, nil
 }
 
+// NewWithRotation returns a logger writing to pathname/leaf.log rotated by r
+func NewWithRotation(strLevel string, pathname string, flag int, r Rotation) (*Logger, error) {
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	rotate, err := newRotation(path.Join(pathname, "leaf.log"), r)
+	if err != nil {
+		return nil, err
+	}
+	logger := new(Logger)
+	logger.level.Store(int32(level))
+	logger.baseLogger = log.New(rotate, "", flag)
+	logger.rotate = rotate
+	return logger, nil
+}
+
+// SetAsync makes logger write through an AsyncWriter of size lines, so
+// that a slow disk doesn't block the callers. Fatal flushes the lines
+// It's dangerous to call the method on logging
+func (logger *Logger) SetAsync(size int, drop bool) {
+	if logger.async != nil {
+		return
+	}
+	logger.async = NewAsyncWriter(logger.baseLogger.Writer(), size, drop)
+	logger.baseLogger.SetOutput(logger.async)
+}
+
 // It's dangerous to call the method on logging
 func (logger *Logger) Close() {
+	if logger.async != nil {
+		logger.async.Close()
+		logger.async = nil
+	}
+	if logger.rotate != nil {
+		logger.rotate.close()
+	}
 	if logger.baseFile != nil {
 		logger.baseFile.Close()
 	}
@@ -91,36 +146,98 @@ func (logger *Logger) Close() {
 	logger.baseFile = nil
 }
 
-func (logger *Logger) doPrintf(level int, printLevel string, format string, a ...interface{}) {
-	if level < logger.level {
+// SetLevel changes the level of logger
+// goroutine safe
+func (logger *Logger) SetLevel(strLevel string) error {
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return err
+	}
+	logger.level.Store(int32(level))
+	return nil
+}
+
+// Level returns the level of logger
+// goroutine safe
+func (logger *Logger) Level() string {
+	return levelNames[logger.level.Load()]
+}
+
+// SetFormat changes the line format of logger, "console" or "json". A json
+// line holds the time if the flag of logger prints it, the level, the
+// module, the message and the fields
+// goroutine safe
+func (logger *Logger) SetFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "console", "":
+		logger.json.Store(false)
+	case "json":
+		logger.json.Store(true)
+	default:
+		return errors.New("unknown format: " + format)
+	}
+	return nil
+}
+
+func (logger *Logger) doPrintf(level int, fields []interface{}, format string, a ...interface{}) {
+	if level < int(logger.level.Load()) {
 		return
 	}
+	logger.output(level, fields, fmt.Sprintf(format, a...))
+}
+
+func (logger *Logger) output(level int, fields []interface{}, s string) {
 	if logger.baseLogger == nil {
 		panic("logger closed")
 	}
 
-	format = printLevel + format
-	logger.baseLogger.Output(3, fmt.Sprintf(format, a...))
+	logger.write(logger, level, fields, s)
+
+	// shared error log
+	if level >= errorLevel {
+		if errLogger := gErrLogger.Load(); errLogger != nil && errLogger != logger {
+			errLogger.write(logger, level, fields, s)
+		}
+	}
 
 	if level == fatalLevel {
+		if logger.async != nil {
+			logger.async.Flush()
+		}
+		if errLogger := gErrLogger.Load(); errLogger != nil && errLogger.async != nil {
+			errLogger.async.Flush()
+		}
 		os.Exit(1)
 	}
 }
 
+// write writes the line of origin
+func (logger *Logger) write(origin *Logger, level int, fields []interface{}, s string) {
+	if logger.json.Load() {
+		logger.baseLogger.Writer().Write(encodeJSON(logger.baseLogger.Flags(), origin.name, level, fields, s))
+		return
+	}
+
+	if origin != logger && origin.name != "" {
+		s = "[" + origin.name + "] " + s
+	}
+	logger.baseLogger.Output(5, printLevels[level]+s+encodeFields(fields))
+}
+
 func (logger *Logger) Debug(format string, a ...interface{}) {
-	logger.doPrintf(debugLevel, printDebugLevel, format, a...)
+	logger.doPrintf(debugLevel, nil, format, a...)
 }
 
 func (logger *Logger) Release(format string, a ...interface{}) {
-	logger.doPrintf(releaseLevel, printReleaseLevel, format, a...)
+	logger.doPrintf(releaseLevel, nil, format, a...)
 }
 
 func (logger *Logger) Error(format string, a ...interface{}) {
-	logger.doPrintf(errorLevel, printErrorLevel, format, a...)
+	logger.doPrintf(errorLevel, nil, format, a...)
 }
 
 func (logger *Logger) Fatal(format string, a ...interface{}) {
-	logger.doPrintf(fatalLevel, printFatalLevel, format, a...)
+	logger.doPrintf(fatalLevel, nil, format, a...)
 }
 
 var gLogger, _ = New("debug", "", log.LstdFlags)
@@ -133,19 +250,19 @@ func Export(logger *Logger) {
 }
 
 func Debug(format string, a ...interface{}) {
-	gLogger.doPrintf(debugLevel, printDebugLevel, format, a...)
+	gLogger.doPrintf(debugLevel, nil, format, a...)
 }
 
 func Release(format string, a ...interface{}) {
-	gLogger.doPrintf(releaseLevel, printReleaseLevel, format, a...)
+	gLogger.doPrintf(releaseLevel, nil, format, a...)
 }
 
 func Error(format string, a ...interface{}) {
-	gLogger.doPrintf(errorLevel, printErrorLevel, format, a...)
+	gLogger.doPrintf(errorLevel, nil, format, a...)
 }
 
 func Fatal(format string, a ...interface{}) {
-	gLogger.doPrintf(fatalLevel, printFatalLevel, format, a...)
+	gLogger.doPrintf(fatalLevel, nil, format, a...)
 }
 
 func Close() {
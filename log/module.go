@@ -0,0 +1,130 @@
+package log
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	gModuleLoggers sync.Map
+	gErrLogger     atomic.Pointer[Logger]
+)
+
+// NewModule returns a logger writing to pathname/name.log, the file is renamed
+// with its opening time appended every rotate, zero never rotates it. An empty
+// pathname logs to stdout
+func NewModule(name string, strLevel string, pathname string, flag int, rotate time.Duration) (*Logger, error) {
+	return NewModuleWithRotation(name, strLevel, pathname, flag, Rotation{Interval: rotate})
+}
+
+// NewModuleWithRotation returns a logger writing to pathname/name.log rotated
+// by r, see NewModule
+func NewModuleWithRotation(name string, strLevel string, pathname string, flag int, r Rotation) (*Logger, error) {
+	level, err := parseLevel(strLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := new(Logger)
+	logger.level.Store(int32(level))
+	logger.name = name
+	if pathname == "" {
+		logger.baseLogger = log.New(os.Stdout, "["+name+"] ", flag|log.Lmsgprefix)
+		return logger, nil
+	}
+
+	rotate, err := newRotation(path.Join(pathname, name+".log"), r)
+	if err != nil {
+		return nil, err
+	}
+	logger.baseLogger = log.New(rotate, "", flag)
+	logger.rotate = rotate
+	return logger, nil
+}
+
+// ExportModule makes Module(name) return logger
+// It's dangerous to call the method on logging
+func ExportModule(name string, logger *Logger) {
+	if logger != nil {
+		gModuleLoggers.Store(name, logger)
+	}
+}
+
+// ExportError sets the shared error log, the errors of every other logger are
+// also written to it
+// It's dangerous to call the method on logging
+func ExportError(logger *Logger) {
+	gErrLogger.Store(logger)
+}
+
+// Module returns the logger exported for name, or the global logger.
+// goroutine safe
+func Module(name string) *Logger {
+	if logger, ok := gModuleLoggers.Load(name); ok {
+		return logger.(*Logger)
+	}
+	return gLogger
+}
+
+// CloseModules closes the module loggers and the error log
+func CloseModules() {
+	gModuleLoggers.Range(func(name, logger interface{}) bool {
+		logger.(*Logger).Close()
+		gModuleLoggers.Delete(name)
+		return true
+	})
+	if errLogger := gErrLogger.Swap(nil); errLogger != nil {
+		errLogger.Close()
+	}
+}
+
+// Command lists or changes the levels of the global and module loggers, for
+// the console:
+//
+//	console.RegisterFunc("log", "list or set the log levels", log.Command)
+func Command(args []string) string {
+	usage := "usage: log list | set <level> [<module>]"
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "list":
+		lines := []string{"global: " + gLogger.Level()}
+		var names []string
+		gModuleLoggers.Range(func(name, _ interface{}) bool {
+			names = append(names, name.(string))
+			return true
+		})
+		sort.Strings(names)
+		for _, name := range names {
+			lines = append(lines, name+": "+Module(name).Level())
+		}
+		return strings.Join(lines, "\r\n")
+	case "set":
+		if len(args) != 2 && len(args) != 3 {
+			return usage
+		}
+		logger := gLogger
+		if len(args) == 3 {
+			l, ok := gModuleLoggers.Load(args[2])
+			if !ok {
+				return fmt.Sprintf("module log %v not found", args[2])
+			}
+			logger = l.(*Logger)
+		}
+		if err := logger.SetLevel(args[1]); err != nil {
+			return err.Error()
+		}
+		return "done"
+	default:
+		return usage
+	}
+}
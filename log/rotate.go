@@ -0,0 +1,204 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation rotates a log file, renamed with its opening time appended
+type Rotation struct {
+	// rotate every Interval and before the file exceeds MaxSize bytes, zero
+	// never rotates it
+	Interval time.Duration
+	MaxSize  int64
+	// the rotated files kept at most and how long, zero keeps them all
+	MaxBackups int
+	MaxAge     time.Duration
+	// gzip the rotated files
+	Compress bool
+}
+
+// rotation is the file of a logger, rotated on writing
+type rotation struct {
+	sync.Mutex
+	Rotation
+	filename string
+	file     *os.File
+	size     int64
+	opened   time.Time
+	// the compression and removal of the rotated files
+	cleanup sync.Mutex
+	wg      sync.WaitGroup
+}
+
+func newRotation(filename string, r Rotation) (*rotation, error) {
+	rotate := &rotation{Rotation: r, filename: filename}
+	if err := rotate.open(); err != nil {
+		return nil, err
+	}
+	return rotate, nil
+}
+
+func (r *rotation) open() error {
+	file, err := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotation) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.Interval > 0 && time.Since(r.opened) >= r.Interval ||
+		r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		r.rotate()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate keeps writing to the old file on errors
+func (r *rotation) rotate() {
+	t := r.opened
+	base := strings.TrimSuffix(r.filename, ".log")
+	stamp := fmt.Sprintf("%s_%d%02d%02d_%02d_%02d_%02d",
+		base,
+		t.Year(),
+		t.Month(),
+		t.Day(),
+		t.Hour(),
+		t.Minute(),
+		t.Second())
+	// rotated by size within a second
+	rotated := stamp + ".log"
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s_%d.log", stamp, i)
+	}
+
+	old := r.file
+	if err := os.Rename(r.filename, rotated); err != nil {
+		r.opened = time.Now()
+		return
+	}
+	if err := r.open(); err != nil {
+		r.file = old
+		r.opened = time.Now()
+		return
+	}
+	old.Close()
+
+	if r.Compress || r.MaxBackups > 0 || r.MaxAge > 0 {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.clean(rotated)
+		}()
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// clean compresses the file rotated and removes the expired ones
+func (r *rotation) clean(rotated string) {
+	r.cleanup.Lock()
+	defer r.cleanup.Unlock()
+
+	if r.Compress {
+		// removed already if the cleanups run out of order
+		if err := compress(rotated); err != nil && !os.IsNotExist(err) {
+			Error("compress %v error: %v", rotated, err)
+		}
+	}
+	if r.MaxBackups <= 0 && r.MaxAge <= 0 {
+		return
+	}
+
+	dir, base := filepath.Split(strings.TrimSuffix(r.filename, ".log"))
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(base) + `_\d{8}_\d{2}_\d{2}_\d{2}(_\d+)?\.log(\.gz)?$`)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && pattern.MatchString(e.Name()) {
+			backups = append(backups, e.Name())
+		}
+	}
+	// the newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, name := range backups {
+		name = filepath.Join(dir, name)
+		expired := r.MaxBackups > 0 && i >= r.MaxBackups
+		if !expired && r.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > r.MaxAge {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(name)
+		}
+	}
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err1 := dst.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func (r *rotation) close() {
+	r.Lock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.Unlock()
+	r.wg.Wait()
+}
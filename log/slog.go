@@ -0,0 +1,96 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+type handler struct {
+	logger *Logger
+	fields []interface{}
+	group  string
+}
+
+// NewHandler returns a slog.Handler writing to logger, or to the global
+// logger if nil, so that the records of other libraries go to the same
+// log:
+//
+//	slog.SetDefault(slog.New(log.NewHandler(nil)))
+//
+// The slog levels below Info are debug, below Error release, the others
+// error
+func NewHandler(logger *Logger) slog.Handler {
+	return &handler{logger: logger}
+}
+
+func (h *handler) target() *Logger {
+	if h.logger != nil {
+		return h.logger
+	}
+	return gLogger
+}
+
+func slogLevel(l slog.Level) int {
+	switch {
+	case l < slog.LevelInfo:
+		return debugLevel
+	case l < slog.LevelError:
+		return releaseLevel
+	default:
+		return errorLevel
+	}
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return slogLevel(l) >= int(h.target().level.Load())
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	logger := h.target()
+	level := slogLevel(r.Level)
+	if level < int(logger.level.Load()) {
+		return nil
+	}
+
+	fields := make([]interface{}, len(h.fields), len(h.fields)+2*r.NumAttrs())
+	copy(fields, h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = h.appendAttr(fields, h.group, a)
+		return true
+	})
+	logger.output(level, fields, r.Message)
+	return nil
+}
+
+func (h *handler) appendAttr(fields []interface{}, group string, a slog.Attr) []interface{} {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = h.appendAttr(fields, group, ga)
+		}
+		return fields
+	}
+	return append(fields, group+a.Key, a.Value.Any())
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]interface{}, len(h.fields), len(h.fields)+2*len(attrs))
+	copy(fields, h.fields)
+	for _, a := range attrs {
+		fields = h.appendAttr(fields, h.group, a)
+	}
+	return &handler{logger: h.logger, fields: fields, group: h.group}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{logger: h.logger, fields: h.fields, group: h.group + name + "."}
+}
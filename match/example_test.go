@@ -0,0 +1,34 @@
+package match_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/czx-lab/leaf/match"
+)
+
+func Example() {
+	done := make(chan bool)
+	m := &match.Matcher{
+		Size:     2,
+		Interval: 10 * time.Millisecond,
+		Window:   100,
+		OnMatch: func(tickets []*match.Ticket) {
+			fmt.Println(tickets[0].ID, "vs", tickets[1].ID)
+			done <- true
+		},
+	}
+	m.OnInit()
+	closeSig := make(chan bool, 1)
+	go m.Run(closeSig)
+
+	m.Enqueue(&match.Ticket{ID: "a", Mode: "1v1", Rating: 1000})
+	m.Enqueue(&match.Ticket{ID: "b", Mode: "1v1", Rating: 1500})
+	m.Enqueue(&match.Ticket{ID: "c", Mode: "1v1", Rating: 1050})
+	<-done
+
+	closeSig <- true
+
+	// Output:
+	// a vs c
+}
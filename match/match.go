@@ -0,0 +1,222 @@
+package match
+
+import (
+	"sort"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/room"
+)
+
+type Ticket struct {
+	ID     interface{}
+	Agent  gate.Agent
+	Mode   string
+	Rating int
+	Attrs  map[string]interface{}
+	Time   time.Time
+}
+
+// Matcher is a module matching queued tickets of the same mode whose
+// ratings are within each other's window. The window of a ticket starts at
+// Window and grows by WindowStep per second waited, up to MaxWindow.
+type Matcher struct {
+	Size       int
+	Interval   time.Duration
+	Window     int
+	WindowStep int
+	MaxWindow  int
+	// optional extra rule, both tickets have the same mode
+	Rule func(a, b *Ticket) bool
+	// called in the matcher goroutine
+	OnMatch func(tickets []*Ticket)
+
+	skeleton *module.Skeleton
+	queues   map[string][]*Ticket
+	tickets  map[interface{}]*Ticket
+}
+
+func (m *Matcher) OnInit() {
+	if m.Size <= 0 {
+		m.Size = 2
+		log.Release("invalid Size, reset to %v", m.Size)
+	}
+	if m.Interval <= 0 {
+		m.Interval = time.Second
+		log.Release("invalid Interval, reset to %v", m.Interval)
+	}
+	if m.MaxWindow < m.Window {
+		m.MaxWindow = m.Window
+	}
+	if m.OnMatch == nil {
+		log.Fatal("OnMatch must not be nil")
+	}
+
+	m.queues = make(map[string][]*Ticket)
+	m.tickets = make(map[interface{}]*Ticket)
+	m.skeleton = &module.Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		AsynCallLen:        10,
+		ChanRPCServer:      chanrpc.NewServer(1000),
+	}
+	m.skeleton.Init()
+	m.skeleton.RegisterChanRPC("enqueue", m.enqueue)
+	m.skeleton.RegisterChanRPC("cancel", m.cancel)
+}
+
+func (m *Matcher) Run(closeSig chan bool) {
+	var tick func()
+	tick = func() {
+		m.match()
+		m.skeleton.AfterFunc(m.Interval, tick)
+	}
+	m.skeleton.AfterFunc(m.Interval, tick)
+
+	m.skeleton.Run(closeSig)
+}
+
+func (m *Matcher) OnDestroy() {}
+
+// a queued ticket with the same ID is replaced
+// goroutine safe
+func (m *Matcher) Enqueue(t *Ticket) {
+	if t.Time.IsZero() {
+		t.Time = time.Now()
+	}
+	m.skeleton.ChanRPCServer.Go("enqueue", t)
+}
+
+// goroutine safe
+func (m *Matcher) Cancel(id interface{}) {
+	m.skeleton.ChanRPCServer.Go("cancel", id)
+}
+
+func (m *Matcher) enqueue(args []interface{}) {
+	t := args[0].(*Ticket)
+	m.remove(t.ID)
+	m.tickets[t.ID] = t
+	m.queues[t.Mode] = append(m.queues[t.Mode], t)
+}
+
+func (m *Matcher) cancel(args []interface{}) {
+	m.remove(args[0])
+}
+
+func (m *Matcher) remove(id interface{}) {
+	t, ok := m.tickets[id]
+	if !ok {
+		return
+	}
+	delete(m.tickets, id)
+
+	q := m.queues[t.Mode]
+	for i, qt := range q {
+		if qt == t {
+			m.queues[t.Mode] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *Matcher) window(t *Ticket, now time.Time) int {
+	w := m.Window + int(now.Sub(t.Time)/time.Second)*m.WindowStep
+	if w > m.MaxWindow {
+		w = m.MaxWindow
+	}
+	return w
+}
+
+func (m *Matcher) match() {
+	now := time.Now()
+	for mode, q := range m.queues {
+		// oldest first
+		matched := make(map[*Ticket]bool)
+		for _, t := range q {
+			if matched[t] {
+				continue
+			}
+
+			var candidates []*Ticket
+			for _, c := range q {
+				if c == t || matched[c] {
+					continue
+				}
+				diff := c.Rating - t.Rating
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > m.window(t, now) || diff > m.window(c, now) {
+					continue
+				}
+				if m.Rule != nil && !m.Rule(t, c) {
+					continue
+				}
+				candidates = append(candidates, c)
+			}
+			if len(candidates) < m.Size-1 {
+				continue
+			}
+
+			sort.SliceStable(candidates, func(i, j int) bool {
+				return abs(candidates[i].Rating-t.Rating) < abs(candidates[j].Rating-t.Rating)
+			})
+			tickets := append([]*Ticket{t}, candidates[:m.Size-1]...)
+			for _, mt := range tickets {
+				matched[mt] = true
+				delete(m.tickets, mt.ID)
+			}
+			m.OnMatch(tickets)
+		}
+
+		rest := q[:0]
+		for _, t := range q {
+			if !matched[t] {
+				rest = append(rest, t)
+			}
+		}
+		if len(rest) == 0 {
+			delete(m.queues, mode)
+		} else {
+			m.queues[mode] = rest
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// CreateRoom returns an OnMatch callback creating a room for every match,
+// joining the matched agents and sending each of them notify(r, t) if it is
+// not nil.
+func CreateRoom(rooms *room.Manager,
+	newRoom func(tickets []*Ticket) (id interface{}, handler room.Handler),
+	notify func(r *room.Room, t *Ticket) interface{}) func([]*Ticket) {
+	return func(tickets []*Ticket) {
+		id, handler := newRoom(tickets)
+		r, err := rooms.Create(id, handler)
+		if err != nil {
+			log.Error("create room %v error: %v", id, err)
+			return
+		}
+
+		for _, t := range tickets {
+			if t.Agent == nil {
+				continue
+			}
+			r.Join(t.Agent)
+			if notify != nil {
+				if msg := notify(r, t); msg != nil {
+					t.Agent.WriteMsg(msg)
+				}
+			}
+		}
+	}
+}
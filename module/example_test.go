@@ -0,0 +1,59 @@
+package module_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/module"
+)
+
+type mod struct {
+	name string
+	deps []string
+}
+
+func (m *mod) ModuleName() string  { return m.name }
+func (m *mod) DependsOn() []string { return m.deps }
+func (m *mod) OnInit()             { fmt.Println("init", m.name) }
+func (m *mod) OnStart()            { fmt.Println("start", m.name) }
+func (m *mod) Run(closeSig chan bool) {
+	<-closeSig
+}
+func (m *mod) OnStop()    { fmt.Println("stop", m.name) }
+func (m *mod) OnDestroy() { fmt.Println("destroy", m.name) }
+
+func ExampleDependent() {
+	// registered before the module it depends on
+	module.Register(&mod{name: "game", deps: []string{"db"}})
+	module.Register(&mod{name: "db"})
+
+	module.Init()
+	module.Destroy()
+
+	// Output:
+	// init db
+	// init game
+	// start db
+	// start game
+	// stop game
+	// stop db
+	// destroy game
+	// destroy db
+}
+
+func ExampleStartModule() {
+	// e.g. the module of a seasonal event, after leaf.Run
+	if err := module.StartModule(&mod{name: "event"}); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(module.StartModule(&mod{name: "event"}))
+	module.StopModule("event")
+	fmt.Println(module.StopModule("event"))
+
+	// Output:
+	// init event
+	// start event
+	// module event: already running
+	// stop event
+	// destroy event
+	// module event: not running
+}
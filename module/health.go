@@ -0,0 +1,169 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
+)
+
+// ErrNotResponding is reported by the watchdog for the modules stuck, e.g.
+// a skeleton deadlocked in a chanrpc call
+var ErrNotResponding = errors.New("module not responding")
+
+// HealthChecker is implemented by modules reporting their health to the
+// watchdog, e.g. a module losing its database. HealthCheck is called in the
+// skeleton goroutine for the modules embedding a Skeleton, in the watchdog
+// goroutine otherwise
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+// prober is implemented by Skeleton
+type prober interface {
+	probe(timeout time.Duration, check func() error) error
+}
+
+// Health returns the error the watchdog reported for the module named name
+// the last time, nil if the module is healthy, not checked or not running
+// goroutine safe
+func Health(name string) error {
+	mutexMods.Lock()
+	m := find(name)
+	mutexMods.Unlock()
+	if m == nil {
+		return nil
+	}
+	if err := m.health.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+var (
+	watchdogClose chan struct{}
+	watchdogDone  chan struct{}
+)
+
+// startWatchdog checks the modules every conf.WatchdogInterval
+func startWatchdog() {
+	if conf.WatchdogInterval <= 0 {
+		return
+	}
+	timeout := conf.WatchdogTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+		log.Release("invalid WatchdogTimeout, reset to %v", timeout)
+	}
+
+	watchdogClose = make(chan struct{})
+	watchdogDone = make(chan struct{})
+	go func() {
+		defer close(watchdogDone)
+
+		ticker := time.NewTicker(conf.WatchdogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-watchdogClose:
+				return
+			case <-ticker.C:
+				checkModules(timeout)
+			}
+		}
+	}()
+}
+
+func stopWatchdog() {
+	if watchdogClose == nil {
+		return
+	}
+	close(watchdogClose)
+	<-watchdogDone
+	watchdogClose = nil
+}
+
+func checkModules(timeout time.Duration) {
+	mutexHot.Lock()
+	defer mutexHot.Unlock()
+
+	for _, m := range snapshot() {
+		select {
+		case <-m.started:
+		default:
+			continue
+		}
+
+		err := check(m, timeout)
+		report(m, err)
+		if err != nil && conf.WatchdogRestart {
+			restart(m, err)
+		}
+	}
+}
+
+func check(m *module, timeout time.Duration) error {
+	hc, _ := m.mi.(HealthChecker)
+	if p, ok := m.mi.(prober); ok {
+		return p.probe(timeout, func() error {
+			if hc == nil {
+				return nil
+			}
+			return hc.HealthCheck()
+		})
+	}
+	if hc == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				util.Recovered(r, util.PanicContext{Source: "module", Module: Name(m.mi)})
+				done <- fmt.Errorf("HealthCheck panicked: %v", r)
+			}
+		}()
+		done <- hc.HealthCheck()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("%w in %v: HealthCheck", ErrNotResponding, timeout)
+	}
+}
+
+// report logs the module turning unhealthy and healthy again
+func report(m *module, err error) {
+	prev := m.health.Swap(&err)
+	was := prev != nil && *prev != nil
+	if err != nil && !was {
+		log.Error("module %v unhealthy: %v", Name(m.mi), err)
+	} else if err == nil && was {
+		log.Release("module %v healthy again", Name(m.mi))
+	}
+}
+
+// restart replaces m with a module of its factory, the goroutine of a module
+// not responding is abandoned
+func restart(m *module, cause error) {
+	name := Name(m.mi)
+	f := factory(name)
+	if f == nil {
+		return
+	}
+
+	if err := stopModule(m, errors.Is(cause, ErrNotResponding)); err != nil {
+		log.Error("module %v not restarted: %v", name, err)
+		return
+	}
+	if err := startModule(f()); err != nil {
+		log.Error("module %v not restarted: %v", name, err)
+		return
+	}
+	log.Release("module %v restarted", name)
+}
@@ -0,0 +1,207 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+var (
+	// serializes StartModule, StopModule and the watchdog
+	mutexHot sync.Mutex
+	// guards mods once the modules are initialized
+	mutexMods sync.Mutex
+
+	mutexFactories sync.Mutex
+	factories      = make(map[string]func() Module)
+)
+
+func snapshot() []*module {
+	mutexMods.Lock()
+	defer mutexMods.Unlock()
+	return append([]*module(nil), mods...)
+}
+
+func find(name string) *module {
+	for _, m := range mods {
+		if Name(m.mi) == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// StartModule initializes and starts mi once leaf.Run started the modules,
+// e.g. the module of a seasonal event. The modules mi depends on must be
+// running, it returns once OnStart of mi returned
+// goroutine safe
+func StartModule(mi Module) error {
+	mutexHot.Lock()
+	defer mutexHot.Unlock()
+
+	return startModule(mi)
+}
+
+func startModule(mi Module) error {
+	name := Name(mi)
+	m := newModule(mi)
+	mutexMods.Lock()
+	if find(name) != nil {
+		mutexMods.Unlock()
+		return fmt.Errorf("module %v: already running", name)
+	}
+	if d, ok := mi.(Dependent); ok {
+		for _, n := range d.DependsOn() {
+			dep := find(n)
+			if dep == nil {
+				mutexMods.Unlock()
+				return fmt.Errorf("module %v depends on %v: module not running", name, n)
+			}
+			m.deps = append(m.deps, dep)
+		}
+	}
+	mutexMods.Unlock()
+
+	mi.OnInit()
+	if n, ok := mi.(named); ok {
+		n.setName(name)
+	}
+
+	mutexMods.Lock()
+	mods = append(mods, m)
+	mutexMods.Unlock()
+
+	m.wg.Add(1)
+	go run(m)
+	<-m.started
+	log.Release("module %v started", name)
+	return nil
+}
+
+// StopModule drains, stops and destroys the module named name, the modules
+// depending on it must be stopped first
+// goroutine safe
+func StopModule(name string) error {
+	mutexHot.Lock()
+	defer mutexHot.Unlock()
+
+	mutexMods.Lock()
+	m := find(name)
+	mutexMods.Unlock()
+	if m == nil {
+		return fmt.Errorf("module %v: not running", name)
+	}
+	return stopModule(m, false)
+}
+
+// stopModule doesn't wait for the goroutine of m if abandon
+func stopModule(m *module, abandon bool) error {
+	name := Name(m.mi)
+	mutexMods.Lock()
+	for _, other := range mods {
+		for _, dep := range other.deps {
+			if dep == m {
+				mutexMods.Unlock()
+				return fmt.Errorf("module %v: module %v depends on it", name, Name(other.mi))
+			}
+		}
+	}
+	mutexMods.Unlock()
+
+	if abandon {
+		select {
+		case m.closeSig <- true:
+		default:
+		}
+		log.Error("module %v abandoned, its goroutine is stuck", name)
+	} else {
+		if d, ok := m.mi.(Drainer); ok {
+			d.OnDrain()
+		}
+		m.closeSig <- true
+		m.wg.Wait()
+		stop(m)
+		destroy(m)
+	}
+
+	mutexMods.Lock()
+	for i := range mods {
+		if mods[i] == m {
+			mods = append(mods[:i], mods[i+1:]...)
+			break
+		}
+	}
+	mutexMods.Unlock()
+	if !abandon {
+		log.Release("module %v stopped", name)
+	}
+	return nil
+}
+
+// RegisterFactory lets the console start the module named name with the
+// module f returns, and the watchdog restart it
+// goroutine safe
+func RegisterFactory(name string, f func() Module) {
+	mutexFactories.Lock()
+	defer mutexFactories.Unlock()
+	factories[name] = f
+}
+
+func factory(name string) func() Module {
+	mutexFactories.Lock()
+	defer mutexFactories.Unlock()
+	return factories[name]
+}
+
+// Command is the console command managing the modules, see console.RegisterFunc
+func Command(args []string) string {
+	usage := "usage: module list | start <name> | stop <name>"
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "list":
+		var running []string
+		for _, m := range snapshot() {
+			name := Name(m.mi)
+			if err := m.health.Load(); err != nil && *err != nil {
+				name += "(unhealthy)"
+			}
+			running = append(running, name)
+		}
+		mutexFactories.Lock()
+		var startable []string
+		for name := range factories {
+			startable = append(startable, name)
+		}
+		mutexFactories.Unlock()
+		sort.Strings(startable)
+		return fmt.Sprintf("running: %v\r\nstartable: %v",
+			strings.Join(running, " "), strings.Join(startable, " "))
+	case "start":
+		if len(args) != 2 {
+			return usage
+		}
+		f := factory(args[1])
+		if f == nil {
+			return fmt.Sprintf("module %v: no factory registered", args[1])
+		}
+		if err := StartModule(f()); err != nil {
+			return err.Error()
+		}
+		return "started"
+	case "stop":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := StopModule(args[1]); err != nil {
+			return err.Error()
+		}
+		return "stopped"
+	}
+	return usage
+}
@@ -0,0 +1,121 @@
+package module
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// Loop calls Update every Step in the skeleton goroutine. A late tick runs
+// the missed steps, at most MaxFrameSkip extra ones, and drops the rest
+type Loop struct {
+	Step         time.Duration
+	MaxFrameSkip int
+	Update       func(dt time.Duration)
+
+	ticker  *time.Ticker
+	last    time.Time
+	lag     time.Duration
+	stopped bool
+
+	// stats
+	ticks        atomic.Int64
+	dropped      atomic.Int64
+	lastDuration atomic.Int64
+	maxDuration  atomic.Int64
+	sumDuration  atomic.Int64
+}
+
+type LoopStats struct {
+	// Update calls
+	Ticks int64
+	// steps dropped by MaxFrameSkip
+	Dropped      int64
+	LastDuration time.Duration
+	MaxDuration  time.Duration
+	AvgDuration  time.Duration
+}
+
+// StartLoop runs loop until Stop, one loop per skeleton. Call it in the
+// skeleton goroutine or in OnInit
+func (s *Skeleton) StartLoop(loop *Loop) *Loop {
+	if s.loop != nil && !s.loop.stopped {
+		panic("loop is running")
+	}
+	if loop.Step <= 0 {
+		panic("invalid Step")
+	}
+	if loop.MaxFrameSkip < 0 {
+		loop.MaxFrameSkip = 0
+		log.Release("invalid MaxFrameSkip, reset to %v", loop.MaxFrameSkip)
+	}
+
+	loop.ticker = time.NewTicker(loop.Step)
+	loop.last = time.Now()
+	loop.lag = 0
+	loop.stopped = false
+	s.loop = loop
+	s.tickC = loop.ticker.C
+	return loop
+}
+
+// Stop is called in the skeleton goroutine
+func (loop *Loop) Stop() {
+	if loop.stopped {
+		return
+	}
+	loop.stopped = true
+	loop.ticker.Stop()
+}
+
+func (s *Skeleton) tick(now time.Time) {
+	loop := s.loop
+	if loop.stopped {
+		s.tickC = nil
+		return
+	}
+
+	loop.lag += now.Sub(loop.last)
+	loop.last = now
+	for n := 0; loop.lag >= loop.Step && !loop.stopped; n++ {
+		if n > loop.MaxFrameSkip {
+			dropped := int64(loop.lag / loop.Step)
+			loop.dropped.Add(dropped)
+			loop.lag -= time.Duration(dropped) * loop.Step
+			break
+		}
+		loop.lag -= loop.Step
+		loop.update()
+	}
+	if loop.stopped {
+		s.tickC = nil
+	}
+}
+
+func (loop *Loop) update() {
+	begin := time.Now()
+	loop.Update(loop.Step)
+	d := int64(time.Since(begin))
+
+	loop.ticks.Add(1)
+	loop.lastDuration.Store(d)
+	loop.sumDuration.Add(d)
+	if d > loop.maxDuration.Load() {
+		loop.maxDuration.Store(d)
+	}
+}
+
+// goroutine safe
+func (loop *Loop) Stats() LoopStats {
+	st := LoopStats{
+		Ticks:        loop.ticks.Load(),
+		Dropped:      loop.dropped.Load(),
+		LastDuration: time.Duration(loop.lastDuration.Load()),
+		MaxDuration:  time.Duration(loop.maxDuration.Load()),
+	}
+	if st.Ticks > 0 {
+		st.AvgDuration = time.Duration(loop.sumDuration.Load() / st.Ticks)
+	}
+	return st
+}
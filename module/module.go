@@ -1,11 +1,12 @@
 package module
 
 import (
-	"runtime"
+	"reflect"
 	"sync"
+	"sync/atomic"
 
-	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
 )
 
 type Module interface {
@@ -14,25 +15,125 @@ type Module interface {
 	Run(closeSig chan bool)
 }
 
+// Namer is implemented by modules named other than by their type, the name
+// DependsOn refers to
+type Namer interface {
+	ModuleName() string
+}
+
+// Dependent is implemented by modules using other modules, e.g. calling
+// their chanrpc servers. A module is initialized and started after the
+// modules it depends on, and stopped and destroyed before them
+type Dependent interface {
+	// the names of the modules, see Name
+	DependsOn() []string
+}
+
+// Starter is implemented by modules starting once all the modules are
+// initialized. OnStart is called on the goroutine of Run right before it,
+// once the modules depended on are started
+type Starter interface {
+	OnStart()
+}
+
+// Stopper is implemented by modules stopping before any module is
+// destroyed. OnStop is called once Run returned, while the modules depended
+// on still run
+type Stopper interface {
+	OnStop()
+}
+
 type module struct {
 	mi       Module
 	closeSig chan bool
 	wg       sync.WaitGroup
+	// closed once OnStart returned
+	started chan struct{}
+	deps    []*module
+	// the last error the watchdog reported
+	health atomic.Pointer[error]
 }
 
 var mods []*module
 
 func Register(mi Module) {
+	mods = append(mods, newModule(mi))
+}
+
+func newModule(mi Module) *module {
 	m := new(module)
 	m.mi = mi
 	m.closeSig = make(chan bool, 1)
+	m.started = make(chan struct{})
+	return m
+}
 
-	mods = append(mods, m)
+// sortModules orders mods after the modules they depend on, in the order
+// they were registered otherwise
+func sortModules() {
+	byName := make(map[string]*module, len(mods))
+	for _, m := range mods {
+		byName[Name(m.mi)] = m
+	}
+	for _, m := range mods {
+		d, ok := m.mi.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, n := range d.DependsOn() {
+			dep := byName[n]
+			if dep == nil {
+				log.Fatal("module %v depends on %v: module not registered", Name(m.mi), n)
+			}
+			m.deps = append(m.deps, dep)
+		}
+	}
+
+	sorted := make([]*module, 0, len(mods))
+	placed := make(map[*module]bool, len(mods))
+	for len(sorted) < len(mods) {
+		n := len(sorted)
+		for _, m := range mods {
+			if placed[m] {
+				continue
+			}
+			ready := true
+			for _, dep := range m.deps {
+				ready = ready && placed[dep]
+			}
+			if ready {
+				sorted = append(sorted, m)
+				placed[m] = true
+				break
+			}
+		}
+		if len(sorted) == n {
+			var cycle []string
+			for _, m := range mods {
+				if !placed[m] {
+					cycle = append(cycle, Name(m.mi))
+				}
+			}
+			log.Fatal("module dependency cycle among %v", cycle)
+		}
+	}
+	mods = sorted
+}
+
+// named is implemented by Skeleton, the name is reported with the panics of
+// the module
+type named interface {
+	setName(name string)
 }
 
 func Init() {
+	sortModules()
+
 	for i := 0; i < len(mods); i++ {
 		mods[i].mi.OnInit()
+		if n, ok := mods[i].mi.(named); ok {
+			n.setName(Name(mods[i].mi))
+		}
 	}
 
 	for i := 0; i < len(mods); i++ {
@@ -40,32 +141,105 @@ func Init() {
 		m.wg.Add(1)
 		go run(m)
 	}
+
+	startWatchdog()
+}
+
+// Range calls f with the registered modules in the order they are
+// initialized
+// goroutine safe
+func Range(f func(mi Module)) {
+	for _, m := range snapshot() {
+		f(m.mi)
+	}
+}
+
+// Drainer is implemented by modules finishing their work before the modules
+// are destroyed, e.g. the gates closing their connections
+type Drainer interface {
+	OnDrain()
+}
+
+// Drain calls OnDrain of the modules at the same time
+func Drain() {
+	var wg sync.WaitGroup
+	for _, m := range snapshot() {
+		if d, ok := m.mi.(Drainer); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.OnDrain()
+			}()
+		}
+	}
+	wg.Wait()
 }
 
+// Destroy stops the modules in the reverse order of Init, then destroys them
 func Destroy() {
+	stopWatchdog()
+
+	mutexHot.Lock()
+	defer mutexHot.Unlock()
+
+	mods := snapshot()
 	for i := len(mods) - 1; i >= 0; i-- {
 		m := mods[i]
 		m.closeSig <- true
 		m.wg.Wait()
-		destroy(m)
+		stop(m)
+	}
+	for i := len(mods) - 1; i >= 0; i-- {
+		destroy(mods[i])
+	}
+}
+
+// Name returns ModuleName if mi is a Namer, the type of mi otherwise, e.g.
+// *game.Module
+func Name(mi Module) string {
+	if n, ok := mi.(Namer); ok {
+		return n.ModuleName()
 	}
+	return reflect.TypeOf(mi).String()
 }
 
+// a panic of OnStart or Run is reported and still crashes the process
 func run(m *module) {
+	defer func() {
+		if r := recover(); r != nil {
+			util.Recovered(r, util.PanicContext{Source: "module", Module: Name(m.mi)})
+			panic(r)
+		}
+	}()
+
+	for _, dep := range m.deps {
+		<-dep.started
+	}
+	if s, ok := m.mi.(Starter); ok {
+		s.OnStart()
+	}
+	close(m.started)
+
 	m.mi.Run(m.closeSig)
 	m.wg.Done()
 }
 
+func stop(m *module) {
+	defer func() {
+		if r := recover(); r != nil {
+			util.Recovered(r, util.PanicContext{Source: "module", Module: Name(m.mi)})
+		}
+	}()
+
+	if s, ok := m.mi.(Stopper); ok {
+		s.OnStop()
+	}
+}
+
 func destroy(m *module) {
 	defer func() {
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
-			}
+			util.Recovered(r, util.PanicContext{Source: "module", Module: Name(m.mi)})
 		}
 	}()
 
@@ -1,12 +1,18 @@
 package module
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/conf"
 	"github.com/czx-lab/leaf/console"
+	"github.com/czx-lab/leaf/event"
 	g "github.com/czx-lab/leaf/go"
 	"github.com/czx-lab/leaf/timer"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Skeleton struct {
@@ -19,6 +25,14 @@ type Skeleton struct {
 	client             *chanrpc.Client
 	server             *chanrpc.Server
 	commandServer      *chanrpc.Server
+	loop               *Loop
+	tickC              <-chan time.Time
+
+	// the timers are scheduled with a timing wheel of this granularity if
+	// not zero, for modules with many timers
+	TimerTick time.Duration
+	// records the timers of AfterFuncPersistent
+	TimerStore timer.TimerStore
 }
 
 func (s *Skeleton) Init() {
@@ -33,7 +47,14 @@ func (s *Skeleton) Init() {
 	}
 
 	s.g = g.New(s.GoLen)
-	s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	if s.TimerTick > 0 {
+		s.dispatcher = timer.NewWheelDispatcher(s.TimerDispatcherLen, s.TimerTick)
+	} else {
+		s.dispatcher = timer.NewDispatcher(s.TimerDispatcherLen)
+	}
+	if s.TimerStore != nil {
+		s.dispatcher.SetStore(s.TimerStore)
+	}
 	s.client = chanrpc.NewClient(s.AsynCallLen)
 	s.server = s.ChanRPCServer
 
@@ -41,12 +62,33 @@ func (s *Skeleton) Init() {
 		s.server = chanrpc.NewServer(0)
 	}
 	s.commandServer = chanrpc.NewServer(0)
+	chanrpc.Register1(s.commandServer, probeID{}, func(check func() error) error {
+		return check()
+	})
+}
+
+func (s *Skeleton) setName(name string) {
+	if s.server == nil {
+		return
+	}
+	s.server.Name = name
+	s.commandServer.Name = name
+	s.g.Name = name
+	s.dispatcher.Name = name
 }
 
 func (s *Skeleton) Run(closeSig chan bool) {
+	// a restarted module runs the same servers in a new goroutine
+	s.server.Attach()
+	s.commandServer.Attach()
 	for {
 		select {
 		case <-closeSig:
+			if s.loop != nil {
+				s.loop.Stop()
+			}
+			s.drain(conf.ShutdownTimeout)
+			s.dispatcher.Close()
 			s.commandServer.Close()
 			s.server.Close()
 			for !s.g.Idle() || !s.client.Idle() {
@@ -56,18 +98,100 @@ func (s *Skeleton) Run(closeSig chan bool) {
 			return
 		case ri := <-s.client.ChanAsynRet:
 			s.client.Cb(ri)
+		case ci := <-s.server.ChanCallHigh:
+			s.server.Exec(ci)
 		case ci := <-s.server.ChanCall:
 			s.server.Exec(ci)
+		case ci := <-s.server.ChanCallLow:
+			s.server.Exec(ci)
 		case ci := <-s.commandServer.ChanCall:
 			s.commandServer.Exec(ci)
 		case cb := <-s.g.ChanCb:
 			s.g.Cb(cb)
 		case t := <-s.dispatcher.ChanTimer:
 			t.Cb()
+		case now := <-s.tickC:
+			s.tick(now)
+		}
+	}
+}
+
+// drain runs the queued chanrpc calls and waits for the pending calls up to
+// timeout
+func (s *Skeleton) drain(timeout time.Duration) {
+	deadline := time.After(timeout)
+	for s.server.Len() > 0 || !s.client.Idle() || !s.g.Idle() {
+		select {
+		case <-deadline:
+			return
+		case ri := <-s.client.ChanAsynRet:
+			s.client.Cb(ri)
+		case ci := <-s.server.ChanCallHigh:
+			s.server.Exec(ci)
+		case ci := <-s.server.ChanCall:
+			s.server.Exec(ci)
+		case ci := <-s.server.ChanCallLow:
+			s.server.Exec(ci)
+		case cb := <-s.g.ChanCb:
+			s.g.Cb(cb)
 		}
 	}
 }
 
+// SkeletonStats is a snapshot of the queues of a skeleton
+type SkeletonStats struct {
+	// chanrpc calls waiting for the skeleton goroutine
+	ChanRPCLen int
+	// timers not fired or stopped
+	Timers int
+	// functions started by Go not called back yet
+	PendingGo int
+	// the functions of the chanrpc server
+	ChanRPC chanrpc.ServerStats
+}
+
+// zero before Init
+// goroutine safe
+func (s *Skeleton) Stats() SkeletonStats {
+	if s.server == nil {
+		return SkeletonStats{}
+	}
+	return SkeletonStats{
+		ChanRPCLen: s.server.Len(),
+		Timers:     s.dispatcher.Len(),
+		PendingGo:  s.g.Pending(),
+		ChanRPC:    s.server.Stats(),
+	}
+}
+
+// probeID is the command of the watchdog
+type probeID struct{}
+
+// probe calls check in the skeleton goroutine, the skeleton is stuck if the
+// goroutine doesn't get to it within timeout
+func (s *Skeleton) probe(timeout time.Duration, check func() error) error {
+	if s.commandServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	r, err := s.commandServer.Call1Context(ctx, probeID{}, check)
+	if errors.Is(err, context.DeadlineExceeded) {
+		stats := s.server.Stats()
+		if stats.Executing != nil {
+			return fmt.Errorf("%w in %v: executing %v for %v", ErrNotResponding, timeout,
+				stats.Executing, time.Since(stats.ExecutingSince).Truncate(time.Millisecond))
+		}
+		return fmt.Errorf("%w in %v: %v chanrpc calls queued", ErrNotResponding, timeout, stats.Len)
+	}
+	if err != nil {
+		return err
+	}
+	err, _ = r.(error)
+	return err
+}
+
 func (s *Skeleton) AfterFunc(d time.Duration, cb func()) *timer.Timer {
 	if s.TimerDispatcherLen == 0 {
 		panic("invalid TimerDispatcherLen")
@@ -84,6 +208,43 @@ func (s *Skeleton) CronFunc(cronExpr *timer.CronExpr, cb func()) *timer.Cron {
 	return s.dispatcher.CronFunc(cronExpr, cb)
 }
 
+// AfterFuncPersistent is AfterFunc surviving a restart, see
+// timer.Dispatcher.AfterFuncPersistent
+func (s *Skeleton) AfterFuncPersistent(key string, d time.Duration, cb func()) (*timer.Timer, error) {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.AfterFuncPersistent(key, d, cb)
+}
+
+// RestoreTimers schedules the timers of AfterFuncPersistent recorded before
+// the restart, call it in OnInit
+func (s *Skeleton) RestoreTimers(resolve func(key string) func()) error {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.Restore(resolve)
+}
+
+// CronFuncIn is CronFunc matching cronExpr with the wall clock of loc
+func (s *Skeleton) CronFuncIn(loc *time.Location, cronExpr *timer.CronExpr, cb func()) *timer.Cron {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronFuncIn(loc, cronExpr, cb)
+}
+
+func (s *Skeleton) CronJob(job *timer.CronJob) *timer.Cron {
+	if s.TimerDispatcherLen == 0 {
+		panic("invalid TimerDispatcherLen")
+	}
+
+	return s.dispatcher.CronJob(job)
+}
+
 func (s *Skeleton) Go(f func(), cb func()) {
 	if s.GoLen == 0 {
 		panic("invalid GoLen")
@@ -100,13 +261,34 @@ func (s *Skeleton) NewLinearContext() *g.LinearContext {
 	return s.g.NewLinearContext()
 }
 
+// NewPool executes the functions with a fixed set of goroutines, see g.Pool
+func (s *Skeleton) NewPool(workers int, queueSize int) *g.Pool {
+	if s.GoLen == 0 {
+		panic("invalid GoLen")
+	}
+
+	return s.g.NewPool(workers, queueSize)
+}
+
 func (s *Skeleton) AsynCall(server *chanrpc.Server, id interface{}, args ...interface{}) {
 	if s.AsynCallLen == 0 {
 		panic("invalid AsynCallLen")
 	}
 
 	s.client.Attach(server)
-	s.client.AsynCall(id, args...)
+	// continues the trace of the function executing, not its deadline
+	ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(s.server.Context()))
+	s.client.AsynCallContext(ctx, id, args...)
+}
+
+// AsynCall0 is AsynCall of a function registered with chanrpc.Register0
+func AsynCall0[A any](s *Skeleton, server *chanrpc.Server, id interface{}, a A, cb func(err error)) {
+	s.AsynCall(server, id, a, cb)
+}
+
+// AsynCall1 is AsynCall of a function registered with chanrpc.Register1
+func AsynCall1[A, R any](s *Skeleton, server *chanrpc.Server, id interface{}, a A, cb func(r R, err error)) {
+	s.AsynCall(server, id, a, chanrpc.Callback1(id, cb))
 }
 
 func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
@@ -120,3 +302,9 @@ func (s *Skeleton) RegisterChanRPC(id interface{}, f interface{}) {
 func (s *Skeleton) RegisterCommand(name string, help string, f interface{}) {
 	console.Register(name, help, f, s.commandServer)
 }
+
+// f is called in the skeleton goroutine for every event of the same type
+// published on the default event bus
+func (s *Skeleton) Subscribe(ev interface{}, f func(ev interface{})) *event.Subscription {
+	return event.Default().Subscribe(ev, s.server, f)
+}
@@ -0,0 +1,108 @@
+package network
+
+import (
+	"math"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// Backoff spaces the connection attempts of a client, the delay grows from
+// Min by Factor up to Max and is randomized by Jitter so that the clients
+// of a server don't retry at once
+type Backoff struct {
+	// the ConnectInterval of the client if zero
+	Min time.Duration
+	// 1 minute if zero
+	Max time.Duration
+	// 2 if zero
+	Factor float64
+	// the fraction of the delay randomized, 0.5 if zero, negative disables
+	// it
+	Jitter float64
+	// the failed attempts at most before OnReconnectFailed is called and the
+	// connection given up, zero retries forever
+	MaxRetries int
+}
+
+// Delay returns the delay before the attempt following failures failed ones
+func (b *Backoff) Delay(failures int) time.Duration {
+	max := b.Max
+	if max <= 0 {
+		max = time.Minute
+	}
+	factor := b.Factor
+	if factor < 1 {
+		factor = 2
+	}
+	jitter := b.Jitter
+	if jitter == 0 {
+		jitter = 0.5
+	}
+
+	d := float64(b.Min) * math.Pow(factor, float64(failures))
+	if d > float64(max) || math.IsInf(d, 0) {
+		d = float64(max)
+	}
+	if jitter > 0 {
+		d -= d * min(jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// ConnEvents are the callbacks of a client on the connectivity changes, they
+// are called in the goroutines connecting and may be nil
+type ConnEvents struct {
+	OnConnect    func(addr net.Addr)
+	OnDisconnect func(addr net.Addr)
+	// the connection of the client is given up after Backoff.MaxRetries
+	OnReconnectFailed func(err error)
+}
+
+// retry is the connection attempts of a client
+type retry struct {
+	backoff   Backoff
+	closeChan chan struct{}
+}
+
+func (r *retry) init(interval time.Duration, backoff *Backoff) {
+	r.backoff = Backoff{Min: interval, Max: interval, Factor: 1, Jitter: -1}
+	if backoff != nil {
+		r.backoff = *backoff
+		if r.backoff.Min <= 0 {
+			r.backoff.Min = interval
+		}
+		if r.backoff.Max > 0 && r.backoff.Max < r.backoff.Min {
+			r.backoff.Max = r.backoff.Min
+			log.Release("invalid Backoff.Max, reset to %v", r.backoff.Max)
+		}
+	}
+	r.closeChan = make(chan struct{})
+}
+
+// gaveUp reports whether the connection is given up after failures failed
+// attempts
+func (r *retry) gaveUp(failures int) bool {
+	return r.backoff.MaxRetries > 0 && failures > r.backoff.MaxRetries
+}
+
+// wait returns false if the client is closed meanwhile
+func (r *retry) wait(failures int) bool {
+	t := time.NewTimer(r.backoff.Delay(failures))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.closeChan:
+		return false
+	}
+}
+
+func (r *retry) close() {
+	// closed before started
+	if r.closeChan != nil {
+		close(r.closeChan)
+	}
+}
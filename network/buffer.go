@@ -0,0 +1,68 @@
+package network
+
+import "sync"
+
+// buffers larger than maxPooledBuffer are left to the garbage collector
+const maxPooledBuffer = 64 * 1024
+
+// bufferPool holds the frames built by the write paths, a frame goes back
+// to the pool once the write goroutine wrote it
+var bufferPool sync.Pool
+
+func getBuffer(n int) *[]byte {
+	if b, ok := bufferPool.Get().(*[]byte); ok {
+		if cap(*b) >= n {
+			*b = (*b)[:n]
+		} else {
+			*b = make([]byte, n)
+		}
+		return b
+	}
+	b := make([]byte, n)
+	return &b
+}
+
+func putBuffer(b *[]byte) {
+	if b == nil || cap(*b) > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(b)
+}
+
+// Buffer is a message read into a pooled slice by a BufferReader, Release
+// returns it to the pool. A Buffer not released is left to the garbage
+// collector
+type Buffer struct {
+	data []byte
+}
+
+// readPool holds the Buffers released
+var readPool sync.Pool
+
+func getReadBuffer(n int) *Buffer {
+	b, ok := readPool.Get().(*Buffer)
+	if !ok {
+		b = new(Buffer)
+	}
+	if cap(b.data) >= n {
+		b.data = b.data[:n]
+	} else {
+		b.data = make([]byte, n)
+	}
+	return b
+}
+
+// Bytes returns the message, it must not be used after Release
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Release returns b to the pool, b must not be used afterwards
+// goroutine safe
+func (b *Buffer) Release() {
+	if b == nil || cap(b.data) > maxPooledBuffer {
+		return
+	}
+	b.data = b.data[:0]
+	readPool.Put(b)
+}
@@ -0,0 +1,297 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+const magic = "LEAFCAP1"
+
+// ---------------------------------------------
+// | time | agent id | kind | len | frame data |
+// ---------------------------------------------
+type Record struct {
+	Time    time.Time
+	AgentID uint32
+	Inbound bool
+	Kind    Kind
+	// the remote address of KindOpen
+	Data []byte
+}
+
+// Kind of a record, the files written before the events have only frames
+type Kind byte
+
+const (
+	KindOutbound Kind = iota
+	KindInbound
+	// the agent connected or resumed
+	KindOpen
+	KindClose
+)
+
+type Writer struct {
+	sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+func Create(name string) (*Writer, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(Writer)
+	w.file = file
+	w.w = bufio.NewWriter(file)
+	if _, err := w.w.WriteString(magic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// goroutine safe
+func (w *Writer) Write(agentID uint32, inbound bool, data ...[]byte) error {
+	kind := KindOutbound
+	if inbound {
+		kind = KindInbound
+	}
+	return w.write(agentID, kind, data...)
+}
+
+// WriteEvent records that the agent connected, data being its remote
+// address, or closed
+// goroutine safe
+func (w *Writer) WriteEvent(agentID uint32, kind Kind, data []byte) error {
+	return w.write(agentID, kind, data)
+}
+
+func (w *Writer) write(agentID uint32, kind Kind, data ...[]byte) error {
+	var l int
+	for _, b := range data {
+		l += len(b)
+	}
+
+	var head [17]byte
+	binary.BigEndian.PutUint64(head[0:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(head[8:], agentID)
+	head[12] = byte(kind)
+	binary.BigEndian.PutUint32(head[13:], uint32(l))
+
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return errors.New("capture writer closed")
+	}
+	if _, err := w.w.Write(head[:]); err != nil {
+		return err
+	}
+	for _, b := range data {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goroutine safe
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.w.Flush()
+	if e := w.file.Close(); err == nil {
+		err = e
+	}
+	w.file = nil
+	return err
+}
+
+type Reader struct {
+	file *os.File
+	r    *bufio.Reader
+}
+
+func Open(name string) (*Reader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Reader)
+	r.file = file
+	r.r = bufio.NewReader(file)
+
+	var m [len(magic)]byte
+	if _, err := io.ReadFull(r.r, m[:]); err != nil || string(m[:]) != magic {
+		file.Close()
+		return nil, errors.New("invalid capture file")
+	}
+	return r, nil
+}
+
+// returns io.EOF after the last record
+func (r *Reader) Next() (*Record, error) {
+	var head [17]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated capture record")
+		}
+		return nil, err
+	}
+
+	rec := new(Record)
+	rec.Time = time.Unix(0, int64(binary.BigEndian.Uint64(head[0:])))
+	rec.AgentID = binary.BigEndian.Uint32(head[8:])
+	rec.Kind = Kind(head[12])
+	rec.Inbound = rec.Kind == KindInbound
+	rec.Data = make([]byte, binary.BigEndian.Uint32(head[13:]))
+	if _, err := io.ReadFull(r.r, rec.Data); err != nil {
+		return nil, errors.New("truncated capture record")
+	}
+	return rec, nil
+}
+
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// Replay feeds the inbound records through processor, userData returns the
+// value passed to Route for the recorded agent. If realtime is set the
+// original gaps between records are kept.
+func Replay(r *Reader, processor network.Processor, userData func(agentID uint32) interface{}, realtime bool) error {
+	p := &Player{
+		Processor: processor,
+		UserData: func(agentID uint32, _ string) interface{} {
+			return userData(agentID)
+		},
+	}
+	if realtime {
+		p.Speed = 1
+	}
+	return p.Play(r)
+}
+
+// Player feeds the inbound records of a capture through a processor, the
+// agents as recorded:
+//
+//	p := &capture.Player{Processor: processor, Speed: 4, UserData: newAgent}
+//	err := p.Play(r)
+type Player struct {
+	Processor network.Processor
+	// the gaps between records are divided by Speed, zero doesn't wait
+	Speed float64
+	// the agents replayed, all if nil
+	Filter func(agentID uint32) bool
+	// returns the value passed to Route for the agent, called once for every
+	// opening and before the first frame of an agent opened before the
+	// capture started. remoteAddr is empty then
+	UserData func(agentID uint32, remoteAddr string) interface{}
+	// called when the agent closed, may be nil
+	OnClose func(agentID uint32, userData interface{})
+	// called for every frame routed, e.g. to compare the states at the
+	// point of a desync, may be nil
+	OnRecord func(rec *Record, msg interface{})
+}
+
+// Play returns the first error of Unmarshal or Route
+func (p *Player) Play(r *Reader) error {
+	agents := make(map[uint32]interface{})
+	return each(r, p.Speed, func(rec *Record) error {
+		if p.Filter != nil && !p.Filter(rec.AgentID) {
+			return nil
+		}
+
+		switch rec.Kind {
+		case KindOpen:
+			agents[rec.AgentID] = p.UserData(rec.AgentID, string(rec.Data))
+			return nil
+		case KindClose:
+			ud, ok := agents[rec.AgentID]
+			if !ok {
+				return nil
+			}
+			delete(agents, rec.AgentID)
+			if p.OnClose != nil {
+				p.OnClose(rec.AgentID, ud)
+			}
+			return nil
+		}
+
+		ud, ok := agents[rec.AgentID]
+		if !ok {
+			ud = p.UserData(rec.AgentID, "")
+			agents[rec.AgentID] = ud
+		}
+		msg, err := p.Processor.Unmarshal(rec.Data)
+		if err != nil {
+			return err
+		}
+		if err := p.Processor.Route(msg, ud); err != nil {
+			return err
+		}
+		if p.OnRecord != nil {
+			p.OnRecord(rec, msg)
+		}
+		return nil
+	})
+}
+
+// Send writes the inbound records of the recorded agent to conn, e.g. a
+// connection of a TCPClient dialed to a test server.
+func Send(r *Reader, conn network.Conn, agentID uint32, realtime bool) error {
+	speed := 0.0
+	if realtime {
+		speed = 1
+	}
+	return SendWithSpeed(r, conn, agentID, speed)
+}
+
+// SendWithSpeed is Send with the gaps between records divided by speed,
+// zero doesn't wait
+func SendWithSpeed(r *Reader, conn network.Conn, agentID uint32, speed float64) error {
+	return each(r, speed, func(rec *Record) error {
+		if rec.AgentID != agentID || rec.Kind != KindInbound {
+			return nil
+		}
+		return conn.WriteMsg(rec.Data)
+	})
+}
+
+// each calls f with the inbound records and the events
+func each(r *Reader, speed float64, f func(rec *Record) error) error {
+	var last time.Time
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Kind == KindOutbound {
+			continue
+		}
+
+		if speed > 0 && !last.IsZero() && rec.Time.After(last) {
+			time.Sleep(time.Duration(float64(rec.Time.Sub(last)) / speed))
+		}
+		last = rec.Time
+
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package capture_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/czx-lab/leaf/network/capture"
+	"github.com/czx-lab/leaf/network/json"
+)
+
+func Example() {
+	dir, err := os.MkdirTemp("", "capture")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "leaf.cap")
+
+	w, err := capture.Create(name)
+	if err != nil {
+		return
+	}
+	w.Write(1, true, []byte("hello"))
+	w.Write(1, false, []byte("ignored"))
+	w.Write(2, true, []byte("world"))
+	w.Close()
+
+	r, err := capture.Open(name)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			break
+		}
+		fmt.Println(rec.AgentID, rec.Inbound, string(rec.Data))
+	}
+
+	// Output:
+	// 1 true hello
+	// 1 false ignored
+	// 2 true world
+}
+
+type Move struct {
+	X, Y int
+}
+
+func ExamplePlayer() {
+	dir, err := os.MkdirTemp("", "capture")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "leaf.cap")
+
+	w, err := capture.Create(name)
+	if err != nil {
+		return
+	}
+	w.WriteEvent(1, capture.KindOpen, []byte("127.0.0.1:5000"))
+	w.Write(1, true, []byte(`{"Move":{"X":1,"Y":2}}`))
+	w.Write(1, false, []byte(`{"Ack":{}}`))
+	w.WriteEvent(1, capture.KindClose, nil)
+	w.Close()
+
+	processor := json.NewProcessor()
+	processor.Register(&Move{})
+	processor.SetHandler(&Move{}, func(args []interface{}) {
+		fmt.Println(args[1], "moves to", *args[0].(*Move))
+	})
+
+	r, err := capture.Open(name)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	p := &capture.Player{
+		Processor: processor,
+		Speed:     10,
+		UserData: func(agentID uint32, remoteAddr string) interface{} {
+			return remoteAddr
+		},
+		OnClose: func(agentID uint32, userData interface{}) {
+			fmt.Println(userData, "closed")
+		},
+	}
+	fmt.Println(p.Play(r))
+
+	// Output:
+	// 127.0.0.1:5000 moves to {1 2}
+	// 127.0.0.1:5000 closed
+	// <nil>
+}
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressionAlgorithm byte
+
+const (
+	CompressSnappy CompressionAlgorithm = iota + 1
+	CompressZstd
+)
+
+func (a CompressionAlgorithm) String() string {
+	switch a {
+	case CompressSnappy:
+		return "snappy"
+	case CompressZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("CompressionAlgorithm(%d)", byte(a))
+}
+
+// ----------------
+// | flag | frame |
+// ----------------
+// Compression is a pipeline Stage compressing the frames of at least
+// Threshold bytes. flag is 0 for a plain frame, or the algorithm of a
+// compressed one, so any algorithm is decoded whatever Algorithm is
+type Compression struct {
+	Algorithm CompressionAlgorithm
+	Threshold int
+	// decompressed frames longer than it are rejected
+	MaxDecodedLen uint32
+
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (c *Compression) init() {
+	if c.Algorithm != CompressSnappy && c.Algorithm != CompressZstd {
+		c.Algorithm = CompressSnappy
+		log.Release("invalid Algorithm, reset to %v", c.Algorithm)
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 1024
+		log.Release("invalid Threshold, reset to %v", c.Threshold)
+	}
+	if c.MaxDecodedLen == 0 {
+		c.MaxDecodedLen = 1 << 20
+		log.Release("invalid MaxDecodedLen, reset to %v", c.MaxDecodedLen)
+	}
+
+	var err error
+	c.encoder, err = zstd.NewWriter(nil)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	c.decoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(c.MaxDecodedLen)))
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+}
+
+// goroutine safe
+func (c *Compression) Encode(data []byte) ([]byte, error) {
+	c.once.Do(c.init)
+
+	if len(data) < c.Threshold {
+		return append([]byte{0}, data...), nil
+	}
+
+	out := []byte{byte(c.Algorithm)}
+	switch c.Algorithm {
+	case CompressSnappy:
+		out = append(out, snappy.Encode(nil, data)...)
+	case CompressZstd:
+		out = c.encoder.EncodeAll(data, out)
+	}
+	// not worth it
+	if len(out) >= len(data)+1 {
+		return append([]byte{0}, data...), nil
+	}
+	return out, nil
+}
+
+// goroutine safe
+func (c *Compression) Decode(data []byte) ([]byte, error) {
+	c.once.Do(c.init)
+
+	if len(data) < 1 {
+		return nil, errors.New("compression flag missing")
+	}
+
+	switch CompressionAlgorithm(data[0]) {
+	case 0:
+		return data[1:], nil
+	case CompressSnappy:
+		n, err := snappy.DecodedLen(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n > int(c.MaxDecodedLen) {
+			return nil, errors.New("decompressed frame too long")
+		}
+		return snappy.Decode(nil, data[1:])
+	case CompressZstd:
+		return c.decoder.DecodeAll(data[1:], nil)
+	}
+	return nil, fmt.Errorf("unknown compression flag %v", data[0])
+}
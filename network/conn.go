@@ -7,8 +7,16 @@ import (
 type Conn interface {
 	ReadMsg() ([]byte, error)
 	WriteMsg(args ...[]byte) error
+	WriteMsgPriority(p Priority, args ...[]byte) error
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	Close()
 	Destroy()
 }
+
+// BufferReader is implemented by the conns able to read messages into
+// pooled buffers, see BufferUnmarshaler
+type BufferReader interface {
+	// goroutine not safe
+	ReadBuffer() (*Buffer, error)
+}
@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const cryptoInfo = "leaf aes-gcm"
+
+// Encryption is a pipeline StatefulStage encrypting the frames of every
+// connection with AES-256-GCM under its own key. The key is agreed with an
+// X25519 exchange before the first encrypted frame:
+//
+//	client: Offer  -> | client public key (32 bytes) |
+//	server: Accept -> | server public key (32 bytes) |
+//	client: Finish
+//
+// Nonces are per direction counters which aren't sent, so frames must be
+// decoded in the order they were encoded and a replayed or dropped frame
+// fails to decrypt
+type Encryption struct {
+	sessions sync.Map
+}
+
+type cryptoSession struct {
+	private *ecdh.PrivateKey
+	aead    cipher.AEAD
+	// 0 from the client, 1 from the server
+	send    uint32
+	sendSeq atomic.Uint64
+	recvSeq atomic.Uint64
+}
+
+// Offer starts the exchange on the client side and returns the public key to
+// send
+// goroutine safe
+func (e *Encryption) Offer(userData interface{}) ([]byte, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	e.sessions.Store(userData, &cryptoSession{private: private})
+	return private.PublicKey().Bytes(), nil
+}
+
+// Finish ends the exchange on the client side with the public key of the
+// server
+// goroutine safe
+func (e *Encryption) Finish(userData interface{}, serverPublic []byte) error {
+	v, ok := e.sessions.Load(userData)
+	if !ok || v.(*cryptoSession).private == nil {
+		return errors.New("key exchange not offered")
+	}
+	s, err := newCryptoSession(v.(*cryptoSession).private, serverPublic, 0)
+	if err != nil {
+		return err
+	}
+	e.sessions.Store(userData, s)
+	return nil
+}
+
+// Accept answers the public key of a client on the server side and returns
+// the public key to send back
+// goroutine safe
+func (e *Encryption) Accept(userData interface{}, clientPublic []byte) ([]byte, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newCryptoSession(private, clientPublic, 1)
+	if err != nil {
+		return nil, err
+	}
+	e.sessions.Store(userData, s)
+	return private.PublicKey().Bytes(), nil
+}
+
+func newCryptoSession(private *ecdh.PrivateKey, peerPublic []byte, send uint32) (*cryptoSession, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Key(sha256.New, secret, nil, cryptoInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptoSession{aead: aead, send: send}, nil
+}
+
+func (e *Encryption) session(userData interface{}) (*cryptoSession, error) {
+	v, ok := e.sessions.Load(userData)
+	if !ok || v.(*cryptoSession).aead == nil {
+		return nil, errors.New("no session key")
+	}
+	return v.(*cryptoSession), nil
+}
+
+func (s *cryptoSession) nonce(direction uint32, seq uint64) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce, direction)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// goroutine safe
+func (e *Encryption) EncodeTo(userData interface{}, data []byte) ([]byte, error) {
+	s, err := e.session(userData)
+	if err != nil {
+		return nil, err
+	}
+	nonce := s.nonce(s.send, s.sendSeq.Add(1)-1)
+	return s.aead.Seal(nil, nonce, data, nil), nil
+}
+
+// goroutine safe
+func (e *Encryption) DecodeFrom(userData interface{}, data []byte) ([]byte, error) {
+	s, err := e.session(userData)
+	if err != nil {
+		return nil, err
+	}
+	nonce := s.nonce(1-s.send, s.recvSeq.Load())
+	data, err = s.aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.recvSeq.Add(1)
+	return data, nil
+}
+
+// goroutine safe
+func (e *Encryption) Release(userData interface{}) {
+	e.sessions.Delete(userData)
+}
+
+// frames are always encrypted per connection
+func (e *Encryption) Encode(data []byte) ([]byte, error) {
+	return nil, errors.New("encryption needs a connection")
+}
+
+func (e *Encryption) Decode(data []byte) ([]byte, error) {
+	return nil, errors.New("encryption needs a connection")
+}
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// Deprecation tracks the use of a deprecated message ID. After RemoveAt the
+// ID is rejected, a zero RemoveAt keeps accepting it
+type Deprecation struct {
+	ID       uint32
+	Type     reflect.Type
+	RemoveAt time.Time
+	uses     atomic.Int64
+}
+
+// goroutine safe
+func (d *Deprecation) Uses() int64 {
+	return d.uses.Load()
+}
+
+// Use counts a message received with the deprecated ID, warning on the first
+// use and every power of two after it. goroutine safe
+func (d *Deprecation) Use() error {
+	if !d.RemoveAt.IsZero() && !time.Now().Before(d.RemoveAt) {
+		return fmt.Errorf("message id %v (%v) was removed at %v", d.ID, d.Type, d.RemoveAt)
+	}
+
+	n := d.uses.Add(1)
+	if n&(n-1) == 0 {
+		if d.RemoveAt.IsZero() {
+			log.Release("deprecated message id %v (%v) used %v times", d.ID, d.Type, n)
+		} else {
+			log.Release("deprecated message id %v (%v) used %v times, removal at %v", d.ID, d.Type, n, d.RemoveAt)
+		}
+	}
+	return nil
+}
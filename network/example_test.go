@@ -0,0 +1,131 @@
+package network_test
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/network/json"
+)
+
+type xor byte
+
+func (x xor) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ byte(x)
+	}
+	return out, nil
+}
+
+func (x xor) Decode(data []byte) ([]byte, error) {
+	return x.Encode(data)
+}
+
+type Hello struct {
+	Name string
+}
+
+func ExamplePipeline() {
+	processor := json.NewProcessor()
+	processor.Register(&Hello{})
+	processor.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Println("hello", args[0].(*Hello).Name)
+	})
+	p := network.NewPipeline(processor, xor(0x20), xor(0x01))
+
+	data, err := p.Marshal(&Hello{Name: "leaf"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(bytes.Contains(data[0], []byte("leaf")))
+
+	msg, err := p.Unmarshal(data[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	// Output:
+	// false
+	// hello leaf
+}
+
+func ExampleCompression() {
+	c := &network.Compression{Algorithm: network.CompressZstd, Threshold: 64}
+
+	small, _ := c.Encode([]byte("hi"))
+	large, _ := c.Encode(bytes.Repeat([]byte("lobby"), 1000))
+	fmt.Println(small[0], len(small))
+	fmt.Println(large[0], len(large) < 100)
+
+	data, err := c.Decode(large)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(len(data))
+
+	// Output:
+	// 0 3
+	// 2 true
+	// 5000
+}
+
+func ExampleEncryption() {
+	// both ends in one process, userData tells the connections apart
+	client, server := new(network.Encryption), new(network.Encryption)
+
+	offer, _ := client.Offer("conn")
+	answer, err := server.Accept("agent", offer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := client.Finish("conn", answer); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	frame, _ := client.EncodeTo("conn", []byte("login"))
+	data, err := server.DecodeFrom("agent", frame)
+	fmt.Printf("%s %v\n", data, err)
+
+	// replayed
+	_, err = server.DecodeFrom("agent", frame)
+	fmt.Println(err != nil)
+
+	// Output:
+	// login <nil>
+	// true
+}
+
+func ExampleBackoff() {
+	b := &network.Backoff{Min: time.Second, Max: 10 * time.Second, Jitter: -1}
+	for failures := 0; failures < 5; failures++ {
+		fmt.Println(b.Delay(failures))
+	}
+
+	// Output:
+	// 1s
+	// 2s
+	// 4s
+	// 8s
+	// 10s
+}
+
+func ExampleMsgParser_SetLenIncludesHeader() {
+	p := network.NewMsgParser()
+	p.SetMsgLen(network.VarintMsgLen, 1, 1<<20)
+	p.SetLenIncludesHeader(true)
+
+	// a varint of 6, the header and hello
+	data, err := p.Read(bytes.NewReader([]byte{6, 'h', 'e', 'l', 'l', 'o'}))
+	fmt.Println(string(data), err)
+
+	// Output:
+	// hello <nil>
+}
@@ -0,0 +1,73 @@
+package flatbuffers_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/czx-lab/leaf/network/flatbuffers"
+	fb "github.com/google/flatbuffers/go"
+)
+
+// Move is what flatc generates for
+//
+//	table Move { x:float; y:float; }
+type Move struct {
+	_tab fb.Table
+}
+
+func (rcv *Move) Init(buf []byte, i fb.UOffsetT) {
+	rcv._tab.Bytes = buf
+	rcv._tab.Pos = i
+}
+
+func (rcv *Move) Table() fb.Table {
+	return rcv._tab
+}
+
+func (rcv *Move) X() float32 {
+	o := fb.UOffsetT(rcv._tab.Offset(4))
+	if o != 0 {
+		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+func (rcv *Move) Y() float32 {
+	o := fb.UOffsetT(rcv._tab.Offset(6))
+	if o != 0 {
+		return rcv._tab.GetFloat32(o + rcv._tab.Pos)
+	}
+	return 0.0
+}
+
+func Example() {
+	p := flatbuffers.NewProcessor()
+	p.Register(&Move{})
+	p.SetHandler(&Move{}, func(args []interface{}) {
+		m := args[0].(*Move)
+		fmt.Println(m.X(), m.Y())
+	})
+
+	b := fb.NewBuilder(0)
+	b.StartObject(2)
+	b.PrependFloat32Slot(0, 1.5, 0)
+	b.PrependFloat32Slot(1, -2, 0)
+	b.Finish(b.EndObject())
+	m := new(Move)
+	fb.GetRootAs(b.FinishedBytes(), 0, m)
+
+	data, err := p.Marshal(m)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	msg, err := p.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	// Output:
+	// 1.5 -2
+}
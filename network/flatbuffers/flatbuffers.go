@@ -0,0 +1,171 @@
+package flatbuffers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
+	fb "github.com/google/flatbuffers/go"
+)
+
+// --------------------
+// | id | flatbuffer |
+// --------------------
+// Messages are the tables generated by flatc. Unmarshal only points the table
+// at the received buffer, fields are read when they are accessed. The buffer
+// isn't verified, so handlers of untrusted peers should recover from a
+// malformed one
+type Processor struct {
+	littleEndian bool
+	msgInfo      []*MsgInfo
+	msgID        map[reflect.Type]uint16
+}
+
+type MsgInfo struct {
+	msgType    reflect.Type
+	msgRouter  *chanrpc.Server
+	msgHandler MsgHandler
+}
+
+type MsgHandler func([]interface{})
+
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.littleEndian = false
+	p.msgID = make(map[reflect.Type]uint16)
+	return p
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetByteOrder(littleEndian bool) {
+	p.littleEndian = littleEndian
+}
+
+// msg is a pointer to a generated table, e.g. &game.Move{}
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg fb.FlatBuffer) uint16 {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("flatbuffers table pointer required")
+	}
+	if _, ok := p.msgID[msgType]; ok {
+		log.Fatal("message %s is already registered", msgType)
+	}
+	if len(p.msgInfo) >= math.MaxUint16 {
+		log.Fatal("too many flatbuffers messages (max = %v)", math.MaxUint16)
+	}
+
+	i := new(MsgInfo)
+	i.msgType = msgType
+	p.msgInfo = append(p.msgInfo, i)
+	id := uint16(len(p.msgInfo) - 1)
+	p.msgID[msgType] = id
+	return id
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg fb.FlatBuffer, msgRouter *chanrpc.Server) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].msgRouter = msgRouter
+}
+
+// the handler is called with the table, its Table().Bytes is the raw buffer
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg fb.FlatBuffer, msgHandler MsgHandler) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].msgHandler = msgHandler
+}
+
+// goroutine safe
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		return fmt.Errorf("message %s not registered", msgType)
+	}
+	i := p.msgInfo[id]
+	if i.msgHandler != nil {
+		begin := time.Now()
+		i.msgHandler([]interface{}{msg, userData})
+		util.CheckSlow(msgType, begin)
+	}
+	if i.msgRouter != nil {
+		i.msgRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
+// goroutine safe
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	if len(data) < 2+fb.SizeUOffsetT {
+		return nil, errors.New("flatbuffers data too short")
+	}
+
+	// id
+	var id uint16
+	if p.littleEndian {
+		id = binary.LittleEndian.Uint16(data)
+	} else {
+		id = binary.BigEndian.Uint16(data)
+	}
+	if id >= uint16(len(p.msgInfo)) {
+		return nil, fmt.Errorf("message id %v not registered", id)
+	}
+
+	// msg
+	buf := data[2:]
+	if root := fb.GetUOffsetT(buf); int(root)+fb.SizeSOffsetT > len(buf) {
+		return nil, errors.New("flatbuffers root out of range")
+	}
+	msg := reflect.New(p.msgInfo[id].msgType.Elem()).Interface().(fb.FlatBuffer)
+	fb.GetRootAs(buf, 0, msg)
+	return msg, nil
+}
+
+// msg must be the root table of a finished buffer, e.g. initialized by
+// GetRootAs with Builder.FinishedBytes(). The buffer is sent as it is
+// goroutine safe
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	msgType := reflect.TypeOf(msg)
+
+	// id
+	_id, ok := p.msgID[msgType]
+	if !ok {
+		err := fmt.Errorf("message %s not registered", msgType)
+		return nil, err
+	}
+
+	id := make([]byte, 2)
+	if p.littleEndian {
+		binary.LittleEndian.PutUint16(id, _id)
+	} else {
+		binary.BigEndian.PutUint16(id, _id)
+	}
+
+	// data
+	t := msg.(fb.FlatBuffer).Table()
+	return [][]byte{id, t.Bytes}, nil
+}
+
+// goroutine safe
+func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
+	for id, i := range p.msgInfo {
+		f(uint16(id), i.msgType)
+	}
+}
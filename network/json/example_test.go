@@ -0,0 +1,42 @@
+package json_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/czx-lab/leaf/network/json"
+)
+
+type Hello struct {
+	Name string
+}
+
+func ExampleProcessor_SetNumericID() {
+	p := json.NewProcessor()
+	p.Register(&Hello{})
+	p.SetNumericID(true)
+	p.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Println("hello", args[0].(*Hello).Name)
+	})
+
+	data, err := p.Marshal(&Hello{Name: "leaf"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%q\n", bytes.Join(data, nil))
+
+	for _, frame := range [][]byte{bytes.Join(data, nil), []byte(`{"Hello":{"Name":"web"}}`)} {
+		msg, err := p.Unmarshal(frame)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		p.Route(msg, nil)
+	}
+
+	// Output:
+	// "\x00\x00{\"Name\":\"leaf\"}"
+	// hello leaf
+	// hello web
+}
@@ -1,24 +1,37 @@
 package json
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
 	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/util"
 )
 
 type Processor struct {
 	msgInfo map[string]*MsgInfo
+	// numeric ids
+	msgList      []*MsgInfo
+	numericID    bool
+	nameFallback bool
+	littleEndian bool
 }
 
 type MsgInfo struct {
+	msgID         string
+	msgNum        uint16
 	msgType       reflect.Type
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	frameType     network.FrameType
 }
 
 type MsgHandler func([]interface{})
@@ -47,13 +60,51 @@ func (p *Processor) Register(msg interface{}) string {
 	if _, ok := p.msgInfo[msgID]; ok {
 		log.Fatal("message %v is already registered", msgID)
 	}
+	if len(p.msgList) >= math.MaxUint16 {
+		log.Fatal("too many json messages (max = %v)", math.MaxUint16)
+	}
 
 	i := new(MsgInfo)
+	i.msgID = msgID
+	i.msgNum = uint16(len(p.msgList))
 	i.msgType = msgType
 	p.msgInfo[msgID] = i
+	p.msgList = append(p.msgList, i)
 	return msgID
 }
 
+// SetNumericID switches to the frames below, ids are given by Register in
+// order from zero, see ID. With nameFallback, frames starting with '{' are
+// still routed by name, so no id may be encoded with a leading '{' byte
+//
+// ---------------------
+// | id | json message |
+// ---------------------
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetNumericID(nameFallback bool) {
+	p.numericID = true
+	p.nameFallback = nameFallback
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetByteOrder(littleEndian bool) {
+	p.littleEndian = littleEndian
+}
+
+// ID is the numeric id of a registered message
+// goroutine safe
+func (p *Processor) ID(msg interface{}) (uint16, bool) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return 0, false
+	}
+	i, ok := p.msgInfo[msgType.Elem().Name()]
+	if !ok {
+		return 0, false
+	}
+	return i.msgNum, true
+}
+
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
 	msgType := reflect.TypeOf(msg)
@@ -94,6 +145,34 @@ func (p *Processor) SetRawHandler(msgID string, msgRawHandler MsgHandler) {
 	i.msgRawHandler = msgRawHandler
 }
 
+// SetFrameType selects the websocket frame type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetFrameType(msg interface{}, t network.FrameType) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		log.Fatal("json message pointer required")
+	}
+	msgID := msgType.Elem().Name()
+	i, ok := p.msgInfo[msgID]
+	if !ok {
+		log.Fatal("message %v not registered", msgID)
+	}
+
+	i.frameType = t
+}
+
+// goroutine safe
+func (p *Processor) FrameType(msg interface{}) network.FrameType {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr {
+		return network.FrameDefault
+	}
+	if i, ok := p.msgInfo[msgType.Elem().Name()]; ok {
+		return i.frameType
+	}
+	return network.FrameDefault
+}
+
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
 	// raw
@@ -103,7 +182,9 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 			return fmt.Errorf("message %v not registered", msgRaw.msgID)
 		}
 		if i.msgRawHandler != nil {
+			begin := time.Now()
 			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			util.CheckSlow(msgRaw.msgID, begin)
 		}
 		return nil
 	}
@@ -119,7 +200,9 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 		return fmt.Errorf("message %v not registered", msgID)
 	}
 	if i.msgHandler != nil {
+		begin := time.Now()
 		i.msgHandler([]interface{}{msg, userData})
+		util.CheckSlow(msgID, begin)
 	}
 	if i.msgRouter != nil {
 		i.msgRouter.Go(msgType, msg, userData)
@@ -129,6 +212,10 @@ func (p *Processor) Route(msg interface{}, userData interface{}) error {
 
 // goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	if p.numericID && !(p.nameFallback && len(data) > 0 && data[0] == '{') {
+		return p.unmarshalNumeric(data)
+	}
+
 	var m map[string]json.RawMessage
 	err := json.Unmarshal(data, &m)
 	if err != nil {
@@ -141,7 +228,7 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	for msgID, data := range m {
 		i, ok := p.msgInfo[msgID]
 		if !ok {
-			return nil, fmt.Errorf("message %v not registered", msgID)
+			return nil, &network.RouteError{ID: msgID, Err: fmt.Errorf("message %v not registered", msgID)}
 		}
 
 		// msg
@@ -156,6 +243,32 @@ func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
 	panic("bug")
 }
 
+func (p *Processor) unmarshalNumeric(data []byte) (interface{}, error) {
+	if len(data) < 2 {
+		return nil, errors.New("json data too short")
+	}
+
+	// id
+	var id uint16
+	if p.littleEndian {
+		id = binary.LittleEndian.Uint16(data)
+	} else {
+		id = binary.BigEndian.Uint16(data)
+	}
+	if id >= uint16(len(p.msgList)) {
+		return nil, &network.RouteError{ID: id, Err: fmt.Errorf("message id %v not registered", id)}
+	}
+
+	// msg
+	i := p.msgList[id]
+	if i.msgRawHandler != nil {
+		return MsgRaw{i.msgID, data[2:]}, nil
+	} else {
+		msg := reflect.New(i.msgType.Elem()).Interface()
+		return msg, json.Unmarshal(data[2:], msg)
+	}
+}
+
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 	msgType := reflect.TypeOf(msg)
@@ -163,10 +276,22 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 		return nil, errors.New("json message pointer required")
 	}
 	msgID := msgType.Elem().Name()
-	if _, ok := p.msgInfo[msgID]; !ok {
+	i, ok := p.msgInfo[msgID]
+	if !ok {
 		return nil, fmt.Errorf("message %v not registered", msgID)
 	}
 
+	if p.numericID {
+		id := make([]byte, 2)
+		if p.littleEndian {
+			binary.LittleEndian.PutUint16(id, i.msgNum)
+		} else {
+			binary.BigEndian.PutUint16(id, i.msgNum)
+		}
+		data, err := json.Marshal(msg)
+		return [][]byte{id, data}, err
+	}
+
 	// data
 	m := map[string]interface{}{msgID: msg}
 	data, err := json.Marshal(m)
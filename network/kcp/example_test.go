@@ -0,0 +1,49 @@
+package kcp_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/czx-lab/leaf/network/kcp"
+)
+
+func Example() {
+	l, err := kcp.Listen("127.0.0.1:0", kcp.Config{})
+	if err != nil {
+		return
+	}
+	defer l.Close()
+
+	// echo
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	// fast mode
+	s, err := kcp.Dial(l.Addr().String(), kcp.Config{
+		NoDelay:      true,
+		Interval:     10 * time.Millisecond,
+		Resend:       2,
+		NoCongestion: true,
+	})
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	msg := bytes.Repeat([]byte("leaf"), 10000)
+	s.Write(msg)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(s, buf)
+	fmt.Println(err, bytes.Equal(buf, msg))
+
+	// Output:
+	// <nil> true
+}
@@ -0,0 +1,626 @@
+// Package kcp implements the KCP reliable UDP protocol (see
+// github.com/skywind3000/kcp) and exposes KCP sessions as net.Conn
+package kcp
+
+import "encoding/binary"
+
+const (
+	rtoNoDelay   = 30
+	rtoMin       = 100
+	rtoDef       = 200
+	rtoMax       = 60000
+	cmdPush      = 81
+	cmdAck       = 82
+	cmdWask      = 83
+	cmdWins      = 84
+	askSend      = 1
+	askTell      = 2
+	wndSnd       = 32
+	wndRcv       = 128
+	mtuDef       = 1400
+	interval     = 100
+	overhead     = 24
+	deadLink     = 20
+	threshInit   = 2
+	threshMin    = 2
+	probeInit    = 7000
+	probeLimit   = 120000
+	fastackLimit = 5
+)
+
+func timediff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// -------------------------------------------------------------
+// | conv | cmd | frg | wnd | ts | sn | una | len | data       |
+// |  4   |  1  |  1  |  2  | 4  | 4  |  4  |  4  | len bytes  |
+// -------------------------------------------------------------
+type segment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+	data     []byte
+}
+
+func (seg *segment) encode(b []byte) int {
+	binary.LittleEndian.PutUint32(b, seg.conv)
+	b[4] = seg.cmd
+	b[5] = seg.frg
+	binary.LittleEndian.PutUint16(b[6:], seg.wnd)
+	binary.LittleEndian.PutUint32(b[8:], seg.ts)
+	binary.LittleEndian.PutUint32(b[12:], seg.sn)
+	binary.LittleEndian.PutUint32(b[16:], seg.una)
+	binary.LittleEndian.PutUint32(b[20:], uint32(len(seg.data)))
+	return overhead
+}
+
+// kcp is the protocol state of a session (goroutine not safe)
+type kcp struct {
+	conv, mtu, mss, state  uint32
+	sndUna, sndNxt, rcvNxt uint32
+	ssthresh               uint32
+	rxRttval, rxSrtt       int32
+	rxRto, rxMinrto        uint32
+	sndWnd, rcvWnd, rmtWnd uint32
+	cwnd, probe            uint32
+	current, interval      uint32
+	tsFlush, xmit          uint32
+	nodelay, updated       uint32
+	tsProbe, probeWait     uint32
+	deadLink, incr         uint32
+	fastresend             int32
+	fastlimit              int32
+	nocwnd, stream         int32
+
+	sndQueue []*segment
+	rcvQueue []*segment
+	sndBuf   []*segment
+	rcvBuf   []*segment
+	// sn and ts pairs
+	acklist []uint32
+	buffer  []byte
+	output  func(data []byte)
+}
+
+func newKCP(conv uint32, output func(data []byte)) *kcp {
+	k := new(kcp)
+	k.conv = conv
+	k.sndWnd = wndSnd
+	k.rcvWnd = wndRcv
+	k.rmtWnd = wndRcv
+	k.mtu = mtuDef
+	k.mss = k.mtu - overhead
+	k.buffer = make([]byte, (k.mtu+overhead)*3)
+	k.rxRto = rtoDef
+	k.rxMinrto = rtoMin
+	k.interval = interval
+	k.tsFlush = interval
+	k.ssthresh = threshInit
+	k.fastlimit = fastackLimit
+	k.deadLink = deadLink
+	k.output = output
+	return k
+}
+
+// recv returns the size of the message copied to buffer, -1 if there is none
+// and -2 if buffer is too small
+func (k *kcp) recv(buffer []byte) int {
+	peeksize := k.peekSize()
+	if peeksize < 0 {
+		return -1
+	}
+	if peeksize > len(buffer) {
+		return -2
+	}
+
+	fastRecover := uint32(len(k.rcvQueue)) >= k.rcvWnd
+
+	// merge fragments
+	n, count := 0, 0
+	for _, seg := range k.rcvQueue {
+		n += copy(buffer[n:], seg.data)
+		count++
+		if seg.frg == 0 {
+			break
+		}
+	}
+	k.rcvQueue = k.rcvQueue[count:]
+
+	k.moveRcvBuf()
+
+	// fast recover, tell the peer the window is open again
+	if uint32(len(k.rcvQueue)) < k.rcvWnd && fastRecover {
+		k.probe |= askTell
+	}
+	return n
+}
+
+func (k *kcp) peekSize() int {
+	if len(k.rcvQueue) == 0 {
+		return -1
+	}
+
+	seg := k.rcvQueue[0]
+	if seg.frg == 0 {
+		return len(seg.data)
+	}
+	if len(k.rcvQueue) < int(seg.frg)+1 {
+		return -1
+	}
+
+	length := 0
+	for _, seg := range k.rcvQueue {
+		length += len(seg.data)
+		if seg.frg == 0 {
+			break
+		}
+	}
+	return length
+}
+
+// moveRcvBuf moves the in order segments to rcvQueue
+func (k *kcp) moveRcvBuf() {
+	count := 0
+	for _, seg := range k.rcvBuf {
+		if seg.sn != k.rcvNxt || uint32(len(k.rcvQueue)+count) >= k.rcvWnd {
+			break
+		}
+		k.rcvNxt++
+		count++
+	}
+	k.rcvQueue = append(k.rcvQueue, k.rcvBuf[:count]...)
+	k.rcvBuf = k.rcvBuf[count:]
+}
+
+// send queues buffer, it returns -1 if a message has too many fragments
+func (k *kcp) send(buffer []byte) int {
+	// append to the last segment in stream mode
+	if k.stream != 0 && len(k.sndQueue) > 0 {
+		last := k.sndQueue[len(k.sndQueue)-1]
+		if len(last.data) < int(k.mss) {
+			extend := min(len(buffer), int(k.mss)-len(last.data))
+			last.data = append(last.data, buffer[:extend]...)
+			buffer = buffer[extend:]
+		}
+		if len(buffer) == 0 {
+			return 0
+		}
+	}
+
+	count := 1
+	if len(buffer) > int(k.mss) {
+		count = (len(buffer) + int(k.mss) - 1) / int(k.mss)
+	}
+	if k.stream == 0 && count > 255 {
+		return -1
+	}
+
+	for i := 0; i < count; i++ {
+		size := min(len(buffer), int(k.mss))
+		seg := &segment{data: append([]byte(nil), buffer[:size]...)}
+		if k.stream == 0 {
+			seg.frg = uint8(count - i - 1)
+		}
+		k.sndQueue = append(k.sndQueue, seg)
+		buffer = buffer[size:]
+	}
+	return 0
+}
+
+func (k *kcp) updateAck(rtt int32) {
+	if k.rxSrtt == 0 {
+		k.rxSrtt = rtt
+		k.rxRttval = rtt / 2
+	} else {
+		delta := rtt - k.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		k.rxRttval = (3*k.rxRttval + delta) / 4
+		k.rxSrtt = (7*k.rxSrtt + rtt) / 8
+		if k.rxSrtt < 1 {
+			k.rxSrtt = 1
+		}
+	}
+	rto := uint32(k.rxSrtt) + max(k.interval, uint32(4*k.rxRttval))
+	k.rxRto = min(max(k.rxMinrto, rto), rtoMax)
+}
+
+func (k *kcp) shrinkBuf() {
+	if len(k.sndBuf) > 0 {
+		k.sndUna = k.sndBuf[0].sn
+	} else {
+		k.sndUna = k.sndNxt
+	}
+}
+
+func (k *kcp) parseAck(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+
+	for i, seg := range k.sndBuf {
+		if sn == seg.sn {
+			k.sndBuf = append(k.sndBuf[:i], k.sndBuf[i+1:]...)
+			break
+		}
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+func (k *kcp) parseUna(una uint32) {
+	count := 0
+	for _, seg := range k.sndBuf {
+		if timediff(una, seg.sn) <= 0 {
+			break
+		}
+		count++
+	}
+	k.sndBuf = k.sndBuf[count:]
+}
+
+func (k *kcp) parseFastack(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+
+	for _, seg := range k.sndBuf {
+		if timediff(sn, seg.sn) < 0 {
+			break
+		} else if sn != seg.sn {
+			seg.fastack++
+		}
+	}
+}
+
+func (k *kcp) parseData(newseg *segment) {
+	sn := newseg.sn
+	if timediff(sn, k.rcvNxt+k.rcvWnd) >= 0 || timediff(sn, k.rcvNxt) < 0 {
+		return
+	}
+
+	// keep rcvBuf sorted, drop duplicates
+	i := len(k.rcvBuf)
+	for ; i > 0; i-- {
+		seg := k.rcvBuf[i-1]
+		if seg.sn == sn {
+			return
+		}
+		if timediff(sn, seg.sn) > 0 {
+			break
+		}
+	}
+	k.rcvBuf = append(k.rcvBuf, nil)
+	copy(k.rcvBuf[i+1:], k.rcvBuf[i:])
+	k.rcvBuf[i] = newseg
+
+	k.moveRcvBuf()
+}
+
+// input processes a received packet, it returns a negative value if the
+// packet is malformed or belongs to another conversation
+func (k *kcp) input(data []byte) int {
+	if len(data) < overhead {
+		return -1
+	}
+
+	prevUna := k.sndUna
+	var maxack uint32
+	flag := false
+	for len(data) >= overhead {
+		seg := segment{
+			conv: binary.LittleEndian.Uint32(data),
+			cmd:  data[4],
+			frg:  data[5],
+			wnd:  binary.LittleEndian.Uint16(data[6:]),
+			ts:   binary.LittleEndian.Uint32(data[8:]),
+			sn:   binary.LittleEndian.Uint32(data[12:]),
+			una:  binary.LittleEndian.Uint32(data[16:]),
+		}
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[overhead:]
+
+		if seg.conv != k.conv {
+			return -1
+		}
+		if uint32(len(data)) < length {
+			return -2
+		}
+		if seg.cmd < cmdPush || seg.cmd > cmdWins {
+			return -3
+		}
+
+		k.rmtWnd = uint32(seg.wnd)
+		k.parseUna(seg.una)
+		k.shrinkBuf()
+
+		switch seg.cmd {
+		case cmdAck:
+			if rtt := timediff(k.current, seg.ts); rtt >= 0 {
+				k.updateAck(rtt)
+			}
+			k.parseAck(seg.sn)
+			k.shrinkBuf()
+			if !flag || timediff(seg.sn, maxack) > 0 {
+				flag = true
+				maxack = seg.sn
+			}
+		case cmdPush:
+			if timediff(seg.sn, k.rcvNxt+k.rcvWnd) < 0 {
+				k.acklist = append(k.acklist, seg.sn, seg.ts)
+				if timediff(seg.sn, k.rcvNxt) >= 0 {
+					seg.data = append([]byte(nil), data[:length]...)
+					k.parseData(&seg)
+				}
+			}
+		case cmdWask:
+			k.probe |= askTell
+		case cmdWins:
+		}
+
+		data = data[length:]
+	}
+
+	if flag {
+		k.parseFastack(maxack)
+	}
+
+	// congestion window
+	if timediff(k.sndUna, prevUna) > 0 && k.cwnd < k.rmtWnd {
+		mss := k.mss
+		if k.cwnd < k.ssthresh {
+			k.cwnd++
+			k.incr += mss
+		} else {
+			if k.incr < mss {
+				k.incr = mss
+			}
+			k.incr += (mss*mss)/k.incr + mss/16
+			if (k.cwnd+1)*mss <= k.incr {
+				k.cwnd = (k.incr + mss - 1) / mss
+			}
+		}
+		if k.cwnd > k.rmtWnd {
+			k.cwnd = k.rmtWnd
+			k.incr = k.rmtWnd * mss
+		}
+	}
+	return 0
+}
+
+func (k *kcp) wndUnused() uint16 {
+	if uint32(len(k.rcvQueue)) < k.rcvWnd {
+		return uint16(k.rcvWnd - uint32(len(k.rcvQueue)))
+	}
+	return 0
+}
+
+// flush sends the acks, the window probes and the due segments
+func (k *kcp) flush() {
+	if k.updated == 0 {
+		return
+	}
+
+	current := k.current
+	buffer := k.buffer
+	ptr := 0
+	makeSpace := func(space int) {
+		if ptr+space > int(k.mtu) {
+			k.output(buffer[:ptr])
+			ptr = 0
+		}
+	}
+
+	seg := segment{conv: k.conv, cmd: cmdAck, wnd: k.wndUnused(), una: k.rcvNxt}
+
+	// acks
+	for i := 0; i+1 < len(k.acklist); i += 2 {
+		makeSpace(overhead)
+		seg.sn, seg.ts = k.acklist[i], k.acklist[i+1]
+		ptr += seg.encode(buffer[ptr:])
+	}
+	k.acklist = k.acklist[:0]
+
+	// probe the window size of the peer
+	if k.rmtWnd == 0 {
+		if k.probeWait == 0 {
+			k.probeWait = probeInit
+			k.tsProbe = current + k.probeWait
+		} else if timediff(current, k.tsProbe) >= 0 {
+			if k.probeWait < probeInit {
+				k.probeWait = probeInit
+			}
+			k.probeWait += k.probeWait / 2
+			if k.probeWait > probeLimit {
+				k.probeWait = probeLimit
+			}
+			k.tsProbe = current + k.probeWait
+			k.probe |= askSend
+		}
+	} else {
+		k.tsProbe = 0
+		k.probeWait = 0
+	}
+
+	seg.sn, seg.ts = 0, 0
+	if k.probe&askSend != 0 {
+		seg.cmd = cmdWask
+		makeSpace(overhead)
+		ptr += seg.encode(buffer[ptr:])
+	}
+	if k.probe&askTell != 0 {
+		seg.cmd = cmdWins
+		makeSpace(overhead)
+		ptr += seg.encode(buffer[ptr:])
+	}
+	k.probe = 0
+
+	// move data from sndQueue to sndBuf
+	cwnd := min(k.sndWnd, k.rmtWnd)
+	if k.nocwnd == 0 {
+		cwnd = min(k.cwnd, cwnd)
+	}
+	for len(k.sndQueue) > 0 && timediff(k.sndNxt, k.sndUna+cwnd) < 0 {
+		newseg := k.sndQueue[0]
+		k.sndQueue = k.sndQueue[1:]
+
+		newseg.conv = k.conv
+		newseg.cmd = cmdPush
+		newseg.sn = k.sndNxt
+		k.sndNxt++
+		newseg.resendts = current
+		newseg.rto = k.rxRto
+		k.sndBuf = append(k.sndBuf, newseg)
+	}
+
+	resent := uint32(0xffffffff)
+	if k.fastresend > 0 {
+		resent = uint32(k.fastresend)
+	}
+	rtomin := uint32(0)
+	if k.nodelay == 0 {
+		rtomin = k.rxRto >> 3
+	}
+
+	// send and resend the segments
+	change, lost := false, false
+	for _, segment := range k.sndBuf {
+		needsend := false
+		if segment.xmit == 0 {
+			needsend = true
+			segment.rto = k.rxRto
+			segment.resendts = current + segment.rto + rtomin
+		} else if timediff(current, segment.resendts) >= 0 {
+			needsend = true
+			k.xmit++
+			if k.nodelay == 0 {
+				segment.rto += max(segment.rto, k.rxRto)
+			} else if k.nodelay < 2 {
+				segment.rto += segment.rto / 2
+			} else {
+				segment.rto += k.rxRto / 2
+			}
+			segment.resendts = current + segment.rto
+			lost = true
+		} else if segment.fastack >= resent {
+			if k.fastlimit <= 0 || int32(segment.xmit) <= k.fastlimit {
+				needsend = true
+				segment.fastack = 0
+				segment.resendts = current + segment.rto
+				change = true
+			}
+		}
+
+		if needsend {
+			segment.xmit++
+			segment.ts = current
+			segment.wnd = seg.wnd
+			segment.una = k.rcvNxt
+
+			makeSpace(overhead + len(segment.data))
+			ptr += segment.encode(buffer[ptr:])
+			ptr += copy(buffer[ptr:], segment.data)
+
+			if segment.xmit >= k.deadLink {
+				k.state = 0xffffffff
+			}
+		}
+	}
+	if ptr > 0 {
+		k.output(buffer[:ptr])
+	}
+
+	// congestion control
+	if change {
+		inflight := k.sndNxt - k.sndUna
+		k.ssthresh = max(inflight/2, threshMin)
+		k.cwnd = k.ssthresh + resent
+		k.incr = k.cwnd * k.mss
+	}
+	if lost {
+		k.ssthresh = max(cwnd/2, threshMin)
+		k.cwnd = 1
+		k.incr = k.mss
+	}
+	if k.cwnd < 1 {
+		k.cwnd = 1
+		k.incr = k.mss
+	}
+}
+
+// update is called every interval with the current time in milliseconds
+func (k *kcp) update(current uint32) {
+	k.current = current
+	if k.updated == 0 {
+		k.updated = 1
+		k.tsFlush = current
+	}
+
+	slap := timediff(current, k.tsFlush)
+	if slap >= 10000 || slap < -10000 {
+		k.tsFlush = current
+		slap = 0
+	}
+	if slap >= 0 {
+		k.tsFlush += k.interval
+		if timediff(current, k.tsFlush) >= 0 {
+			k.tsFlush = current + k.interval
+		}
+		k.flush()
+	}
+}
+
+func (k *kcp) waitSnd() int {
+	return len(k.sndBuf) + len(k.sndQueue)
+}
+
+func (k *kcp) setMTU(mtu int) bool {
+	if mtu < 50 {
+		return false
+	}
+	k.mtu = uint32(mtu)
+	k.mss = k.mtu - overhead
+	k.buffer = make([]byte, (mtu+overhead)*3)
+	return true
+}
+
+func (k *kcp) setNoDelay(nodelay bool, interval int, resend int, nc bool) {
+	if nodelay {
+		k.nodelay = 1
+		k.rxMinrto = rtoNoDelay
+	} else {
+		k.nodelay = 0
+		k.rxMinrto = rtoMin
+	}
+	if interval > 0 {
+		k.interval = uint32(min(max(interval, 10), 5000))
+	}
+	if resend >= 0 {
+		k.fastresend = int32(resend)
+	}
+	if nc {
+		k.nocwnd = 1
+	} else {
+		k.nocwnd = 0
+	}
+}
+
+func (k *kcp) setWndSize(sndWnd int, rcvWnd int) {
+	if sndWnd > 0 {
+		k.sndWnd = uint32(sndWnd)
+	}
+	if rcvWnd > 0 {
+		k.rcvWnd = uint32(rcvWnd)
+	}
+}
@@ -0,0 +1,397 @@
+package kcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// packet is a datagram on the simulated link
+type packet struct {
+	at   uint32
+	seq  int
+	data []byte
+}
+
+// link delivers the output of a kcp to another one after latency and up to
+// jitter more, so that packets are reordered, and drops loss of them
+type link struct {
+	rnd     *rand.Rand
+	latency uint32
+	jitter  uint32
+	loss    float64
+	// drops the packet if it returns true, after loss
+	drop    func(data []byte) bool
+	now     *uint32
+	seq     int
+	packets []packet
+}
+
+func (l *link) output(data []byte) {
+	if l.rnd.Float64() < l.loss || l.drop != nil && l.drop(data) {
+		return
+	}
+	at := *l.now + l.latency
+	if l.jitter > 0 {
+		at += uint32(l.rnd.Intn(int(l.jitter)))
+	}
+	l.seq++
+	l.packets = append(l.packets, packet{at, l.seq, append([]byte(nil), data...)})
+}
+
+// deliver inputs the packets due to k
+func (l *link) deliver(k *kcp) {
+	sort.Slice(l.packets, func(i, j int) bool {
+		if l.packets[i].at != l.packets[j].at {
+			return l.packets[i].at < l.packets[j].at
+		}
+		return l.packets[i].seq < l.packets[j].seq
+	})
+	n := 0
+	for ; n < len(l.packets) && timediff(*l.now, l.packets[n].at) >= 0; n++ {
+		if k.input(l.packets[n].data) < 0 {
+			panic("invalid packet")
+		}
+	}
+	l.packets = l.packets[n:]
+}
+
+// pair is two kcp talking over links, in simulated milliseconds
+type pair struct {
+	now  uint32
+	a, b *kcp
+	ab   *link
+	ba   *link
+}
+
+func newPair(seed int64, latency uint32, jitter uint32, loss float64) *pair {
+	p := new(pair)
+	rnd := rand.New(rand.NewSource(seed))
+	p.ab = &link{rnd: rnd, latency: latency, jitter: jitter, loss: loss, now: &p.now}
+	p.ba = &link{rnd: rnd, latency: latency, jitter: jitter, loss: loss, now: &p.now}
+	p.a = newKCP(1, p.ab.output)
+	p.b = newKCP(1, p.ba.output)
+	return p
+}
+
+// step advances 1ms
+func (p *pair) step() {
+	p.now++
+	p.ab.deliver(p.b)
+	p.ba.deliver(p.a)
+	p.a.update(p.now)
+	p.b.update(p.now)
+}
+
+// transfer sends msgs from a to b and returns what b received, in up to
+// limit ms
+func (p *pair) transfer(t *testing.T, msgs [][]byte, limit uint32) [][]byte {
+	t.Helper()
+	for _, msg := range msgs {
+		if p.a.send(msg) < 0 {
+			t.Fatal("send error")
+		}
+	}
+	var received [][]byte
+	buf := make([]byte, 1<<20)
+	for start := p.now; len(received) < len(msgs); {
+		if p.now-start > limit {
+			t.Fatalf("%v of %v messages received in %vms", len(received), len(msgs), limit)
+		}
+		p.step()
+		for {
+			n := p.b.recv(buf)
+			if n < 0 {
+				break
+			}
+			received = append(received, append([]byte(nil), buf[:n]...))
+		}
+	}
+	return received
+}
+
+func messages(rnd *rand.Rand, n int, maxLen int) [][]byte {
+	msgs := make([][]byte, n)
+	for i := range msgs {
+		msgs[i] = make([]byte, 1+rnd.Intn(maxLen))
+		rnd.Read(msgs[i])
+	}
+	return msgs
+}
+
+func equal(t *testing.T, received [][]byte, msgs [][]byte) {
+	t.Helper()
+	if len(received) != len(msgs) {
+		t.Fatalf("%v messages received, want %v", len(received), len(msgs))
+	}
+	for i := range msgs {
+		if !bytes.Equal(received[i], msgs[i]) {
+			t.Fatalf("message %v corrupted or out of order", i)
+		}
+	}
+}
+
+func TestLossReorder(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		loss    float64
+		jitter  uint32
+		nodelay bool
+	}{
+		{"reorder", 0, 40, false},
+		{"loss", 0.2, 0, false},
+		{"loss and reorder", 0.3, 40, false},
+		{"loss and reorder fast", 0.3, 40, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPair(1, 20, tt.jitter, tt.loss)
+			if tt.nodelay {
+				p.a.setNoDelay(true, 10, 2, true)
+				p.b.setNoDelay(true, 10, 2, true)
+			}
+			// fragmented up to 4 segments
+			msgs := messages(rand.New(rand.NewSource(2)), 300, 4*int(p.a.mss))
+			equal(t, p.transfer(t, msgs, 600000), msgs)
+			if tt.loss > 0 && p.a.xmit == 0 {
+				t.Fatal("nothing retransmitted")
+			}
+		})
+	}
+}
+
+func TestRetransmit(t *testing.T) {
+	p := newPair(1, 10, 0, 0)
+	// the first transmission of sn 0 is lost
+	dropped := false
+	p.ab.drop = func(data []byte) bool {
+		if !dropped && data[4] == cmdPush && binary.LittleEndian.Uint32(data[12:]) == 0 {
+			dropped = true
+			return true
+		}
+		return false
+	}
+
+	msgs := [][]byte{[]byte("leaf")}
+	equal(t, p.transfer(t, msgs, 10000), msgs)
+	if !dropped || p.a.xmit != 1 {
+		t.Fatalf("dropped %v, retransmitted %v", dropped, p.a.xmit)
+	}
+	// resent after the rto, not before
+	if p.now < rtoDef {
+		t.Fatalf("received at %vms, before the rto", p.now)
+	}
+}
+
+func TestFastResend(t *testing.T) {
+	p := newPair(1, 10, 0, 0)
+	p.a.setNoDelay(false, 10, 2, true)
+	p.a.setWndSize(128, 128)
+	p.a.rxRto = rtoMax
+	p.a.rxMinrto = rtoMax
+
+	// sn 1 is lost once, the acks of the next ones resend it
+	dropped := false
+	p.ab.drop = func(data []byte) bool {
+		if !dropped && data[4] == cmdPush && binary.LittleEndian.Uint32(data[12:]) == 1 {
+			dropped = true
+			return true
+		}
+		return false
+	}
+
+	msgs := messages(rand.New(rand.NewSource(3)), 10, 100)
+	equal(t, p.transfer(t, msgs, 10000), msgs)
+	if p.now >= rtoMax {
+		t.Fatalf("received at %vms, after the rto", p.now)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	p := newPair(1, 10, 0, 0)
+	p.a.setNoDelay(false, 10, 0, true)
+	p.a.setWndSize(4, 0)
+	p.b.setWndSize(0, 8)
+
+	// b doesn't read, a stops when b's window is full
+	msgs := messages(rand.New(rand.NewSource(4)), 20, 100)
+	for _, msg := range msgs {
+		p.a.send(msg)
+	}
+	for i := 0; i < 2000; i++ {
+		p.step()
+		if inflight := p.a.sndNxt - p.a.sndUna; inflight > 4 {
+			t.Fatalf("%v segments in flight, sndWnd 4", inflight)
+		}
+	}
+	if len(p.b.rcvQueue) != 8 || p.a.rmtWnd != 0 {
+		t.Fatalf("rcvQueue %v, rmtWnd %v", len(p.b.rcvQueue), p.a.rmtWnd)
+	}
+
+	// the window reopens once b reads, told by b or probed by a
+	var received [][]byte
+	buf := make([]byte, 1024)
+	for i := 0; len(received) < len(msgs); i++ {
+		if i > 20000 {
+			t.Fatalf("%v of %v messages received", len(received), len(msgs))
+		}
+		for {
+			n := p.b.recv(buf)
+			if n < 0 {
+				break
+			}
+			received = append(received, append([]byte(nil), buf[:n]...))
+		}
+		p.step()
+	}
+	equal(t, received, msgs)
+}
+
+func TestCongestion(t *testing.T) {
+	p := newPair(1, 10, 0, 0)
+	p.a.setWndSize(64, 0)
+	p.a.setNoDelay(false, 10, 0, false)
+
+	// slow start
+	msgs := messages(rand.New(rand.NewSource(5)), 64, int(p.a.mss))
+	equal(t, p.transfer(t, msgs, 100000), msgs)
+	if p.a.cwnd <= threshInit {
+		t.Fatalf("cwnd %v after 64 acked segments", p.a.cwnd)
+	}
+
+	// a timeout shrinks the window to 1
+	dropped := false
+	p.ab.drop = func(data []byte) bool {
+		if !dropped && data[4] == cmdPush {
+			dropped = true
+			return true
+		}
+		return false
+	}
+	p.a.send([]byte("leaf"))
+	for i := 0; i < 5000 && p.a.xmit == 0; i++ {
+		p.step()
+	}
+	if p.a.xmit == 0 || p.a.cwnd != 1 {
+		t.Fatalf("xmit %v, cwnd %v after a timeout", p.a.xmit, p.a.cwnd)
+	}
+}
+
+// the segments of skywind3000/kcp, ikcp_encode_seg
+func TestWireFormat(t *testing.T) {
+	push := []byte{
+		0x44, 0x33, 0x22, 0x11, // conv
+		cmdPush,    // cmd
+		0,          // frg
+		0x80, 0x00, // wnd
+		0x10, 0x00, 0x00, 0x00, // ts
+		0x00, 0x00, 0x00, 0x00, // sn
+		0x00, 0x00, 0x00, 0x00, // una
+		0x04, 0x00, 0x00, 0x00, // len
+		'l', 'e', 'a', 'f',
+	}
+	var out [][]byte
+	k := newKCP(0x11223344, func(data []byte) {
+		out = append(out, append([]byte(nil), data...))
+	})
+	if k.input(push) != 0 {
+		t.Fatal("push not accepted")
+	}
+	buf := make([]byte, 16)
+	if n := k.recv(buf); n != 4 || string(buf[:n]) != "leaf" {
+		t.Fatalf("received %q", buf[:max(n, 0)])
+	}
+
+	// acked with the ts of the push, the window and the next sn
+	k.update(0x20)
+	ack := []byte{
+		0x44, 0x33, 0x22, 0x11,
+		cmdAck,
+		0,
+		0x80, 0x00,
+		0x10, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	if len(out) != 1 || !bytes.Equal(out[0], ack) {
+		t.Fatalf("ack % x, want % x", out, ack)
+	}
+
+	// another conversation or a truncated segment
+	push[0] = 0x45
+	if k.input(push) >= 0 {
+		t.Fatal("segment of another conversation accepted")
+	}
+	push[0] = 0x44
+	if k.input(push[:len(push)-1]) >= 0 {
+		t.Fatal("truncated segment accepted")
+	}
+}
+
+// lossyConn drops and delays the datagrams of a PacketConn
+type lossyConn struct {
+	net.PacketConn
+	loss float64
+}
+
+func (c *lossyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || rand.Float64() >= c.loss {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if rand.Float64() < c.loss {
+		return len(b), nil
+	}
+	data := append([]byte(nil), b...)
+	time.AfterFunc(time.Duration(rand.Intn(20))*time.Millisecond, func() {
+		c.PacketConn.WriteTo(data, addr)
+	})
+	return len(b), nil
+}
+
+func TestSessionLossyLink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{NoDelay: true, Interval: 10 * time.Millisecond, Resend: 2, NoCongestion: true}
+	l := Serve(&lossyConn{conn, 0.2}, config)
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	s, err := Dial(l.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	msg := make([]byte, 256*1024)
+	rand.Read(msg)
+	go s.Write(msg)
+	s.SetReadDeadline(time.Now().Add(30 * time.Second))
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatal("echo corrupted")
+	}
+}
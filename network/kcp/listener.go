@@ -0,0 +1,113 @@
+package kcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Listener accepts the KCP sessions of a UDP port, a session is created by
+// the first packet of a new remote address or conversation
+type Listener struct {
+	conn     net.PacketConn
+	config   Config
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	chAccept chan *Session
+	die      chan struct{}
+	dieOnce  sync.Once
+}
+
+// Listen announces on the UDP address addr
+func Listen(addr string, config Config) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(conn, config), nil
+}
+
+// Serve accepts the sessions of conn, closing the listener closes conn
+func Serve(conn net.PacketConn, config Config) *Listener {
+	l := new(Listener)
+	l.conn = conn
+	l.config = config
+	l.sessions = make(map[string]*Session)
+	l.chAccept = make(chan *Session, 128)
+	l.die = make(chan struct{})
+
+	go l.readLoop()
+	return l
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		if n < overhead {
+			continue
+		}
+		data := buf[:n]
+		conv := binary.LittleEndian.Uint32(data)
+
+		key := from.String()
+		l.mutex.Lock()
+		s := l.sessions[key]
+		if s != nil && s.kcp.conv != conv {
+			// the peer reconnected from the same address
+			l.mutex.Unlock()
+			s.close()
+			l.mutex.Lock()
+			s = nil
+		}
+		if s == nil {
+			if len(l.chAccept) == cap(l.chAccept) {
+				l.mutex.Unlock()
+				continue
+			}
+			s = newSession(conv, l.config, l.conn, from, l)
+			l.sessions[key] = s
+			l.chAccept <- s
+		}
+		l.mutex.Unlock()
+
+		s.input(data)
+	}
+}
+
+func (l *Listener) remove(s *Session) {
+	l.mutex.Lock()
+	key := s.remote.String()
+	if l.sessions[key] == s {
+		delete(l.sessions, key)
+	}
+	l.mutex.Unlock()
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.chAccept:
+		return s, nil
+	case <-l.die:
+		return nil, errors.New("kcp: use of closed listener")
+	}
+}
+
+// Close stops accepting, the accepted sessions are not closed
+func (l *Listener) Close() error {
+	var err error
+	l.dieOnce.Do(func() {
+		close(l.die)
+		err = l.conn.Close()
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,315 @@
+package kcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config tunes a KCP session, the zero value is the normal mode. The fast
+// mode is NoDelay, 10ms Interval, Resend 2 and NoCongestion
+type Config struct {
+	NoDelay bool
+	// how often the session is flushed, 100ms if zero
+	Interval time.Duration
+	// resend a segment skipped by Resend acks, zero disables fast resend
+	Resend       int
+	NoCongestion bool
+	// window sizes in segments, 32 and 128 if zero
+	SndWnd int
+	RcvWnd int
+	// 1400 if zero
+	MTU int
+	// sessions receiving nothing for IdleTimeout are closed, zero never
+	// closes them
+	IdleTimeout time.Duration
+}
+
+var (
+	epoch       = time.Now()
+	errClosed   = errors.New("kcp: use of closed session")
+	errTooLarge = errors.New("kcp: message too large")
+)
+
+func currentMs() uint32 {
+	return uint32(time.Since(epoch) / time.Millisecond)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "kcp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Session is a KCP stream over UDP, it implements net.Conn
+type Session struct {
+	mutex    sync.Mutex
+	kcp      *kcp
+	config   Config
+	conn     net.PacketConn
+	remote   net.Addr
+	listener *Listener
+	// data received but not read yet
+	rbuf  []byte
+	rdata []byte
+
+	chRead    chan struct{}
+	chWrite   chan struct{}
+	die       chan struct{}
+	closeOnce sync.Once
+	lastRecv  time.Time
+
+	rdeadline time.Time
+	wdeadline time.Time
+}
+
+func newSession(conv uint32, config Config, conn net.PacketConn, remote net.Addr, l *Listener) *Session {
+	s := new(Session)
+	s.config = config
+	s.conn = conn
+	s.remote = remote
+	s.listener = l
+	s.chRead = make(chan struct{}, 1)
+	s.chWrite = make(chan struct{}, 1)
+	s.die = make(chan struct{})
+	s.lastRecv = time.Now()
+
+	s.kcp = newKCP(conv, s.output)
+	s.kcp.stream = 1
+	s.kcp.setNoDelay(config.NoDelay, int(config.Interval/time.Millisecond), config.Resend, config.NoCongestion)
+	s.kcp.setWndSize(config.SndWnd, config.RcvWnd)
+	if config.MTU > 0 {
+		s.kcp.setMTU(config.MTU)
+	}
+	s.rbuf = make([]byte, 64*1024)
+
+	go s.run()
+	return s
+}
+
+func (s *Session) output(data []byte) {
+	if s.listener != nil {
+		s.conn.WriteTo(data, s.remote)
+	} else {
+		s.conn.(net.Conn).Write(data)
+	}
+}
+
+func (s *Session) run() {
+	t := time.NewTicker(time.Duration(s.kcp.interval) * time.Millisecond)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.die:
+			return
+		case now := <-t.C:
+			s.mutex.Lock()
+			s.kcp.update(currentMs())
+			dead := s.kcp.state == 0xffffffff
+			idle := s.config.IdleTimeout > 0 && now.Sub(s.lastRecv) > s.config.IdleTimeout
+			s.mutex.Unlock()
+
+			notify(s.chWrite)
+			if dead || idle {
+				s.close()
+				return
+			}
+		}
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// input is called with each packet received from the peer
+func (s *Session) input(data []byte) bool {
+	s.mutex.Lock()
+	ok := s.kcp.input(data) >= 0
+	if ok {
+		s.lastRecv = time.Now()
+	}
+	readable := s.kcp.peekSize() > 0
+	s.mutex.Unlock()
+
+	if readable {
+		notify(s.chRead)
+	}
+	notify(s.chWrite)
+	return ok
+}
+
+func deadline(t time.Time) (<-chan time.Time, *time.Timer) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, timer
+}
+
+func (s *Session) Read(b []byte) (int, error) {
+	for {
+		s.mutex.Lock()
+		if len(s.rdata) > 0 {
+			n := copy(b, s.rdata)
+			s.rdata = s.rdata[n:]
+			s.mutex.Unlock()
+			return n, nil
+		}
+		if size := s.kcp.peekSize(); size > 0 {
+			if size > len(s.rbuf) {
+				s.rbuf = make([]byte, size)
+			}
+			size = s.kcp.recv(s.rbuf)
+			n := copy(b, s.rbuf[:size])
+			s.rdata = s.rbuf[n:size]
+			s.mutex.Unlock()
+			return n, nil
+		}
+		rdeadline := s.rdeadline
+		s.mutex.Unlock()
+
+		if !rdeadline.IsZero() && !time.Now().Before(rdeadline) {
+			return 0, timeoutError{}
+		}
+		c, timer := deadline(rdeadline)
+		select {
+		case <-s.chRead:
+		case <-c:
+		case <-s.die:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, io.EOF
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *Session) Write(b []byte) (int, error) {
+	for {
+		select {
+		case <-s.die:
+			return 0, errClosed
+		default:
+		}
+
+		s.mutex.Lock()
+		if s.kcp.waitSnd() < int(s.kcp.sndWnd)*2 {
+			if s.kcp.send(b) < 0 {
+				s.mutex.Unlock()
+				return 0, errTooLarge
+			}
+			s.kcp.flush()
+			s.mutex.Unlock()
+			return len(b), nil
+		}
+		wdeadline := s.wdeadline
+		s.mutex.Unlock()
+
+		if !wdeadline.IsZero() && !time.Now().Before(wdeadline) {
+			return 0, timeoutError{}
+		}
+		c, timer := deadline(wdeadline)
+		select {
+		case <-s.chWrite:
+		case <-c:
+		case <-s.die:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *Session) close() {
+	s.closeOnce.Do(func() {
+		close(s.die)
+
+		if s.listener != nil {
+			s.listener.remove(s)
+		} else {
+			s.conn.Close()
+		}
+	})
+}
+
+// Close doesn't notify the peer, it notices the session is gone by its dead
+// link detection or IdleTimeout
+func (s *Session) Close() error {
+	s.close()
+	return nil
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *Session) SetDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.rdeadline = t
+	s.wdeadline = t
+	s.mutex.Unlock()
+	notify(s.chRead)
+	notify(s.chWrite)
+	return nil
+}
+
+func (s *Session) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.rdeadline = t
+	s.mutex.Unlock()
+	notify(s.chRead)
+	return nil
+}
+
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.wdeadline = t
+	s.mutex.Unlock()
+	notify(s.chWrite)
+	return nil
+}
+
+// Dial connects to a KCP listener
+func Dial(addr string, config Config) (*Session, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := newSession(binary.LittleEndian.Uint32(b[:]), config, conn.(net.PacketConn), conn.RemoteAddr(), nil)
+	go s.readLoop(conn)
+	return s, nil
+}
+
+func (s *Session) readLoop(conn net.Conn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			s.close()
+			return
+		}
+		s.input(buf[:n])
+	}
+}
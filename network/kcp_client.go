@@ -0,0 +1,63 @@
+package network
+
+import (
+	"net"
+	"time"
+
+	"github.com/czx-lab/leaf/network/kcp"
+)
+
+type KCPClient struct {
+	Addr            string
+	ConnNum         int
+	ConnectInterval time.Duration
+	PendingWriteNum int
+	AutoReconnect   bool
+	NewAgent        func(*TCPConn) Agent
+	// see TCPClient
+	Backoff *Backoff
+	ConnEvents
+	// window, MTU and nodelay settings, set IdleTimeout to notice a server
+	// gone without a reply
+	KCP kcp.Config
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+
+	client *TCPClient
+}
+
+func (client *KCPClient) Start() {
+	config := client.KCP
+	client.client = &TCPClient{
+		Addr:              client.Addr,
+		ConnNum:           client.ConnNum,
+		ConnectInterval:   client.ConnectInterval,
+		PendingWriteNum:   client.PendingWriteNum,
+		AutoReconnect:     client.AutoReconnect,
+		NewAgent:          client.NewAgent,
+		Backoff:           client.Backoff,
+		ConnEvents:        client.ConnEvents,
+		LenMsgLen:         client.LenMsgLen,
+		MinMsgLen:         client.MinMsgLen,
+		MaxMsgLen:         client.MaxMsgLen,
+		LittleEndian:      client.LittleEndian,
+		MaxFragmentedLen:  client.MaxFragmentedLen,
+		LenIncludesHeader: client.LenIncludesHeader,
+		dialer: func(addr string) (net.Conn, error) {
+			return kcp.Dial(addr, config)
+		},
+	}
+	client.client.Start()
+}
+
+func (client *KCPClient) Close() {
+	client.client.Close()
+}
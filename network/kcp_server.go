@@ -0,0 +1,61 @@
+package network
+
+import (
+	"net"
+	"time"
+
+	"github.com/czx-lab/leaf/network/kcp"
+)
+
+// KCPServer serves KCP over UDP, the sessions are framed like TCP
+// connections
+type KCPServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	NewAgent        func(*TCPConn) Agent
+	// window, MTU and nodelay settings
+	KCP kcp.Config
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+
+	server *TCPServer
+}
+
+func (server *KCPServer) Start() {
+	config := server.KCP
+	server.server = &TCPServer{
+		Addr:              server.Addr,
+		MaxConnNum:        server.MaxConnNum,
+		PendingWriteNum:   server.PendingWriteNum,
+		NewAgent:          server.NewAgent,
+		LenMsgLen:         server.LenMsgLen,
+		MinMsgLen:         server.MinMsgLen,
+		MaxMsgLen:         server.MaxMsgLen,
+		LittleEndian:      server.LittleEndian,
+		MaxFragmentedLen:  server.MaxFragmentedLen,
+		LenIncludesHeader: server.LenIncludesHeader,
+		listen: func(addr string) (net.Listener, error) {
+			return kcp.Listen(addr, config)
+		},
+	}
+	server.server.Start()
+}
+
+// Drain stops accepting, waits up to timeout for the sessions to be closed
+// by their agents and then closes the remaining ones
+func (server *KCPServer) Drain(timeout time.Duration) {
+	server.server.Drain(timeout)
+}
+
+func (server *KCPServer) Close() {
+	server.server.Close()
+}
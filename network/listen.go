@@ -0,0 +1,251 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+const envListenAddrs = "LEAF_LISTEN_ADDRS"
+
+var (
+	mutexListeners sync.Mutex
+	inheritOnce    sync.Once
+	inherited      []inheritedListener
+	listeners      = make(map[string]*listener)
+	upgraded       atomic.Bool
+)
+
+type listener struct {
+	net.Listener
+	addr string
+	file interface {
+		File() (*os.File, error)
+	}
+}
+
+func (ln *listener) Close() error {
+	// the socket file belongs to the new process after Upgrade
+	if ul, ok := ln.Listener.(*net.UnixListener); ok && upgraded.Load() {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	mutexListeners.Lock()
+	if listeners[ln.addr] == ln {
+		delete(listeners, ln.addr)
+	}
+	mutexListeners.Unlock()
+	return ln.Listener.Close()
+}
+
+type inheritedListener struct {
+	name string
+	ln   net.Listener
+}
+
+func inherit() {
+	var names []string
+	if addrs := os.Getenv(envListenAddrs); addrs != "" {
+		names = strings.Split(addrs, ",")
+	} else {
+		names = systemdNames()
+	}
+	os.Unsetenv(envListenAddrs)
+
+	// the listening sockets follow stdin, stdout and stderr
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Error("inherited listener %v: %v", name, err)
+			continue
+		}
+		inherited = append(inherited, inheritedListener{name, ln})
+	}
+}
+
+// systemdNames implements the socket activation protocol, see sd_listen_fds(3).
+// Name sockets with FileDescriptorName= in the socket unit to match them
+// against Listen addresses verbatim, unnamed ones are matched by address
+func systemdNames() []string {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	names := make([]string, n)
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		copy(names, strings.Split(s, ":"))
+	}
+	return names
+}
+
+// takeInherited removes and returns the inherited listener for addr
+func takeInherited(addr string) net.Listener {
+	for i, il := range inherited {
+		if il.name == addr {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			return il.ln
+		}
+	}
+
+	if network, path := splitAddr(addr); network == "unix" {
+		for i, il := range inherited {
+			got, ok := il.ln.Addr().(*net.UnixAddr)
+			if ok && got.Name == path {
+				inherited = append(inherited[:i], inherited[i+1:]...)
+				return il.ln
+			}
+		}
+		return nil
+	}
+
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil
+	}
+	for i, il := range inherited {
+		got, ok := il.ln.Addr().(*net.TCPAddr)
+		if !ok || got.Port != want.Port {
+			continue
+		}
+		if want.IP == nil || want.IP.IsUnspecified() {
+			if !got.IP.IsUnspecified() {
+				continue
+			}
+		} else if !want.IP.Equal(got.IP) {
+			continue
+		}
+		inherited = append(inherited[:i], inherited[i+1:]...)
+		return il.ln
+	}
+	return nil
+}
+
+// splitAddr maps unix:///path/to.sock to the unix network and a path, other
+// addresses are tcp
+func splitAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// listenUnix removes the socket file left by a process that didn't exit
+// cleanly, a socket still accepting connections is kept
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+		} else {
+			os.Remove(path)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// Listen announces on the TCP address addr, or the Unix domain socket
+// unix:///path/to.sock. A listener handed off by the parent process through
+// Upgrade or passed by systemd socket activation is reused instead of binding
+// a new one
+// goroutine safe
+func Listen(addr string) (net.Listener, error) {
+	mutexListeners.Lock()
+	defer mutexListeners.Unlock()
+
+	inheritOnce.Do(inherit)
+
+	ln := takeInherited(addr)
+	if ln == nil {
+		var err error
+		if network, path := splitAddr(addr); network == "unix" {
+			ln, err = listenUnix(path)
+		} else {
+			ln, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l := &listener{Listener: ln, addr: addr}
+	if f, ok := ln.(interface {
+		File() (*os.File, error)
+	}); ok {
+		l.file = f
+	}
+	listeners[addr] = l
+	return l, nil
+}
+
+// Upgrade starts a new instance of the running executable with the same
+// arguments, the listeners created by Listen are passed to it. The caller
+// should then stop accepting and drain its connections, see Upgraded
+func Upgrade() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	mutexListeners.Lock()
+	var addrs []string
+	var files []*os.File
+	for addr, ln := range listeners {
+		if ln.file == nil {
+			continue
+		}
+		f, err := ln.file.File()
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+		files = append(files, f)
+	}
+	mutexListeners.Unlock()
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if len(files) == 0 {
+		return nil, errors.New("no listener to hand off")
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), envListenAddrs+"="+strings.Join(addrs, ","))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	upgraded.Store(true)
+	return cmd.Process, nil
+}
+
+// Upgraded reports whether Upgrade has handed the listeners off to a new
+// process
+func Upgraded() bool {
+	return upgraded.Load()
+}
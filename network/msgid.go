@@ -0,0 +1,74 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// IDWidth is how processors encode the message id before the message
+type IDWidth int
+
+const (
+	// 2 bytes, the default
+	IDWidth16 IDWidth = iota
+	// 4 bytes
+	IDWidth32
+	// uvarint, 1 byte up to 127
+	IDWidthVarint
+)
+
+// Max is the largest id of the width
+func (w IDWidth) Max() uint32 {
+	if w == IDWidth16 {
+		return math.MaxUint16
+	}
+	return math.MaxUint32
+}
+
+// Append appends the encoded id to b, littleEndian is ignored by varints.
+// id must not exceed Max, IDWidth16 keeps its low 16 bits
+func (w IDWidth) Append(b []byte, littleEndian bool, id uint32) []byte {
+	switch w {
+	case IDWidth32:
+		if littleEndian {
+			return binary.LittleEndian.AppendUint32(b, id)
+		}
+		return binary.BigEndian.AppendUint32(b, id)
+	case IDWidthVarint:
+		return binary.AppendUvarint(b, uint64(id))
+	default:
+		if littleEndian {
+			return binary.LittleEndian.AppendUint16(b, uint16(id))
+		}
+		return binary.BigEndian.AppendUint16(b, uint16(id))
+	}
+}
+
+// Read decodes the id at the start of data and returns its length
+func (w IDWidth) Read(data []byte, littleEndian bool) (uint32, int, error) {
+	switch w {
+	case IDWidth32:
+		if len(data) < 4 {
+			return 0, 0, errors.New("message id too short")
+		}
+		if littleEndian {
+			return binary.LittleEndian.Uint32(data), 4, nil
+		}
+		return binary.BigEndian.Uint32(data), 4, nil
+	case IDWidthVarint:
+		id, n := binary.Uvarint(data)
+		if n <= 0 || id > math.MaxUint32 {
+			return 0, 0, errors.New("invalid message id")
+		}
+		return uint32(id), n, nil
+	default:
+		if len(data) < 2 {
+			return 0, 0, errors.New("message id too short")
+		}
+		if littleEndian {
+			return uint32(binary.LittleEndian.Uint16(data)), 2, nil
+		}
+		return uint32(binary.BigEndian.Uint16(data)), 2, nil
+	}
+}
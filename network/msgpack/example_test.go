@@ -0,0 +1,38 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/czx-lab/leaf/network/msgpack"
+)
+
+type Hello struct {
+	Name string `msgpack:"name"`
+}
+
+func Example() {
+	p := msgpack.NewProcessor()
+	p.Register(&Hello{})
+	p.SetHandler(&Hello{}, func(args []interface{}) {
+		fmt.Println("hello", args[0].(*Hello).Name)
+	})
+
+	data, err := p.Marshal(&Hello{Name: "leaf"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%q\n", bytes.Join(data, nil))
+
+	msg, err := p.Unmarshal(bytes.Join(data, nil))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p.Route(msg, nil)
+
+	// Output:
+	// "\x00\x00\x81\xa4name\xa4leaf"
+	// hello leaf
+}
@@ -0,0 +1,217 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/util"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ------------------------
+// | id | msgpack message |
+// ------------------------
+type Processor struct {
+	littleEndian bool
+	msgInfo      []*MsgInfo
+	msgID        map[reflect.Type]uint16
+}
+
+type MsgInfo struct {
+	msgType       reflect.Type
+	msgRouter     *chanrpc.Server
+	msgHandler    MsgHandler
+	msgRawHandler MsgHandler
+	frameType     network.FrameType
+}
+
+type MsgHandler func([]interface{})
+
+type MsgRaw struct {
+	msgID      uint16
+	msgRawData []byte
+}
+
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.littleEndian = false
+	p.msgID = make(map[reflect.Type]uint16)
+	return p
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetByteOrder(littleEndian bool) {
+	p.littleEndian = littleEndian
+}
+
+// msg is a pointer to a struct, fields are encoded as a map keyed by the
+// field names or the msgpack tags
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Register(msg interface{}) uint16 {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr || msgType.Elem().Kind() != reflect.Struct {
+		log.Fatal("msgpack message pointer required")
+	}
+	if _, ok := p.msgID[msgType]; ok {
+		log.Fatal("message %s is already registered", msgType)
+	}
+	if len(p.msgInfo) >= math.MaxUint16 {
+		log.Fatal("too many msgpack messages (max = %v)", math.MaxUint16)
+	}
+
+	i := new(MsgInfo)
+	i.msgType = msgType
+	p.msgInfo = append(p.msgInfo, i)
+	id := uint16(len(p.msgInfo) - 1)
+	p.msgID[msgType] = id
+	return id
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRouter(msg interface{}, msgRouter *chanrpc.Server) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].msgRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetHandler(msg interface{}, msgHandler MsgHandler) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].msgHandler = msgHandler
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
+	if id >= uint16(len(p.msgInfo)) {
+		log.Fatal("message id %v not registered", id)
+	}
+
+	p.msgInfo[id].msgRawHandler = msgRawHandler
+}
+
+// SetFrameType selects the websocket frame type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetFrameType(msg interface{}, t network.FrameType) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].frameType = t
+}
+
+// goroutine safe
+func (p *Processor) FrameType(msg interface{}) network.FrameType {
+	if id, ok := p.msgID[reflect.TypeOf(msg)]; ok {
+		return p.msgInfo[id].frameType
+	}
+	return network.FrameDefault
+}
+
+// goroutine safe
+func (p *Processor) Route(msg interface{}, userData interface{}) error {
+	// raw
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		if msgRaw.msgID >= uint16(len(p.msgInfo)) {
+			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
+		}
+		i := p.msgInfo[msgRaw.msgID]
+		if i.msgRawHandler != nil {
+			begin := time.Now()
+			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			util.CheckSlow(i.msgType, begin)
+		}
+		return nil
+	}
+
+	// msgpack
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		return fmt.Errorf("message %s not registered", msgType)
+	}
+	i := p.msgInfo[id]
+	if i.msgHandler != nil {
+		begin := time.Now()
+		i.msgHandler([]interface{}{msg, userData})
+		util.CheckSlow(msgType, begin)
+	}
+	if i.msgRouter != nil {
+		i.msgRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
+// goroutine safe
+func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
+	if len(data) < 2 {
+		return nil, errors.New("msgpack data too short")
+	}
+
+	// id
+	var id uint16
+	if p.littleEndian {
+		id = binary.LittleEndian.Uint16(data)
+	} else {
+		id = binary.BigEndian.Uint16(data)
+	}
+	if id >= uint16(len(p.msgInfo)) {
+		return nil, fmt.Errorf("message id %v not registered", id)
+	}
+
+	// msg
+	i := p.msgInfo[id]
+	if i.msgRawHandler != nil {
+		return MsgRaw{id, data[2:]}, nil
+	} else {
+		msg := reflect.New(i.msgType.Elem()).Interface()
+		return msg, msgpack.Unmarshal(data[2:], msg)
+	}
+}
+
+// goroutine safe
+func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	msgType := reflect.TypeOf(msg)
+
+	// id
+	_id, ok := p.msgID[msgType]
+	if !ok {
+		err := fmt.Errorf("message %s not registered", msgType)
+		return nil, err
+	}
+
+	id := make([]byte, 2)
+	if p.littleEndian {
+		binary.LittleEndian.PutUint16(id, _id)
+	} else {
+		binary.BigEndian.PutUint16(id, _id)
+	}
+
+	// data
+	data, err := msgpack.Marshal(msg)
+	return [][]byte{id, data}, err
+}
+
+// goroutine safe
+func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
+	for id, i := range p.msgInfo {
+		f(uint16(id), i.msgType)
+	}
+}
@@ -0,0 +1,264 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// MuxServer serves TCP and WS on the same port, a connection goes to WS if it
+// starts with an HTTP GET and to TCP otherwise. A tls connection goes to the
+// server with a certificate, so only one of them may use tls. PROXY protocol
+// headers are skipped when sniffing
+type MuxServer struct {
+	Addr string
+	// how long a connection has to send its first bytes, 10s if zero
+	SniffTimeout time.Duration
+	TCP          *TCPServer
+	WS           *WSServer
+}
+
+func (server *MuxServer) Start() {
+	if server.SniffTimeout <= 0 {
+		server.SniffTimeout = 10 * time.Second
+		log.Release("invalid SniffTimeout, reset to %v", server.SniffTimeout)
+	}
+	if server.TCP == nil || server.WS == nil {
+		log.Fatal("TCP and WS must not be nil")
+	}
+
+	ln, err := Listen(server.Addr)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	m := &mux{ln: ln, timeout: server.SniffTimeout, die: make(chan struct{})}
+	m.tcp = m.newListener()
+	m.ws = m.newListener()
+	m.tlsToWS = server.WS.TLS != nil || server.WS.CertFile != "" || server.WS.KeyFile != ""
+
+	server.TCP.listen = func(string) (net.Listener, error) {
+		return m.tcp, nil
+	}
+	server.WS.listen = func(string) (net.Listener, error) {
+		return m.ws, nil
+	}
+	server.TCP.Start()
+	server.WS.Start()
+	go m.run()
+}
+
+// Drain stops accepting, waits up to timeout for the connections to be
+// closed by their agents and then closes the remaining ones
+func (server *MuxServer) Drain(timeout time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		server.TCP.Drain(timeout)
+		wg.Done()
+	}()
+	go func() {
+		server.WS.Drain(timeout)
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+func (server *MuxServer) Close() {
+	server.TCP.Close()
+	server.WS.Close()
+}
+
+type mux struct {
+	ln      net.Listener
+	timeout time.Duration
+	tcp     *muxListener
+	ws      *muxListener
+	tlsToWS bool
+	// the listener is closed with the last muxListener
+	mutex sync.Mutex
+	ref   int
+	die   chan struct{}
+}
+
+func (m *mux) newListener() *muxListener {
+	m.ref++
+	return &muxListener{mux: m, chConn: make(chan net.Conn), die: make(chan struct{})}
+}
+
+func (m *mux) release() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ref--
+	if m.ref == 0 {
+		m.ln.Close()
+	}
+}
+
+func (m *mux) run() {
+	defer close(m.die)
+
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Release("accept error: %v; retrying", err)
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go m.dispatch(conn)
+	}
+}
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Prefix = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+func (m *mux) dispatch(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(m.timeout))
+	http, tls, err := sniff(r)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		// a short first frame of a TCP client
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() || r.Buffered() == 0 {
+			log.Debug("sniff %v error: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+	}
+
+	l := m.tcp
+	if http || tls && m.tlsToWS {
+		l = m.ws
+	}
+	select {
+	case l.chConn <- &muxConn{Conn: conn, r: r}:
+	case <-l.die:
+		conn.Close()
+	}
+}
+
+// sniff skips a PROXY protocol header and tells whether the connection
+// starts with an HTTP GET or a tls handshake. The first byte decides, more
+// bytes are waited for only while they may be one of these
+func sniff(r *bufio.Reader) (http bool, tls bool, err error) {
+	n, err := skipProxyHeader(r)
+	if err != nil {
+		return false, false, err
+	}
+
+	b, err := r.Peek(n + 1)
+	if err != nil {
+		return false, false, err
+	}
+	switch b[n] {
+	case 0x16:
+		return false, true, nil
+	case 'G':
+		http, err = hasPrefix(r, n, []byte("GET "))
+		return http, false, err
+	}
+	return false, false, nil
+}
+
+// skipProxyHeader returns the length of the PROXY protocol header, zero if
+// there is none
+func skipProxyHeader(r *bufio.Reader) (int, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		ok, err := hasPrefix(r, 0, proxyV1Prefix)
+		if !ok {
+			return 0, err
+		}
+		// at most 107 bytes, see the PROXY protocol spec
+		for i := len(proxyV1Prefix); i < 107; i++ {
+			if b, err = r.Peek(i + 1); err != nil {
+				return 0, err
+			}
+			if bytes.HasSuffix(b, []byte("\r\n")) {
+				return i + 1, nil
+			}
+		}
+	case proxyV2Prefix[0]:
+		ok, err := hasPrefix(r, 0, proxyV2Prefix)
+		if !ok {
+			return 0, err
+		}
+		if b, err = r.Peek(16); err != nil {
+			return 0, err
+		}
+		n := 16 + int(binary.BigEndian.Uint16(b[14:]))
+		if n+4 <= r.Size() {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// hasPrefix peeks the bytes from offset n while they match prefix
+func hasPrefix(r *bufio.Reader, n int, prefix []byte) (bool, error) {
+	for i := range prefix {
+		b, err := r.Peek(n + i + 1)
+		if err != nil {
+			return false, err
+		}
+		if b[n+i] != prefix[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type muxListener struct {
+	mux       *mux
+	chConn    chan net.Conn
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.chConn:
+		return conn, nil
+	case <-l.die:
+		return nil, net.ErrClosed
+	case <-l.mux.die:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.die)
+		l.mux.release()
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.mux.ln.Addr()
+}
+
+// muxConn replays the sniffed bytes
+type muxConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *muxConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMuxSniff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mux{ln: ln, timeout: 5 * time.Second, tlsToWS: true, die: make(chan struct{})}
+	m.tcp = m.newListener()
+	m.ws = m.newListener()
+	go m.run()
+	defer m.tcp.Close()
+	defer m.ws.Close()
+
+	tests := []struct {
+		name  string
+		first string
+		ws    bool
+	}{
+		// a new session Resume request with LenMsgLen 2
+		{"short frame", "\x00\x01\x00", false},
+		{"one byte frame", "\x00", false},
+		{"http", "GET / HTTP/1.1\r\n\r\n", true},
+		{"tls", "\x16\x03\x01", true},
+		{"proxy short frame", "PROXY TCP4 1.2.3.4 5.6.7.8 1000 2000\r\n\x00\x01\x00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, err := c.Write([]byte(tt.first)); err != nil {
+				t.Fatal(err)
+			}
+
+			want, other := m.tcp, m.ws
+			if tt.ws {
+				want, other = m.ws, m.tcp
+			}
+			var conn net.Conn
+			select {
+			case conn = <-want.chConn:
+			case <-other.chConn:
+				t.Fatal("dispatched to the wrong server")
+			case <-time.After(time.Second):
+				t.Fatal("not dispatched")
+			}
+			defer conn.Close()
+
+			b := make([]byte, len(tt.first))
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.first {
+				t.Fatalf("replayed %q, want %q", b, tt.first)
+			}
+		})
+	}
+}
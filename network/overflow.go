@@ -0,0 +1,125 @@
+package network
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// OverflowPolicy is what a connection does with a message written when the
+// PendingWriteNum queue of its priority is full
+type OverflowPolicy int
+
+const (
+	// close the connection, the default
+	OverflowClose OverflowPolicy = iota
+	// drop the oldest queued message of the priority
+	OverflowDropOldest
+	// drop the message written
+	OverflowDropNewest
+	// wait up to Timeout for room, then close the connection
+	OverflowBlock
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowClose:
+		return "close"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowBlock:
+		return "block"
+	}
+	return "unknown"
+}
+
+// Overflow sheds the traffic of a slow connection, e.g. broadcasts written
+// at PriorityBulk, instead of closing it. PriorityControl messages are never
+// dropped, a drop policy closes the connection when their queue is full
+type Overflow struct {
+	Policy OverflowPolicy
+	// OverflowBlock only
+	Timeout time.Duration
+	// called in the goroutine writing when a queue is full, after the policy
+	// is applied
+	OnOverflow func(stats QueueStats)
+}
+
+// QueueStats describes the queue of a connection when it overflowed
+type QueueStats struct {
+	Priority Priority
+	Len      int
+	Cap      int
+	// messages dropped by the connection so far
+	Dropped uint64
+	// the connection is closed
+	Closed bool
+}
+
+type overflowState struct {
+	overflow *Overflow
+	dropped  uint64
+}
+
+// overflowEvent is the callback to run once the connection is unlocked
+type overflowEvent struct {
+	onOverflow func(stats QueueStats)
+	stats      QueueStats
+}
+
+func (e *overflowEvent) notify() {
+	if e != nil && e.onOverflow != nil {
+		e.onOverflow(e.stats)
+	}
+}
+
+// push queues m for the priority p and applies the policy if the queue is
+// full, last is the message closing the connection. destroy is true if the
+// connection must be destroyed, release is called with a message dropped
+func push[T any](q *writeQueue[T], s *overflowState, p Priority, m T, last bool, release func(T)) (e *overflowEvent, destroy bool) {
+	if !q.full(p) {
+		q.push(p, m)
+		return nil, false
+	}
+
+	var o Overflow
+	if s.overflow != nil {
+		o = *s.overflow
+	}
+	policy := o.Policy
+	if (last || p == PriorityControl) && policy != OverflowBlock {
+		policy = OverflowClose
+	}
+
+	closed := false
+	switch policy {
+	case OverflowDropOldest:
+		if old, ok := q.drop(p); ok {
+			s.dropped++
+			release(old)
+		}
+		q.push(p, m)
+	case OverflowDropNewest:
+		s.dropped++
+		release(m)
+	case OverflowBlock:
+		closed = !q.pushTimeout(p, m, o.Timeout)
+	default:
+		closed = true
+	}
+	if closed {
+		log.Debug("close conn: channel full")
+		release(m)
+	}
+
+	e = &overflowEvent{o.OnOverflow, QueueStats{
+		Priority: p,
+		Len:      len(q[p]),
+		Cap:      cap(q[p]),
+		Dropped:  s.dropped,
+		Closed:   closed,
+	}}
+	return e, closed
+}
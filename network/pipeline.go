@@ -0,0 +1,158 @@
+package network
+
+import "bytes"
+
+// Stage transforms whole frames between the connection and the processor of
+// a Pipeline, e.g. compression or encryption
+type Stage interface {
+	// must goroutine safe
+	Encode(data []byte) ([]byte, error)
+	// must goroutine safe
+	Decode(data []byte) ([]byte, error)
+}
+
+// StatefulStage is implemented by stages keeping per connection state, such
+// as a session key. userData identifies the connection
+type StatefulStage interface {
+	Stage
+	// must goroutine safe
+	EncodeTo(userData interface{}, data []byte) ([]byte, error)
+	// must goroutine safe
+	DecodeFrom(userData interface{}, data []byte) ([]byte, error)
+	// must goroutine safe
+	Release(userData interface{})
+}
+
+// Pipeline chains stages in front of a processor. Stages are listed from the
+// wire side: received frames are decoded by the first stage to the last one
+// and then unmarshaled, marshaled messages are encoded the other way round
+//
+//	network.NewPipeline(processor, crypt, compress)
+//
+// Pipeline is a StatefulProcessor, so the gate keeps the order of encoding
+// and writing per connection
+type Pipeline struct {
+	Processor Processor
+	Stages    []Stage
+}
+
+func NewPipeline(processor Processor, stages ...Stage) *Pipeline {
+	return &Pipeline{Processor: processor, Stages: stages}
+}
+
+// goroutine safe
+func (p *Pipeline) Route(msg interface{}, userData interface{}) error {
+	return p.Processor.Route(msg, userData)
+}
+
+// goroutine safe
+func (p *Pipeline) Unmarshal(data []byte) (interface{}, error) {
+	return p.UnmarshalFrom(nil, data)
+}
+
+// goroutine safe
+func (p *Pipeline) Marshal(msg interface{}) ([][]byte, error) {
+	return p.MarshalTo(nil, msg)
+}
+
+// goroutine safe
+func (p *Pipeline) UnmarshalFrom(userData interface{}, data []byte) (interface{}, error) {
+	data, err := p.Decode(userData, data)
+	if err != nil {
+		return nil, err
+	}
+	if sp, ok := p.Processor.(StatefulProcessor); ok && userData != nil {
+		return sp.UnmarshalFrom(userData, data)
+	}
+	return p.Processor.Unmarshal(data)
+}
+
+// goroutine safe
+func (p *Pipeline) MarshalTo(userData interface{}, msg interface{}) ([][]byte, error) {
+	var data [][]byte
+	var err error
+	if sp, ok := p.Processor.(StatefulProcessor); ok && userData != nil {
+		data, err = sp.MarshalTo(userData, msg)
+	} else {
+		data, err = p.Processor.Marshal(msg)
+	}
+	if err != nil || len(p.Stages) == 0 {
+		return data, err
+	}
+
+	frame, err := p.Encode(userData, bytes.Join(data, nil))
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{frame}, nil
+}
+
+// Shared reports whether msg is encoded the same for every connection
+// goroutine safe
+func (p *Pipeline) Shared(msg interface{}) bool {
+	for _, s := range p.Stages {
+		if _, ok := s.(StatefulStage); ok {
+			return false
+		}
+	}
+	return Shared(p.Processor, msg)
+}
+
+// Decode runs the stages on a received frame
+// goroutine safe
+func (p *Pipeline) Decode(userData interface{}, data []byte) ([]byte, error) {
+	var err error
+	for _, s := range p.Stages {
+		if ss, ok := s.(StatefulStage); ok && userData != nil {
+			data, err = ss.DecodeFrom(userData, data)
+		} else {
+			data, err = s.Decode(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Encode runs the stages on a frame to send
+// goroutine safe
+func (p *Pipeline) Encode(userData interface{}, data []byte) ([]byte, error) {
+	var err error
+	for i := len(p.Stages) - 1; i >= 0; i-- {
+		s := p.Stages[i]
+		if ss, ok := s.(StatefulStage); ok && userData != nil {
+			data, err = ss.EncodeTo(userData, data)
+		} else {
+			data, err = s.Encode(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// goroutine safe
+func (p *Pipeline) Release(userData interface{}) {
+	for _, s := range p.Stages {
+		if ss, ok := s.(StatefulStage); ok {
+			ss.Release(userData)
+		}
+	}
+	if sp, ok := p.Processor.(StatefulProcessor); ok {
+		sp.Release(userData)
+	}
+}
+
+// encoded frames are binary, otherwise the processor selects the frame type
+// goroutine safe
+func (p *Pipeline) FrameType(msg interface{}) FrameType {
+	if len(p.Stages) > 0 {
+		return FrameBinary
+	}
+	if ft, ok := p.Processor.(FrameTyper); ok {
+		return ft.FrameType(msg)
+	}
+	return FrameDefault
+}
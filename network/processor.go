@@ -8,3 +8,52 @@ type Processor interface {
 	// must goroutine safe
 	Marshal(msg interface{}) ([][]byte, error)
 }
+
+// StatefulProcessor is implemented by processors keeping per connection
+// state, such as delta compression. userData identifies the connection
+type StatefulProcessor interface {
+	Processor
+	// must goroutine safe
+	MarshalTo(userData interface{}, msg interface{}) ([][]byte, error)
+	// must goroutine safe
+	UnmarshalFrom(userData interface{}, data []byte) (interface{}, error)
+	// must goroutine safe
+	Release(userData interface{})
+}
+
+// BufferUnmarshaler is implemented by processors taking the messages read
+// into pooled buffers, saving a copy per message. userData identifies the
+// connection as for StatefulProcessor
+type BufferUnmarshaler interface {
+	// ZeroCopy reports whether the messages are to be read into Buffers
+	// must goroutine safe
+	ZeroCopy() bool
+	// buf is owned by the processor afterwards, it releases buf or hands
+	// it over to the handler of the message
+	// must goroutine safe
+	UnmarshalBuffer(userData interface{}, buf *Buffer) (interface{}, error)
+}
+
+// FrameTyper is implemented by processors selecting the websocket frame type
+// per message
+type FrameTyper interface {
+	// must goroutine safe
+	FrameType(msg interface{}) FrameType
+}
+
+// SharedMarshaler is implemented by stateful processors marshaling some
+// messages the same for every connection, so they are marshaled once when
+// they are sent to many connections
+type SharedMarshaler interface {
+	// must goroutine safe
+	Shared(msg interface{}) bool
+}
+
+// Shared reports whether msg is marshaled the same by p for every connection
+func Shared(p Processor, msg interface{}) bool {
+	if sm, ok := p.(SharedMarshaler); ok {
+		return sm.Shared(msg)
+	}
+	_, stateful := p.(StatefulProcessor)
+	return !stateful
+}
@@ -0,0 +1,57 @@
+package protobuf
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// RegisterAlias allocates another ID unmarshaling as msg, so old clients
+// keep sending the ID they know. msg is always marshaled with the ID returned
+// by Register and handlers only see that ID
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterAlias(msg proto.Message) uint16 {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+	if len(p.msgInfo) >= math.MaxUint16 {
+		log.Fatal("too many protobuf messages (max = %v)", math.MaxUint16)
+	}
+
+	p.msgInfo = append(p.msgInfo, p.msgInfo[id])
+	return uint16(len(p.msgInfo) - 1)
+}
+
+// Deprecate counts and warns about the messages received with id and rejects
+// them after removeAt, a zero removeAt never rejects them
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Deprecate(id uint16, removeAt time.Time) {
+	if id >= uint16(len(p.msgInfo)) {
+		log.Fatal("message id %v not registered", id)
+	}
+
+	p.deprecated[id] = &network.Deprecation{
+		ID:       uint32(id),
+		Type:     p.msgInfo[id].msgType,
+		RemoveAt: removeAt,
+	}
+}
+
+// goroutine safe
+func (p *Processor) Deprecations() []*network.Deprecation {
+	ds := make([]*network.Deprecation, 0, len(p.deprecated))
+	for _, d := range p.deprecated {
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].ID < ds[j].ID
+	})
+	return ds
+}
@@ -0,0 +1,233 @@
+package protobuf
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// ConcurrentProcessor is a Processor whose registration methods may be
+// called while routing or marshaling, e.g. by a module loaded after the gate
+// started. A change copies the message tables and swaps them in, so routing
+// and marshaling don't take a lock. IDs are still assigned in the order of
+// Register, already assigned IDs never change
+type ConcurrentProcessor struct {
+	mutex sync.Mutex
+	p     atomic.Pointer[Processor]
+}
+
+func NewConcurrentProcessor() *ConcurrentProcessor {
+	c := new(ConcurrentProcessor)
+	c.p.Store(NewProcessor())
+	return c
+}
+
+// Load returns the current tables, the Processor returned must not be
+// changed
+// goroutine safe
+func (c *ConcurrentProcessor) Load() *Processor {
+	return c.p.Load()
+}
+
+// Update calls f with a copy of the current tables and swaps it in when f
+// returns. Updates are serialized, f must not call the methods of c
+// goroutine safe
+func (c *ConcurrentProcessor) Update(f func(p *Processor)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p := c.p.Load().clone()
+	f(p)
+	c.p.Store(p)
+}
+
+// clone copies what the methods of Processor change, the delta states and
+// deprecation counters are shared
+func (p *Processor) clone() *Processor {
+	n := *p
+	n.msgID = maps.Clone(p.msgID)
+	n.deprecated = maps.Clone(p.deprecated)
+	n.middlewares = slices.Clip(p.middlewares)
+
+	// aliases share their MsgInfo
+	infos := make(map[*MsgInfo]*MsgInfo, len(p.msgInfo))
+	n.msgInfo = make([]*MsgInfo, len(p.msgInfo))
+	for id, i := range p.msgInfo {
+		if infos[i] == nil {
+			ni := *i
+			ni.msgRouters = slices.Clip(i.msgRouters)
+			ni.msgHandlers = slices.Clip(i.msgHandlers)
+			infos[i] = &ni
+		}
+		n.msgInfo[id] = infos[i]
+	}
+
+	// the chain of p dispatches with the tables of p
+	if n.route != nil {
+		n.chain()
+	}
+	return &n
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetByteOrder(littleEndian bool) {
+	c.Update(func(p *Processor) { p.SetByteOrder(littleEndian) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetIDWidth(w network.IDWidth) {
+	c.Update(func(p *Processor) { p.SetIDWidth(w) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Register(msg proto.Message) (id uint16) {
+	c.Update(func(p *Processor) { id = p.Register(msg) })
+	return
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) RegisterAlias(msg proto.Message) (id uint16) {
+	c.Update(func(p *Processor) { id = p.RegisterAlias(msg) })
+	return
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Deprecate(id uint16, removeAt time.Time) {
+	c.Update(func(p *Processor) { p.Deprecate(id, removeAt) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	c.Update(func(p *Processor) { p.SetRouter(msg, msgRouter) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) AddRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	c.Update(func(p *Processor) { p.AddRouter(msg, msgRouter) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
+	c.Update(func(p *Processor) { p.SetHandler(msg, msgHandler) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) AddHandler(msg proto.Message, msgHandler MsgHandler) {
+	c.Update(func(p *Processor) { p.AddHandler(msg, msgHandler) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
+	c.Update(func(p *Processor) { p.SetRawHandler(id, msgRawHandler) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetZeroCopy(zeroCopy bool) {
+	c.Update(func(p *Processor) { p.SetZeroCopy(zeroCopy) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetFrameType(msg proto.Message, t network.FrameType) {
+	c.Update(func(p *Processor) { p.SetFrameType(msg, t) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetMaxLen(msg proto.Message, maxLen uint32) {
+	c.Update(func(p *Processor) { p.SetMaxLen(msg, maxLen) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) SetDelta(msg proto.Message, keyframeInterval int) {
+	c.Update(func(p *Processor) { p.SetDelta(msg, keyframeInterval) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Use(mw ...Middleware) {
+	c.Update(func(p *Processor) { p.Use(mw...) })
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Route(msg interface{}, userData interface{}) error {
+	return c.p.Load().Route(msg, userData)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Unmarshal(data []byte) (interface{}, error) {
+	return c.p.Load().Unmarshal(data)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) UnmarshalBuffer(userData interface{}, buf *network.Buffer) (interface{}, error) {
+	return c.p.Load().UnmarshalBuffer(userData, buf)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Marshal(msg interface{}) ([][]byte, error) {
+	return c.p.Load().Marshal(msg)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) MarshalTo(userData interface{}, msg interface{}) ([][]byte, error) {
+	return c.p.Load().MarshalTo(userData, msg)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) UnmarshalFrom(userData interface{}, data []byte) (interface{}, error) {
+	return c.p.Load().UnmarshalFrom(userData, data)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Release(userData interface{}) {
+	c.p.Load().Release(userData)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) ZeroCopy() bool {
+	return c.p.Load().ZeroCopy()
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) FrameType(msg interface{}) network.FrameType {
+	return c.p.Load().FrameType(msg)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Shared(msg interface{}) bool {
+	return c.p.Load().Shared(msg)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) ID(msg interface{}) (uint16, bool) {
+	return c.p.Load().ID(msg)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Export() *Manifest {
+	return c.p.Load().Export()
+}
+
+// RegisterExportCommand adds a console command writing the current message
+// table to a JSON file
+// you must call the function before calling console.Init
+func (c *ConcurrentProcessor) RegisterExportCommand(name string) {
+	registerExportCommand(name, c.Export)
+}
+
+// goroutine safe
+func (c *ConcurrentProcessor) Range(f func(id uint16, t reflect.Type)) {
+	c.p.Load().Range(f)
+}
+
+var (
+	_ network.StatefulProcessor = (*ConcurrentProcessor)(nil)
+	_ network.FrameTyper        = (*ConcurrentProcessor)(nil)
+	_ network.SharedMarshaler   = (*ConcurrentProcessor)(nil)
+	_ network.BufferUnmarshaler = (*ConcurrentProcessor)(nil)
+)
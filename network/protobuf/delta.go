@@ -0,0 +1,235 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/czx-lab/leaf/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// messages marked by SetDelta carry a flag after the id
+//
+// -------------------------------------------------------
+// | id | keyframe | protobuf message                   |
+// | id | delta    | n | n cleared fields | protobuf delta |
+// -------------------------------------------------------
+//
+// n and the cleared field numbers are uvarints
+const (
+	deltaKeyframe = 0
+	deltaDelta    = 1
+)
+
+type deltaInfo struct {
+	keyframeInterval int
+}
+
+// deltaState is the delta compression state of one connection
+type deltaState struct {
+	sync.Mutex
+	sent map[uint16]*deltaSent
+	recv map[uint16]proto.Message
+}
+
+type deltaSent struct {
+	last  proto.Message
+	count int
+}
+
+// SetDelta enables delta compression of msg. MarshalTo and UnmarshalFrom
+// keep the last instance per connection and send only the changed top-level
+// fields, every keyframeInterval deltas (zero means never after the first
+// one) the whole message is sent again. Marshal and Unmarshal, having no
+// connection, always use keyframes
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetDelta(msg proto.Message, keyframeInterval int) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].delta = &deltaInfo{keyframeInterval}
+}
+
+func (p *Processor) deltaState(userData interface{}) *deltaState {
+	if v, ok := p.deltas.Load(userData); ok {
+		return v.(*deltaState)
+	}
+	v, _ := p.deltas.LoadOrStore(userData, &deltaState{
+		sent: make(map[uint16]*deltaSent),
+		recv: make(map[uint16]proto.Message),
+	})
+	return v.(*deltaState)
+}
+
+// MarshalTo marshals msg for the connection identified by userData.
+// Messages of a connection must be written in the order they are marshaled
+// goroutine safe
+func (p *Processor) MarshalTo(userData interface{}, msg interface{}) ([][]byte, error) {
+	return p.marshal(p.deltaState(userData), msg)
+}
+
+// Shared reports whether msg is marshaled without delta
+// goroutine safe
+func (p *Processor) Shared(msg interface{}) bool {
+	if _, ok := msg.(MsgRaw); ok {
+		return true
+	}
+	id, ok := p.msgID[reflect.TypeOf(msg)]
+	return ok && p.msgInfo[id].delta == nil
+}
+
+// UnmarshalFrom unmarshals data received from the connection identified by
+// userData, deltas are returned applied
+// goroutine safe
+func (p *Processor) UnmarshalFrom(userData interface{}, data []byte) (interface{}, error) {
+	return p.unmarshal(p.deltaState(userData), data)
+}
+
+// Release drops the state of a closed connection
+// goroutine safe
+func (p *Processor) Release(userData interface{}) {
+	p.deltas.Delete(userData)
+}
+
+func (p *Processor) marshalDelta(st *deltaState, id uint16, i *MsgInfo, head []byte, msg proto.Message) ([][]byte, error) {
+	if st == nil {
+		data, err := proto.Marshal(msg)
+		return [][]byte{head, {deltaKeyframe}, data}, err
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	s := st.sent[id]
+	if s == nil || i.delta.keyframeInterval > 0 && s.count >= i.delta.keyframeInterval {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		st.sent[id] = &deltaSent{last: proto.Clone(msg)}
+		return [][]byte{head, {deltaKeyframe}, data}, nil
+	}
+
+	delta, cleared, _ := Diff(s.last, msg)
+	data, err := proto.Marshal(delta)
+	if err != nil {
+		return nil, err
+	}
+	s.last = proto.Clone(msg)
+	s.count++
+
+	b := []byte{deltaDelta}
+	b = binary.AppendUvarint(b, uint64(len(cleared)))
+	for _, n := range cleared {
+		b = binary.AppendUvarint(b, uint64(n))
+	}
+	return [][]byte{head, b, data}, nil
+}
+
+func (p *Processor) unmarshalDelta(st *deltaState, id uint16, i *MsgInfo, data []byte) (interface{}, error) {
+	if len(data) < 1 {
+		return nil, errors.New("protobuf delta flag missing")
+	}
+	flag, data := data[0], data[1:]
+
+	msg := reflect.New(i.msgType.Elem()).Interface().(proto.Message)
+	switch flag {
+	case deltaKeyframe:
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return nil, err
+		}
+		if st != nil {
+			st.Lock()
+			st.recv[id] = proto.Clone(msg)
+			st.Unlock()
+		}
+		return msg, nil
+	case deltaDelta:
+	default:
+		return nil, fmt.Errorf("invalid protobuf delta flag %v", flag)
+	}
+
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)) {
+		return nil, errors.New("invalid protobuf delta")
+	}
+	data = data[l:]
+	cleared := make([]int32, n)
+	for k := range cleared {
+		v, l := binary.Uvarint(data)
+		if l <= 0 {
+			return nil, errors.New("invalid protobuf delta")
+		}
+		cleared[k] = int32(v)
+		data = data[l:]
+	}
+
+	delta := msg.ProtoReflect().New().Interface()
+	if err := proto.Unmarshal(data, delta); err != nil {
+		return nil, err
+	}
+
+	if st == nil {
+		return nil, errors.New("protobuf delta without connection state")
+	}
+	st.Lock()
+	defer st.Unlock()
+	last, ok := st.recv[id]
+	if !ok {
+		return nil, errors.New("protobuf delta before keyframe")
+	}
+	ApplyDelta(last, delta, cleared)
+	proto.Merge(msg, last)
+	return msg, nil
+}
+
+// Diff compares two states of the same message type at top-level field
+// granularity. delta holds the fields of cur that differ from prev (a
+// changed message, list or map field is sent whole), cleared holds the
+// numbers of the fields set in prev but not in cur.
+func Diff(prev, cur proto.Message) (delta proto.Message, cleared []int32, changed bool) {
+	p := prev.ProtoReflect()
+	c := cur.ProtoReflect()
+	d := c.New()
+
+	fields := c.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		ph, ch := p.Has(fd), c.Has(fd)
+		switch {
+		case !ph && !ch:
+		case ph && !ch:
+			cleared = append(cleared, int32(fd.Number()))
+			changed = true
+		case !ph || !p.Get(fd).Equal(c.Get(fd)):
+			d.Set(fd, c.Get(fd))
+			changed = true
+		}
+	}
+
+	// don't share lists, maps and messages with cur
+	return proto.Clone(d.Interface()), cleared, changed
+}
+
+// ApplyDelta applies a delta returned by Diff to dst
+func ApplyDelta(dst, delta proto.Message, cleared []int32) {
+	m := dst.ProtoReflect()
+	fields := m.Descriptor().Fields()
+
+	proto.Clone(delta).ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		m.Set(fd, v)
+		return true
+	})
+	for _, n := range cleared {
+		if fd := fields.ByNumber(protoreflect.FieldNumber(n)); fd != nil {
+			m.Clear(fd)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ------------
+// | envelope |
+// ------------
+// EnvelopeProcessor reads and writes messages wrapped in a oneof of an
+// envelope message instead of prefixing an id, e.g.
+//
+//	message Envelope {
+//	  oneof body {
+//	    Login login = 1;
+//	    Move move = 2;
+//	  }
+//	}
+//
+// Messages are routed by the type of the field set, the other fields of the
+// envelope are ignored. An envelope passed to Marshal is sent as it is
+type EnvelopeProcessor struct {
+	envelope protoreflect.Message
+	oneof    protoreflect.OneofDescriptor
+	msgInfo  map[reflect.Type]*envelopeInfo
+}
+
+type envelopeInfo struct {
+	field      protoreflect.FieldDescriptor
+	msgRouter  *chanrpc.Server
+	msgHandler MsgHandler
+}
+
+// every message field of oneof is registered, the other fields are rejected by
+// Unmarshal
+func NewEnvelopeProcessor(envelope proto.Message, oneof string) *EnvelopeProcessor {
+	p := new(EnvelopeProcessor)
+	p.envelope = envelope.ProtoReflect()
+	p.oneof = p.envelope.Descriptor().Oneofs().ByName(protoreflect.Name(oneof))
+	if p.oneof == nil {
+		log.Fatal("oneof %v not found in %v", oneof, p.envelope.Descriptor().FullName())
+	}
+
+	p.msgInfo = make(map[reflect.Type]*envelopeInfo)
+	fields := p.oneof.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Message() == nil {
+			continue
+		}
+		msgType := reflect.TypeOf(p.envelope.NewField(fd).Message().Interface())
+		if _, ok := p.msgInfo[msgType]; ok {
+			log.Fatal("message %s is in oneof %v twice", msgType, oneof)
+		}
+		p.msgInfo[msgType] = &envelopeInfo{field: fd}
+	}
+	return p
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *EnvelopeProcessor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	msgType := reflect.TypeOf(msg)
+	i, ok := p.msgInfo[msgType]
+	if !ok {
+		log.Fatal("message %s not in the envelope", msgType)
+	}
+
+	i.msgRouter = msgRouter
+}
+
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *EnvelopeProcessor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
+	msgType := reflect.TypeOf(msg)
+	i, ok := p.msgInfo[msgType]
+	if !ok {
+		log.Fatal("message %s not in the envelope", msgType)
+	}
+
+	i.msgHandler = msgHandler
+}
+
+// goroutine safe
+func (p *EnvelopeProcessor) Route(msg interface{}, userData interface{}) error {
+	msgType := reflect.TypeOf(msg)
+	i, ok := p.msgInfo[msgType]
+	if !ok {
+		return fmt.Errorf("message %s not in the envelope", msgType)
+	}
+	if i.msgHandler != nil {
+		begin := time.Now()
+		i.msgHandler([]interface{}{msg, userData})
+		util.CheckSlow(msgType, begin)
+	}
+	if i.msgRouter != nil {
+		i.msgRouter.Go(msgType, msg, userData)
+	}
+	return nil
+}
+
+// goroutine safe
+func (p *EnvelopeProcessor) Unmarshal(data []byte) (interface{}, error) {
+	envelope := p.envelope.New()
+	if err := proto.Unmarshal(data, envelope.Interface()); err != nil {
+		return nil, err
+	}
+	fd := envelope.WhichOneof(p.oneof)
+	if fd == nil {
+		return nil, errors.New("empty envelope")
+	}
+	if fd.Message() == nil {
+		return nil, fmt.Errorf("envelope field %v is not a message", fd.Name())
+	}
+	return envelope.Get(fd).Message().Interface(), nil
+}
+
+// goroutine safe
+func (p *EnvelopeProcessor) Marshal(msg interface{}) ([][]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("message %T is not a protobuf message", msg)
+	}
+
+	envelope := m.ProtoReflect()
+	if envelope.Descriptor() != p.envelope.Descriptor() {
+		msgType := reflect.TypeOf(msg)
+		i, ok := p.msgInfo[msgType]
+		if !ok {
+			return nil, fmt.Errorf("message %s not in the envelope", msgType)
+		}
+		envelope = p.envelope.New()
+		envelope.Set(i.field, protoreflect.ValueOfMessage(m.ProtoReflect()))
+	}
+
+	data, err := proto.Marshal(envelope.Interface())
+	return [][]byte{data}, err
+}
@@ -0,0 +1,297 @@
+package protobuf_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/network/protobuf"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/apipb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func ExampleProcessor_SetDelta() {
+	server := protobuf.NewProcessor()
+	server.Register(&apipb.Method{})
+	server.SetDelta(&apipb.Method{}, 10)
+
+	client := protobuf.NewProcessor()
+	client.Register(&apipb.Method{})
+	client.SetDelta(&apipb.Method{}, 10)
+
+	// conn identifies the connection on both sides
+	conn := new(int)
+	send := func(state *apipb.Method) {
+		data, _ := server.MarshalTo(conn, state)
+		msg, _ := client.UnmarshalFrom(conn, bytes.Join(data, nil))
+		fmt.Println(len(bytes.Join(data, nil)), proto.Equal(msg.(proto.Message), state))
+	}
+
+	state := &apipb.Method{
+		Name:            "Move",
+		RequestTypeUrl:  "type.googleapis.com/game.MoveRequest",
+		ResponseTypeUrl: "type.googleapis.com/game.MoveResponse",
+	}
+	send(state)
+	state.RequestStreaming = true
+	send(state)
+
+	// Output:
+	// 86 true
+	// 6 true
+}
+
+func ExampleProcessor_RegisterAlias() {
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+	old := p.RegisterAlias(&apipb.Method{})
+	p.Deprecate(old, time.Time{})
+	p.SetHandler(&apipb.Method{}, func(args []interface{}) {
+		fmt.Println(args[0].(*apipb.Method).Name)
+	})
+
+	data, _ := proto.Marshal(&apipb.Method{Name: "Move"})
+	msg, _ := p.Unmarshal(append([]byte{0, byte(old)}, data...))
+	p.Route(msg, nil)
+	fmt.Println(old, p.Deprecations()[0].Uses())
+
+	// Output:
+	// Move
+	// 1 1
+}
+
+// vtMethod stands for a message generated by protoc-gen-go-vtproto
+type vtMethod struct {
+	apipb.Method
+}
+
+func (m *vtMethod) SizeVT() int {
+	return proto.Size(&m.Method)
+}
+
+func (m *vtMethod) MarshalToSizedBufferVT(data []byte) (int, error) {
+	fmt.Println("MarshalVT")
+	b, err := proto.Marshal(&m.Method)
+	return copy(data[len(data)-len(b):], b), err
+}
+
+func (m *vtMethod) UnmarshalVT(data []byte) error {
+	fmt.Println("UnmarshalVT")
+	return proto.Unmarshal(data, &m.Method)
+}
+
+func ExampleProcessor_vtprotobuf() {
+	p := protobuf.NewProcessor()
+	p.Register(&vtMethod{})
+
+	data, _ := p.Marshal(&vtMethod{Method: apipb.Method{Name: "Move"}})
+	msg, _ := p.Unmarshal(bytes.Join(data, nil))
+	fmt.Println(msg.(*vtMethod).Name)
+
+	// Output:
+	// MarshalVT
+	// UnmarshalVT
+	// Move
+}
+
+func ExampleProcessor_SetIDWidth() {
+	p := protobuf.NewProcessor()
+	p.SetIDWidth(network.IDWidthVarint)
+	p.Register(&apipb.Method{})
+
+	data, _ := p.Marshal(&apipb.Method{Name: "Move"})
+	fmt.Printf("%q\n", bytes.Join(data, nil))
+	msg, _ := p.Unmarshal(bytes.Join(data, nil))
+	fmt.Println(msg.(*apipb.Method).Name)
+
+	// Output:
+	// "\x00\n\x04Move"
+	// Move
+}
+
+func ExampleProcessor_RegisterFile() {
+	p := protobuf.NewProcessor()
+	p.RegisterFile(apipb.File_google_protobuf_api_proto)
+	p.Range(func(id uint16, t reflect.Type) {
+		fmt.Println(id, t)
+	})
+
+	// Output:
+	// 0 *apipb.Api
+	// 1 *apipb.Method
+	// 2 *apipb.Mixin
+}
+
+func ExampleEnvelopeProcessor() {
+	// oneof kind { ... Struct struct_value = 5; ListValue list_value = 6; }
+	p := protobuf.NewEnvelopeProcessor(&structpb.Value{}, "kind")
+	p.SetHandler(&structpb.ListValue{}, func(args []interface{}) {
+		fmt.Println(len(args[0].(*structpb.ListValue).Values))
+	})
+
+	data, _ := p.Marshal(&structpb.ListValue{Values: []*structpb.Value{structpb.NewBoolValue(true)}})
+	fmt.Printf("%q\n", bytes.Join(data, nil))
+	msg, _ := p.Unmarshal(bytes.Join(data, nil))
+	p.Route(msg, nil)
+
+	_, err := p.Unmarshal(nil)
+	fmt.Println(err)
+
+	// Output:
+	// "2\x04\n\x02 \x01"
+	// 1
+	// empty envelope
+}
+
+func ExampleProcessor_Use() {
+	p := protobuf.NewProcessor()
+	login := p.Register(&apipb.Api{})
+	p.Register(&apipb.Method{})
+	p.SetHandler(&apipb.Api{}, func(args []interface{}) {
+		*args[1].(*bool) = true
+		fmt.Println("login")
+	})
+	p.SetHandler(&apipb.Method{}, func(args []interface{}) {
+		fmt.Println(args[0].(*apipb.Method).Name)
+	})
+
+	// only Login before logging in
+	p.Use(func(next protobuf.RouteFunc) protobuf.RouteFunc {
+		return func(id uint16, msg interface{}, userData interface{}) error {
+			if id != login && !*userData.(*bool) {
+				return fmt.Errorf("message %v before login", id)
+			}
+			return next(id, msg, userData)
+		}
+	})
+
+	loggedIn := new(bool)
+	fmt.Println(p.Route(&apipb.Method{Name: "Move"}, loggedIn))
+	fmt.Println(p.Route(&apipb.Api{}, loggedIn))
+	fmt.Println(p.Route(&apipb.Method{Name: "Move"}, loggedIn))
+
+	// Output:
+	// message 1 before login
+	// login
+	// <nil>
+	// Move
+	// <nil>
+}
+
+func ExampleHandle() {
+	type Agent interface{ ID() uint32 }
+
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+	protobuf.Handle(p, func(msg *apipb.Method, a Agent) {
+		fmt.Println(msg.Name, a == nil)
+	})
+
+	p.Route(&apipb.Method{Name: "Move"}, nil)
+
+	// Output:
+	// Move true
+}
+
+func ExampleProcessor_AddHandler() {
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+	p.SetHandler(&apipb.Method{}, func(args []interface{}) {
+		fmt.Println("game", args[0].(*apipb.Method).Name)
+	})
+	p.AddHandler(&apipb.Method{}, func(args []interface{}) {
+		fmt.Println("analytics", args[0].(*apipb.Method).Name)
+	})
+
+	p.Route(&apipb.Method{Name: "Move"}, nil)
+
+	// Output:
+	// game Move
+	// analytics Move
+}
+
+func ExampleProcessor_Unmarshal_routeError() {
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+
+	_, err := p.Unmarshal([]byte{0, 7})
+	fmt.Println(network.MsgID(err), err)
+
+	// Output:
+	// 7 message id 7 not registered
+}
+
+func ExampleProcessor_SetMaxLen() {
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+	p.SetMaxLen(&apipb.Method{}, 8)
+
+	data, _ := p.Marshal(&apipb.Method{Name: "a long chat line"})
+	_, err := p.Unmarshal(bytes.Join(data, nil))
+	fmt.Println(err)
+
+	// Output:
+	// message *apipb.Method too long (18 > 8)
+}
+
+func ExampleConcurrentProcessor() {
+	p := protobuf.NewConcurrentProcessor()
+	p.Register(&apipb.Method{})
+
+	// a module loaded later registers its messages while the gate routes
+	done := make(chan struct{})
+	go func() {
+		p.Register(&apipb.Mixin{})
+		p.SetHandler(&apipb.Mixin{}, func(args []interface{}) {
+			fmt.Println("mixin", args[0].(*apipb.Mixin).Name)
+		})
+		close(done)
+	}()
+	p.Route(&apipb.Method{Name: "Move"}, nil)
+	<-done
+
+	data, _ := p.Marshal(&apipb.Mixin{Name: "Trade"})
+	msg, _ := p.Unmarshal(bytes.Join(data, nil))
+	p.Route(msg, nil)
+
+	// Output:
+	// mixin Trade
+}
+
+func ExampleNewMsgRaw() {
+	p := protobuf.NewProcessor()
+	id := p.Register(&apipb.Method{})
+
+	// relay the message without decoding it
+	var out [][]byte
+	p.SetRawHandler(id, func(args []interface{}) {
+		out, _ = p.Marshal(protobuf.NewMsgRaw(args[0].(uint16), args[1].([]byte)))
+	})
+
+	data, _ := proto.Marshal(&apipb.Method{Name: "Move"})
+	in := append([]byte{0, byte(id)}, data...)
+	msg, _ := p.Unmarshal(in)
+	p.Route(msg, nil)
+	fmt.Println(bytes.Equal(bytes.Join(out, nil), in))
+
+	// Output:
+	// true
+}
+
+func ExampleProcessor_Export() {
+	p := protobuf.NewProcessor()
+	p.Register(&apipb.Method{})
+	p.Register(&apipb.Mixin{})
+	old := p.RegisterAlias(&apipb.Method{})
+	p.Deprecate(old, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	data, _ := json.Marshal(p.Export())
+	fmt.Println(string(data))
+
+	// Output:
+	// {"id_width":"16","little_endian":false,"messages":[{"id":0,"name":"Method","full_name":"google.protobuf.Method"},{"id":1,"name":"Mixin","full_name":"google.protobuf.Mixin"},{"id":2,"name":"Method","full_name":"google.protobuf.Method","alias_of":0,"deprecated":true,"remove_at":"2030-01-01T00:00:00Z"}]}
+}
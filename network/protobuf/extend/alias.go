@@ -0,0 +1,59 @@
+package extend
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// Alias makes aliasID unmarshal as msg, so old clients keep sending the ID
+// they know. msg is always marshaled with its registered ID and handlers only
+// see that ID
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Alias(aliasID uint32, msg proto.Message) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatalf("message %s not registered", msgType)
+	}
+	if aliasID > p.idWidth.Max() {
+		log.Fatalf("protobuf: message ID %v exceeds the id width (max = %v)", aliasID, p.idWidth.Max())
+	}
+	if i, ok := p.msgInfo[aliasID]; ok {
+		log.Fatalf("protobuf: message ID %v is already used by %v", aliasID, i.msgType)
+	}
+
+	p.msgInfo[aliasID] = p.msgInfo[id]
+}
+
+// Deprecate counts and warns about the messages received with id and rejects
+// them after removeAt, a zero removeAt never rejects them
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Deprecate(id uint32, removeAt time.Time) {
+	info, ok := p.msgInfo[id]
+	if !ok {
+		log.Fatalf("message id %v not registered", id)
+	}
+
+	p.deprecated[id] = &network.Deprecation{
+		ID:       id,
+		Type:     info.msgType,
+		RemoveAt: removeAt,
+	}
+}
+
+// goroutine safe
+func (p *Processor) Deprecations() []*network.Deprecation {
+	ds := make([]*network.Deprecation, 0, len(p.deprecated))
+	for _, d := range p.deprecated {
+		ds = append(ds, d)
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		return ds[i].ID < ds[j].ID
+	})
+	return ds
+}
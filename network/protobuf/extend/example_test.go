@@ -0,0 +1,26 @@
+package extend_test
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/czx-lab/leaf/network/protobuf/extend"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+func ExampleProcessor_RegisterFile() {
+	p := extend.NewProcessor()
+	p.Register(1, &apipb.Api{})
+	p.RegisterFile(apipb.File_google_protobuf_api_proto)
+	fmt.Println(p.HashID(&apipb.Method{}))
+
+	n := 0
+	p.Range(func(id uint32, t reflect.Type) {
+		n++
+	})
+	fmt.Println(n)
+
+	// Output:
+	// 31105
+	// 3
+}
@@ -2,14 +2,15 @@ package extend
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"log"
 	"math"
 	"reflect"
+	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
 	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/util"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -17,14 +18,17 @@ type MsgHandler func([]any)
 
 type MsgInfo struct {
 	msgType       reflect.Type
-	msgID         uint16
+	msgID         uint32
 	msgRouter     *chanrpc.Server
 	msgHandler    MsgHandler
 	msgRawHandler MsgHandler
+	frameType     network.FrameType
+	vt            bool
+	maxLen        uint32
 }
 
 type MsgRaw struct {
-	msgID      uint16
+	msgID      uint32
 	msgRawData []byte
 }
 
@@ -33,8 +37,19 @@ type MsgRaw struct {
 // -------------------------
 type Processor struct {
 	littleEndian bool
-	msgInfo      map[uint16]*MsgInfo
-	msgID        map[reflect.Type]uint16
+	idWidth      network.IDWidth
+	msgInfo      map[uint32]*MsgInfo
+	msgID        map[reflect.Type]uint32
+	deprecated   map[uint32]*network.Deprecation
+}
+
+func NewProcessor() *Processor {
+	p := new(Processor)
+	p.littleEndian = false
+	p.msgInfo = make(map[uint32]*MsgInfo)
+	p.msgID = make(map[reflect.Type]uint32)
+	p.deprecated = make(map[uint32]*network.Deprecation)
+	return p
 }
 
 // Marshal implements network.Processor.
@@ -44,17 +59,15 @@ func (p *Processor) Marshal(msg any) ([][]byte, error) {
 	if !ok {
 		return nil, fmt.Errorf("protobuf: message %v not registered", msgType)
 	}
-
-	id := make([]byte, 2)
-	if p.littleEndian {
-		binary.LittleEndian.PutUint16(id, msgId)
-	} else {
-		binary.BigEndian.PutUint16(id, msgId)
+	if msgId > p.idWidth.Max() {
+		return nil, fmt.Errorf("protobuf: message ID %v exceeds the id width (max = %v)", msgId, p.idWidth.Max())
 	}
 
-	// data
-	data, err := proto.Marshal(msg.(proto.Message))
-	return [][]byte{id, data}, err
+	// id and data
+	if p.msgInfo[msgId].vt {
+		return marshalVT(p.idWidth, p.littleEndian, msgId, msg.(vtMessage))
+	}
+	return marshalProto(p.idWidth, p.littleEndian, msgId, msg.(proto.Message))
 }
 
 // Route implements network.Processor.
@@ -66,7 +79,9 @@ func (p *Processor) Route(msg, userData any) error {
 			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
 		}
 		if info.msgRawHandler != nil {
+			begin := time.Now()
 			info.msgRawHandler([]any{msgRaw.msgID, msgRaw.msgRawData, userData})
+			util.CheckSlow(info.msgType, begin)
 		}
 		return nil
 	}
@@ -80,7 +95,9 @@ func (p *Processor) Route(msg, userData any) error {
 
 	info := p.msgInfo[id]
 	if info.msgHandler != nil {
+		begin := time.Now()
 		info.msgHandler([]any{msg, userData})
+		util.CheckSlow(msgType, begin)
 	}
 	if info.msgRouter != nil {
 		info.msgRouter.Go(msgType, msg, userData)
@@ -90,52 +107,77 @@ func (p *Processor) Route(msg, userData any) error {
 
 // Unmarshal implements network.Processor.
 func (p *Processor) Unmarshal(data []byte) (any, error) {
-	if len(data) < 2 {
-		return nil, errors.New("protobuf data too short")
-	}
-
 	// id
-	var id uint16
-	if p.littleEndian {
-		id = binary.LittleEndian.Uint16(data)
-	} else {
-		id = binary.BigEndian.Uint16(data)
+	id, n, err := p.idWidth.Read(data, p.littleEndian)
+	if err != nil {
+		return nil, err
 	}
+	data = data[n:]
 
 	info, ok := p.msgInfo[id]
 	if !ok {
-		return nil, fmt.Errorf("protobuf: message ID %d not registered", id)
+		return nil, &network.RouteError{ID: id, Err: fmt.Errorf("protobuf: message ID %d not registered", id)}
+	}
+	if d, ok := p.deprecated[id]; ok {
+		if err := d.Use(); err != nil {
+			return nil, &network.RouteError{ID: id, Err: err}
+		}
+	}
+	id = info.msgID
+	if info.maxLen > 0 && uint32(len(data)) > info.maxLen {
+		return nil, &network.RouteError{ID: id, Err: fmt.Errorf("protobuf: message %v too long (%v > %v)", info.msgType, len(data), info.maxLen)}
 	}
 	if info.msgRawHandler != nil {
-		return MsgRaw{id, data[2:]}, nil
+		return MsgRaw{id, data}, nil
 	}
 
 	msg := reflect.New(info.msgType.Elem()).Interface()
-	return msg, proto.Unmarshal(data[2:], msg.(proto.Message))
+	if info.vt {
+		return msg, msg.(vtMessage).UnmarshalVT(data)
+	}
+	return msg, proto.Unmarshal(data, msg.(proto.Message))
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) Register(msgID uint16, msg proto.Message) {
+func (p *Processor) Register(msgID uint32, msg proto.Message) {
 	msgType := reflect.TypeOf(msg)
 	if msgType == nil || msgType.Kind() != reflect.Ptr {
 		log.Fatal("protobuf: message must be a pointer")
 	}
 
-	id, ok := p.msgID[msgType]
-	if ok {
+	if _, ok := p.msgID[msgType]; ok {
 		log.Fatalf("protobuf: message %v is already registered", msgType)
 	}
+	if i, ok := p.msgInfo[msgID]; ok {
+		log.Fatalf("protobuf: message ID %v is already used by %v", msgID, i.msgType)
+	}
 	if len(p.msgInfo) >= math.MaxUint16 {
 		log.Fatalf("too many protobuf messages (max = %v)", math.MaxUint16)
 	}
+	if msgID > p.idWidth.Max() {
+		log.Fatalf("protobuf: message ID %v exceeds the id width (max = %v)", msgID, p.idWidth.Max())
+	}
 
-	p.msgInfo[id] = &MsgInfo{
+	p.msgInfo[msgID] = &MsgInfo{
 		msgType: msgType,
 		msgID:   msgID,
+		vt:      msgType.Implements(vtMessageType),
 	}
 	p.msgID[msgType] = msgID
 }
 
+// SetIDWidth changes how ids are encoded, IDWidth16 limits them to 65535.
+// Call it before Register for the ids above 65535
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetIDWidth(w network.IDWidth) {
+	for id := range p.msgInfo {
+		if id > w.Max() {
+			log.Fatalf("protobuf: message ID %v exceeds the id width (max = %v)", id, w.Max())
+		}
+	}
+	p.idWidth = w
+}
+
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
@@ -164,7 +206,7 @@ func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
-func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
+func (p *Processor) SetRawHandler(id uint32, msgRawHandler MsgHandler) {
 	info, ok := p.msgInfo[id]
 	if !ok {
 		log.Fatalf("message id %v not registered", id)
@@ -173,11 +215,63 @@ func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
 	info.msgRawHandler = msgRawHandler
 }
 
+// SetFrameType selects the websocket frame type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetFrameType(msg proto.Message, t network.FrameType) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatalf("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].frameType = t
+}
+
+// SetMaxLen rejects the msg payloads longer than maxLen before unmarshaling
+// them, zero is unlimited
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetMaxLen(msg proto.Message, maxLen uint32) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatalf("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].maxLen = maxLen
+}
+
+// FrameType implements network.FrameTyper.
+func (p *Processor) FrameType(msg any) network.FrameType {
+	if id, ok := p.msgID[reflect.TypeOf(msg)]; ok {
+		return p.msgInfo[id].frameType
+	}
+	return network.FrameDefault
+}
+
 // goroutine safe
-func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
-	for _, i := range p.msgInfo {
-		f(uint16(i.msgID), i.msgType)
+func (p *Processor) Range(f func(id uint32, t reflect.Type)) {
+	for id, i := range p.msgInfo {
+		// aliases
+		if id != i.msgID {
+			continue
+		}
+		f(i.msgID, i.msgType)
 	}
 }
 
-var _ network.Processor = (*Processor)(nil)
+// marshalProto marshals the id and msg into a single buffer
+func marshalProto(w network.IDWidth, littleEndian bool, id uint32, msg proto.Message) ([][]byte, error) {
+	size := proto.Size(msg)
+	buf := w.Append(make([]byte, 0, binary.MaxVarintLen32+size), littleEndian, id)
+	l := len(buf)
+	buf, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(buf, msg)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{buf[:l], buf[l:]}, nil
+}
+
+var (
+	_ network.Processor  = (*Processor)(nil)
+	_ network.FrameTyper = (*Processor)(nil)
+)
@@ -0,0 +1,90 @@
+package extend
+
+import (
+	"hash/fnv"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/czx-lab/leaf/network/protobuf/leafpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RegisterFile registers the top-level messages of fd with their (leaf.msg_id)
+// option or ids hashed from their full names, see HashID. Registered messages
+// are skipped, register a message with an explicit ID first to resolve a
+// collision
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterFile(fd protoreflect.FileDescriptor) {
+	for i := 0; i < fd.Messages().Len(); i++ {
+		md := fd.Messages().Get(i)
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+		if err != nil {
+			log.Fatalf("protobuf: message %v: %v", md.FullName(), err)
+		}
+		p.registerAuto(mt.New().Interface())
+	}
+}
+
+// RegisterAll registers the top-level messages of the generated code linked
+// in whose full names start with prefix (e.g. "game."), like RegisterFile
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterAll(prefix string) {
+	protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+		md := mt.Descriptor()
+		if _, ok := md.Parent().(protoreflect.FileDescriptor); ok && strings.HasPrefix(string(md.FullName()), prefix) {
+			p.registerAuto(mt.New().Interface())
+		}
+		return true
+	})
+}
+
+// HashID is the FNV-1a hash of the full name of msg folded into the id width,
+// so ids stay the same when messages are added or moved
+// goroutine safe
+func (p *Processor) HashID(msg proto.Message) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(msg.ProtoReflect().Descriptor().FullName()))
+	id := h.Sum32()
+	if max := p.idWidth.Max(); max < ^uint32(0) {
+		id = id>>16 ^ id&max
+	}
+	return id
+}
+
+// OptionID reads the (leaf.msg_id) option of msg, see leafpb/leaf.proto
+// goroutine safe
+func OptionID(msg proto.Message) (uint32, bool) {
+	opts := msg.ProtoReflect().Descriptor().Options()
+	if opts == nil || !proto.HasExtension(opts, leafpb.E_MsgId) {
+		return 0, false
+	}
+	return proto.GetExtension(opts, leafpb.E_MsgId).(uint32), true
+}
+
+// RegisterByOption registers msg with its (leaf.msg_id) option
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterByOption(msg proto.Message) uint32 {
+	id, ok := OptionID(msg)
+	if !ok {
+		log.Fatalf("protobuf: message %v has no (leaf.msg_id) option", reflect.TypeOf(msg))
+	}
+	p.Register(id, msg)
+	return id
+}
+
+func (p *Processor) registerAuto(msg proto.Message) {
+	if _, ok := p.msgID[reflect.TypeOf(msg)]; ok {
+		return
+	}
+	id, ok := OptionID(msg)
+	if !ok {
+		id = p.HashID(msg)
+		if i, ok := p.msgInfo[id]; ok {
+			log.Fatalf("protobuf: hashed ID %v of %v collides with %v", id, reflect.TypeOf(msg), i.msgType)
+		}
+	}
+	p.Register(id, msg)
+}
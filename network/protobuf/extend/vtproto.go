@@ -0,0 +1,32 @@
+package extend
+
+import (
+	"encoding/binary"
+	"reflect"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// vtMessage is implemented by the messages generated by protoc-gen-go-vtproto
+// with the marshal, unmarshal and size features, they are marshaled without
+// reflection and into a single buffer with the id
+type vtMessage interface {
+	SizeVT() int
+	MarshalToSizedBufferVT(data []byte) (int, error)
+	UnmarshalVT(data []byte) error
+}
+
+var vtMessageType = reflect.TypeOf((*vtMessage)(nil)).Elem()
+
+// marshalVT marshals the id and msg into a single buffer
+func marshalVT(w network.IDWidth, littleEndian bool, id uint32, msg vtMessage) ([][]byte, error) {
+	size := msg.SizeVT()
+	buf := w.Append(make([]byte, 0, binary.MaxVarintLen32+size), littleEndian, id)
+	l := len(buf)
+	buf = buf[:l+size]
+	n, err := msg.MarshalToSizedBufferVT(buf[l:])
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{buf[:l], buf[l+size-n:]}, nil
+}
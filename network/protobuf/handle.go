@@ -0,0 +1,31 @@
+package protobuf
+
+import (
+	"reflect"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Handle sets the handler of the registered message T, A is the type of
+// userData such as gate.Agent
+//
+//	protobuf.Handle(p, func(msg *msg.Login, a gate.Agent) {})
+//
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func Handle[T proto.Message, A any](p *Processor, fn func(msg T, userData A)) {
+	var msg T
+	p.SetHandler(msg, func(args []interface{}) {
+		userData, _ := args[1].(A)
+		fn(args[0].(T), userData)
+	})
+}
+
+// HandleRouted registers fn on s for the message T routed to s by SetRouter,
+// e.g. on the ChanRPCServer of a module skeleton
+func HandleRouted[T proto.Message, A any](s *chanrpc.Server, fn func(msg T, userData A)) {
+	s.Register(reflect.TypeFor[T](), func(args []interface{}) {
+		userData, _ := args[1].(A)
+		fn(args[0].(T), userData)
+	})
+}
@@ -0,0 +1,105 @@
+package protobuf
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/czx-lab/leaf/console"
+	"github.com/czx-lab/leaf/network"
+	"google.golang.org/protobuf/proto"
+)
+
+// Manifest is the message table of a Processor, for the clients to generate
+// their message ids from the ones the server registered
+type Manifest struct {
+	// "16", "32" or "varint"
+	IDWidth      string            `json:"id_width"`
+	LittleEndian bool              `json:"little_endian"`
+	Messages     []ManifestMessage `json:"messages"`
+}
+
+type ManifestMessage struct {
+	ID uint16 `json:"id"`
+	// the Go type, e.g. "Login"
+	Name string `json:"name"`
+	// the protobuf message, e.g. "game.Login"
+	FullName string `json:"full_name"`
+	// the id msg is marshaled with if the id is one of RegisterAlias
+	AliasOf *uint16 `json:"alias_of,omitempty"`
+	// see Deprecate, RemoveAt is RFC 3339 or empty if never removed
+	Deprecated bool   `json:"deprecated,omitempty"`
+	RemoveAt   string `json:"remove_at,omitempty"`
+	// see SetDelta
+	Delta bool `json:"delta,omitempty"`
+}
+
+// Export returns the message table in the id order, aliases included
+// goroutine safe
+func (p *Processor) Export() *Manifest {
+	m := &Manifest{
+		IDWidth:      idWidthName(p.idWidth),
+		LittleEndian: p.littleEndian,
+		Messages:     make([]ManifestMessage, 0, len(p.msgInfo)),
+	}
+	for id, i := range p.msgInfo {
+		msg := ManifestMessage{
+			ID:       uint16(id),
+			Name:     i.msgType.Elem().Name(),
+			FullName: string(reflect.New(i.msgType.Elem()).Interface().(proto.Message).ProtoReflect().Descriptor().FullName()),
+			Delta:    i.delta != nil,
+		}
+		if to := p.msgID[i.msgType]; to != uint16(id) {
+			msg.AliasOf = &to
+		}
+		if d, ok := p.deprecated[uint16(id)]; ok {
+			msg.Deprecated = true
+			if !d.RemoveAt.IsZero() {
+				msg.RemoveAt = d.RemoveAt.Format(time.RFC3339)
+			}
+		}
+		m.Messages = append(m.Messages, msg)
+	}
+	return m
+}
+
+func idWidthName(w network.IDWidth) string {
+	switch w {
+	case network.IDWidth32:
+		return "32"
+	case network.IDWidthVarint:
+		return "varint"
+	default:
+		return "16"
+	}
+}
+
+// WriteFile writes m as indented JSON
+func (m *Manifest) WriteFile(name string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// RegisterExportCommand adds a console command writing the message table to
+// a JSON file
+// you must call the function before calling console.Init
+func (p *Processor) RegisterExportCommand(name string) {
+	registerExportCommand(name, p.Export)
+}
+
+func registerExportCommand(name string, export func() *Manifest) {
+	console.RegisterFunc(name, "writes the message table to a JSON file", func(args []string) string {
+		if len(args) != 1 {
+			return "Usage: " + name + " <file>"
+		}
+		m := export()
+		if err := m.WriteFile(args[0]); err != nil {
+			return err.Error()
+		}
+		return "wrote " + args[0]
+	})
+}
@@ -0,0 +1,24 @@
+package protobuf
+
+// RouteFunc routes a message received from userData, msg is a MsgRaw if the
+// message has a raw handler
+type RouteFunc func(id uint16, msg interface{}, userData interface{}) error
+
+// Middleware wraps the routing of every registered message. It may inspect
+// or replace the message, or return an error instead of calling next
+type Middleware func(next RouteFunc) RouteFunc
+
+// Use adds middlewares, the first one added is the outermost
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) Use(mw ...Middleware) {
+	p.middlewares = append(p.middlewares, mw...)
+	p.chain()
+}
+
+func (p *Processor) chain() {
+	var route RouteFunc = p.dispatch
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		route = p.middlewares[i](route)
+	}
+	p.route = route
+}
@@ -2,13 +2,16 @@ package protobuf
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/czx-lab/leaf/chanrpc"
 	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/util"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -17,15 +20,25 @@ import (
 // -------------------------
 type Processor struct {
 	littleEndian bool
+	idWidth      network.IDWidth
 	msgInfo      []*MsgInfo
 	msgID        map[reflect.Type]uint16
+	deltas       *sync.Map
+	deprecated   map[uint16]*network.Deprecation
+	middlewares  []Middleware
+	route        RouteFunc
+	zeroCopy     bool
 }
 
 type MsgInfo struct {
 	msgType       reflect.Type
-	msgRouter     *chanrpc.Server
-	msgHandler    MsgHandler
+	msgRouters    []*chanrpc.Server
+	msgHandlers   []MsgHandler
 	msgRawHandler MsgHandler
+	delta         *deltaInfo
+	frameType     network.FrameType
+	vt            bool
+	maxLen        uint32
 }
 
 type MsgHandler func([]interface{})
@@ -33,12 +46,23 @@ type MsgHandler func([]interface{})
 type MsgRaw struct {
 	msgID      uint16
 	msgRawData []byte
+	// the pooled buffer of msgRawData with zero copy
+	buf *network.Buffer
+}
+
+// NewMsgRaw returns a message marshaled as id followed by data as is, e.g.
+// to relay a message received by a raw handler without decoding it. The
+// gate copies data on writing, unless Resume keeps the message to replay it
+func NewMsgRaw(id uint16, data []byte) MsgRaw {
+	return MsgRaw{msgID: id, msgRawData: data}
 }
 
 func NewProcessor() *Processor {
 	p := new(Processor)
 	p.littleEndian = false
 	p.msgID = make(map[reflect.Type]uint16)
+	p.deltas = new(sync.Map)
+	p.deprecated = make(map[uint16]*network.Deprecation)
 	return p
 }
 
@@ -47,6 +71,13 @@ func (p *Processor) SetByteOrder(littleEndian bool) {
 	p.littleEndian = littleEndian
 }
 
+// SetIDWidth changes how ids are encoded, ids are still assigned from zero
+// and below 65535. Only the extend processor registers wider ids
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetIDWidth(w network.IDWidth) {
+	p.idWidth = w
+}
+
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) Register(msg proto.Message) uint16 {
 	msgType := reflect.TypeOf(msg)
@@ -62,32 +93,50 @@ func (p *Processor) Register(msg proto.Message) uint16 {
 
 	i := new(MsgInfo)
 	i.msgType = msgType
+	i.vt = msgType.Implements(vtMessageType)
 	p.msgInfo = append(p.msgInfo, i)
 	id := uint16(len(p.msgInfo) - 1)
 	p.msgID[msgType] = id
 	return id
 }
 
+// SetRouter replaces the routers of msg
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) SetRouter(msg proto.Message, msgRouter *chanrpc.Server) {
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
-	if !ok {
-		log.Fatal("message %s not registered", msgType)
-	}
+	i := p.info(msg)
+	i.msgRouters = []*chanrpc.Server{msgRouter}
+}
 
-	p.msgInfo[id].msgRouter = msgRouter
+// AddRouter adds a router of msg, routers are called in the order they are
+// added
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) AddRouter(msg proto.Message, msgRouter *chanrpc.Server) {
+	i := p.info(msg)
+	i.msgRouters = append(i.msgRouters, msgRouter)
 }
 
+// SetHandler replaces the handlers of msg
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
 func (p *Processor) SetHandler(msg proto.Message, msgHandler MsgHandler) {
+	i := p.info(msg)
+	i.msgHandlers = []MsgHandler{msgHandler}
+}
+
+// AddHandler adds a handler of msg, e.g. an analytics tap next to the game
+// logic. Handlers are called in the order they are added, before the routers
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) AddHandler(msg proto.Message, msgHandler MsgHandler) {
+	i := p.info(msg)
+	i.msgHandlers = append(i.msgHandlers, msgHandler)
+}
+
+func (p *Processor) info(msg proto.Message) *MsgInfo {
 	msgType := reflect.TypeOf(msg)
 	id, ok := p.msgID[msgType]
 	if !ok {
 		log.Fatal("message %s not registered", msgType)
 	}
-
-	p.msgInfo[id].msgHandler = msgHandler
+	return p.msgInfo[id]
 }
 
 // It's dangerous to call the method on routing or marshaling (unmarshaling)
@@ -99,65 +148,184 @@ func (p *Processor) SetRawHandler(id uint16, msgRawHandler MsgHandler) {
 	p.msgInfo[id].msgRawHandler = msgRawHandler
 }
 
+// SetZeroCopy makes the gate read the messages into pooled buffers. The
+// []byte a raw handler gets then refers to the buffer, passed as args[3] as
+// a *network.Buffer, and the handler must call its Release once done with
+// it. The other messages are released when they are unmarshaled
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetZeroCopy(zeroCopy bool) {
+	p.zeroCopy = zeroCopy
+}
+
+// goroutine safe
+func (p *Processor) ZeroCopy() bool {
+	return p.zeroCopy
+}
+
+// SetFrameType selects the websocket frame type of msg
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetFrameType(msg proto.Message, t network.FrameType) {
+	msgType := reflect.TypeOf(msg)
+	id, ok := p.msgID[msgType]
+	if !ok {
+		log.Fatal("message %s not registered", msgType)
+	}
+
+	p.msgInfo[id].frameType = t
+}
+
+// SetMaxLen rejects the msg payloads longer than maxLen before unmarshaling
+// them, zero is unlimited
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) SetMaxLen(msg proto.Message, maxLen uint32) {
+	p.info(msg).maxLen = maxLen
+}
+
+// goroutine safe
+func (p *Processor) FrameType(msg interface{}) network.FrameType {
+	if id, ok := p.msgID[reflect.TypeOf(msg)]; ok {
+		return p.msgInfo[id].frameType
+	}
+	return network.FrameDefault
+}
+
 // goroutine safe
 func (p *Processor) Route(msg interface{}, userData interface{}) error {
-	// raw
+	var id uint16
 	if msgRaw, ok := msg.(MsgRaw); ok {
 		if msgRaw.msgID >= uint16(len(p.msgInfo)) {
-			return fmt.Errorf("message id %v not registered", msgRaw.msgID)
+			return &network.RouteError{ID: msgRaw.msgID, Err: fmt.Errorf("message id %v not registered", msgRaw.msgID)}
+		}
+		id = msgRaw.msgID
+	} else {
+		msgType := reflect.TypeOf(msg)
+		id, ok = p.msgID[msgType]
+		if !ok {
+			return fmt.Errorf("message %s not registered", msgType)
 		}
-		i := p.msgInfo[msgRaw.msgID]
+	}
+
+	var err error
+	if p.route != nil {
+		err = p.route(id, msg, userData)
+	} else {
+		err = p.dispatch(id, msg, userData)
+	}
+	if err != nil && network.MsgID(err) == nil {
+		err = &network.RouteError{ID: id, Err: err}
+	}
+	return err
+}
+
+func (p *Processor) dispatch(id uint16, msg interface{}, userData interface{}) error {
+	i := p.msgInfo[id]
+
+	// raw
+	if msgRaw, ok := msg.(MsgRaw); ok {
 		if i.msgRawHandler != nil {
-			i.msgRawHandler([]interface{}{msgRaw.msgID, msgRaw.msgRawData, userData})
+			begin := time.Now()
+			args := []interface{}{msgRaw.msgID, msgRaw.msgRawData, userData}
+			if msgRaw.buf != nil {
+				args = append(args, msgRaw.buf)
+			}
+			i.msgRawHandler(args)
+			util.CheckSlow(i.msgType, begin)
 		}
 		return nil
 	}
 
 	// protobuf
-	msgType := reflect.TypeOf(msg)
-	id, ok := p.msgID[msgType]
-	if !ok {
-		return fmt.Errorf("message %s not registered", msgType)
+	for _, h := range i.msgHandlers {
+		begin := time.Now()
+		h([]interface{}{msg, userData})
+		util.CheckSlow(i.msgType, begin)
 	}
-	i := p.msgInfo[id]
-	if i.msgHandler != nil {
-		i.msgHandler([]interface{}{msg, userData})
-	}
-	if i.msgRouter != nil {
-		i.msgRouter.Go(msgType, msg, userData)
+	for _, r := range i.msgRouters {
+		r.Go(i.msgType, msg, userData)
 	}
 	return nil
 }
 
 // goroutine safe
 func (p *Processor) Unmarshal(data []byte) (interface{}, error) {
-	if len(data) < 2 {
-		return nil, errors.New("protobuf data too short")
-	}
+	return p.unmarshal(nil, data)
+}
 
+func (p *Processor) unmarshal(st *deltaState, data []byte) (interface{}, error) {
 	// id
-	var id uint16
-	if p.littleEndian {
-		id = binary.LittleEndian.Uint16(data)
-	} else {
-		id = binary.BigEndian.Uint16(data)
+	_id, n, err := p.idWidth.Read(data, p.littleEndian)
+	if err != nil {
+		return nil, err
 	}
-	if id >= uint16(len(p.msgInfo)) {
-		return nil, fmt.Errorf("message id %v not registered", id)
+	if _id >= uint32(len(p.msgInfo)) {
+		// an id too wide for uint16 is reported as is
+		var routeID interface{} = _id
+		if _id <= math.MaxUint16 {
+			routeID = uint16(_id)
+		}
+		return nil, &network.RouteError{ID: routeID, Err: fmt.Errorf("message id %v not registered", _id)}
+	}
+	id := uint16(_id)
+	if d, ok := p.deprecated[id]; ok {
+		if err := d.Use(); err != nil {
+			return nil, &network.RouteError{ID: id, Err: err}
+		}
 	}
+	data = data[n:]
 
 	// msg
 	i := p.msgInfo[id]
+	id = p.msgID[i.msgType]
+	if i.maxLen > 0 && uint32(len(data)) > i.maxLen {
+		return nil, &network.RouteError{ID: id, Err: fmt.Errorf("message %v too long (%v > %v)", i.msgType, len(data), i.maxLen)}
+	}
+	var msg interface{}
 	if i.msgRawHandler != nil {
-		return MsgRaw{id, data[2:]}, nil
+		return MsgRaw{msgID: id, msgRawData: data}, nil
+	} else if i.delta != nil {
+		msg, err = p.unmarshalDelta(st, id, i, data)
+	} else if i.vt {
+		msg = reflect.New(i.msgType.Elem()).Interface()
+		err = msg.(vtMessage).UnmarshalVT(data)
 	} else {
-		msg := reflect.New(i.msgType.Elem()).Interface()
-		return msg, proto.UnmarshalOptions{Merge: true}.Unmarshal(data[2:], msg.(proto.Message))
+		msg = reflect.New(i.msgType.Elem()).Interface()
+		err = proto.UnmarshalOptions{Merge: true}.Unmarshal(data, msg.(proto.Message))
+	}
+	if err != nil {
+		err = &network.RouteError{ID: id, Err: err}
+	}
+	return msg, err
+}
+
+// UnmarshalBuffer is UnmarshalFrom taking the ownership of buf, which is
+// handed over to the raw handler of the message or released
+// goroutine safe
+func (p *Processor) UnmarshalBuffer(userData interface{}, buf *network.Buffer) (interface{}, error) {
+	msg, err := p.unmarshal(p.deltaState(userData), buf.Bytes())
+	if msgRaw, ok := msg.(MsgRaw); ok && err == nil {
+		msgRaw.buf = buf
+		return msgRaw, nil
 	}
+	buf.Release()
+	return msg, err
 }
 
+// Marshal marshals msg, a MsgRaw is written as is
 // goroutine safe
 func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
+	return p.marshal(nil, msg)
+}
+
+func (p *Processor) marshal(st *deltaState, msg interface{}) ([][]byte, error) {
+	// pre-encoded
+	if msgRaw, ok := msg.(MsgRaw); ok {
+		if msgRaw.msgID >= uint16(len(p.msgInfo)) {
+			return nil, fmt.Errorf("message id %v not registered", msgRaw.msgID)
+		}
+		id := p.idWidth.Append(nil, p.littleEndian, uint32(msgRaw.msgID))
+		return [][]byte{id, msgRaw.msgRawData}, nil
+	}
+
 	msgType := reflect.TypeOf(msg)
 
 	// id
@@ -167,21 +335,45 @@ func (p *Processor) Marshal(msg interface{}) ([][]byte, error) {
 		return nil, err
 	}
 
-	id := make([]byte, 2)
-	if p.littleEndian {
-		binary.LittleEndian.PutUint16(id, _id)
-	} else {
-		binary.BigEndian.PutUint16(id, _id)
+	i := p.msgInfo[_id]
+	if i.delta != nil {
+		id := p.idWidth.Append(nil, p.littleEndian, uint32(_id))
+		return p.marshalDelta(st, _id, i, id, msg.(proto.Message))
+	}
+
+	// id and data
+	if i.vt {
+		return marshalVT(p.idWidth, p.littleEndian, uint32(_id), msg.(vtMessage))
 	}
+	return marshalProto(p.idWidth, p.littleEndian, uint32(_id), msg.(proto.Message))
+}
 
-	// data
-	data, err := proto.Marshal(msg.(proto.Message))
-	return [][]byte{id, data}, err
+// ID returns the id msg is marshaled with
+// goroutine safe
+func (p *Processor) ID(msg interface{}) (uint16, bool) {
+	id, ok := p.msgID[reflect.TypeOf(msg)]
+	return id, ok
 }
 
 // goroutine safe
 func (p *Processor) Range(f func(id uint16, t reflect.Type)) {
 	for id, i := range p.msgInfo {
+		// aliases
+		if p.msgID[i.msgType] != uint16(id) {
+			continue
+		}
 		f(uint16(id), i.msgType)
 	}
 }
+
+// marshalProto marshals the id and msg into a single buffer
+func marshalProto(w network.IDWidth, littleEndian bool, id uint32, msg proto.Message) ([][]byte, error) {
+	size := proto.Size(msg)
+	buf := w.Append(make([]byte, 0, binary.MaxVarintLen32+size), littleEndian, id)
+	l := len(buf)
+	buf, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(buf, msg)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{buf[:l], buf[l:]}, nil
+}
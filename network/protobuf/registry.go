@@ -0,0 +1,56 @@
+package protobuf
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/czx-lab/leaf/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RegisterFile registers the top-level messages of fd, sorted by their full
+// names so that ids don't depend on the declaration order. Registered
+// messages are skipped
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterFile(fd protoreflect.FileDescriptor) {
+	var msgs []proto.Message
+	for i := 0; i < fd.Messages().Len(); i++ {
+		md := fd.Messages().Get(i)
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+		if err != nil {
+			log.Fatal("message %v: %v", md.FullName(), err)
+		}
+		msgs = append(msgs, mt.New().Interface())
+	}
+	p.registerSorted(msgs)
+}
+
+// RegisterAll registers the top-level messages of the generated code linked
+// in whose full names start with prefix (e.g. "game."), sorted by their full
+// names. Registered messages are skipped
+// It's dangerous to call the method on routing or marshaling (unmarshaling)
+func (p *Processor) RegisterAll(prefix string) {
+	var msgs []proto.Message
+	protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+		md := mt.Descriptor()
+		if _, ok := md.Parent().(protoreflect.FileDescriptor); ok && strings.HasPrefix(string(md.FullName()), prefix) {
+			msgs = append(msgs, mt.New().Interface())
+		}
+		return true
+	})
+	p.registerSorted(msgs)
+}
+
+func (p *Processor) registerSorted(msgs []proto.Message) {
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ProtoReflect().Descriptor().FullName() < msgs[j].ProtoReflect().Descriptor().FullName()
+	})
+	for _, msg := range msgs {
+		if _, ok := p.msgID[reflect.TypeOf(msg)]; !ok {
+			p.Register(msg)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"net"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// ProxyProtocol reads the PROXY protocol v1 or v2 header sent by a load
+// balancer, RemoteAddr of the connection is then the client address
+type ProxyProtocol struct {
+	// IP addresses or CIDRs of the load balancers. Headers sent by other
+	// peers close the connection, so an empty list trusts none but the unix
+	// socket peers
+	TrustedProxies []string
+	// trusts every peer instead of TrustedProxies, only for a listener the
+	// clients can't reach but through the load balancers, as any client may
+	// spoof its address otherwise
+	TrustAll bool
+	// trusted peers must send the header
+	Required bool
+	// 10s if zero
+	ReadHeaderTimeout time.Duration
+}
+
+func (p *ProxyProtocol) listener(ln net.Listener) (net.Listener, error) {
+	var trusted []*net.IPNet
+	for _, s := range p.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, err
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		trusted = append(trusted, ipNet)
+	}
+
+	use := proxyproto.USE
+	if p.Required {
+		use = proxyproto.REQUIRE
+	}
+	return &proxyproto.Listener{
+		Listener:          ln,
+		ReadHeaderTimeout: p.ReadHeaderTimeout,
+		// an error would stop the accept loop, so there is none
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if p.TrustAll {
+				return use, nil
+			}
+			tcpAddr, ok := upstream.(*net.TCPAddr)
+			if !ok {
+				return use, nil
+			}
+			for _, ipNet := range trusted {
+				if ipNet.Contains(tcpAddr.IP) {
+					return use, nil
+				}
+			}
+			return proxyproto.REJECT, nil
+		},
+	}, nil
+}
@@ -0,0 +1,143 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QUICProto is the ALPN protocol of leaf over QUIC
+const QUICProto = "leaf"
+
+const quicLinger = 5 * time.Second
+
+// the client writes it on the stream so that the server sees the stream
+const quicPreamble = 0x1
+
+// quicConn is a QUIC connection carrying one bidirectional stream
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close sends the written data and closes the connection once the peer
+// closes it too or after quicLinger
+func (c *quicConn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+	go func() {
+		select {
+		case <-c.conn.Context().Done():
+		case <-time.After(quicLinger):
+		}
+		c.conn.CloseWithError(0, "")
+	}()
+	return err
+}
+
+type quicListener struct {
+	ln       *quic.EarlyListener
+	chAccept chan net.Conn
+	die      chan struct{}
+	timeout  time.Duration
+}
+
+func listenQUIC(addr string, tlsConf *tls.Config, config *quic.Config, timeout time.Duration) (*quicListener, error) {
+	ln, err := quic.ListenAddrEarly(addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &quicListener{
+		ln:       ln,
+		chAccept: make(chan net.Conn, 128),
+		die:      make(chan struct{}),
+		timeout:  timeout,
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *quicListener) run() {
+	for {
+		conn, err := l.ln.Accept(context.Background())
+		if err != nil {
+			close(l.die)
+			return
+		}
+		go l.acceptStream(conn)
+	}
+}
+
+func (l *quicListener) acceptStream(conn *quic.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "no stream")
+		return
+	}
+	stream.SetReadDeadline(time.Now().Add(l.timeout))
+	var b [1]byte
+	if _, err := stream.Read(b[:]); err != nil || b[0] != quicPreamble {
+		conn.CloseWithError(0, "bad preamble")
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	select {
+	case l.chAccept <- &quicConn{stream, conn}:
+	case <-l.die:
+		conn.CloseWithError(0, "")
+	}
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.chAccept:
+		return conn, nil
+	case <-l.die:
+		return nil, errors.New("quic: use of closed listener")
+	}
+}
+
+func (l *quicListener) Close() error {
+	return l.ln.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func dialQUIC(addr string, tlsConf *tls.Config, config *quic.Config, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := quic.DialAddrEarly(ctx, addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	if _, err := stream.Write([]byte{quicPreamble}); err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return &quicConn{stream, conn}, nil
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+type QUICClient struct {
+	Addr            string
+	ConnNum         int
+	ConnectInterval time.Duration
+	PendingWriteNum int
+	AutoReconnect   bool
+	NewAgent        func(*TCPConn) Agent
+	// see TCPClient
+	Backoff *Backoff
+	ConnEvents
+	// NextProtos is set to QUICProto, a session cache is added for 0-RTT
+	// reconnects
+	TLSConfig *tls.Config
+	// nil uses the quic-go defaults
+	QUIC        *quic.Config
+	DialTimeout time.Duration
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+
+	client *TCPClient
+}
+
+func (client *QUICClient) Start() {
+	if client.DialTimeout <= 0 {
+		client.DialTimeout = 10 * time.Second
+	}
+
+	tlsConf := new(tls.Config)
+	if client.TLSConfig != nil {
+		tlsConf = client.TLSConfig.Clone()
+	}
+	tlsConf.NextProtos = []string{QUICProto}
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	config := client.QUIC
+	timeout := client.DialTimeout
+
+	client.client = &TCPClient{
+		Addr:              client.Addr,
+		ConnNum:           client.ConnNum,
+		ConnectInterval:   client.ConnectInterval,
+		PendingWriteNum:   client.PendingWriteNum,
+		AutoReconnect:     client.AutoReconnect,
+		NewAgent:          client.NewAgent,
+		Backoff:           client.Backoff,
+		ConnEvents:        client.ConnEvents,
+		LenMsgLen:         client.LenMsgLen,
+		MinMsgLen:         client.MinMsgLen,
+		MaxMsgLen:         client.MaxMsgLen,
+		LittleEndian:      client.LittleEndian,
+		MaxFragmentedLen:  client.MaxFragmentedLen,
+		LenIncludesHeader: client.LenIncludesHeader,
+		dialer: func(addr string) (net.Conn, error) {
+			return dialQUIC(addr, tlsConf, config, timeout)
+		},
+	}
+	client.client.Start()
+}
+
+func (client *QUICClient) Close() {
+	client.client.Close()
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"net"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICServer serves one stream per QUIC connection, framed like TCP
+// connections. 0-RTT is accepted
+type QUICServer struct {
+	Addr            string
+	MaxConnNum      int
+	PendingWriteNum int
+	NewAgent        func(*TCPConn) Agent
+	CertFile        string
+	KeyFile         string
+	// reloadable certificate and session resumption, CertFile and KeyFile
+	// are ignored
+	TLS *TLSConfig
+	// nil uses the quic-go defaults
+	QUIC *quic.Config
+	// how long a new connection may take to open its stream
+	HandshakeTimeout time.Duration
+
+	// msg parser
+	LenMsgLen    int
+	MinMsgLen    uint32
+	MaxMsgLen    uint32
+	LittleEndian bool
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+
+	server *TCPServer
+	tls    *TLSConfig
+}
+
+func (server *QUICServer) Start() {
+	if server.HandshakeTimeout <= 0 {
+		server.HandshakeTimeout = 10 * time.Second
+		log.Release("invalid HandshakeTimeout, reset to %v", server.HandshakeTimeout)
+	}
+
+	server.tls = server.TLS
+	if server.tls == nil {
+		server.tls = &TLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile}
+	}
+	tlsConf, err := server.tls.open()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{QUICProto}
+
+	config := server.QUIC
+	if config == nil {
+		config = new(quic.Config)
+	}
+	config = config.Clone()
+	config.Allow0RTT = true
+
+	server.server = &TCPServer{
+		Addr:              server.Addr,
+		MaxConnNum:        server.MaxConnNum,
+		PendingWriteNum:   server.PendingWriteNum,
+		NewAgent:          server.NewAgent,
+		LenMsgLen:         server.LenMsgLen,
+		MinMsgLen:         server.MinMsgLen,
+		MaxMsgLen:         server.MaxMsgLen,
+		LittleEndian:      server.LittleEndian,
+		MaxFragmentedLen:  server.MaxFragmentedLen,
+		LenIncludesHeader: server.LenIncludesHeader,
+		listen: func(addr string) (net.Listener, error) {
+			return listenQUIC(addr, tlsConf, config, server.HandshakeTimeout)
+		},
+	}
+	server.server.Start()
+}
+
+// Drain stops accepting, waits up to timeout for the connections to be
+// closed by their agents and then closes the remaining ones
+func (server *QUICServer) Drain(timeout time.Duration) {
+	server.server.Drain(timeout)
+}
+
+func (server *QUICServer) Close() {
+	server.server.Close()
+	server.tls.close()
+}
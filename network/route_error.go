@@ -0,0 +1,28 @@
+package network
+
+import "errors"
+
+// RouteError is returned by processors for a message they can't unmarshal or
+// route. ID is the message id, e.g. a uint16, or the name of a json message
+type RouteError struct {
+	ID  interface{}
+	Err error
+}
+
+func (e *RouteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// MsgID returns the message id of a RouteError in err, it's nil if there is
+// none
+func MsgID(err error) interface{} {
+	var re *RouteError
+	if errors.As(err, &re) {
+		return re.ID
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SRVScheme prefixes an address resolved with a DNS SRV lookup, e.g.
+// srv://_game._tcp.example.com
+const SRVScheme = "srv://"
+
+type srvResolver struct {
+	sync.Mutex
+	name     string
+	interval time.Duration
+	records  []*net.SRV
+	resolved time.Time
+}
+
+func newSRVResolver(addr string, interval time.Duration) *srvResolver {
+	if !strings.HasPrefix(addr, SRVScheme) {
+		return nil
+	}
+	return &srvResolver{name: strings.TrimPrefix(addr, SRVScheme), interval: interval}
+}
+
+// targets returns the addresses to try in order: by priority and, within
+// a priority, in a random order weighted as described in RFC 2782. The
+// records are resolved again once interval has passed, the previous ones
+// are kept if that fails
+func (r *srvResolver) targets() ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.records == nil || time.Since(r.resolved) >= r.interval {
+		_, records, err := net.LookupSRV("", "", r.name)
+		if err != nil && r.records == nil {
+			return nil, err
+		}
+		if err == nil {
+			r.records = records
+			r.resolved = time.Now()
+		}
+	}
+
+	records := append([]*net.SRV(nil), r.records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	addrs := make([]string, 0, len(records))
+	for i := 0; i < len(records); {
+		j := i
+		for j < len(records) && records[j].Priority == records[i].Priority {
+			j++
+		}
+		for _, srv := range weightedOrder(records[i:j]) {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		i = j
+	}
+	return addrs, nil
+}
+
+func weightedOrder(records []*net.SRV) []*net.SRV {
+	records = append([]*net.SRV(nil), records...)
+	out := make([]*net.SRV, 0, len(records))
+	for len(records) > 0 {
+		total := 0
+		for _, srv := range records {
+			total += int(srv.Weight)
+		}
+		k := 0
+		if total > 0 {
+			n := rand.Intn(total + 1)
+			for k = range records {
+				n -= int(records[k].Weight)
+				if n <= 0 {
+					break
+				}
+			}
+		}
+		out = append(out, records[k])
+		records = append(records[:k], records[k+1:]...)
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
@@ -10,6 +11,7 @@ import (
 
 type TCPClient struct {
 	sync.Mutex
+	// host:port, unix:///path/to.sock or srv:// followed by a DNS SRV name
 	Addr            string
 	ConnNum         int
 	ConnectInterval time.Duration
@@ -20,12 +22,40 @@ type TCPClient struct {
 	wg              sync.WaitGroup
 	closeFlag       bool
 
+	// exponential backoff between the attempts, every ConnectInterval if nil
+	Backoff *Backoff
+	ConnEvents
+	retry retry
+
+	// how long SRV records are used before resolving them again
+	ResolveInterval time.Duration
+	srv             *srvResolver
+
+	// tls, enabled by TLSConfig or CertFile and KeyFile, which are the client
+	// certificate. ServerName defaults to the host dialed
+	TLSConfig        *tls.Config
+	CertFile         string
+	KeyFile          string
+	HandshakeTimeout time.Duration
+	tlsConfig        *tls.Config
+
+	// the frames queued while a write is in progress are coalesced into one
+	// writev of up to WriteBatch frames, zero writes them one by one
+	WriteBatch int
+
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+	msgParser         *MsgParser
+
+	// dialer replaces net.Dial over TCP, e.g. for KCP
+	dialer func(addr string) (net.Conn, error)
 }
 
 func (client *TCPClient) Start() {
@@ -60,27 +90,118 @@ func (client *TCPClient) init() {
 		log.Fatal("client is running")
 	}
 
+	if client.ResolveInterval <= 0 {
+		client.ResolveInterval = 30 * time.Second
+	}
+
+	client.tlsConfig = nil
+	if client.TLSConfig != nil || client.CertFile != "" || client.KeyFile != "" {
+		config := new(tls.Config)
+		if client.TLSConfig != nil {
+			config = client.TLSConfig.Clone()
+		}
+		if client.CertFile != "" || client.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(client.CertFile, client.KeyFile)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			config.Certificates = append(config.Certificates, cert)
+		}
+		client.tlsConfig = config
+
+		if client.HandshakeTimeout <= 0 {
+			client.HandshakeTimeout = 10 * time.Second
+			log.Release("invalid HandshakeTimeout, reset to %v", client.HandshakeTimeout)
+		}
+	}
+
 	client.conns = make(ConnSet)
 	client.closeFlag = false
+	client.retry.init(client.ConnectInterval, client.Backoff)
+	client.srv = newSRVResolver(client.Addr, client.ResolveInterval)
 
 	// msg parser
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(client.LenMsgLen, client.MinMsgLen, client.MaxMsgLen)
 	msgParser.SetByteOrder(client.LittleEndian)
+	msgParser.SetFragmentation(client.MaxFragmentedLen)
+	msgParser.SetLenIncludesHeader(client.LenIncludesHeader)
 	client.msgParser = msgParser
 }
 
 func (client *TCPClient) dial() net.Conn {
-	for {
-		conn, err := net.Dial("tcp", client.Addr)
-		if err == nil || client.closeFlag {
+	for failures := 1; ; failures++ {
+		conn, err := client.dialOnce()
+		if err == nil || client.closed() {
 			return conn
 		}
 
 		log.Release("connect to %v error: %v", client.Addr, err)
-		time.Sleep(client.ConnectInterval)
-		continue
+		if client.retry.gaveUp(failures) {
+			if client.OnReconnectFailed != nil {
+				client.OnReconnectFailed(err)
+			}
+			return nil
+		}
+		if !client.retry.wait(failures) {
+			return nil
+		}
+	}
+}
+
+func (client *TCPClient) closed() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.closeFlag
+}
+
+// dialOnce fails over among the SRV targets
+func (client *TCPClient) dialOnce() (net.Conn, error) {
+	if client.srv == nil {
+		return client.dialAddr(client.Addr)
+	}
+
+	addrs, err := client.srv.targets()
+	if err != nil {
+		return nil, err
 	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = client.dialAddr(addr)
+		if err == nil {
+			return conn, nil
+		}
+		log.Debug("connect to %v error: %v", addr, err)
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no SRV targets", Name: client.srv.name}
+	}
+	return nil, err
+}
+
+func (client *TCPClient) dialAddr(addr string) (net.Conn, error) {
+	if client.dialer != nil {
+		return client.dialer(addr)
+	}
+	network, address := splitAddr(addr)
+	conn, err := net.Dial(network, address)
+	if err != nil || client.tlsConfig == nil {
+		return conn, err
+	}
+
+	config := client.tlsConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(client.HandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
 }
 
 func (client *TCPClient) connect() {
@@ -101,7 +222,10 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.msgParser)
+	tcpConn := newTCPConn(conn, client.PendingWriteNum, client.WriteBatch, client.msgParser)
+	if client.OnConnect != nil {
+		client.OnConnect(conn.RemoteAddr())
+	}
 	agent := client.NewAgent(tcpConn)
 	agent.Run()
 
@@ -111,15 +235,20 @@ reconnect:
 	delete(client.conns, conn)
 	client.Unlock()
 	agent.OnClose()
+	if client.OnDisconnect != nil {
+		client.OnDisconnect(conn.RemoteAddr())
+	}
 
-	if client.AutoReconnect {
-		time.Sleep(client.ConnectInterval)
+	if client.AutoReconnect && client.retry.wait(0) {
 		goto reconnect
 	}
 }
 
 func (client *TCPClient) Close() {
 	client.Lock()
+	if !client.closeFlag {
+		client.retry.close()
+	}
 	client.closeFlag = true
 	for conn := range client.conns {
 		conn.Close()
@@ -1,39 +1,42 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 
-	"github.com/czx-lab/leaf/log"
+	"github.com/pires/go-proxyproto"
 )
 
 type ConnSet map[net.Conn]struct{}
 
 type TCPConn struct {
 	sync.Mutex
-	conn      net.Conn
-	writeChan chan []byte
-	closeFlag bool
-	msgParser *MsgParser
+	conn       net.Conn
+	writeQueue *writeQueue[tcpFrame]
+	closeFlag  bool
+	msgParser  *MsgParser
+	throttle   throttle
+	overflow   overflowState
+
+	// the len of the message being read
+	bufMsgLen [maxLenMsgLen]byte
 }
 
-func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPConn {
+// tcpFrame is a queued write, pooled goes back to the pool once b is written
+type tcpFrame struct {
+	b      []byte
+	pooled *[]byte
+}
+
+func newTCPConn(conn net.Conn, pendingWriteNum int, writeBatch int, msgParser *MsgParser) *TCPConn {
 	tcpConn := new(TCPConn)
 	tcpConn.conn = conn
-	tcpConn.writeChan = make(chan []byte, pendingWriteNum)
+	tcpConn.writeQueue = newWriteQueue[tcpFrame](pendingWriteNum)
 	tcpConn.msgParser = msgParser
 
 	go func() {
-		for b := range tcpConn.writeChan {
-			if b == nil {
-				break
-			}
-
-			_, err := conn.Write(b)
-			if err != nil {
-				break
-			}
-		}
+		tcpConn.writeLoop(writeBatch)
 
 		conn.Close()
 		tcpConn.Lock()
@@ -44,12 +47,93 @@ func newTCPConn(conn net.Conn, pendingWriteNum int, msgParser *MsgParser) *TCPCo
 	return tcpConn
 }
 
+// writeLoop writes the queued frames until the connection is closed, the
+// frames already queued are coalesced into a single writev of up to
+// writeBatch frames
+func (tcpConn *TCPConn) writeLoop(writeBatch int) {
+	frames := make([]tcpFrame, 0, max(writeBatch, 1))
+	bufs := make(net.Buffers, 0, cap(frames))
+	for {
+		f, ok := tcpConn.writeQueue.pop()
+		if !ok || f.b == nil {
+			return
+		}
+		frames = append(frames[:0], f)
+		closing := false
+		for len(frames) < writeBatch {
+			f, ok := tcpConn.writeQueue.tryPop()
+			if !ok {
+				break
+			}
+			if f.b == nil {
+				closing = true
+				break
+			}
+			frames = append(frames, f)
+		}
+
+		var err error
+		if len(frames) == 1 {
+			tcpConn.throttle.wait(len(frames[0].b))
+			_, err = tcpConn.conn.Write(frames[0].b)
+		} else {
+			n := 0
+			bufs = bufs[:0]
+			for _, f := range frames {
+				n += len(f.b)
+				bufs = append(bufs, f.b)
+			}
+			tcpConn.throttle.wait(n)
+			// WriteTo consumes the copy
+			b := bufs
+			_, err = b.WriteTo(tcpConn.conn)
+			clear(bufs)
+		}
+		for i := range frames {
+			putBuffer(frames[i].pooled)
+			frames[i] = tcpFrame{}
+		}
+		if err != nil || closing {
+			return
+		}
+	}
+}
+
+// resetOnClose discards unsent data when conn is closed, conn may be wrapped
+// by tls, the PROXY protocol or MuxServer. Other transports are left as they are
+func resetOnClose(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if proxyConn, ok := conn.(*proxyproto.Conn); ok {
+		conn = proxyConn.Raw()
+	}
+	if muxConn, ok := conn.(*muxConn); ok {
+		conn = muxConn.Conn
+	}
+	if conn, ok := conn.(*net.TCPConn); ok {
+		conn.SetLinger(0)
+	}
+}
+
+// SetWriteRate paces the writes to rate bytes per second with bursts of
+// burst bytes, burst defaults to rate. A zero rate is unlimited
+// goroutine safe
+func (tcpConn *TCPConn) SetWriteRate(rate int, burst int) {
+	tcpConn.throttle.set(rate, burst)
+}
+
+// goroutine safe
+func (tcpConn *TCPConn) WriteRate() (rate int, burst int) {
+	return tcpConn.throttle.get()
+}
+
 func (tcpConn *TCPConn) doDestroy() {
-	tcpConn.conn.(*net.TCPConn).SetLinger(0)
+	resetOnClose(tcpConn.conn)
 	tcpConn.conn.Close()
 
 	if !tcpConn.closeFlag {
-		close(tcpConn.writeChan)
+		tcpConn.writeQueue.close()
 		tcpConn.closeFlag = true
 	}
 }
@@ -63,34 +147,67 @@ func (tcpConn *TCPConn) Destroy() {
 
 func (tcpConn *TCPConn) Close() {
 	tcpConn.Lock()
-	defer tcpConn.Unlock()
 	if tcpConn.closeFlag {
+		tcpConn.Unlock()
 		return
 	}
 
-	tcpConn.doWrite(nil)
+	// queued last so that everything written before is flushed
+	e := tcpConn.doWrite(PriorityBulk, tcpFrame{})
 	tcpConn.closeFlag = true
+	tcpConn.Unlock()
+	e.notify()
+}
+
+// SetOverflow replaces the policy applied when a write queue is full, nil
+// closes the connection
+// goroutine safe
+func (tcpConn *TCPConn) SetOverflow(o *Overflow) {
+	tcpConn.Lock()
+	tcpConn.overflow.overflow = o
+	tcpConn.Unlock()
 }
 
-func (tcpConn *TCPConn) doWrite(b []byte) {
-	if len(tcpConn.writeChan) == cap(tcpConn.writeChan) {
-		log.Debug("close conn: channel full")
+func (tcpConn *TCPConn) doWrite(p Priority, f tcpFrame) *overflowEvent {
+	e, destroy := push(tcpConn.writeQueue, &tcpConn.overflow, p, f, f.b == nil, func(f tcpFrame) {
+		putBuffer(f.pooled)
+	})
+	if destroy {
 		tcpConn.doDestroy()
-		return
 	}
-
-	tcpConn.writeChan <- b
+	return e
 }
 
 // b must not be modified by the others goroutines
 func (tcpConn *TCPConn) Write(b []byte) {
+	tcpConn.WritePriority(PriorityNormal, b)
+}
+
+// b must not be modified by the others goroutines
+func (tcpConn *TCPConn) WritePriority(p Priority, b []byte) {
 	tcpConn.Lock()
-	defer tcpConn.Unlock()
 	if tcpConn.closeFlag || b == nil {
+		tcpConn.Unlock()
+		return
+	}
+
+	e := tcpConn.doWrite(validPriority(p), tcpFrame{b: b})
+	tcpConn.Unlock()
+	e.notify()
+}
+
+// writeBuffer writes a frame taken from the pool
+func (tcpConn *TCPConn) writeBuffer(p Priority, b *[]byte) {
+	tcpConn.Lock()
+	if tcpConn.closeFlag {
+		tcpConn.Unlock()
+		putBuffer(b)
 		return
 	}
 
-	tcpConn.doWrite(b)
+	e := tcpConn.doWrite(validPriority(p), tcpFrame{*b, b})
+	tcpConn.Unlock()
+	e.notify()
 }
 
 func (tcpConn *TCPConn) Read(b []byte) (int, error) {
@@ -105,10 +222,21 @@ func (tcpConn *TCPConn) RemoteAddr() net.Addr {
 	return tcpConn.conn.RemoteAddr()
 }
 
+// goroutine not safe
 func (tcpConn *TCPConn) ReadMsg() ([]byte, error) {
-	return tcpConn.msgParser.Read(tcpConn)
+	return tcpConn.msgParser.read(tcpConn, tcpConn.bufMsgLen[:])
+}
+
+// ReadBuffer is ReadMsg with the message in a pooled Buffer
+// goroutine not safe
+func (tcpConn *TCPConn) ReadBuffer() (*Buffer, error) {
+	return tcpConn.msgParser.readBuffer(tcpConn, tcpConn.bufMsgLen[:])
 }
 
 func (tcpConn *TCPConn) WriteMsg(args ...[]byte) error {
 	return tcpConn.msgParser.Write(tcpConn, args...)
 }
+
+func (tcpConn *TCPConn) WriteMsgPriority(p Priority, args ...[]byte) error {
+	return tcpConn.msgParser.write(tcpConn, p, args...)
+}
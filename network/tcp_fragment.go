@@ -0,0 +1,113 @@
+package network
+
+import (
+	"errors"
+	"io"
+)
+
+// with fragmentation enabled every frame starts with a flag byte
+//
+// -----------------------------
+// | len | more | data part |
+// -----------------------------
+//
+// and a message longer than MaxMsgLen is split over several frames, all
+// but the last one with more set to 1
+const (
+	fragmentLast = 0
+	fragmentMore = 1
+)
+
+// SetFragmentation enables splitting messages longer than the frame limit,
+// reassembled messages are limited to maxLen. Zero disables it. Both sides
+// of a connection must agree on it
+// It's dangerous to call the method on reading or writing
+func (p *MsgParser) SetFragmentation(maxLen uint32) {
+	if maxLen > 0 && p.maxMsgLen < 2 {
+		maxLen = 0
+	}
+	p.maxFragmentedLen = maxLen
+}
+
+func (p *MsgParser) readFragments(conn io.Reader, b []byte) ([]byte, error) {
+	var msg []byte
+	for {
+		frame, err := p.readFrame(conn, b)
+		if err != nil {
+			return nil, err
+		}
+		if len(frame) == 0 {
+			return nil, errors.New("fragment flag missing")
+		}
+
+		flag, part := frame[0], frame[1:]
+		if flag != fragmentLast && flag != fragmentMore {
+			return nil, errors.New("invalid fragment flag")
+		}
+		if uint64(len(msg))+uint64(len(part)) > uint64(p.maxFragmentedLen) {
+			return nil, errors.New("fragmented message too long")
+		}
+
+		if msg == nil && flag == fragmentLast {
+			return part, nil
+		}
+		msg = append(msg, part...)
+		if flag == fragmentLast {
+			return msg, nil
+		}
+	}
+}
+
+func (p *MsgParser) writeFragments(conn *TCPConn, pri Priority, msgLen uint32, args [][]byte) error {
+	// check len
+	if msgLen > p.maxFragmentedLen {
+		return errors.New("message too long")
+	} else if msgLen+1 < p.minMsgLen {
+		return errors.New("message too short")
+	}
+
+	partLen := p.maxMsgLen - 1
+
+	// all frames are queued at once so they are never interleaved
+	size := int(msgLen)
+	for remain := msgLen; ; remain -= partLen {
+		size += p.headerLen(min(remain, partLen)+1) + 1
+		if remain <= partLen {
+			break
+		}
+	}
+	buf := getBuffer(size)
+	msg := *buf
+	l := 0
+	arg, off := 0, 0
+	for remain := msgLen; ; {
+		size := min(remain, partLen)
+		remain -= size
+
+		l += p.putMsgLen(msg[l:], size+1)
+		if remain > 0 {
+			msg[l] = fragmentMore
+		} else {
+			msg[l] = fragmentLast
+		}
+		l++
+
+		// copy size bytes from args
+		for end := l + int(size); l < end; {
+			c := copy(msg[l:end], args[arg][off:])
+			l += c
+			off += c
+			if off == len(args[arg]) {
+				arg, off = arg+1, 0
+			}
+		}
+
+		if remain == 0 {
+			break
+		}
+	}
+
+	conn.writeBuffer(pri, buf)
+
+	return nil
+}
@@ -10,13 +10,25 @@ import (
 // --------------
 // | len | data |
 // --------------
+//
+// len is 1, 2, 4 or 8 bytes or a varint, see VarintMsgLen
 type MsgParser struct {
-	lenMsgLen    int
-	minMsgLen    uint32
-	maxMsgLen    uint32
-	littleEndian bool
+	lenMsgLen        int
+	minMsgLen        uint32
+	maxMsgLen        uint32
+	littleEndian     bool
+	maxFragmentedLen uint32
+	// len counts itself, see SetLenIncludesHeader
+	lenIncludesHeader bool
 }
 
+// VarintMsgLen as the LenMsgLen makes len an unsigned varint as encoded by
+// protobuf, 1 to 5 bytes
+const VarintMsgLen = -1
+
+// the bytes of len at most, a varint of a uint64
+const maxLenMsgLen = binary.MaxVarintLen64
+
 func NewMsgParser() *MsgParser {
 	p := new(MsgParser)
 	p.lenMsgLen = 2
@@ -29,7 +41,8 @@ func NewMsgParser() *MsgParser {
 
 // It's dangerous to call the method on reading or writing
 func (p *MsgParser) SetMsgLen(lenMsgLen int, minMsgLen uint32, maxMsgLen uint32) {
-	if lenMsgLen == 1 || lenMsgLen == 2 || lenMsgLen == 4 {
+	switch lenMsgLen {
+	case 1, 2, 4, 8, VarintMsgLen:
 		p.lenMsgLen = lenMsgLen
 	}
 	if minMsgLen != 0 {
@@ -38,16 +51,35 @@ func (p *MsgParser) SetMsgLen(lenMsgLen int, minMsgLen uint32, maxMsgLen uint32)
 	if maxMsgLen != 0 {
 		p.maxMsgLen = maxMsgLen
 	}
+	p.clamp()
+}
 
+// SetLenIncludesHeader makes len count its own bytes in addition to the
+// data, as some gateways do. Both sides of a connection must agree on it
+// It's dangerous to call the method on reading or writing
+func (p *MsgParser) SetLenIncludesHeader(includes bool) {
+	p.lenIncludesHeader = includes
+	p.clamp()
+}
+
+// clamp limits the lengths to what len can hold
+func (p *MsgParser) clamp() {
 	var max uint32
 	switch p.lenMsgLen {
 	case 1:
 		max = math.MaxUint8
 	case 2:
 		max = math.MaxUint16
-	case 4:
+	default:
 		max = math.MaxUint32
 	}
+	if p.lenIncludesHeader {
+		if p.lenMsgLen == VarintMsgLen {
+			max -= binary.MaxVarintLen32
+		} else {
+			max -= uint32(min(p.lenMsgLen, 4))
+		}
+	}
 	if p.minMsgLen > max {
 		p.minMsgLen = max
 	}
@@ -62,58 +94,216 @@ func (p *MsgParser) SetByteOrder(littleEndian bool) {
 }
 
 // goroutine safe
-func (p *MsgParser) Read(conn *TCPConn) ([]byte, error) {
-	var b [4]byte
-	bufMsgLen := b[:p.lenMsgLen]
+func (p *MsgParser) Read(conn io.Reader) ([]byte, error) {
+	var b [maxLenMsgLen]byte
+	return p.read(conn, b[:])
+}
 
-	// read len
-	if _, err := io.ReadFull(conn, bufMsgLen); err != nil {
+// read reads the len into b, the data is returned in a new buffer owned by
+// the caller
+func (p *MsgParser) read(conn io.Reader, b []byte) ([]byte, error) {
+	if p.maxFragmentedLen > 0 {
+		return p.readFragments(conn, b)
+	}
+	return p.readFrame(conn, b)
+}
+
+func (p *MsgParser) readFrame(conn io.Reader, b []byte) ([]byte, error) {
+	msgLen, err := p.readFrameLen(conn, b)
+	if err != nil {
 		return nil, err
 	}
 
-	// parse len
-	var msgLen uint32
+	// data
+	msgData := make([]byte, msgLen)
+	if _, err := io.ReadFull(conn, msgData); err != nil {
+		return nil, err
+	}
+
+	return msgData, nil
+}
+
+// readBuffer is read with the data in a pooled Buffer, reassembled
+// fragments are not pooled
+func (p *MsgParser) readBuffer(conn io.Reader, b []byte) (*Buffer, error) {
+	if p.maxFragmentedLen > 0 {
+		data, err := p.readFragments(conn, b)
+		if err != nil {
+			return nil, err
+		}
+		return &Buffer{data}, nil
+	}
+
+	msgLen, err := p.readFrameLen(conn, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// data
+	buf := getReadBuffer(int(msgLen))
+	if _, err := io.ReadFull(conn, buf.data); err != nil {
+		buf.Release()
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// readFrameLen reads and checks len
+func (p *MsgParser) readFrameLen(conn io.Reader, b []byte) (uint32, error) {
+	// read len
+	msgLen, err := p.readMsgLen(conn, b)
+	if err != nil {
+		return 0, err
+	}
+
+	// check len
+	if msgLen > uint64(p.maxMsgLen) {
+		return 0, errors.New("message too long")
+	} else if msgLen < uint64(p.minMsgLen) {
+		return 0, errors.New("message too short")
+	}
+	return uint32(msgLen), nil
+}
+
+// readMsgLen reads len into b and returns the length of the data
+func (p *MsgParser) readMsgLen(conn io.Reader, b []byte) (uint64, error) {
+	var msgLen uint64
+	var n int
+	if p.lenMsgLen == VarintMsgLen {
+		for {
+			if n == binary.MaxVarintLen64 {
+				return 0, errors.New("invalid message length")
+			}
+			if _, err := io.ReadFull(conn, b[n:n+1]); err != nil {
+				if err == io.EOF && n > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			n++
+			if b[n-1] < 0x80 {
+				break
+			}
+		}
+		var m int
+		msgLen, m = binary.Uvarint(b[:n])
+		if m <= 0 {
+			return 0, errors.New("invalid message length")
+		}
+	} else {
+		n = p.lenMsgLen
+		if _, err := io.ReadFull(conn, b[:n]); err != nil {
+			return 0, err
+		}
+		msgLen = p.getMsgLen(b[:n])
+	}
+
+	if p.lenIncludesHeader {
+		if msgLen < uint64(n) {
+			return 0, errors.New("message too short")
+		}
+		msgLen -= uint64(n)
+	}
+	return msgLen, nil
+}
+
+func (p *MsgParser) getMsgLen(b []byte) uint64 {
 	switch p.lenMsgLen {
 	case 1:
-		msgLen = uint32(bufMsgLen[0])
+		return uint64(b[0])
 	case 2:
 		if p.littleEndian {
-			msgLen = uint32(binary.LittleEndian.Uint16(bufMsgLen))
-		} else {
-			msgLen = uint32(binary.BigEndian.Uint16(bufMsgLen))
+			return uint64(binary.LittleEndian.Uint16(b))
 		}
+		return uint64(binary.BigEndian.Uint16(b))
 	case 4:
 		if p.littleEndian {
-			msgLen = binary.LittleEndian.Uint32(bufMsgLen)
-		} else {
-			msgLen = binary.BigEndian.Uint32(bufMsgLen)
+			return uint64(binary.LittleEndian.Uint32(b))
+		}
+		return uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		if p.littleEndian {
+			return binary.LittleEndian.Uint64(b)
 		}
+		return binary.BigEndian.Uint64(b)
 	}
+	return 0
+}
 
-	// check len
-	if msgLen > p.maxMsgLen {
-		return nil, errors.New("message too long")
-	} else if msgLen < p.minMsgLen {
-		return nil, errors.New("message too short")
+// headerLen returns the bytes of len for msgLen bytes of data
+func (p *MsgParser) headerLen(msgLen uint32) int {
+	if p.lenMsgLen != VarintMsgLen {
+		return p.lenMsgLen
+	}
+	n := varintLen(uint64(msgLen))
+	// counting itself may make it longer
+	if p.lenIncludesHeader && varintLen(uint64(msgLen)+uint64(n)) > n {
+		n++
 	}
+	return n
+}
 
-	// data
-	msgData := make([]byte, msgLen)
-	if _, err := io.ReadFull(conn, msgData); err != nil {
-		return nil, err
+func varintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
 	}
+	return n
+}
 
-	return msgData, nil
+// putMsgLen writes len of msgLen bytes of data and returns its bytes
+func (p *MsgParser) putMsgLen(b []byte, msgLen uint32) int {
+	n := p.headerLen(msgLen)
+	v := uint64(msgLen)
+	if p.lenIncludesHeader {
+		v += uint64(n)
+	}
+
+	switch p.lenMsgLen {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		if p.littleEndian {
+			binary.LittleEndian.PutUint16(b, uint16(v))
+		} else {
+			binary.BigEndian.PutUint16(b, uint16(v))
+		}
+	case 4:
+		if p.littleEndian {
+			binary.LittleEndian.PutUint32(b, uint32(v))
+		} else {
+			binary.BigEndian.PutUint32(b, uint32(v))
+		}
+	case 8:
+		if p.littleEndian {
+			binary.LittleEndian.PutUint64(b, v)
+		} else {
+			binary.BigEndian.PutUint64(b, v)
+		}
+	case VarintMsgLen:
+		binary.PutUvarint(b, v)
+	}
+	return n
 }
 
 // goroutine safe
 func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
+	return p.write(conn, PriorityNormal, args...)
+}
+
+func (p *MsgParser) write(conn *TCPConn, pri Priority, args ...[]byte) error {
 	// get len
 	var msgLen uint32
 	for i := 0; i < len(args); i++ {
 		msgLen += uint32(len(args[i]))
 	}
 
+	if p.maxFragmentedLen > 0 {
+		return p.writeFragments(conn, pri, msgLen, args)
+	}
+
 	// check len
 	if msgLen > p.maxMsgLen {
 		return errors.New("message too long")
@@ -121,34 +311,19 @@ func (p *MsgParser) Write(conn *TCPConn, args ...[]byte) error {
 		return errors.New("message too short")
 	}
 
-	msg := make([]byte, uint32(p.lenMsgLen)+msgLen)
+	buf := getBuffer(p.headerLen(msgLen) + int(msgLen))
+	msg := *buf
 
 	// write len
-	switch p.lenMsgLen {
-	case 1:
-		msg[0] = byte(msgLen)
-	case 2:
-		if p.littleEndian {
-			binary.LittleEndian.PutUint16(msg, uint16(msgLen))
-		} else {
-			binary.BigEndian.PutUint16(msg, uint16(msgLen))
-		}
-	case 4:
-		if p.littleEndian {
-			binary.LittleEndian.PutUint32(msg, msgLen)
-		} else {
-			binary.BigEndian.PutUint32(msg, msgLen)
-		}
-	}
+	l := p.putMsgLen(msg, msgLen)
 
 	// write data
-	l := p.lenMsgLen
 	for i := 0; i < len(args); i++ {
 		copy(msg[l:], args[i])
 		l += len(args[i])
 	}
 
-	conn.Write(msg)
+	conn.writeBuffer(pri, buf)
 
 	return nil
 }
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
@@ -9,6 +10,7 @@ import (
 )
 
 type TCPServer struct {
+	// host:port or unix:///path/to.sock
 	Addr            string
 	MaxConnNum      int
 	PendingWriteNum int
@@ -19,12 +21,36 @@ type TCPServer struct {
 	wgLn            sync.WaitGroup
 	wgConns         sync.WaitGroup
 
+	// tls, enabled by CertFile and KeyFile or TLS
+	CertFile string
+	KeyFile  string
+	// reloadable certificate, session resumption and client certificates,
+	// CertFile and KeyFile are ignored
+	TLS *TLSConfig
+	// connections not finishing the tls handshake in time are closed
+	HandshakeTimeout time.Duration
+	tls              *TLSConfig
+
+	// behind a load balancer, read before the tls handshake
+	ProxyProtocol *ProxyProtocol
+
+	// the frames queued while a write is in progress are coalesced into one
+	// writev of up to WriteBatch frames, zero writes them one by one
+	WriteBatch int
+
 	// msg parser
 	LenMsgLen    int
 	MinMsgLen    uint32
 	MaxMsgLen    uint32
 	LittleEndian bool
-	msgParser    *MsgParser
+	// messages longer than MaxMsgLen are fragmented, zero disables it
+	MaxFragmentedLen uint32
+	// the length in the header counts the header itself
+	LenIncludesHeader bool
+	msgParser         *MsgParser
+
+	// listen replaces Listen, e.g. for KCP or MuxServer
+	listen func(addr string) (net.Listener, error)
 }
 
 func (server *TCPServer) Start() {
@@ -33,7 +59,11 @@ func (server *TCPServer) Start() {
 }
 
 func (server *TCPServer) init() {
-	ln, err := net.Listen("tcp", server.Addr)
+	listen := server.listen
+	if listen == nil {
+		listen = Listen
+	}
+	ln, err := listen(server.Addr)
 	if err != nil {
 		log.Fatal("%v", err)
 	}
@@ -50,6 +80,32 @@ func (server *TCPServer) init() {
 		log.Fatal("NewAgent must not be nil")
 	}
 
+	if server.ProxyProtocol != nil {
+		ln, err = server.ProxyProtocol.listener(ln)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+	}
+
+	server.tls = server.TLS
+	if server.tls == nil && (server.CertFile != "" || server.KeyFile != "") {
+		server.tls = &TLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile}
+	}
+	if server.tls != nil {
+		config, err := server.tls.open()
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		config = config.Clone()
+		config.NextProtos = nil
+		ln = tls.NewListener(ln, config)
+
+		if server.HandshakeTimeout <= 0 {
+			server.HandshakeTimeout = 10 * time.Second
+			log.Release("invalid HandshakeTimeout, reset to %v", server.HandshakeTimeout)
+		}
+	}
+
 	server.ln = ln
 	server.conns = make(ConnSet)
 
@@ -57,6 +113,8 @@ func (server *TCPServer) init() {
 	msgParser := NewMsgParser()
 	msgParser.SetMsgLen(server.LenMsgLen, server.MinMsgLen, server.MaxMsgLen)
 	msgParser.SetByteOrder(server.LittleEndian)
+	msgParser.SetFragmentation(server.MaxFragmentedLen)
+	msgParser.SetLenIncludesHeader(server.LenIncludesHeader)
 	server.msgParser = msgParser
 }
 
@@ -97,9 +155,19 @@ func (server *TCPServer) run() {
 
 		server.wgConns.Add(1)
 
-		tcpConn := newTCPConn(conn, server.PendingWriteNum, server.msgParser)
-		agent := server.NewAgent(tcpConn)
 		go func() {
+			if err := server.handshake(conn); err != nil {
+				log.Debug("tls handshake error: %v", err)
+				conn.Close()
+				server.mutexConns.Lock()
+				delete(server.conns, conn)
+				server.mutexConns.Unlock()
+				server.wgConns.Done()
+				return
+			}
+
+			tcpConn := newTCPConn(conn, server.PendingWriteNum, server.WriteBatch, server.msgParser)
+			agent := server.NewAgent(tcpConn)
 			agent.Run()
 
 			// cleanup
@@ -114,6 +182,39 @@ func (server *TCPServer) run() {
 	}
 }
 
+// handshake runs the tls handshake off the accept loop, so that slow clients
+// don't hold up the others
+func (server *TCPServer) handshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(server.HandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	return tlsConn.SetDeadline(time.Time{})
+}
+
+// Drain stops accepting, waits up to timeout for the connections to be
+// closed by their agents and then closes the remaining ones
+func (server *TCPServer) Drain(timeout time.Duration) {
+	server.ln.Close()
+	server.wgLn.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		server.wgConns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+
+	server.Close()
+}
+
 func (server *TCPServer) Close() {
 	server.ln.Close()
 	server.wgLn.Wait()
@@ -125,4 +226,9 @@ func (server *TCPServer) Close() {
 	server.conns = nil
 	server.mutexConns.Unlock()
 	server.wgConns.Wait()
+
+	if server.tls != nil {
+		server.tls.close()
+		server.tls = nil
+	}
 }
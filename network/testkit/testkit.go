@@ -0,0 +1,354 @@
+// Package testkit feeds malformed, truncated and oversized input through
+// the framing and the processors and checks that they fail cleanly. Run it
+// from the tests of custom processors:
+//
+//	func TestProcessor(t *testing.T) {
+//		testkit.Processor(t, p, &msg.Hello{Name: "leaf"})
+//	}
+package testkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+// ParserConfig mirrors the settings of network.MsgParser
+type ParserConfig struct {
+	LenMsgLen        int
+	MinMsgLen        uint32
+	MaxMsgLen        uint32
+	LittleEndian     bool
+	MaxFragmentedLen uint32
+	// see MsgParser.SetLenIncludesHeader
+	LenIncludesHeader bool
+}
+
+// parser applies the MsgParser defaults to cfg
+func (cfg *ParserConfig) parser() *network.MsgParser {
+	switch cfg.LenMsgLen {
+	case 1, 4, 8, network.VarintMsgLen:
+	default:
+		cfg.LenMsgLen = 2
+	}
+	if cfg.MinMsgLen == 0 {
+		cfg.MinMsgLen = 1
+	}
+	if cfg.MaxMsgLen == 0 {
+		cfg.MaxMsgLen = 4096
+	}
+	if limit := cfg.limit(); uint64(cfg.MaxMsgLen) > limit {
+		cfg.MaxMsgLen = uint32(limit)
+	}
+	if cfg.MinMsgLen > cfg.MaxMsgLen {
+		cfg.MinMsgLen = cfg.MaxMsgLen
+	}
+
+	p := network.NewMsgParser()
+	p.SetMsgLen(cfg.LenMsgLen, cfg.MinMsgLen, cfg.MaxMsgLen)
+	p.SetByteOrder(cfg.LittleEndian)
+	p.SetFragmentation(cfg.MaxFragmentedLen)
+	p.SetLenIncludesHeader(cfg.LenIncludesHeader)
+	return p
+}
+
+// limit returns the longest data the length prefix can hold
+func (cfg *ParserConfig) limit() uint64 {
+	limit := uint64(1<<32 - 1)
+	if cfg.LenMsgLen < 4 && cfg.LenMsgLen != network.VarintMsgLen {
+		limit = uint64(1)<<(8*cfg.LenMsgLen) - 1
+	}
+	if cfg.LenIncludesHeader {
+		if cfg.LenMsgLen == network.VarintMsgLen {
+			limit -= binary.MaxVarintLen32
+		} else {
+			limit -= uint64(min(cfg.LenMsgLen, 4))
+		}
+	}
+	return limit
+}
+
+// header encodes a length prefix of n bytes of data, n is truncated to the
+// prefix size
+func (cfg *ParserConfig) header(n uint64) []byte {
+	if cfg.LenMsgLen == network.VarintMsgLen {
+		if cfg.LenIncludesHeader {
+			m := len(binary.AppendUvarint(nil, n))
+			if len(binary.AppendUvarint(nil, n+uint64(m))) > m {
+				m++
+			}
+			n += uint64(m)
+		}
+		return binary.AppendUvarint(nil, n)
+	}
+
+	if cfg.LenIncludesHeader {
+		n += uint64(cfg.LenMsgLen)
+	}
+	b := make([]byte, cfg.LenMsgLen)
+	switch cfg.LenMsgLen {
+	case 1:
+		b[0] = byte(n)
+	case 2:
+		if cfg.LittleEndian {
+			binary.LittleEndian.PutUint16(b, uint16(n))
+		} else {
+			binary.BigEndian.PutUint16(b, uint16(n))
+		}
+	case 4:
+		if cfg.LittleEndian {
+			binary.LittleEndian.PutUint32(b, uint32(n))
+		} else {
+			binary.BigEndian.PutUint32(b, uint32(n))
+		}
+	case 8:
+		if cfg.LittleEndian {
+			binary.LittleEndian.PutUint64(b, n)
+		} else {
+			binary.BigEndian.PutUint64(b, n)
+		}
+	}
+	return b
+}
+
+// truncatedHeader returns a length prefix missing its last byte
+func (cfg *ParserConfig) truncatedHeader(n uint64) []byte {
+	if cfg.LenMsgLen == network.VarintMsgLen {
+		// a continuation byte
+		return []byte{0x80}
+	}
+	return cfg.header(n)[:cfg.LenMsgLen-1]
+}
+
+type parserCase struct {
+	name  string
+	input []byte
+	ok    bool
+	want  []byte
+}
+
+func (cfg *ParserConfig) frame(body []byte) []byte {
+	return append(cfg.header(uint64(len(body))), body...)
+}
+
+func (cfg *ParserConfig) cases() []parserCase {
+	body := bytes.Repeat([]byte{0xa5}, int(cfg.MinMsgLen))
+	want := body
+	if cfg.MaxFragmentedLen > 0 {
+		// flag byte, last fragment
+		body = append([]byte{0}, body...)
+	}
+	frame := cfg.frame(body)
+
+	cs := []parserCase{
+		{"valid", frame, true, want},
+		{"empty", nil, false, nil},
+		{"truncated header", cfg.truncatedHeader(uint64(len(body))), false, nil},
+		{"truncated body", frame[:len(frame)-1], false, nil},
+	}
+	if cfg.MaxFragmentedLen > 0 {
+		part := bytes.Repeat([]byte{0x5a}, int(cfg.MaxMsgLen-1))
+		more := cfg.frame(append([]byte{1}, part...))
+		cs = append(cs,
+			parserCase{"fragmented", append(more, frame...), true, append(append([]byte(nil), part...), want...)},
+			parserCase{"truncated fragments", more, false, nil},
+			parserCase{"invalid fragment flag", cfg.frame(append([]byte{2}, want...)), false, nil})
+		n := int(cfg.MaxFragmentedLen/(cfg.MaxMsgLen-1)) + 2
+		cs = append(cs, parserCase{"fragmented too long",
+			append(bytes.Repeat(more, n), frame...), false, nil})
+	}
+	if cfg.MinMsgLen > 0 {
+		cs = append(cs, parserCase{"too short",
+			append(cfg.header(uint64(cfg.MinMsgLen-1)), body...), false, nil})
+	}
+	if cfg.LenMsgLen == network.VarintMsgLen {
+		cs = append(cs, parserCase{"varint overflow",
+			bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64+1), false, nil})
+	}
+	if cfg.LenIncludesHeader {
+		// a zero length
+		cs = append(cs, parserCase{"shorter than header",
+			make([]byte, max(cfg.LenMsgLen, 1)), false, nil})
+	}
+	if uint64(cfg.MaxMsgLen) < cfg.limit() {
+		cs = append(cs, parserCase{"too long",
+			append(cfg.header(uint64(cfg.MaxMsgLen)+1), body...), false, nil})
+	}
+	return cs
+}
+
+// Parser checks that MsgParser rejects bad frames with an error and without
+// reading past the frame. Zero fields of cfg take the MsgParser defaults
+func Parser(t testing.TB, cfg ParserConfig) {
+	t.Helper()
+
+	p := cfg.parser()
+	for _, c := range cfg.cases() {
+		data, err := readFrame(p, c.input)
+		if c.ok {
+			if err != nil {
+				t.Errorf("parser %v: unexpected error: %v", c.name, err)
+			} else if !bytes.Equal(data, c.want) {
+				t.Errorf("parser %v: got %x, want %x", c.name, data, c.want)
+			}
+		} else if err == nil {
+			t.Errorf("parser %v: no error for %x", c.name, c.input)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, r.Intn(64))
+		r.Read(b)
+		readFrame(p, b)
+	}
+}
+
+func readFrame(p *network.MsgParser, input []byte) ([]byte, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("parser panics on %x: %v", input, r))
+		}
+	}()
+	return p.Read(bytes.NewReader(input))
+}
+
+// Processor checks that p round-trips msgs, rejects unregistered messages
+// and returns errors instead of panicking on corrupted input. msgs are
+// registered messages, Route is never called
+func Processor(t testing.TB, p network.Processor, msgs ...interface{}) {
+	t.Helper()
+
+	// round trip
+	var frames [][]byte
+	for _, msg := range msgs {
+		data, err := marshal(p, msg)
+		if err != nil {
+			t.Errorf("processor: marshal %T: %v", msg, err)
+			continue
+		}
+		frames = append(frames, data)
+
+		got, err := unmarshal(p, data)
+		if err != nil {
+			t.Errorf("processor: unmarshal %T: %v", msg, err)
+			continue
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(msg) {
+			t.Errorf("processor: unmarshal %T: got %T", msg, got)
+			continue
+		}
+		again, err := marshal(p, got)
+		if err != nil || !bytes.Equal(again, data) {
+			t.Errorf("processor: %T does not round trip: %x != %x", msg, again, data)
+		}
+	}
+
+	// unregistered
+	if _, err := marshal(p, &unregistered{}); err == nil {
+		t.Errorf("processor: marshal of an unregistered message succeeds")
+	}
+	if _, err := marshal(p, nil); err == nil {
+		t.Errorf("processor: marshal of nil succeeds")
+	}
+
+	// corrupted input, errors are fine as long as nothing panics
+	unmarshal(p, nil)
+	unmarshal(p, []byte{})
+	r := rand.New(rand.NewSource(1))
+	for _, data := range frames {
+		for _, m := range Mutate(r, data, 200) {
+			unmarshal(p, m)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, r.Intn(64))
+		r.Read(b)
+		unmarshal(p, b)
+	}
+}
+
+type unregistered struct{}
+
+func marshal(p network.Processor, msg interface{}) ([]byte, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("processor panics marshaling %T: %v", msg, r))
+		}
+	}()
+
+	parts, err := p.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(parts, nil), nil
+}
+
+func unmarshal(p network.Processor, data []byte) (interface{}, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("processor panics on %x: %v", data, r))
+		}
+	}()
+	return p.Unmarshal(data)
+}
+
+// Mutate returns n corrupted variants of data: truncations, bit flips,
+// inserted and removed bytes and oversized copies
+func Mutate(r *rand.Rand, data []byte, n int) [][]byte {
+	out := make([][]byte, 0, n)
+	for len(out) < n {
+		b := append([]byte(nil), data...)
+		switch r.Intn(5) {
+		case 0:
+			if len(b) > 0 {
+				b = b[:r.Intn(len(b))]
+			}
+		case 1:
+			if len(b) > 0 {
+				b[r.Intn(len(b))] ^= 1 << uint(r.Intn(8))
+			}
+		case 2:
+			i := r.Intn(len(b) + 1)
+			b = append(b[:i], append([]byte{byte(r.Intn(256))}, b[i:]...)...)
+		case 3:
+			if len(b) > 0 {
+				i := r.Intn(len(b))
+				b = append(b[:i], b[i+1:]...)
+			}
+		case 4:
+			b = bytes.Repeat(b, 2+r.Intn(64))
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// FuzzProcessor runs p.Unmarshal under the native fuzzer, seeded with the
+// encodings of msgs
+func FuzzProcessor(f *testing.F, p network.Processor, msgs ...interface{}) {
+	for _, msg := range msgs {
+		if data, err := marshal(p, msg); err == nil {
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		unmarshal(p, data)
+	})
+}
+
+// FuzzParser runs MsgParser.Read under the native fuzzer
+func FuzzParser(f *testing.F, cfg ParserConfig) {
+	p := cfg.parser()
+	for _, c := range cfg.cases() {
+		f.Add(c.input)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readFrame(p, data)
+	})
+}
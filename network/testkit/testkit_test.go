@@ -0,0 +1,60 @@
+package testkit_test
+
+import (
+	"testing"
+
+	"github.com/czx-lab/leaf/network"
+	"github.com/czx-lab/leaf/network/json"
+	"github.com/czx-lab/leaf/network/protobuf"
+	"github.com/czx-lab/leaf/network/protobuf/extend"
+	"github.com/czx-lab/leaf/network/testkit"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestParser(t *testing.T) {
+	testkit.Parser(t, testkit.ParserConfig{})
+	testkit.Parser(t, testkit.ParserConfig{LenMsgLen: 1, MinMsgLen: 4, MaxMsgLen: 16})
+	testkit.Parser(t, testkit.ParserConfig{LenMsgLen: 4, MaxMsgLen: 1 << 20, LittleEndian: true})
+	testkit.Parser(t, testkit.ParserConfig{MaxMsgLen: 16, MaxFragmentedLen: 100})
+	testkit.Parser(t, testkit.ParserConfig{LenMsgLen: 8, LenIncludesHeader: true})
+	testkit.Parser(t, testkit.ParserConfig{LenMsgLen: network.VarintMsgLen, MinMsgLen: 200, MaxMsgLen: 1 << 20})
+	testkit.Parser(t, testkit.ParserConfig{LenMsgLen: network.VarintMsgLen, MaxMsgLen: 200, MaxFragmentedLen: 1000, LenIncludesHeader: true})
+}
+
+type Hello struct {
+	Name string
+}
+
+func TestJSON(t *testing.T) {
+	p := json.NewProcessor()
+	p.Register(&Hello{})
+	testkit.Processor(t, p, &Hello{Name: "leaf"})
+}
+
+func TestProtobuf(t *testing.T) {
+	p := protobuf.NewProcessor()
+	p.Register(&wrapperspb.StringValue{})
+	p.Register(&wrapperspb.Int64Value{})
+	testkit.Processor(t, p, wrapperspb.String("leaf"), wrapperspb.Int64(-1))
+}
+
+func TestProtobufExtend(t *testing.T) {
+	p := extend.NewProcessor()
+	p.Register(1, &wrapperspb.StringValue{})
+	testkit.Processor(t, p, wrapperspb.String("leaf"))
+}
+
+func TestProtobufExtendWideID(t *testing.T) {
+	p := extend.NewProcessor()
+	p.SetIDWidth(network.IDWidth32)
+	p.Register(70000, &wrapperspb.StringValue{})
+	testkit.Processor(t, p, wrapperspb.String("leaf"))
+
+	data, err := p.Marshal(wrapperspb.String("leaf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, _ := network.IDWidth32.Read(data[0], false); id != 70000 {
+		t.Fatalf("id %v, want 70000", id)
+	}
+}
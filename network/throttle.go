@@ -0,0 +1,57 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle paces the writes of a connection to rate bytes per second, up to
+// burst bytes at once. A zero rate is unlimited
+type throttle struct {
+	mutex  sync.Mutex
+	rate   int
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func (t *throttle) set(rate int, burst int) {
+	if burst <= 0 {
+		burst = rate
+	}
+
+	t.mutex.Lock()
+	t.rate = rate
+	t.burst = burst
+	t.tokens = float64(burst)
+	t.last = time.Now()
+	t.mutex.Unlock()
+}
+
+func (t *throttle) get() (rate int, burst int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.rate, t.burst
+}
+
+// wait blocks until n bytes may be written, a write longer than burst waits
+// for the bytes over it
+func (t *throttle) wait(n int) {
+	t.mutex.Lock()
+	if t.rate <= 0 {
+		t.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	t.tokens = min(float64(t.burst), t.tokens+now.Sub(t.last).Seconds()*float64(t.rate))
+	t.last = now
+	t.tokens -= float64(n)
+
+	var d time.Duration
+	if t.tokens < 0 {
+		d = time.Duration(-t.tokens / float64(t.rate) * float64(time.Second))
+	}
+	t.mutex.Unlock()
+
+	time.Sleep(d)
+}
@@ -0,0 +1,208 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// TLSConfig serves a certificate reloaded from disk, existing connections keep
+// the certificate they were established with. It can be shared by servers
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// how often the files are checked for changes, zero only reloads them on
+	// Reload
+	ReloadInterval time.Duration
+	// clients must present a certificate signed by one of these CAs (PEM)
+	ClientCAFile string
+
+	// session resumption
+	SessionTicketsDisabled bool
+	// how often a new session ticket key is used, zero lets crypto/tls
+	// rotate its own keys
+	TicketKeyRotation time.Duration
+	// how many keys decrypt tickets, the newest encrypts them
+	TicketKeys int
+
+	mutex   sync.Mutex
+	ref     int
+	cert    atomic.Pointer[tls.Certificate]
+	modTime time.Time
+	config  *tls.Config
+	keys    [][32]byte
+	closeCh chan struct{}
+}
+
+// open loads the certificate and starts reloading it, open and close are
+// paired by the servers
+func (c *TLSConfig) open() (*tls.Config, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ref > 0 {
+		c.ref++
+		return c.config, nil
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.config = &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.cert.Load(), nil
+		},
+		SessionTicketsDisabled: c.SessionTicketsDisabled,
+	}
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.config.ClientCAs = pool
+		c.config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	c.keys = nil
+	if !c.SessionTicketsDisabled && c.TicketKeyRotation > 0 {
+		if c.TicketKeys <= 0 {
+			c.TicketKeys = 3
+			log.Release("invalid TicketKeys, reset to %v", c.TicketKeys)
+		}
+		if err := c.rotateKey(); err != nil {
+			return nil, err
+		}
+	}
+	c.closeCh = make(chan struct{})
+	if c.ReloadInterval > 0 || !c.SessionTicketsDisabled && c.TicketKeyRotation > 0 {
+		go c.run(c.closeCh)
+	}
+	c.ref++
+	return c.config, nil
+}
+
+func (c *TLSConfig) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ref--
+	if c.ref == 0 {
+		close(c.closeCh)
+	}
+}
+
+func (c *TLSConfig) run(closeCh chan struct{}) {
+	var reload, rotate <-chan time.Time
+	if c.ReloadInterval > 0 {
+		t := time.NewTicker(c.ReloadInterval)
+		defer t.Stop()
+		reload = t.C
+	}
+	if !c.SessionTicketsDisabled && c.TicketKeyRotation > 0 {
+		t := time.NewTicker(c.TicketKeyRotation)
+		defer t.Stop()
+		rotate = t.C
+	}
+
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-reload:
+			if err := c.reloadIfModified(); err != nil {
+				log.Error("reload certificate error: %v", err)
+			}
+		case <-rotate:
+			c.mutex.Lock()
+			err := c.rotateKey()
+			c.mutex.Unlock()
+			if err != nil {
+				log.Error("rotate session ticket key error: %v", err)
+			}
+		}
+	}
+}
+
+// Reload loads the certificate again. goroutine safe
+func (c *TLSConfig) Reload() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.load()
+}
+
+func (c *TLSConfig) reloadIfModified() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	if !modTime.After(c.modTime) {
+		return nil
+	}
+	return c.load()
+}
+
+func (c *TLSConfig) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{c.CertFile, c.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (c *TLSConfig) load() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	if c.cert.Swap(&cert) != nil {
+		log.Release("certificate %v reloaded", c.CertFile)
+	}
+	c.modTime = modTime
+	return nil
+}
+
+func (c *TLSConfig) rotateKey() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+
+	c.keys = append([][32]byte{key}, c.keys...)
+	if len(c.keys) > c.TicketKeys {
+		c.keys = c.keys[:c.TicketKeys]
+	}
+	c.config.SetSessionTicketKeys(c.keys)
+	return nil
+}
+
+func loadCertPool(name string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates in " + name)
+	}
+	return pool, nil
+}
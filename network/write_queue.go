@@ -0,0 +1,105 @@
+package network
+
+import "time"
+
+// Priority classes of outgoing messages, a connection always sends the
+// queued messages of a higher class first
+type Priority int
+
+const (
+	// kick notices, transaction results
+	PriorityControl Priority = iota
+	// the default, e.g. chat
+	PriorityNormal
+	// bulk state such as movement updates
+	PriorityBulk
+
+	numPriority = 3
+)
+
+// writeQueue holds one bounded queue per priority class
+type writeQueue[T any] [numPriority]chan T
+
+func newWriteQueue[T any](pendingWriteNum int) *writeQueue[T] {
+	q := new(writeQueue[T])
+	for i := range q {
+		q[i] = make(chan T, pendingWriteNum)
+	}
+	return q
+}
+
+func (q *writeQueue[T]) full(p Priority) bool {
+	return len(q[p]) == cap(q[p])
+}
+
+func (q *writeQueue[T]) push(p Priority, b T) {
+	q[p] <- b
+}
+
+func (q *writeQueue[T]) close() {
+	for i := range q {
+		close(q[i])
+	}
+}
+
+// pop blocks until a message is available, ok is false once the queue is
+// closed
+func (q *writeQueue[T]) pop() (b T, ok bool) {
+	if b, ok = q.tryPop(); ok {
+		return
+	}
+
+	select {
+	case b, ok = <-q[PriorityControl]:
+	case b, ok = <-q[PriorityNormal]:
+	case b, ok = <-q[PriorityBulk]:
+	}
+	return
+}
+
+// tryPop doesn't block, ok is false if no message is queued or the queue is
+// closed
+func (q *writeQueue[T]) tryPop() (b T, ok bool) {
+	for i := range q {
+		select {
+		case b, ok = <-q[i]:
+			return
+		default:
+		}
+	}
+	return
+}
+
+// drop removes the oldest message of p without blocking
+func (q *writeQueue[T]) drop(p Priority) (b T, ok bool) {
+	select {
+	case b, ok = <-q[p]:
+	default:
+	}
+	return
+}
+
+// pushTimeout returns false if b couldn't be queued within timeout
+func (q *writeQueue[T]) pushTimeout(p Priority, b T, timeout time.Duration) bool {
+	select {
+	case q[p] <- b:
+		return true
+	default:
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case q[p] <- b:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func validPriority(p Priority) Priority {
+	if p < 0 || p >= numPriority {
+		return PriorityNormal
+	}
+	return p
+}
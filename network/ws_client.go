@@ -17,11 +17,22 @@ type WSClient struct {
 	MaxMsgLen        uint32
 	HandshakeTimeout time.Duration
 	AutoReconnect    bool
-	NewAgent         func(*WSConn) Agent
-	dialer           websocket.Dialer
-	conns            WebsocketConnSet
-	wg               sync.WaitGroup
-	closeFlag        bool
+	// send text frames unless a message asks for binary
+	TextFrames bool
+	// permessage-deflate, off if nil
+	Compression *WSCompression
+	// asked in order of preference, see WSConn.Subprotocol
+	Subprotocols []string
+	NewAgent     func(*WSConn) Agent
+	dialer       websocket.Dialer
+	conns        WebsocketConnSet
+	wg           sync.WaitGroup
+	closeFlag    bool
+
+	// exponential backoff between the attempts, every ConnectInterval if nil
+	Backoff *Backoff
+	ConnEvents
+	retry retry
 }
 
 func (client *WSClient) Start() {
@@ -66,24 +77,40 @@ func (client *WSClient) init() {
 
 	client.conns = make(WebsocketConnSet)
 	client.closeFlag = false
+	client.retry.init(client.ConnectInterval, client.Backoff)
 	client.dialer = websocket.Dialer{
-		HandshakeTimeout: client.HandshakeTimeout,
+		HandshakeTimeout:  client.HandshakeTimeout,
+		EnableCompression: client.Compression != nil,
+		Subprotocols:      client.Subprotocols,
 	}
 }
 
 func (client *WSClient) dial() *websocket.Conn {
-	for {
+	for failures := 1; ; failures++ {
 		conn, _, err := client.dialer.Dial(client.Addr, nil)
-		if err == nil || client.closeFlag {
+		if err == nil || client.closed() {
 			return conn
 		}
 
 		log.Release("connect to %v error: %v", client.Addr, err)
-		time.Sleep(client.ConnectInterval)
-		continue
+		if client.retry.gaveUp(failures) {
+			if client.OnReconnectFailed != nil {
+				client.OnReconnectFailed(err)
+			}
+			return nil
+		}
+		if !client.retry.wait(failures) {
+			return nil
+		}
 	}
 }
 
+func (client *WSClient) closed() bool {
+	client.Lock()
+	defer client.Unlock()
+	return client.closeFlag
+}
+
 func (client *WSClient) connect() {
 	defer client.wg.Done()
 
@@ -103,7 +130,10 @@ reconnect:
 	client.conns[conn] = struct{}{}
 	client.Unlock()
 
-	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen)
+	wsConn := newWSConn(conn, client.PendingWriteNum, client.MaxMsgLen, client.TextFrames, client.Compression)
+	if client.OnConnect != nil {
+		client.OnConnect(conn.RemoteAddr())
+	}
 	agent := client.NewAgent(wsConn)
 	agent.Run()
 
@@ -113,15 +143,20 @@ reconnect:
 	delete(client.conns, conn)
 	client.Unlock()
 	agent.OnClose()
+	if client.OnDisconnect != nil {
+		client.OnDisconnect(conn.RemoteAddr())
+	}
 
-	if client.AutoReconnect {
-		time.Sleep(client.ConnectInterval)
+	if client.AutoReconnect && client.retry.wait(0) {
 		goto reconnect
 	}
 }
 
 func (client *WSClient) Close() {
 	client.Lock()
+	if !client.closeFlag {
+		client.retry.close()
+	}
 	client.closeFlag = true
 	for conn := range client.conns {
 		conn.Close()
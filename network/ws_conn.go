@@ -2,8 +2,11 @@ package network
 
 import (
 	"errors"
+	"io"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 
 	"github.com/czx-lab/leaf/log"
 	"github.com/gorilla/websocket"
@@ -11,27 +14,79 @@ import (
 
 type WebsocketConnSet map[*websocket.Conn]struct{}
 
+// FrameType selects the websocket frame type of a message
+type FrameType int
+
+const (
+	// the default of the connection
+	FrameDefault FrameType = iota
+	FrameBinary
+	FrameText
+)
+
+// WSCompression negotiates permessage-deflate with the peer
+type WSCompression struct {
+	// flate level from -2 to 9, 1 if zero
+	Level int
+	// messages shorter than Threshold bytes are sent uncompressed
+	Threshold int
+}
+
+type wsMessage struct {
+	data []byte
+	text bool
+	// goes back to the pool once data is written
+	pooled *[]byte
+}
+
 type WSConn struct {
 	sync.Mutex
-	conn      *websocket.Conn
-	writeChan chan []byte
-	maxMsgLen uint32
-	closeFlag bool
+	conn       *websocket.Conn
+	writeQueue *writeQueue[wsMessage]
+	maxMsgLen  uint32
+	closeFlag  bool
+	textFrames bool
+	// -1 if compression is off
+	threshold atomic.Int64
+	// nil on the client side
+	request  *http.Request
+	throttle throttle
+	overflow overflowState
 }
 
-func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSConn {
+func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32, textFrames bool, compression *WSCompression) *WSConn {
 	wsConn := new(WSConn)
 	wsConn.conn = conn
-	wsConn.writeChan = make(chan []byte, pendingWriteNum)
+	wsConn.writeQueue = newWriteQueue[wsMessage](pendingWriteNum)
 	wsConn.maxMsgLen = maxMsgLen
+	wsConn.textFrames = textFrames
+	wsConn.threshold.Store(-1)
+	if compression != nil {
+		if compression.Level != 0 {
+			if err := conn.SetCompressionLevel(compression.Level); err != nil {
+				log.Error("%v", err)
+			}
+		}
+		wsConn.threshold.Store(int64(compression.Threshold))
+	}
 
 	go func() {
-		for b := range wsConn.writeChan {
-			if b == nil {
+		for {
+			m, ok := wsConn.writeQueue.pop()
+			if !ok || m.data == nil {
 				break
 			}
 
-			err := conn.WriteMessage(websocket.BinaryMessage, b)
+			messageType := websocket.BinaryMessage
+			if m.text {
+				messageType = websocket.TextMessage
+			}
+			if threshold := wsConn.threshold.Load(); threshold >= 0 {
+				conn.EnableWriteCompression(int64(len(m.data)) >= threshold)
+			}
+			wsConn.throttle.wait(len(m.data))
+			err := conn.WriteMessage(messageType, m.data)
+			putBuffer(m.pooled)
 			if err != nil {
 				break
 			}
@@ -46,12 +101,24 @@ func newWSConn(conn *websocket.Conn, pendingWriteNum int, maxMsgLen uint32) *WSC
 	return wsConn
 }
 
+// SetWriteRate paces the writes to rate bytes per second with bursts of
+// burst bytes, burst defaults to rate. A zero rate is unlimited
+// goroutine safe
+func (wsConn *WSConn) SetWriteRate(rate int, burst int) {
+	wsConn.throttle.set(rate, burst)
+}
+
+// goroutine safe
+func (wsConn *WSConn) WriteRate() (rate int, burst int) {
+	return wsConn.throttle.get()
+}
+
 func (wsConn *WSConn) doDestroy() {
-	wsConn.conn.UnderlyingConn().(*net.TCPConn).SetLinger(0)
+	resetOnClose(wsConn.conn.UnderlyingConn())
 	wsConn.conn.Close()
 
 	if !wsConn.closeFlag {
-		close(wsConn.writeChan)
+		wsConn.writeQueue.close()
 		wsConn.closeFlag = true
 	}
 }
@@ -65,23 +132,57 @@ func (wsConn *WSConn) Destroy() {
 
 func (wsConn *WSConn) Close() {
 	wsConn.Lock()
-	defer wsConn.Unlock()
 	if wsConn.closeFlag {
+		wsConn.Unlock()
 		return
 	}
 
-	wsConn.doWrite(nil)
+	// queued last so that everything written before is flushed
+	e := wsConn.doWrite(PriorityBulk, wsMessage{})
 	wsConn.closeFlag = true
+	wsConn.Unlock()
+	e.notify()
+}
+
+// SetOverflow replaces the policy applied when a write queue is full, nil
+// closes the connection
+// goroutine safe
+func (wsConn *WSConn) SetOverflow(o *Overflow) {
+	wsConn.Lock()
+	wsConn.overflow.overflow = o
+	wsConn.Unlock()
 }
 
-func (wsConn *WSConn) doWrite(b []byte) {
-	if len(wsConn.writeChan) == cap(wsConn.writeChan) {
-		log.Debug("close conn: channel full")
+func (wsConn *WSConn) doWrite(p Priority, m wsMessage) *overflowEvent {
+	e, destroy := push(wsConn.writeQueue, &wsConn.overflow, p, m, m.data == nil, func(m wsMessage) {
+		putBuffer(m.pooled)
+	})
+	if destroy {
 		wsConn.doDestroy()
-		return
 	}
+	return e
+}
 
-	wsConn.writeChan <- b
+// SetCompressionThreshold changes the threshold of a connection negotiated
+// with compression. goroutine safe
+func (wsConn *WSConn) SetCompressionThreshold(threshold int) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	if wsConn.threshold.Load() >= 0 {
+		wsConn.threshold.Store(int64(threshold))
+	}
+}
+
+// Request is the upgrade request accepted by WSServer, nil for WSClient
+// connections. The body must not be read
+func (wsConn *WSConn) Request() *http.Request {
+	return wsConn.request
+}
+
+// Subprotocol is the negotiated subprotocol
+func (wsConn *WSConn) Subprotocol() string {
+	return wsConn.conn.Subprotocol()
 }
 
 func (wsConn *WSConn) LocalAddr() net.Addr {
@@ -98,11 +199,49 @@ func (wsConn *WSConn) ReadMsg() ([]byte, error) {
 	return b, err
 }
 
+// ReadBuffer is ReadMsg with the message in a pooled Buffer
+// goroutine not safe
+func (wsConn *WSConn) ReadBuffer() (*Buffer, error) {
+	_, r, err := wsConn.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := getReadBuffer(0)
+	for {
+		if len(buf.data) == cap(buf.data) {
+			buf.data = append(buf.data, 0)[:len(buf.data)]
+		}
+		n, err := r.Read(buf.data[len(buf.data):cap(buf.data)])
+		buf.data = buf.data[:len(buf.data)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			buf.Release()
+			return nil, err
+		}
+	}
+}
+
 // args must not be modified by the others goroutines
 func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
+	return wsConn.WriteMsgPriority(PriorityNormal, args...)
+}
+
+// args must not be modified by the others goroutines
+func (wsConn *WSConn) WriteMsgPriority(p Priority, args ...[]byte) error {
+	return wsConn.WriteMsgFrame(FrameDefault, p, args...)
+}
+
+// args must not be modified by the others goroutines
+func (wsConn *WSConn) WriteMsgFrame(t FrameType, p Priority, args ...[]byte) error {
+	p = validPriority(p)
+	text := t == FrameText || t == FrameDefault && wsConn.textFrames
+
 	wsConn.Lock()
-	defer wsConn.Unlock()
 	if wsConn.closeFlag {
+		wsConn.Unlock()
 		return nil
 	}
 
@@ -114,26 +253,30 @@ func (wsConn *WSConn) WriteMsg(args ...[]byte) error {
 
 	// check len
 	if msgLen > wsConn.maxMsgLen {
+		wsConn.Unlock()
 		return errors.New("message too long")
 	} else if msgLen < 1 {
+		wsConn.Unlock()
 		return errors.New("message too short")
 	}
 
-	// don't copy
+	var e *overflowEvent
 	if len(args) == 1 {
-		wsConn.doWrite(args[0])
-		return nil
-	}
-
-	// merge the args
-	msg := make([]byte, msgLen)
-	l := 0
-	for i := 0; i < len(args); i++ {
-		copy(msg[l:], args[i])
-		l += len(args[i])
+		// don't copy
+		e = wsConn.doWrite(p, wsMessage{data: args[0], text: text})
+	} else {
+		// merge the args
+		buf := getBuffer(int(msgLen))
+		msg := *buf
+		l := 0
+		for i := 0; i < len(args); i++ {
+			copy(msg[l:], args[i])
+			l += len(args[i])
+		}
+		e = wsConn.doWrite(p, wsMessage{msg, text, buf})
 	}
-
-	wsConn.doWrite(msg)
+	wsConn.Unlock()
+	e.notify()
 
 	return nil
 }
@@ -19,15 +19,35 @@ type WSServer struct {
 	HTTPTimeout     time.Duration
 	CertFile        string
 	KeyFile         string
-	NewAgent        func(*WSConn) Agent
-	ln              net.Listener
-	handler         *WSHandler
+	// reloadable certificate and session resumption, CertFile and KeyFile
+	// are ignored
+	TLS *TLSConfig
+	// behind a load balancer, read before the tls handshake
+	ProxyProtocol *ProxyProtocol
+	// permessage-deflate, off if nil
+	Compression *WSCompression
+	// any origin is accepted if nil
+	CheckOrigin func(r *http.Request) bool
+	// picks one of the subprotocols asked by the client, "" selects none
+	SelectSubprotocol func(protocols []string) string
+	// send text frames unless a message asks for binary
+	TextFrames bool
+	NewAgent   func(*WSConn) Agent
+	ln         net.Listener
+	handler    *WSHandler
+	tls        *TLSConfig
+
+	// listen replaces Listen, e.g. for MuxServer
+	listen func(addr string) (net.Listener, error)
 }
 
 type WSHandler struct {
 	maxConnNum      int
 	pendingWriteNum int
 	maxMsgLen       uint32
+	textFrames      bool
+	compression     *WSCompression
+	subprotocol     func([]string) string
 	newAgent        func(*WSConn) Agent
 	upgrader        websocket.Upgrader
 	conns           WebsocketConnSet
@@ -40,7 +60,13 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
-	conn, err := handler.upgrader.Upgrade(w, r, nil)
+	var header http.Header
+	if handler.subprotocol != nil {
+		if protocol := handler.subprotocol(websocket.Subprotocols(r)); protocol != "" {
+			header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+		}
+	}
+	conn, err := handler.upgrader.Upgrade(w, r, header)
 	if err != nil {
 		log.Debug("upgrade error: %v", err)
 		return
@@ -65,7 +91,8 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.conns[conn] = struct{}{}
 	handler.mutexConns.Unlock()
 
-	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen)
+	wsConn := newWSConn(conn, handler.pendingWriteNum, handler.maxMsgLen, handler.textFrames, handler.compression)
+	wsConn.request = r
 	agent := handler.newAgent(wsConn)
 	agent.Run()
 
@@ -78,7 +105,11 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (server *WSServer) Start() {
-	ln, err := net.Listen("tcp", server.Addr)
+	listen := server.listen
+	if listen == nil {
+		listen = Listen
+	}
+	ln, err := listen(server.Addr)
 	if err != nil {
 		log.Fatal("%v", err)
 	}
@@ -103,13 +134,19 @@ func (server *WSServer) Start() {
 		log.Fatal("NewAgent must not be nil")
 	}
 
-	if server.CertFile != "" || server.KeyFile != "" {
-		config := &tls.Config{}
-		config.NextProtos = []string{"http/1.1"}
+	if server.ProxyProtocol != nil {
+		ln, err = server.ProxyProtocol.listener(ln)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+	}
 
-		var err error
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(server.CertFile, server.KeyFile)
+	server.tls = server.TLS
+	if server.tls == nil && (server.CertFile != "" || server.KeyFile != "") {
+		server.tls = &TLSConfig{CertFile: server.CertFile, KeyFile: server.KeyFile}
+	}
+	if server.tls != nil {
+		config, err := server.tls.open()
 		if err != nil {
 			log.Fatal("%v", err)
 		}
@@ -122,14 +159,22 @@ func (server *WSServer) Start() {
 		maxConnNum:      server.MaxConnNum,
 		pendingWriteNum: server.PendingWriteNum,
 		maxMsgLen:       server.MaxMsgLen,
+		textFrames:      server.TextFrames,
+		compression:     server.Compression,
+		subprotocol:     server.SelectSubprotocol,
 		newAgent:        server.NewAgent,
 		conns:           make(WebsocketConnSet),
 		upgrader: websocket.Upgrader{
-			HandshakeTimeout: server.HTTPTimeout,
-			CheckOrigin:      func(_ *http.Request) bool { return true },
+			HandshakeTimeout:  server.HTTPTimeout,
+			CheckOrigin:       func(_ *http.Request) bool { return true },
+			EnableCompression: server.Compression != nil,
 		},
 	}
 
+	if server.CheckOrigin != nil {
+		server.handler.upgrader.CheckOrigin = server.CheckOrigin
+	}
+
 	httpServer := &http.Server{
 		Addr:           server.Addr,
 		Handler:        server.handler,
@@ -141,8 +186,30 @@ func (server *WSServer) Start() {
 	go httpServer.Serve(ln)
 }
 
+// Drain stops accepting, waits up to timeout for the connections to be
+// closed by their agents and then closes the remaining ones
+func (server *WSServer) Drain(timeout time.Duration) {
+	server.ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.handler.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+
+	server.Close()
+}
+
 func (server *WSServer) Close() {
 	server.ln.Close()
+	if server.tls != nil {
+		server.tls.close()
+		server.tls = nil
+	}
 
 	server.handler.mutexConns.Lock()
 	for conn := range server.handler.conns {
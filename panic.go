@@ -0,0 +1,16 @@
+package leaf
+
+import "github.com/czx-lab/leaf/util"
+
+// PanicContext describes where a panic was recovered
+type PanicContext = util.PanicContext
+
+// SetPanicHandler replaces the logging of the panics recovered in chanrpc
+// functions and callbacks, Skeleton.Go, timers, cron jobs, util.SafeGo, actors
+// and module.OnDestroy. A panic of Module.Run is passed to f and still
+// crashes the process. f may report to an error tracker or exit to crash
+// fast, nil restores the logging
+// goroutine safe
+func SetPanicHandler(f func(recovered interface{}, stack []byte, ctx PanicContext)) {
+	util.SetPanicHandler(f)
+}
@@ -0,0 +1,47 @@
+package plugin_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/plugin"
+)
+
+type analytics struct{}
+
+func (analytics) Name() string {
+	return "analytics"
+}
+
+func (analytics) Install(h *plugin.Host) error {
+	s, err := h.Server("game")
+	if err != nil {
+		return err
+	}
+	s.Register("analytics.track", func(args []interface{}) {
+		fmt.Println("track", args[0])
+	})
+	return nil
+}
+
+func Example() {
+	// in the plugin package
+	plugin.Register(analytics{})
+
+	// in the game, before leaf.Run
+	game := chanrpc.NewServer(10)
+	plugin.ExposeServer("game", game)
+
+	// done by leaf.Run
+	if _, err := plugin.Install(); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(plugin.Plugins())
+
+	game.Go("analytics.track", "login")
+	game.Exec(<-game.ChanCall)
+
+	// Output:
+	// [analytics]
+	// track login
+}
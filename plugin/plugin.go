@@ -0,0 +1,149 @@
+// Package plugin lets third-party modules extend a leaf server without
+// forking it. A plugin registers itself from an init function, the game
+// exposes its chanrpc servers and processors by name and leaf.Run installs
+// every registered plugin before initializing the modules.
+package plugin
+
+import (
+	"fmt"
+	goplugin "plugin"
+	"sort"
+	"sync"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/console"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/network"
+)
+
+type Plugin interface {
+	Name() string
+	Install(h *Host) error
+}
+
+var (
+	mutex      sync.Mutex
+	plugins    []Plugin
+	servers    = make(map[string]*chanrpc.Server)
+	processors = make(map[string]network.Processor)
+	installed  bool
+)
+
+// Register makes a plugin available, call it from the init function of
+// the plugin package
+func Register(p Plugin) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, q := range plugins {
+		if q.Name() == p.Name() {
+			panic(fmt.Sprintf("plugin %v is already registered", p.Name()))
+		}
+	}
+	plugins = append(plugins, p)
+}
+
+// Load opens a Go plugin, its init functions are expected to call Register
+func Load(path string) error {
+	_, err := goplugin.Open(path)
+	return err
+}
+
+// Plugins returns the names of the registered plugins
+func Plugins() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	names := make([]string, len(plugins))
+	for i, p := range plugins {
+		names[i] = p.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExposeServer makes a chanrpc server available to plugins under name,
+// plugins may register handlers on it
+// you must call the function before calling leaf.Run
+func ExposeServer(name string, s *chanrpc.Server) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	servers[name] = s
+}
+
+// ExposeProcessor makes a processor available to plugins under name
+// you must call the function before calling leaf.Run
+func ExposeProcessor(name string, p network.Processor) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	processors[name] = p
+}
+
+// Processor returns a processor exposed by the game or added by a plugin
+func Processor(name string) network.Processor {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return processors[name]
+}
+
+// Host gives a plugin access to the extension points while it's installed
+type Host struct {
+	plugin  string
+	modules []module.Module
+}
+
+func (h *Host) AddModule(m module.Module) {
+	h.modules = append(h.modules, m)
+}
+
+// AddCommand adds a console command
+func (h *Host) AddCommand(name string, help string, f func(args []string) string) {
+	console.RegisterFunc(name, help, f)
+}
+
+// Server returns the chanrpc server exposed by the game under name, handlers
+// must be registered during Install
+func (h *Host) Server(name string) (*chanrpc.Server, error) {
+	s, ok := servers[name]
+	if !ok {
+		return nil, fmt.Errorf("plugin %v: chanrpc server %v not exposed", h.plugin, name)
+	}
+	return s, nil
+}
+
+// Processor returns a processor exposed by the game, message types may be
+// registered on it during Install
+func (h *Host) Processor(name string) (network.Processor, error) {
+	p, ok := processors[name]
+	if !ok {
+		return nil, fmt.Errorf("plugin %v: processor %v not exposed", h.plugin, name)
+	}
+	return p, nil
+}
+
+// AddProcessor exposes a processor provided by the plugin
+func (h *Host) AddProcessor(name string, p network.Processor) {
+	processors[name] = p
+}
+
+// Install installs the registered plugins in registration order and
+// returns the modules they add. It's called by leaf.Run
+func Install() ([]module.Module, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if installed {
+		return nil, nil
+	}
+	installed = true
+
+	var mods []module.Module
+	for _, p := range plugins {
+		h := &Host{plugin: p.Name()}
+		if err := p.Install(h); err != nil {
+			return nil, fmt.Errorf("plugin %v: %v", p.Name(), err)
+		}
+		mods = append(mods, h.modules...)
+	}
+	return mods, nil
+}
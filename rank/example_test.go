@@ -0,0 +1,44 @@
+package rank_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/rank"
+)
+
+func Example() {
+	lb := rank.New(0)
+	lb.Update("a", 100)
+	lb.Update("b", 300)
+	lb.Update("c", 200)
+	lb.Update("d", 200)
+
+	fmt.Println(lb.Rank("a"), lb.Rank("c"), lb.Rank("d"))
+
+	lb.Update("a", 400)
+	for _, e := range lb.Range(1, 2) {
+		fmt.Println(e.ID, e.Score)
+	}
+
+	lb.Remove("b")
+	fmt.Println(lb.Rank("c"), lb.Len())
+
+	// Output:
+	// 4 2 3
+	// a 400
+	// b 300
+	// 2 3
+}
+
+func ExampleMerge() {
+	node1 := []rank.Entry{{ID: "a", Score: 30}, {ID: "b", Score: 10}}
+	node2 := []rank.Entry{{ID: "c", Score: 20}, {ID: "a", Score: 5}}
+
+	for _, e := range rank.Merge(2, node1, node2) {
+		fmt.Println(e.ID, e.Score)
+	}
+
+	// Output:
+	// a 30
+	// c 20
+}
@@ -0,0 +1,143 @@
+package rank
+
+import (
+	"sort"
+)
+
+type Entry struct {
+	ID    interface{}
+	Score int64
+	seq   uint64
+}
+
+// one leaderboard per goroutine (goroutine not safe)
+type Leaderboard struct {
+	// keep the top MaxLen entries only, zero means no limit
+	MaxLen  int
+	list    *skipList
+	entries map[interface{}]*Entry
+	seq     uint64
+}
+
+func New(maxLen int) *Leaderboard {
+	lb := new(Leaderboard)
+	lb.MaxLen = maxLen
+	lb.list = newSkipList()
+	lb.entries = make(map[interface{}]*Entry)
+	return lb
+}
+
+// returns the new rank, 0 if the entry didn't make it into the board
+func (lb *Leaderboard) Update(id interface{}, score int64) int {
+	if e, ok := lb.entries[id]; ok {
+		if e.Score == score {
+			return lb.list.rank(e)
+		}
+		lb.list.delete(e)
+		delete(lb.entries, id)
+	}
+
+	lb.seq++
+	n := lb.list.insert(Entry{ID: id, Score: score, seq: lb.seq})
+	lb.entries[id] = &n.entry
+
+	if lb.MaxLen > 0 && lb.list.len > lb.MaxLen {
+		tail := lb.list.tail.entry
+		lb.list.delete(&tail)
+		delete(lb.entries, tail.ID)
+	}
+	return lb.Rank(id)
+}
+
+func (lb *Leaderboard) Remove(id interface{}) {
+	e, ok := lb.entries[id]
+	if !ok {
+		return
+	}
+	lb.list.delete(e)
+	delete(lb.entries, id)
+}
+
+// 1-based, 0 if not found
+func (lb *Leaderboard) Rank(id interface{}) int {
+	e, ok := lb.entries[id]
+	if !ok {
+		return 0
+	}
+	return lb.list.rank(e)
+}
+
+func (lb *Leaderboard) Score(id interface{}) (int64, bool) {
+	e, ok := lb.entries[id]
+	if !ok {
+		return 0, false
+	}
+	return e.Score, true
+}
+
+func (lb *Leaderboard) Len() int {
+	return lb.list.len
+}
+
+// entries ranked from start to end inclusive (1-based)
+func (lb *Leaderboard) Range(start, end int) []Entry {
+	if start < 1 {
+		start = 1
+	}
+	if end > lb.list.len {
+		end = lb.list.len
+	}
+	if start > end {
+		return nil
+	}
+
+	r := make([]Entry, 0, end-start+1)
+	for x := lb.list.byRank(start); x != nil && len(r) < cap(r); x = x.level[0].next {
+		r = append(r, x.entry)
+	}
+	return r
+}
+
+// the ranked entries, e.g. for persistence
+func (lb *Leaderboard) Snapshot() []Entry {
+	return lb.Range(1, lb.list.len)
+}
+
+// Load replaces the board with entries, ties keep the order of entries
+func (lb *Leaderboard) Load(entries []Entry) {
+	lb.list = newSkipList()
+	lb.entries = make(map[interface{}]*Entry)
+	for _, e := range entries {
+		lb.Update(e.ID, e.Score)
+	}
+}
+
+// Merge aggregates partial leaderboards (e.g. from several nodes) into the
+// top n entries, n <= 0 means all. An ID present in several lists keeps its
+// highest score.
+func Merge(n int, lists ...[]Entry) []Entry {
+	best := make(map[interface{}]Entry)
+	var order []interface{}
+	for _, l := range lists {
+		for _, e := range l {
+			if b, ok := best[e.ID]; !ok {
+				order = append(order, e.ID)
+				best[e.ID] = e
+			} else if e.Score > b.Score {
+				best[e.ID] = e
+			}
+		}
+	}
+
+	r := make([]Entry, 0, len(order))
+	for _, id := range order {
+		r = append(r, best[id])
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Score > r[j].Score
+	})
+	if n > 0 && len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
@@ -0,0 +1,168 @@
+package rank
+
+import (
+	"math/rand"
+)
+
+// reference: redis zset
+const (
+	maxLevel    = 32
+	probability = 0.25
+)
+
+type skipNode struct {
+	entry Entry
+	back  *skipNode
+	level []skipLevel
+}
+
+type skipLevel struct {
+	next *skipNode
+	span int
+}
+
+type skipList struct {
+	head  *skipNode
+	tail  *skipNode
+	len   int
+	level int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{level: make([]skipLevel, maxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.Float64() < probability {
+		level++
+	}
+	return level
+}
+
+// higher score first, earlier update first on ties
+func less(a, b *Entry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.seq < b.seq
+}
+
+func (l *skipList) insert(e Entry) *skipNode {
+	var update [maxLevel]*skipNode
+	var rank [maxLevel]int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i != l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].next != nil && less(&x.level[i].next.entry, &e) {
+			rank[i] += x.level[i].span
+			x = x.level[i].next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].level[i].span = l.len
+		}
+		l.level = level
+	}
+
+	x = &skipNode{entry: e, level: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].next = update[i].level[i].next
+		update[i].level[i].next = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != l.head {
+		x.back = update[0]
+	}
+	if x.level[0].next != nil {
+		x.level[0].next.back = x
+	} else {
+		l.tail = x
+	}
+	l.len++
+	return x
+}
+
+func (l *skipList) delete(e *Entry) {
+	var update [maxLevel]*skipNode
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.level[i].next != nil && less(&x.level[i].next.entry, e) {
+			x = x.level[i].next
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].next
+	if x == nil || x.entry.seq != e.seq {
+		return
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].level[i].next == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].next = x.level[i].next
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].next != nil {
+		x.level[0].next.back = x.back
+	} else {
+		l.tail = x.back
+	}
+	for l.level > 1 && l.head.level[l.level-1].next == nil {
+		l.level--
+	}
+	l.len--
+}
+
+// 1-based, 0 if not found
+func (l *skipList) rank(e *Entry) int {
+	rank := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.level[i].next != nil && !less(e, &x.level[i].next.entry) {
+			rank += x.level[i].span
+			x = x.level[i].next
+		}
+		if x != l.head && x.entry.seq == e.seq {
+			return rank
+		}
+	}
+	return 0
+}
+
+// 1-based
+func (l *skipList) byRank(rank int) *skipNode {
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.level[i].next != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].next
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package rank
+
+import (
+	"context"
+	"time"
+
+	"github.com/czx-lab/leaf/db/mongodb"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/timer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// must goroutine safe
+type Store interface {
+	Save(name string, entries []Entry) error
+	Load(name string) ([]Entry, error)
+}
+
+type MongoStore struct {
+	Dial       *mongodb.DialContext
+	DB         string
+	Collection string
+}
+
+type mongoEntry struct {
+	ID    interface{} `bson:"id"`
+	Score int64       `bson:"score"`
+}
+
+type mongoBoard struct {
+	Name    string       `bson:"_id"`
+	Entries []mongoEntry `bson:"entries"`
+}
+
+func (s *MongoStore) Save(name string, entries []Entry) error {
+	doc := mongoBoard{Name: name, Entries: make([]mongoEntry, len(entries))}
+	for i, e := range entries {
+		doc.Entries[i] = mongoEntry{ID: e.ID, Score: e.Score}
+	}
+	_, err := s.Dial.C(s.DB, s.Collection).ReplaceOne(context.Background(), bson.M{"_id": name}, &doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) Load(name string) ([]Entry, error) {
+	var doc mongoBoard
+	err := s.Dial.C(s.DB, s.Collection).FindOne(context.Background(), bson.M{"_id": name}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, len(doc.Entries))
+	for i, e := range doc.Entries {
+		entries[i] = Entry{ID: e.ID, Score: e.Score}
+	}
+	return entries, nil
+}
+
+type Saver struct {
+	t *timer.Timer
+}
+
+// StartSaver saves a snapshot of lb every interval. The snapshot is taken in
+// the skeleton goroutine and written by skeleton.Go, so s needs GoLen and
+// TimerDispatcherLen.
+func StartSaver(s *module.Skeleton, lb *Leaderboard, name string, store Store, interval time.Duration) *Saver {
+	saver := new(Saver)
+
+	var save func()
+	save = func() {
+		entries := lb.Snapshot()
+		var err error
+		s.Go(func() {
+			err = store.Save(name, entries)
+		}, func() {
+			if err != nil {
+				log.Error("save leaderboard %v error: %v", name, err)
+			}
+		})
+		saver.t = s.AfterFunc(interval, save)
+	}
+	saver.t = s.AfterFunc(interval, save)
+	return saver
+}
+
+// skeleton goroutine only
+func (saver *Saver) Stop() {
+	saver.t.Stop()
+}
@@ -2,6 +2,8 @@ package recordfile_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/czx-lab/leaf/recordfile"
 )
@@ -66,3 +68,171 @@ func Example() {
 	// czx-lab
 	// 6
 }
+
+func ExampleRecordFile_WriteCSV() {
+	type Record struct {
+		ID   int "index"
+		Name string
+		Tags []string
+	}
+
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return
+	}
+	rf.Comma = ','
+
+	err = rf.Read("test.csv")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	rf.WriteCSV(os.Stdout)
+	rf.WriteJSON(os.Stdout)
+
+	// Output:
+	// ID,Name,Tags
+	// 1,sword,"[""weapon""]"
+	// 2,shield,[]
+	// [
+	// 	{
+	// 		"ID": 1,
+	// 		"Name": "sword",
+	// 		"Tags": [
+	// 			"weapon"
+	// 		]
+	// 	},
+	// 	{
+	// 		"ID": 2,
+	// 		"Name": "shield",
+	// 		"Tags": []
+	// 	}
+	// ]
+}
+
+func ExampleRecordFile_ReadJSON() {
+	type Record struct {
+		ID   int "index"
+		Name string
+		Tags []string
+	}
+
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return
+	}
+
+	err = rf.Read("test.json")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r := rf.Index(1).(*Record)
+	fmt.Println(r.Name, r.Tags)
+
+	// Output:
+	// sword [weapon]
+}
+
+func ExampleRecordFile_ReadXLSX() {
+	type Record struct {
+		ID   int "index"
+		Name string
+		Tags []string
+	}
+
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return
+	}
+
+	// the columns by name, "Note" is ignored and the commented row skipped
+	err = rf.ReadXLSX("test.xlsx", "items")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := 0; i < rf.NumRecord(); i++ {
+		r := rf.Record(i).(*Record)
+		fmt.Println(r.ID, r.Name, r.Tags)
+	}
+
+	// Output:
+	// 1 sword [weapon]
+	// 2 shield []
+}
+
+func ExampleRecordFile_Reload() {
+	type Record struct {
+		ID   int "index"
+		Name string
+	}
+
+	name := filepath.Join(os.TempDir(), "recordfile_reload.json")
+	defer os.Remove(name)
+	os.WriteFile(name, []byte(`[{"ID": 1, "Name": "sword"}]`), 0644)
+
+	rf, err := recordfile.New(Record{})
+	if err != nil {
+		return
+	}
+	if err := rf.Read(name); err != nil {
+		fmt.Println(err)
+		return
+	}
+	// readers keep the records they got
+	records := rf.Records()
+
+	os.WriteFile(name, []byte(`[{"ID": 1, "Name": "axe"}, {"ID": 1}]`), 0644)
+	fmt.Println(rf.Reload())
+	os.WriteFile(name, []byte(`[{"ID": 1, "Name": "axe"}]`), 0644)
+	fmt.Println(rf.Reload())
+
+	fmt.Println(records[0].(*Record).Name, rf.Index(1).(*Record).Name)
+
+	// Output:
+	// index error: duplicate at (row=2, col=0)
+	// <nil>
+	// sword axe
+}
+
+func ExampleLoad() {
+	type Item struct {
+		ID    int    `rf:"id,index"`
+		Name  string `rf:"item_name"`
+		Kind  int    `rf:"kind,key=kind_lv"`
+		Level int    `rf:"level,key=kind_lv"`
+	}
+
+	name := filepath.Join(os.TempDir(), "recordfile_load.json")
+	defer os.Remove(name)
+	os.WriteFile(name, []byte(`[
+	{"level": 1, "kind": 2, "item_name": "sword", "id": 10},
+	{"level": 2, "kind": 2, "item_name": "axe", "id": 11}
+]`), 0644)
+
+	items, err := recordfile.Load[Item](name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	item, ok := items.Get(11)
+	fmt.Println(item.Name, ok)
+	item, ok = items.Find("kind_lv", 2, 1)
+	fmt.Println(item.Name, ok)
+	_, ok = items.Get("11")
+	fmt.Println(ok)
+
+	items.Iterate(func(item *Item) bool {
+		fmt.Println(item.ID, item.Name)
+		return true
+	})
+
+	// Output:
+	// axe true
+	// sword true
+	// false
+	// 10 sword
+	// 11 axe
+}
@@ -0,0 +1,134 @@
+package recordfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	mutexTables sync.Mutex
+	tables      = make(map[string]*RecordFile)
+)
+
+// Register names a table for the console export command
+func Register(name string, rf *RecordFile) {
+	mutexTables.Lock()
+	tables[name] = rf
+	mutexTables.Unlock()
+}
+
+// Lookup returns a table registered by Register or nil
+func Lookup(name string) *RecordFile {
+	mutexTables.Lock()
+	defer mutexTables.Unlock()
+	return tables[name]
+}
+
+// Tables returns the names of the registered tables
+func Tables() []string {
+	mutexTables.Lock()
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	mutexTables.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// WriteCSV writes the records in the format read by Read, the first line
+// holds the field names
+func (rf *RecordFile) WriteCSV(w io.Writer) error {
+	if rf.Comma == 0 {
+		rf.Comma = Comma
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma = rf.Comma
+
+	typeRecord := rf.typeRecord
+	line := make([]string, typeRecord.NumField())
+	for i := range line {
+		line[i] = typeRecord.Field(i).Name
+	}
+	if err := writer.Write(line); err != nil {
+		return err
+	}
+
+	for _, r := range rf.Records() {
+		record := reflect.ValueOf(r).Elem()
+		for i := range line {
+			s, err := formatField(record.Field(i))
+			if err != nil {
+				return err
+			}
+			line[i] = s
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatField(field reflect.Value) (string, error) {
+	switch field.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits()), nil
+	case reflect.String:
+		return field.String(), nil
+	}
+
+	// struct, array, slice and map
+	if !field.CanInterface() {
+		return "", nil
+	}
+	data, err := json.Marshal(field.Interface())
+	return string(data), err
+}
+
+// WriteJSON writes the records as a JSON array
+func (rf *RecordFile) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	records := rf.Records()
+	if records == nil {
+		return encoder.Encode([]interface{}{})
+	}
+	return encoder.Encode(records)
+}
+
+// Export writes the records to the file name, as JSON if name ends with .json
+func (rf *RecordFile) Export(name string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(path.Ext(name), ".json") {
+		err = rf.WriteJSON(file)
+	} else {
+		err = rf.WriteCSV(file)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
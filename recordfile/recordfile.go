@@ -6,8 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/czx-lab/leaf/chanrpc"
 )
 
 var Comma = '\t'
@@ -19,8 +25,29 @@ type RecordFile struct {
 	Comma      rune
 	Comment    rune
 	typeRecord reflect.Type
-	records    []interface{}
-	indexes    []Index
+	table      atomic.Pointer[table]
+	fields     []field
+	// the fields are mapped by the rf tags
+	byName bool
+
+	// checks the records read, they don't replace the loaded ones if it
+	// fails
+	Validate func(records []interface{}) error
+
+	// serializes the reads
+	mutexRead sync.Mutex
+	file      string
+	reload    func() error
+
+	mutexNotify sync.Mutex
+	notify      []*chanrpc.Server
+}
+
+// table is the records of a read, replaced as a whole
+type table struct {
+	records []interface{}
+	indexes []Index
+	keys    map[string]Index
 }
 
 func New(st interface{}) (*RecordFile, error) {
@@ -29,6 +56,8 @@ func New(st interface{}) (*RecordFile, error) {
 		return nil, errors.New("st must be a struct")
 	}
 
+	rf := new(RecordFile)
+	var fields []field
 	for i := 0; i < typeRecord.NumField(); i++ {
 		f := typeRecord.Field(i)
 
@@ -57,23 +86,79 @@ func New(st interface{}) (*RecordFile, error) {
 				f.Name, kind)
 		}
 
-		tag := f.Tag
-		if tag == "index" {
+		fi, byName := parseField(f)
+		if fi.key != "" && !f.IsExported() {
+			return nil, fmt.Errorf("could not key unexported field %v %v",
+				i, f.Name)
+		}
+		if fi.index || fi.key != "" {
 			switch kind {
 			case reflect.Struct, reflect.Slice, reflect.Map:
 				return nil, fmt.Errorf("could not index %s field %v %v",
 					kind, i, f.Name)
 			}
 		}
+		fields = append(fields, fi)
+		rf.byName = rf.byName || byName
+	}
+	for key, n := range keyParts(fields) {
+		if n > maxKeyParts {
+			return nil, fmt.Errorf("key %v: %v fields, %v at most",
+				key, n, maxKeyParts)
+		}
 	}
 
-	rf := new(RecordFile)
 	rf.typeRecord = typeRecord
+	rf.fields = fields
 
 	return rf, nil
 }
 
+// Read reads the records of the file name, a JSON array if name ends with
+// .json, the first sheet if .xlsx and the CSV-like format otherwise
+// goroutine safe
 func (rf *RecordFile) Read(name string) error {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return rf.ReadJSON(name)
+	case ".xlsx":
+		return rf.ReadXLSX(name, "")
+	}
+	return rf.load(name, func() error {
+		return rf.readCSV(name)
+	})
+}
+
+// load reads with read, Reload calls it again
+func (rf *RecordFile) load(name string, read func() error) error {
+	rf.mutexRead.Lock()
+	defer rf.mutexRead.Unlock()
+	rf.file = name
+	rf.reload = read
+	return read()
+}
+
+// Reload reads the file read last again, the records loaded are replaced
+// at once if it succeeds and kept otherwise
+// goroutine safe
+func (rf *RecordFile) Reload() error {
+	rf.mutexRead.Lock()
+	defer rf.mutexRead.Unlock()
+	if rf.reload == nil {
+		return errors.New("no file read")
+	}
+	return rf.reload()
+}
+
+// File returns the name of the file read last
+// goroutine safe
+func (rf *RecordFile) File() string {
+	rf.mutexRead.Lock()
+	defer rf.mutexRead.Unlock()
+	return rf.file
+}
+
+func (rf *RecordFile) readCSV(name string) error {
 	file, err := os.Open(name)
 	if err != nil {
 		return err
@@ -96,123 +181,181 @@ func (rf *RecordFile) Read(name string) error {
 
 	typeRecord := rf.typeRecord
 
-	// make records
-	records := make([]interface{}, len(lines)-1)
-
-	// make indexes
-	indexes := []Index{}
-	for i := 0; i < typeRecord.NumField(); i++ {
-		tag := typeRecord.Field(i).Tag
-		if tag == "index" {
-			indexes = append(indexes, make(Index))
+	// the columns are the fields in order if not mapped by the rf tags
+	var columns []int
+	if rf.byName && len(lines) > 0 {
+		columns, err = rf.columns(lines[0])
+		if err != nil {
+			return err
 		}
 	}
 
+	// make records
+	records := make([]interface{}, len(lines)-1)
+
 	for n := 1; n < len(lines); n++ {
 		value := reflect.New(typeRecord)
 		records[n-1] = value.Interface()
 		record := value.Elem()
 
 		line := lines[n]
-		if len(line) != typeRecord.NumField() {
+		if columns == nil && len(line) != typeRecord.NumField() {
 			return fmt.Errorf("line %v, field count mismatch: %v (file) %v (st)",
 				n, len(line), typeRecord.NumField())
 		}
 
-		iIndex := 0
-
 		for i := 0; i < typeRecord.NumField(); i++ {
-			f := typeRecord.Field(i)
-
 			// records
-			strField := line[i]
 			field := record.Field(i)
 			if !field.CanSet() {
 				continue
 			}
 
-			var err error
-
-			kind := f.Type.Kind()
-			if kind == reflect.Bool {
-				var v bool
-				v, err = strconv.ParseBool(strField)
-				if err == nil {
-					field.SetBool(v)
-				}
-			} else if kind == reflect.Int ||
-				kind == reflect.Int8 ||
-				kind == reflect.Int16 ||
-				kind == reflect.Int32 ||
-				kind == reflect.Int64 {
-				var v int64
-				v, err = strconv.ParseInt(strField, 0, f.Type.Bits())
-				if err == nil {
-					field.SetInt(v)
-				}
-			} else if kind == reflect.Uint ||
-				kind == reflect.Uint8 ||
-				kind == reflect.Uint16 ||
-				kind == reflect.Uint32 ||
-				kind == reflect.Uint64 {
-				var v uint64
-				v, err = strconv.ParseUint(strField, 0, f.Type.Bits())
-				if err == nil {
-					field.SetUint(v)
-				}
-			} else if kind == reflect.Float32 ||
-				kind == reflect.Float64 {
-				var v float64
-				v, err = strconv.ParseFloat(strField, f.Type.Bits())
-				if err == nil {
-					field.SetFloat(v)
+			col := i
+			if columns != nil {
+				col = columns[i]
+				if col >= len(line) {
+					continue
 				}
-			} else if kind == reflect.String {
-				field.SetString(strField)
-			} else if kind == reflect.Struct ||
-				kind == reflect.Array ||
-				kind == reflect.Slice ||
-				kind == reflect.Map {
-				err = json.Unmarshal([]byte(strField), field.Addr().Interface())
 			}
-
-			if err != nil {
+			if err := setField(field, line[col]); err != nil {
 				return fmt.Errorf("parse field (row=%v, col=%v) error: %v",
-					n, i, err)
+					n, col, err)
 			}
+		}
+	}
 
-			// indexes
-			if f.Tag == "index" {
-				index := indexes[iIndex]
-				iIndex++
-				if _, ok := index[field.Interface()]; ok {
-					return fmt.Errorf("index error: duplicate at (row=%v, col=%v)",
-						n, i)
-				}
-				index[field.Interface()] = records[n-1]
+	return rf.setRecords(records)
+}
+
+// setField parses the text of a field, the struct, array, slice and map
+// fields are JSON
+func setField(field reflect.Value, strField string) error {
+	var err error
+
+	kind := field.Kind()
+	if kind == reflect.Bool {
+		var v bool
+		v, err = strconv.ParseBool(strField)
+		if err == nil {
+			field.SetBool(v)
+		}
+	} else if kind == reflect.Int ||
+		kind == reflect.Int8 ||
+		kind == reflect.Int16 ||
+		kind == reflect.Int32 ||
+		kind == reflect.Int64 {
+		var v int64
+		v, err = strconv.ParseInt(strField, 0, field.Type().Bits())
+		if err == nil {
+			field.SetInt(v)
+		}
+	} else if kind == reflect.Uint ||
+		kind == reflect.Uint8 ||
+		kind == reflect.Uint16 ||
+		kind == reflect.Uint32 ||
+		kind == reflect.Uint64 {
+		var v uint64
+		v, err = strconv.ParseUint(strField, 0, field.Type().Bits())
+		if err == nil {
+			field.SetUint(v)
+		}
+	} else if kind == reflect.Float32 ||
+		kind == reflect.Float64 {
+		var v float64
+		v, err = strconv.ParseFloat(strField, field.Type().Bits())
+		if err == nil {
+			field.SetFloat(v)
+		}
+	} else if kind == reflect.String {
+		field.SetString(strField)
+	} else if kind == reflect.Struct ||
+		kind == reflect.Array ||
+		kind == reflect.Slice ||
+		kind == reflect.Map {
+		err = json.Unmarshal([]byte(strField), field.Addr().Interface())
+	}
+	return err
+}
+
+// setRecords validates and indexes the records read, then replaces the
+// ones loaded
+func (rf *RecordFile) setRecords(records []interface{}) error {
+	if rf.Validate != nil {
+		if err := rf.Validate(records); err != nil {
+			return err
+		}
+	}
+	typeRecord := rf.typeRecord
+
+	// make indexes
+	indexes := []Index{}
+	for i := 0; i < typeRecord.NumField(); i++ {
+		if rf.fields[i].index {
+			indexes = append(indexes, make(Index))
+		}
+	}
+	keys := make(map[string]Index)
+	for key := range keyParts(rf.fields) {
+		keys[key] = make(Index)
+	}
+
+	for n, r := range records {
+		record := reflect.ValueOf(r).Elem()
+		if err := rf.indexKeys(keys, record, r); err != nil {
+			return fmt.Errorf("%v at row=%v", err, n+1)
+		}
+		iIndex := 0
+		for i := 0; i < typeRecord.NumField(); i++ {
+			field := record.Field(i)
+			if !field.CanSet() || !rf.fields[i].index {
+				continue
+			}
+			index := indexes[iIndex]
+			iIndex++
+			if _, ok := index[field.Interface()]; ok {
+				return fmt.Errorf("index error: duplicate at (row=%v, col=%v)",
+					n+1, i)
 			}
+			index[field.Interface()] = r
 		}
 	}
 
-	rf.records = records
-	rf.indexes = indexes
+	old := rf.table.Swap(&table{records: records, indexes: indexes, keys: keys})
+	if old != nil {
+		rf.notifyReload()
+	}
 
 	return nil
 }
 
+func (rf *RecordFile) current() *table {
+	if t := rf.table.Load(); t != nil {
+		return t
+	}
+	return new(table)
+}
+
 func (rf *RecordFile) Record(i int) interface{} {
-	return rf.records[i]
+	return rf.current().records[i]
 }
 
 func (rf *RecordFile) NumRecord() int {
-	return len(rf.records)
+	return len(rf.current().records)
+}
+
+// Records returns the records loaded, the slice isn't changed by a reload
+// goroutine safe
+func (rf *RecordFile) Records() []interface{} {
+	return rf.current().records
 }
 
 func (rf *RecordFile) Indexes(i int) Index {
-	if i >= len(rf.indexes) {
+	indexes := rf.current().indexes
+	if i >= len(indexes) {
 		return nil
 	}
-	return rf.indexes[i]
+	return indexes[i]
 }
 
 func (rf *RecordFile) Index(i interface{}) interface{} {
@@ -0,0 +1,153 @@
+package recordfile
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadID is the chanrpc function notified of the reloads of rf
+type reloadID struct {
+	rf *RecordFile
+}
+
+// OnReload calls f in the goroutine of s once the records loaded are
+// replaced, e.g. by Reload. The records read before are still valid
+// you must call the function before calling Open and Go of s
+func (rf *RecordFile) OnReload(s *chanrpc.Server, f func(rf *RecordFile)) {
+	chanrpc.Register0(s, reloadID{rf}, f)
+
+	rf.mutexNotify.Lock()
+	rf.notify = append(rf.notify, s)
+	rf.mutexNotify.Unlock()
+}
+
+func (rf *RecordFile) notifyReload() {
+	rf.mutexNotify.Lock()
+	servers := rf.notify
+	rf.mutexNotify.Unlock()
+	for _, s := range servers {
+		s.Go(reloadID{rf}, rf)
+	}
+}
+
+// Watcher reloads the registered tables when their files are written
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	path    string
+	wg      sync.WaitGroup
+
+	mutexTimers sync.Mutex
+	timers      map[string]*time.Timer
+}
+
+// the writes within are reloaded once
+const reloadDelay = 200 * time.Millisecond
+
+// Watch reloads the tables registered by Register read from the file path,
+// or from the files in the directory path, when they are written. A table
+// failing to read keeps its records
+// goroutine safe
+func Watch(path string) (*Watcher, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// the files replaced by a rename are watched by their directory
+	dir := path
+	if !isDir(path) {
+		dir = filepath.Dir(path)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	w := &Watcher{watcher: watcher, path: path, timers: make(map[string]*time.Timer)}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+				continue
+			}
+			if ev.Name != w.path && filepath.Dir(ev.Name) != w.path {
+				continue
+			}
+			w.schedule(ev.Name)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("recordfile: watch %v: %v", w.path, err)
+		}
+	}
+}
+
+func (w *Watcher) schedule(file string) {
+	w.mutexTimers.Lock()
+	defer w.mutexTimers.Unlock()
+	if t := w.timers[file]; t != nil {
+		t.Reset(reloadDelay)
+		return
+	}
+	w.timers[file] = time.AfterFunc(reloadDelay, func() {
+		w.mutexTimers.Lock()
+		delete(w.timers, file)
+		w.mutexTimers.Unlock()
+		reloadFile(file)
+	})
+}
+
+// reloadFile reloads the registered tables read from file
+func reloadFile(file string) {
+	for _, name := range Tables() {
+		rf := Lookup(name)
+		read, err := filepath.Abs(rf.File())
+		if err != nil || read != file {
+			continue
+		}
+		if err := rf.Reload(); err != nil {
+			log.Error("recordfile: reload %v: %v", name, err)
+		} else {
+			log.Release("recordfile: %v reloaded", name)
+		}
+	}
+}
+
+// Close stops watching, a reload started may still complete
+// goroutine safe
+func (w *Watcher) Close() {
+	w.watcher.Close()
+	w.wg.Wait()
+
+	w.mutexTimers.Lock()
+	for file, t := range w.timers {
+		t.Stop()
+		delete(w.timers, file)
+	}
+	w.mutexTimers.Unlock()
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
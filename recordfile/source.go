@@ -0,0 +1,154 @@
+package recordfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ReadJSON reads the records of a JSON array of objects keyed by the column
+// names, e.g. written by WriteJSON
+// goroutine safe
+func (rf *RecordFile) ReadJSON(name string) error {
+	return rf.load(name, func() error {
+		return rf.readJSON(name)
+	})
+}
+
+func (rf *RecordFile) readJSON(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var objects []json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return err
+	}
+
+	records := make([]interface{}, len(objects))
+	for n, object := range objects {
+		value := reflect.New(rf.typeRecord)
+		if err := rf.unmarshalJSON(object, value.Elem()); err != nil {
+			return fmt.Errorf("parse record %v error: %v", n, err)
+		}
+		records[n] = value.Interface()
+	}
+
+	return rf.setRecords(records)
+}
+
+// ReadXLSX reads the records of the sheet of a workbook, the first one if
+// sheet is "". The first row holds the column names, the columns may be in
+// any order and the other columns are ignored. The empty cells are zero
+// values, the rows empty or commented are skipped
+// goroutine safe
+func (rf *RecordFile) ReadXLSX(name string, sheet string) error {
+	return rf.load(name, func() error {
+		return rf.readXLSX(name, sheet)
+	})
+}
+
+func (rf *RecordFile) readXLSX(name string, sheet string) error {
+	file, err := excelize.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if sheet == "" {
+		sheet = file.GetSheetName(0)
+	}
+	rows, err := file.GetRows(sheet)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("sheet %v: no header", sheet)
+	}
+
+	if rf.Comment == 0 {
+		rf.Comment = Comment
+	}
+	typeRecord := rf.typeRecord
+
+	columns, err := rf.columns(rows[0])
+	if err != nil {
+		return fmt.Errorf("sheet %v: %v", sheet, err)
+	}
+
+	records := make([]interface{}, 0, len(rows)-1)
+	for n := 1; n < len(rows); n++ {
+		row := rows[n]
+		if len(row) == 0 || strings.HasPrefix(row[0], string(rf.Comment)) ||
+			strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		value := reflect.New(typeRecord)
+		record := value.Elem()
+		for i, col := range columns {
+			field := record.Field(i)
+			if !field.CanSet() || col >= len(row) || row[col] == "" {
+				continue
+			}
+			if err := setField(field, row[col]); err != nil {
+				return fmt.Errorf("parse field (sheet=%v, row=%v, col=%v) error: %v",
+					sheet, n+1, col+1, err)
+			}
+		}
+		records = append(records, value.Interface())
+	}
+
+	return rf.setRecords(records)
+}
+
+// unmarshalJSON sets the fields of record by the keys of object
+func (rf *RecordFile) unmarshalJSON(object []byte, record reflect.Value) error {
+	if !rf.byName {
+		return json.Unmarshal(object, record.Addr().Interface())
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(object, &values); err != nil {
+		return err
+	}
+	for i, fi := range rf.fields {
+		field := record.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		for key, v := range values {
+			if strings.EqualFold(key, fi.column) {
+				if err := json.Unmarshal(v, field.Addr().Interface()); err != nil {
+					return fmt.Errorf("%v: %v", key, err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// columns returns the column of each field in header, -1 for the unexported
+// fields not in header
+func (rf *RecordFile) columns(header []string) ([]int, error) {
+	columns := make([]int, len(rf.fields))
+	for i, fi := range rf.fields {
+		columns[i] = -1
+		for col, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), fi.column) {
+				columns[i] = col
+				break
+			}
+		}
+		if columns[i] < 0 && rf.typeRecord.Field(i).IsExported() {
+			return nil, fmt.Errorf("no column %v of field %v",
+				fi.column, rf.typeRecord.Field(i).Name)
+		}
+	}
+	return columns, nil
+}
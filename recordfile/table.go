@@ -0,0 +1,93 @@
+package recordfile
+
+import "fmt"
+
+// Table is a RecordFile of the records of type T, the records are read as
+// *T
+type Table[T any] struct {
+	*RecordFile
+}
+
+// NewTable checks the fields of T as New
+func NewTable[T any]() (*Table[T], error) {
+	var st T
+	rf, err := New(st)
+	if err != nil {
+		return nil, err
+	}
+	return &Table[T]{rf}, nil
+}
+
+// Load reads the table of the file name, see Read
+func Load[T any](name string) (*Table[T], error) {
+	t, err := NewTable[T]()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Read(name); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return t, nil
+}
+
+// Get returns the record of key in the first index, key is converted to the
+// type of the field
+// goroutine safe
+func (t *Table[T]) Get(key interface{}) (*T, bool) {
+	return t.GetBy(0, key)
+}
+
+// GetBy returns the record of key in the index i
+// goroutine safe
+func (t *Table[T]) GetBy(i int, key interface{}) (*T, bool) {
+	n := 0
+	for f, fi := range t.fields {
+		if !fi.index {
+			continue
+		}
+		if n == i {
+			k, ok := convert(key, t.typeRecord.Field(f).Type)
+			if !ok {
+				return nil, false
+			}
+			return record[T](t.Indexes(i)[k])
+		}
+		n++
+	}
+	return nil, false
+}
+
+// Find returns the record of the composite key by the values of its fields
+// goroutine safe
+func (t *Table[T]) Find(key string, values ...interface{}) (*T, bool) {
+	return record[T](t.Key(key, values...))
+}
+
+func record[T any](r interface{}) (*T, bool) {
+	if r == nil {
+		return nil, false
+	}
+	return r.(*T), true
+}
+
+// At returns the record i
+// goroutine safe
+func (t *Table[T]) At(i int) *T {
+	return t.Record(i).(*T)
+}
+
+// goroutine safe
+func (t *Table[T]) Len() int {
+	return t.NumRecord()
+}
+
+// Iterate calls f with the records loaded in order until f returns false,
+// a reload meanwhile isn't seen
+// goroutine safe
+func (t *Table[T]) Iterate(f func(r *T) bool) {
+	for _, r := range t.Records() {
+		if !f(r.(*T)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package recordfile
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// field is the mapping of a struct field, set by its tag. The raw tag
+// "index" indexes the field, the rf tag maps it:
+//
+//	Name  string `rf:"item_name"`          // the column "item_name"
+//	ID    int    `rf:"id,index"`           // indexed as "index"
+//	Kind  int    `rf:"kind,key=kind_lv"`   // with Level, the key "kind_lv"
+//	Level int    `rf:"level,key=kind_lv"`
+//
+// The columns are named as the fields by default
+type field struct {
+	column string
+	index  bool
+	// the composite key the field is a part of
+	key string
+}
+
+// the fields of a composite key at most
+const maxKeyParts = 4
+
+// compositeKey is a key of a composite index, the parts in the order of
+// the fields
+type compositeKey [maxKeyParts]interface{}
+
+// parseField returns the mapping of f, tagged is true if f has a rf tag
+func parseField(f reflect.StructField) (fi field, tagged bool) {
+	fi.column = f.Name
+	if f.Tag == "index" {
+		fi.index = true
+		return fi, false
+	}
+	tag, ok := f.Tag.Lookup("rf")
+	if !ok {
+		return fi, false
+	}
+
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		fi.column = opts[0]
+	}
+	for _, opt := range opts[1:] {
+		if opt == "index" {
+			fi.index = true
+		} else if key, ok := strings.CutPrefix(opt, "key="); ok {
+			fi.key = key
+		}
+	}
+	return fi, true
+}
+
+// keyParts returns the number of fields of the composite keys
+func keyParts(fields []field) map[string]int {
+	keys := make(map[string]int)
+	for _, fi := range fields {
+		if fi.key != "" {
+			keys[fi.key]++
+		}
+	}
+	return keys
+}
+
+// indexKeys indexes record r by its composite keys
+func (rf *RecordFile) indexKeys(keys map[string]Index, record reflect.Value, r interface{}) error {
+	for key, index := range keys {
+		var k compositeKey
+		n := 0
+		for i, fi := range rf.fields {
+			if fi.key == key {
+				k[n] = record.Field(i).Interface()
+				n++
+			}
+		}
+		if _, ok := index[k]; ok {
+			return fmt.Errorf("key %v error: duplicate", key)
+		}
+		index[k] = r
+	}
+	return nil
+}
+
+// Key returns the record of the composite key by the values of its fields,
+// converted to the types of the fields
+// goroutine safe
+func (rf *RecordFile) Key(key string, values ...interface{}) interface{} {
+	index := rf.current().keys[key]
+	if index == nil {
+		return nil
+	}
+
+	var k compositeKey
+	n := 0
+	for i, fi := range rf.fields {
+		if fi.key != key {
+			continue
+		}
+		if n >= len(values) {
+			return nil
+		}
+		v, ok := convert(values[n], rf.typeRecord.Field(i).Type)
+		if !ok {
+			return nil
+		}
+		k[n] = v
+		n++
+	}
+	if n != len(values) {
+		return nil
+	}
+	return index[k]
+}
+
+// convert converts v to t, e.g. an untyped constant to the type of a field
+func convert(v interface{}, t reflect.Type) (interface{}, bool) {
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return nil, false
+	}
+	if value.Type() == t {
+		return v, true
+	}
+	// not a number to a string
+	if !value.CanConvert(t) || (value.Kind() == reflect.String) != (t.Kind() == reflect.String) {
+		return nil, false
+	}
+	return value.Convert(t).Interface(), true
+}
@@ -0,0 +1,90 @@
+package room_test
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/room"
+)
+
+type agent struct {
+	gate.Agent
+	name string
+}
+
+func (a *agent) WriteMsg(msg interface{}) {
+	fmt.Println(a.name, "<-", msg)
+}
+
+func (a *agent) RemoteAddr() net.Addr {
+	return nil
+}
+
+type handler struct {
+	destroyed chan bool
+}
+
+func (h *handler) OnCreate(r *room.Room) {
+	fmt.Println("create", r.ID())
+}
+
+func (h *handler) OnJoin(r *room.Room, a gate.Agent) {
+	r.Broadcast("welcome " + a.(*agent).name)
+}
+
+func (h *handler) OnLeave(r *room.Room, a gate.Agent) {
+	fmt.Println("leave", a.(*agent).name)
+}
+
+func (h *handler) OnDestroy(r *room.Room) {
+	fmt.Println("destroy", r.ID())
+	h.destroyed <- true
+}
+
+func Example() {
+	m := new(room.Manager)
+	h := &handler{destroyed: make(chan bool, 1)}
+
+	r, err := m.Create(1, h)
+	if err != nil {
+		return
+	}
+	a := &agent{name: "leaf"}
+	r.Join(a)
+	r.Leave(a)
+
+	// destroyed when empty
+	<-h.destroyed
+	m.Close()
+
+	// Output:
+	// create 1
+	// leaf <- welcome leaf
+	// leave leaf
+	// destroy 1
+}
+
+func ExampleRoom_TryJoin() {
+	m := &room.Manager{Capacity: 1, EmptyTimeout: -1}
+	h := &handler{destroyed: make(chan bool, 1)}
+
+	r, err := m.Create("lobby", h)
+	if err != nil {
+		return
+	}
+	r.SetMeta("map", "desert")
+	fmt.Println(r.TryJoin(&agent{name: "leaf"}))
+	fmt.Println(r.TryJoin(&agent{name: "gopher"}))
+	fmt.Println(r.Meta("map"))
+	m.Close()
+
+	// Output:
+	// create lobby
+	// leaf <- welcome leaf
+	// <nil>
+	// room full
+	// desert true
+	// leave leaf
+	// destroy lobby
+}
@@ -0,0 +1,161 @@
+package room
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/network"
+)
+
+const replayMagic = "LEAFRPL1"
+
+// ----------------------------------
+// | offset ms | tick | len | data |  (uvarints)
+// ----------------------------------
+type recorder struct {
+	file      *os.File
+	w         *bufio.Writer
+	processor network.Processor
+	start     time.Time
+}
+
+type ReplayRecord struct {
+	Offset time.Duration
+	Tick   uint32
+	Msg    interface{}
+}
+
+// StartRecording writes every message broadcast in the room to the named
+// file, marshaled by processor
+// room goroutine only
+func (r *Room) StartRecording(name string, processor network.Processor) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if _, err := w.WriteString(replayMagic); err != nil {
+		file.Close()
+		return err
+	}
+
+	r.StopRecording()
+	r.recorder = &recorder{file: file, w: w, processor: processor, start: time.Now()}
+	return nil
+}
+
+// room goroutine only
+func (r *Room) StopRecording() error {
+	rec := r.recorder
+	if rec == nil {
+		return nil
+	}
+	r.recorder = nil
+
+	err := rec.w.Flush()
+	if e := rec.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// SetTick sets the tick recorded with the following messages
+// room goroutine only
+func (r *Room) SetTick(tick uint32) {
+	r.tick = tick
+}
+
+func (r *Room) record(msg interface{}) error {
+	rec := r.recorder
+	data, err := rec.processor.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var l int
+	for _, b := range data {
+		l += len(b)
+	}
+	var head [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(time.Since(rec.start)/time.Millisecond))
+	n += binary.PutUvarint(head[n:], uint64(r.tick))
+	n += binary.PutUvarint(head[n:], uint64(l))
+	if _, err := rec.w.Write(head[:n]); err != nil {
+		return err
+	}
+	for _, b := range data {
+		if _, err := rec.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadReplay reads a file written by StartRecording, messages are
+// unmarshaled by processor
+func LoadReplay(name string, processor network.Processor) ([]ReplayRecord, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	var m [len(replayMagic)]byte
+	if _, err := io.ReadFull(reader, m[:]); err != nil || string(m[:]) != replayMagic {
+		return nil, errors.New("invalid replay file")
+	}
+
+	var records []ReplayRecord
+	for {
+		offset, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tick, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		l, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+
+		msg, err := processor.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, ReplayRecord{
+			Offset: time.Duration(offset) * time.Millisecond,
+			Tick:   uint32(tick),
+			Msg:    msg,
+		})
+	}
+}
+
+// Replay writes the records to a, keeping the original timing divided by
+// speed (speed <= 0 sends everything at once)
+func Replay(records []ReplayRecord, a gate.Agent, speed float64) {
+	start := time.Now()
+	for _, rec := range records {
+		if speed > 0 {
+			d := time.Duration(float64(rec.Offset)/speed) - time.Since(start)
+			if d > 0 {
+				time.Sleep(d)
+			}
+		}
+		a.WriteMsg(rec.Msg)
+	}
+}
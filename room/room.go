@@ -0,0 +1,463 @@
+package room
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/chanrpc"
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/timer"
+)
+
+var (
+	ErrExists    = errors.New("room already exists")
+	ErrFull      = errors.New("room full")
+	ErrDestroyed = errors.New("room destroyed")
+)
+
+// all methods are called in the room goroutine
+type Handler interface {
+	OnCreate(r *Room)
+	OnJoin(r *Room, a gate.Agent)
+	OnLeave(r *Room, a gate.Agent)
+	OnDestroy(r *Room)
+}
+
+// UserHandler is implemented by the handlers of rooms with user members,
+// see JoinUser
+type UserHandler interface {
+	OnJoinUser(r *Room, userID string)
+	OnLeaveUser(r *Room, userID string)
+}
+
+// Users resolves the user IDs of the members joined by JoinUser, e.g. a
+// gate.Gate or a gate.MultiGate
+type Users interface {
+	AgentOf(userID string) (gate.Agent, bool)
+}
+
+type EventType int
+
+const (
+	EventCreate EventType = iota
+	EventJoin
+	EventLeave
+	EventDestroy
+)
+
+// Event is sent to Manager.Events as "RoomEvent":
+//
+//	skeleton.RegisterChanRPC("RoomEvent", func(args []interface{}) {
+//		e := args[0].(room.Event)
+//	})
+type Event struct {
+	Type EventType
+	Room *Room
+	// the member joining or leaving, an agent or a user
+	Agent  gate.Agent
+	UserID string
+}
+
+type Manager struct {
+	ChanRPCLen int
+	// destroy a room after it has been empty for EmptyTimeout,
+	// zero destroys it as soon as the last member leaves,
+	// negative keeps empty rooms
+	EmptyTimeout time.Duration
+	// destroy a room after MaxLifetime, zero means no limit
+	MaxLifetime time.Duration
+	// the members of a room at most, zero is unlimited. See SetCapacity
+	Capacity int
+	// resolves the user members, needed by JoinUser
+	Users Users
+	// receives the room events, e.g. the ChanRPCServer of a module
+	Events     *chanrpc.Server
+	rooms      map[interface{}]*Room
+	mutexRooms sync.Mutex
+	wg         sync.WaitGroup
+}
+
+type Room struct {
+	id         interface{}
+	manager    *Manager
+	handler    Handler
+	skeleton   *module.Skeleton
+	closeSig   chan bool
+	members    map[gate.Agent]struct{}
+	users      map[string]struct{}
+	capacity   int
+	meta       gate.Values
+	destroyed  bool
+	emptyTimer *timer.Timer
+	recorder   *recorder
+	tick       uint32
+}
+
+func (m *Manager) init() {
+	if m.rooms != nil {
+		return
+	}
+	if m.ChanRPCLen <= 0 {
+		m.ChanRPCLen = 100
+		log.Release("invalid ChanRPCLen, reset to %v", m.ChanRPCLen)
+	}
+	m.rooms = make(map[interface{}]*Room)
+}
+
+// goroutine safe
+func (m *Manager) Create(id interface{}, handler Handler) (*Room, error) {
+	m.mutexRooms.Lock()
+	m.init()
+	if _, ok := m.rooms[id]; ok {
+		m.mutexRooms.Unlock()
+		return nil, ErrExists
+	}
+
+	r := new(Room)
+	r.id = id
+	r.manager = m
+	r.handler = handler
+	r.members = make(map[gate.Agent]struct{})
+	r.users = make(map[string]struct{})
+	r.capacity = m.Capacity
+	r.closeSig = make(chan bool, 1)
+	r.skeleton = &module.Skeleton{
+		GoLen:              10,
+		TimerDispatcherLen: 10,
+		AsynCallLen:        10,
+		ChanRPCServer:      chanrpc.NewServer(m.ChanRPCLen),
+	}
+	r.skeleton.Init()
+	r.skeleton.RegisterChanRPC("exec", func(args []interface{}) {
+		args[0].(func())()
+	})
+	m.rooms[id] = r
+	m.mutexRooms.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		r.skeleton.Run(r.closeSig)
+		m.wg.Done()
+	}()
+
+	r.Exec(func() {
+		if m.MaxLifetime > 0 {
+			r.skeleton.AfterFunc(m.MaxLifetime, r.destroy)
+		}
+		r.handler.OnCreate(r)
+		r.event(Event{Type: EventCreate})
+		if m.EmptyTimeout > 0 {
+			r.checkEmpty()
+		}
+	})
+	return r, nil
+}
+
+// goroutine safe
+func (m *Manager) Get(id interface{}) *Room {
+	m.mutexRooms.Lock()
+	defer m.mutexRooms.Unlock()
+	return m.rooms[id]
+}
+
+// goroutine safe
+func (m *Manager) Destroy(id interface{}) {
+	if r := m.Get(id); r != nil {
+		r.Destroy()
+	}
+}
+
+// goroutine safe
+func (m *Manager) Range(f func(r *Room)) {
+	m.mutexRooms.Lock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mutexRooms.Unlock()
+
+	for _, r := range rooms {
+		f(r)
+	}
+}
+
+// Close destroys all rooms and waits for their goroutines to exit
+func (m *Manager) Close() {
+	m.Range(func(r *Room) {
+		r.Destroy()
+	})
+	m.wg.Wait()
+}
+
+func (r *Room) ID() interface{} {
+	return r.id
+}
+
+// the skeleton running the room goroutine, e.g. for AfterFunc
+func (r *Room) Skeleton() *module.Skeleton {
+	return r.skeleton
+}
+
+// f is called in the room goroutine
+// goroutine safe
+func (r *Room) Exec(f func()) {
+	r.skeleton.ChanRPCServer.Go("exec", f)
+}
+
+// Join adds the agent to the room, it is dropped if the room is full
+// goroutine safe
+func (r *Room) Join(a gate.Agent) {
+	r.Exec(func() {
+		if err := r.join(a); err != nil {
+			log.Debug("join room %v: %v", r.id, err)
+		}
+	})
+}
+
+// TryJoin is Join waiting for the agent to be added
+// goroutine safe
+func (r *Room) TryJoin(a gate.Agent) error {
+	var err error
+	if r.skeleton.ChanRPCServer.Call0("exec", func() { err = r.join(a) }) != nil {
+		return ErrDestroyed
+	}
+	return err
+}
+
+func (r *Room) join(a gate.Agent) error {
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if _, ok := r.members[a]; ok {
+		return nil
+	}
+	if r.full() {
+		return ErrFull
+	}
+	r.members[a] = struct{}{}
+	r.stopEmptyTimer()
+	r.handler.OnJoin(r, a)
+	r.event(Event{Type: EventJoin, Agent: a})
+	return nil
+}
+
+// goroutine safe
+func (r *Room) Leave(a gate.Agent) {
+	r.Exec(func() {
+		if _, ok := r.members[a]; !ok {
+			return
+		}
+		delete(r.members, a)
+		r.handler.OnLeave(r, a)
+		r.event(Event{Type: EventLeave, Agent: a})
+		r.checkEmpty()
+	})
+}
+
+// JoinUser adds the user to the room, the messages broadcast are written to
+// the agent of the user when it is online, so the user stays a member
+// across reconnections. It needs Manager.Users
+// goroutine safe
+func (r *Room) JoinUser(userID string) {
+	r.Exec(func() {
+		if err := r.joinUser(userID); err != nil {
+			log.Debug("join room %v: %v", r.id, err)
+		}
+	})
+}
+
+// TryJoinUser is JoinUser waiting for the user to be added
+// goroutine safe
+func (r *Room) TryJoinUser(userID string) error {
+	var err error
+	if r.skeleton.ChanRPCServer.Call0("exec", func() { err = r.joinUser(userID) }) != nil {
+		return ErrDestroyed
+	}
+	return err
+}
+
+func (r *Room) joinUser(userID string) error {
+	if r.manager.Users == nil {
+		return errors.New("manager has no Users")
+	}
+	if r.destroyed {
+		return ErrDestroyed
+	}
+	if _, ok := r.users[userID]; ok {
+		return nil
+	}
+	if r.full() {
+		return ErrFull
+	}
+	r.users[userID] = struct{}{}
+	r.stopEmptyTimer()
+	if h, ok := r.handler.(UserHandler); ok {
+		h.OnJoinUser(r, userID)
+	}
+	r.event(Event{Type: EventJoin, UserID: userID})
+	return nil
+}
+
+// goroutine safe
+func (r *Room) LeaveUser(userID string) {
+	r.Exec(func() {
+		if _, ok := r.users[userID]; !ok {
+			return
+		}
+		r.leaveUser(userID)
+		r.checkEmpty()
+	})
+}
+
+func (r *Room) leaveUser(userID string) {
+	delete(r.users, userID)
+	if h, ok := r.handler.(UserHandler); ok {
+		h.OnLeaveUser(r, userID)
+	}
+	r.event(Event{Type: EventLeave, UserID: userID})
+}
+
+// goroutine safe
+func (r *Room) Destroy() {
+	r.Exec(r.destroy)
+}
+
+// SetCapacity changes the members of the room at most, zero is unlimited.
+// The members already joined stay
+// goroutine safe
+func (r *Room) SetCapacity(n int) {
+	r.Exec(func() {
+		r.capacity = n
+	})
+}
+
+func (r *Room) full() bool {
+	return r.capacity > 0 && r.Len() >= r.capacity
+}
+
+// SetMeta attaches a value to the room, e.g. the map of a lobby
+// goroutine safe
+func (r *Room) SetMeta(key string, v interface{}) {
+	r.meta.Set(key, v)
+}
+
+// goroutine safe
+func (r *Room) Meta(key string) (interface{}, bool) {
+	return r.meta.Get(key)
+}
+
+// goroutine safe
+func (r *Room) DelMeta(key string) {
+	r.meta.Del(key)
+}
+
+// the agents joined, the user members are not included
+// room goroutine only
+func (r *Room) Members() []gate.Agent {
+	members := make([]gate.Agent, 0, len(r.members))
+	for a := range r.members {
+		members = append(members, a)
+	}
+	return members
+}
+
+// room goroutine only
+func (r *Room) Users() []string {
+	users := make([]string, 0, len(r.users))
+	for userID := range r.users {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// the agents and users joined
+// room goroutine only
+func (r *Room) Len() int {
+	return len(r.members) + len(r.users)
+}
+
+// Broadcast writes msg to the members, it is marshaled once per processor
+// room goroutine only
+func (r *Room) Broadcast(msg interface{}) {
+	if r.recorder != nil {
+		if err := r.record(msg); err != nil {
+			log.Error("record room %v error: %v", r.id, err)
+			r.StopRecording()
+		}
+	}
+
+	agents := make([]gate.Agent, 0, r.Len())
+	for a := range r.members {
+		agents = append(agents, a)
+	}
+	for userID := range r.users {
+		if a, ok := r.manager.Users.AgentOf(userID); ok {
+			// joined both ways
+			if _, ok := r.members[a]; !ok {
+				agents = append(agents, a)
+			}
+		}
+	}
+	gate.MulticastAgents(agents, msg)
+}
+
+func (r *Room) event(e Event) {
+	if r.manager.Events == nil {
+		return
+	}
+	e.Room = r
+	r.manager.Events.Go("RoomEvent", e)
+}
+
+func (r *Room) stopEmptyTimer() {
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+		r.emptyTimer = nil
+	}
+}
+
+func (r *Room) checkEmpty() {
+	if r.Len() > 0 || r.destroyed || r.manager.EmptyTimeout < 0 {
+		return
+	}
+	if r.manager.EmptyTimeout <= 0 {
+		r.destroy()
+		return
+	}
+	if r.emptyTimer == nil {
+		r.emptyTimer = r.skeleton.AfterFunc(r.manager.EmptyTimeout, r.destroy)
+	}
+}
+
+func (r *Room) destroy() {
+	if r.destroyed {
+		return
+	}
+	r.destroyed = true
+
+	for a := range r.members {
+		delete(r.members, a)
+		r.handler.OnLeave(r, a)
+		r.event(Event{Type: EventLeave, Agent: a})
+	}
+	for userID := range r.users {
+		r.leaveUser(userID)
+	}
+	r.handler.OnDestroy(r)
+	r.event(Event{Type: EventDestroy})
+	if err := r.StopRecording(); err != nil {
+		log.Error("record room %v error: %v", r.id, err)
+	}
+
+	m := r.manager
+	m.mutexRooms.Lock()
+	if m.rooms[r.id] == r {
+		delete(m.rooms, r.id)
+	}
+	m.mutexRooms.Unlock()
+
+	r.closeSig <- true
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package leaf
+
+import (
+	"os"
+)
+
+var upgradeSignal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package leaf
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal makes Run hand its listeners off to a new process
+var upgradeSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,33 @@
+package statesync_test
+
+import (
+	"fmt"
+
+	"github.com/czx-lab/leaf/statesync"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Example() {
+	t := statesync.NewTracker(0)
+	state, _ := structpb.NewStruct(map[string]interface{}{"hp": 100, "x": 1})
+
+	u, _ := t.Update(1, state)
+	fmt.Println(u.Full)
+
+	_, ok := t.Update(1, state)
+	fmt.Println(ok)
+
+	state.Fields["hp"] = structpb.NewNumberValue(90)
+	u, ok = t.Update(1, state)
+	fmt.Println(ok, u.Full)
+
+	client, _ := structpb.NewStruct(map[string]interface{}{"hp": 100, "x": 1})
+	u.Apply(client)
+	fmt.Println(client.Fields["hp"].GetNumberValue())
+
+	// Output:
+	// true
+	// false
+	// true false
+	// 90
+}
@@ -0,0 +1,97 @@
+package statesync
+
+import (
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/network/protobuf"
+	"google.golang.org/protobuf/proto"
+)
+
+// Diff compares two states of the same message type at top-level field
+// granularity, see protobuf.Diff
+func Diff(prev, cur proto.Message) (delta proto.Message, cleared []int32, changed bool) {
+	return protobuf.Diff(prev, cur)
+}
+
+// Apply applies a delta returned by Diff to dst
+func Apply(dst, delta proto.Message, cleared []int32) {
+	protobuf.ApplyDelta(dst, delta, cleared)
+}
+
+type Update struct {
+	ID interface{}
+	// Full means State is a complete snapshot (keyframe), not a delta
+	Full    bool
+	State   proto.Message
+	Cleared []int32
+}
+
+// Tracker keeps the last snapshot of every entity and turns new states into
+// updates. Every KeyframeInterval updates of an entity (zero means never
+// after the first one) a full snapshot is sent instead of a delta.
+// goroutine not safe
+type Tracker struct {
+	KeyframeInterval int
+	entities         map[interface{}]*entity
+}
+
+type entity struct {
+	last  proto.Message
+	count int
+}
+
+func NewTracker(keyframeInterval int) *Tracker {
+	t := new(Tracker)
+	t.KeyframeInterval = keyframeInterval
+	t.entities = make(map[interface{}]*entity)
+	return t
+}
+
+// Update captures state and returns the update to send, ok is false if
+// nothing changed since the last update
+func (t *Tracker) Update(id interface{}, state proto.Message) (u *Update, ok bool) {
+	e, exists := t.entities[id]
+	if !exists {
+		e = new(entity)
+		t.entities[id] = e
+	}
+
+	keyframe := !exists || t.KeyframeInterval > 0 && e.count >= t.KeyframeInterval
+	if keyframe {
+		e.last = proto.Clone(state)
+		e.count = 0
+		return &Update{ID: id, Full: true, State: proto.Clone(state)}, true
+	}
+
+	delta, cleared, changed := Diff(e.last, state)
+	if !changed {
+		return nil, false
+	}
+	e.last = proto.Clone(state)
+	e.count++
+	return &Update{ID: id, State: delta, Cleared: cleared}, true
+}
+
+// Send writes the update of an entity to a (through the gate processor),
+// newMsg wraps the update into a registered message
+func (t *Tracker) Send(a gate.Agent, id interface{}, state proto.Message, newMsg func(u *Update) interface{}) bool {
+	u, ok := t.Update(id, state)
+	if ok {
+		a.WriteMsg(newMsg(u))
+	}
+	return ok
+}
+
+// Forget drops the snapshot of an entity, the next update is a keyframe
+func (t *Tracker) Forget(id interface{}) {
+	delete(t.entities, id)
+}
+
+// Apply applies an update to the state kept by the receiver
+func (u *Update) Apply(dst proto.Message) {
+	if u.Full {
+		proto.Reset(dst)
+		proto.Merge(dst, u.State)
+		return
+	}
+	Apply(dst, u.State, u.Cleared)
+}
@@ -0,0 +1,32 @@
+package leaf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/czx-lab/leaf/gate"
+	"github.com/czx-lab/leaf/module"
+)
+
+type skeleton interface {
+	Stats() module.SkeletonStats
+}
+
+// stats is the output of the console stats command
+func stats() string {
+	var lines []string
+	for _, g := range gate.Gates() {
+		lines = append(lines, fmt.Sprintf("gate %v: agents %v, online %v",
+			g.Name, g.AgentCount(), g.OnlineCount()))
+	}
+	module.Range(func(mi module.Module) {
+		s, ok := mi.(skeleton)
+		if !ok {
+			return
+		}
+		st := s.Stats()
+		lines = append(lines, fmt.Sprintf("module %v: chanrpc %v, timers %v, go %v",
+			module.Name(mi), st.ChanRPCLen, st.Timers, st.PendingGo))
+	})
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,98 @@
+package task
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+)
+
+type ClaimResult int
+
+const (
+	// the node runs the job
+	ClaimWon ClaimResult = iota
+	// another node holds an unexpired lease
+	ClaimHeld
+	// the run is finished
+	ClaimDone
+)
+
+// Run is an execution history entry
+type Run struct {
+	Key       string    `bson:"key"`
+	Scheduled time.Time `bson:"scheduled"`
+	Node      string    `bson:"node"`
+	Start     time.Time `bson:"start"`
+	End       time.Time `bson:"end"`
+}
+
+// ClusterStore is a Store shared by the nodes of a cluster, every scheduled
+// run is claimed before being executed. must goroutine safe
+type ClusterStore interface {
+	Store
+	// a claim not finished within lease can be taken over by another node
+	Claim(key string, scheduled time.Time, node string, lease time.Duration) (ClaimResult, error)
+	Finish(run *Run) error
+	// the latest n runs of key, latest first
+	History(key string, n int) ([]*Run, error)
+}
+
+// NewClusterScheduler returns a scheduler running each scheduled time of a job
+// on exactly one node. A node failing during a run loses its claim after
+// lease and the run is taken over by another node, so lease must be longer
+// than the jobs
+func NewClusterScheduler(s *module.Skeleton, store ClusterStore, node string, lease time.Duration) *Scheduler {
+	sched := NewScheduler(s, store)
+	sched.cluster = store
+	sched.node = node
+	sched.lease = lease
+	return sched
+}
+
+func (sched *Scheduler) claim(job *Job, scheduled time.Time) {
+	var result ClaimResult
+	var err error
+	sched.skeleton.Go(func() {
+		result, err = sched.cluster.Claim(job.Key, scheduled, sched.node, sched.lease)
+	}, func() {
+		if sched.jobs[job.Key] != job {
+			return
+		}
+		if err != nil {
+			log.Error("claim job %v error: %v", job.Key, err)
+			result = ClaimHeld
+		}
+
+		switch result {
+		case ClaimWon:
+			sched.runClaimed(job, scheduled)
+		case ClaimHeld:
+			sched.skeleton.AfterFunc(sched.lease, func() {
+				if sched.jobs[job.Key] == job {
+					sched.claim(job, scheduled)
+				}
+			})
+		}
+	})
+}
+
+func (sched *Scheduler) runClaimed(job *Job, scheduled time.Time) {
+	run := &Run{Key: job.Key, Scheduled: scheduled, Node: sched.node, Start: time.Now()}
+	job.Run(scheduled)
+	run.End = time.Now()
+
+	if scheduled.After(job.lastRun) {
+		job.lastRun = scheduled
+	}
+	var err error
+	sched.skeleton.Go(func() {
+		if err = sched.cluster.Finish(run); err == nil {
+			err = sched.store.SetLastRun(job.Key, scheduled)
+		}
+	}, func() {
+		if err != nil {
+			log.Error("finish job %v error: %v", job.Key, err)
+		}
+	})
+}
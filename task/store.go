@@ -0,0 +1,171 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/db/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileStore keeps the last run times in a JSON file
+type FileStore struct {
+	sync.Mutex
+	Name string
+}
+
+func (s *FileStore) load() (map[string]time.Time, error) {
+	m := make(map[string]time.Time)
+	data, err := os.ReadFile(s.Name)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+func (s *FileStore) LastRun(key string) (time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	m, err := s.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return m[key], nil
+}
+
+func (s *FileStore) SetLastRun(key string, t time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	m, err := s.load()
+	if err != nil {
+		return err
+	}
+	m[key] = t
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.Name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Name)
+}
+
+// MongoStore is a ClusterStore when the nodes share the database
+type MongoStore struct {
+	Dial       *mongodb.DialContext
+	DB         string
+	Collection string
+	// claims and execution history, Collection + "_runs" if empty
+	RunCollection string
+}
+
+func (s *MongoStore) LastRun(key string) (time.Time, error) {
+	var doc struct {
+		LastRun time.Time `bson:"last_run"`
+	}
+	err := s.Dial.C(s.DB, s.Collection).FindOne(context.Background(), bson.M{"_id": key}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return doc.LastRun, err
+}
+
+func (s *MongoStore) SetLastRun(key string, t time.Time) error {
+	_, err := s.Dial.C(s.DB, s.Collection).UpdateOne(context.Background(), bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"last_run": t},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *MongoStore) runs() *mongo.Collection {
+	name := s.RunCollection
+	if name == "" {
+		name = s.Collection + "_runs"
+	}
+	return s.Dial.C(s.DB, name)
+}
+
+func runID(key string, scheduled time.Time) string {
+	return fmt.Sprintf("%v@%v", key, scheduled.Unix())
+}
+
+func (s *MongoStore) Claim(key string, scheduled time.Time, node string, lease time.Duration) (ClaimResult, error) {
+	ctx := context.Background()
+	c := s.runs()
+	id := runID(key, scheduled)
+	now := time.Now()
+	_, err := c.InsertOne(ctx, bson.M{
+		"_id":       id,
+		"key":       key,
+		"scheduled": scheduled,
+		"node":      node,
+		"start":     now,
+		"lease":     now.Add(lease),
+	})
+	if err == nil {
+		return ClaimWon, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return ClaimHeld, err
+	}
+
+	// take over an expired claim
+	res, err := c.UpdateOne(ctx, bson.M{
+		"_id":   id,
+		"end":   bson.M{"$exists": false},
+		"lease": bson.M{"$lt": now},
+	}, bson.M{
+		"$set": bson.M{"node": node, "start": now, "lease": now.Add(lease)},
+	})
+	if err != nil {
+		return ClaimHeld, err
+	}
+	if res.MatchedCount > 0 {
+		return ClaimWon, nil
+	}
+
+	n, err := c.CountDocuments(ctx, bson.M{"_id": id, "end": bson.M{"$exists": true}})
+	if err != nil {
+		return ClaimHeld, err
+	}
+	if n > 0 {
+		return ClaimDone, nil
+	}
+	return ClaimHeld, nil
+}
+
+func (s *MongoStore) Finish(run *Run) error {
+	res, err := s.runs().UpdateByID(context.Background(), runID(run.Key, run.Scheduled), bson.M{
+		"$set": bson.M{"node": run.Node, "start": run.Start, "end": run.End},
+	})
+	if err == nil && res.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func (s *MongoStore) History(key string, n int) ([]*Run, error) {
+	ctx := context.Background()
+	cursor, err := s.runs().Find(ctx, bson.M{"key": key},
+		options.Find().SetSort(bson.M{"scheduled": -1}).SetLimit(int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	err = cursor.All(ctx, &runs)
+	return runs, err
+}
@@ -0,0 +1,125 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/module"
+	"github.com/czx-lab/leaf/timer"
+)
+
+// must goroutine safe
+type Store interface {
+	// zero time if the job never ran
+	LastRun(key string) (time.Time, error)
+	SetLastRun(key string, t time.Time) error
+}
+
+type Job struct {
+	// idempotency key, a scheduled time is run at most once per key
+	Key  string
+	Cron string
+	// run a missed schedule (e.g. the server was down) when the job is added
+	CatchUp bool
+	// called in the skeleton goroutine with the scheduled time
+	Run func(scheduled time.Time)
+
+	cronExpr *timer.CronExpr
+	lastRun  time.Time
+	cron     *timer.Cron
+}
+
+// one scheduler per skeleton (goroutine not safe)
+type Scheduler struct {
+	skeleton *module.Skeleton
+	store    Store
+	jobs     map[string]*Job
+
+	// cluster
+	cluster ClusterStore
+	node    string
+	lease   time.Duration
+}
+
+// s needs GoLen and TimerDispatcherLen
+func NewScheduler(s *module.Skeleton, store Store) *Scheduler {
+	sched := new(Scheduler)
+	sched.skeleton = s
+	sched.store = store
+	sched.jobs = make(map[string]*Job)
+	return sched
+}
+
+func (sched *Scheduler) Add(job *Job) error {
+	if _, ok := sched.jobs[job.Key]; ok {
+		return fmt.Errorf("job %v is already added", job.Key)
+	}
+	cronExpr, err := timer.NewCronExpr(job.Cron)
+	if err != nil {
+		return err
+	}
+	lastRun, err := sched.store.LastRun(job.Key)
+	if err != nil {
+		return err
+	}
+
+	job.cronExpr = cronExpr
+	job.lastRun = lastRun
+	sched.jobs[job.Key] = job
+
+	now := time.Now()
+	if lastRun.IsZero() {
+		// first deployment, nothing was missed
+		sched.done(job, now)
+	} else if job.CatchUp {
+		var missed time.Time
+		for t := cronExpr.Next(lastRun); !t.IsZero() && !t.After(now); t = cronExpr.Next(t) {
+			missed = t
+		}
+		if !missed.IsZero() {
+			log.Release("job %v missed %v, run it now", job.Key, missed)
+			sched.run(job, missed)
+		}
+	}
+
+	job.cron = sched.skeleton.CronFunc(cronExpr, func() {
+		sched.run(job, time.Now().Truncate(time.Second))
+	})
+	return nil
+}
+
+func (sched *Scheduler) Remove(key string) {
+	job, ok := sched.jobs[key]
+	if !ok {
+		return
+	}
+	job.cron.Stop()
+	delete(sched.jobs, key)
+}
+
+func (sched *Scheduler) run(job *Job, scheduled time.Time) {
+	if !scheduled.After(job.lastRun) {
+		return
+	}
+	if sched.cluster != nil {
+		job.lastRun = scheduled
+		sched.claim(job, scheduled)
+		return
+	}
+	job.Run(scheduled)
+	sched.done(job, scheduled)
+}
+
+func (sched *Scheduler) done(job *Job, t time.Time) {
+	job.lastRun = t
+
+	var err error
+	sched.skeleton.Go(func() {
+		err = sched.store.SetLastRun(job.Key, t)
+	}, func() {
+		if err != nil {
+			log.Error("save last run of job %v error: %v", job.Key, err)
+		}
+	})
+}
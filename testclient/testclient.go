@@ -0,0 +1,251 @@
+package testclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/network"
+)
+
+var ErrTimeout = errors.New("testclient: timeout")
+
+// Bot is a scripted client connection
+type Bot struct {
+	ID        int
+	Data      interface{}
+	conn      network.Conn
+	processor network.Processor
+	recv      chan interface{}
+	closed    chan struct{}
+	runner    *Runner
+}
+
+// Runner connects Bots clients to Addr and runs Script for each of them
+type Runner struct {
+	Addr string
+	// dial Addr (ws://...) with a WSClient instead of a TCPClient
+	WS        bool
+	Bots      int
+	Processor network.Processor
+	Script    func(b *Bot) error
+	// messages buffered per bot before they are dropped
+	RecvLen int
+
+	// msg parser
+	LenMsgLen    int
+	MaxMsgLen    uint32
+	LittleEndian bool
+
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	latencies []time.Duration
+	errs      []error
+	nextID    int
+}
+
+type Report struct {
+	Bots     int
+	Failed   int
+	Errors   []error
+	Requests int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+	Duration time.Duration
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("bots: %v, failed: %v, requests: %v, p50: %v, p90: %v, p99: %v, max: %v, duration: %v",
+		r.Bots, r.Failed, r.Requests, r.P50, r.P90, r.P99, r.Max, r.Duration)
+}
+
+// Run blocks until every script returned
+func (r *Runner) Run() *Report {
+	if r.RecvLen <= 0 {
+		r.RecvLen = 100
+	}
+	start := time.Now()
+	r.wg.Add(r.Bots)
+
+	var closer interface{ Close() }
+	if r.WS {
+		client := &network.WSClient{
+			Addr:      r.Addr,
+			ConnNum:   r.Bots,
+			MaxMsgLen: r.MaxMsgLen,
+			NewAgent: func(conn *network.WSConn) network.Agent {
+				return r.newBot(conn)
+			},
+		}
+		client.Start()
+		closer = client
+	} else {
+		client := &network.TCPClient{
+			Addr:         r.Addr,
+			ConnNum:      r.Bots,
+			LenMsgLen:    r.LenMsgLen,
+			MaxMsgLen:    r.MaxMsgLen,
+			LittleEndian: r.LittleEndian,
+			NewAgent: func(conn *network.TCPConn) network.Agent {
+				return r.newBot(conn)
+			},
+		}
+		client.Start()
+		closer = client
+	}
+
+	r.wg.Wait()
+	closer.Close()
+	return r.report(time.Since(start))
+}
+
+func (r *Runner) newBot(conn network.Conn) *Bot {
+	r.mutex.Lock()
+	r.nextID++
+	id := r.nextID
+	r.mutex.Unlock()
+
+	return &Bot{
+		ID:        id,
+		conn:      conn,
+		processor: r.Processor,
+		recv:      make(chan interface{}, r.RecvLen),
+		closed:    make(chan struct{}),
+		runner:    r,
+	}
+}
+
+func (r *Runner) report(d time.Duration) *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rep := &Report{
+		Bots:     r.Bots,
+		Failed:   len(r.errs),
+		Errors:   r.errs,
+		Requests: len(r.latencies),
+		Duration: d,
+	}
+	if len(r.latencies) == 0 {
+		return rep
+	}
+
+	l := r.latencies
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	percentile := func(p float64) time.Duration {
+		return l[int(float64(len(l)-1)*p)]
+	}
+	rep.P50 = percentile(0.5)
+	rep.P90 = percentile(0.9)
+	rep.P99 = percentile(0.99)
+	rep.Max = l[len(l)-1]
+	return rep
+}
+
+// Run implements network.Agent
+func (b *Bot) Run() {
+	go func() {
+		defer b.runner.wg.Done()
+		if err := b.Script(); err != nil {
+			b.runner.mutex.Lock()
+			b.runner.errs = append(b.runner.errs, fmt.Errorf("bot %v: %v", b.ID, err))
+			b.runner.mutex.Unlock()
+		}
+		b.conn.Close()
+	}()
+
+	defer close(b.closed)
+	for {
+		data, err := b.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		var msg interface{}
+		if sp, ok := b.processor.(network.StatefulProcessor); ok {
+			msg, err = sp.UnmarshalFrom(b, data)
+		} else {
+			msg, err = b.processor.Unmarshal(data)
+		}
+		if err != nil {
+			continue
+		}
+		select {
+		case b.recv <- msg:
+		default:
+		}
+	}
+}
+
+// OnClose implements network.Agent
+func (b *Bot) OnClose() {
+	if sp, ok := b.processor.(network.StatefulProcessor); ok {
+		sp.Release(b)
+	}
+}
+
+func (b *Bot) Script() error {
+	return b.runner.Script(b)
+}
+
+func (b *Bot) Send(msg interface{}) error {
+	var data [][]byte
+	var err error
+	if sp, ok := b.processor.(network.StatefulProcessor); ok {
+		data, err = sp.MarshalTo(b, msg)
+	} else {
+		data, err = b.processor.Marshal(msg)
+	}
+	if err != nil {
+		return err
+	}
+	return b.conn.WriteMsg(data...)
+}
+
+// Expect waits for a message accepted by match, other messages are dropped
+func (b *Bot) Expect(match func(msg interface{}) bool, timeout time.Duration) (interface{}, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	for {
+		select {
+		case msg := <-b.recv:
+			if match == nil || match(msg) {
+				return msg, nil
+			}
+		case <-b.closed:
+			return nil, errors.New("testclient: connection closed")
+		case <-t.C:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// Request sends msg and waits for the response, the latency is reported
+func (b *Bot) Request(msg interface{}, match func(msg interface{}) bool, timeout time.Duration) (interface{}, error) {
+	start := time.Now()
+	if err := b.Send(msg); err != nil {
+		return nil, err
+	}
+	ret, err := b.Expect(match, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	b.runner.mutex.Lock()
+	b.runner.latencies = append(b.runner.latencies, time.Since(start))
+	b.runner.mutex.Unlock()
+	return ret, nil
+}
+
+// Is returns a matcher accepting messages of the same type as msg
+func Is(msg interface{}) func(interface{}) bool {
+	want := reflect.TypeOf(msg)
+	return func(m interface{}) bool {
+		return reflect.TypeOf(m) == want
+	}
+}
@@ -186,6 +186,7 @@ func (e *CronExpr) matchDay(t time.Time) bool {
 		1<<uint(t.Day())&e.dom != 0
 }
 
+// Next returns the time matching e after t, in the location of t
 // goroutine safe
 func (e *CronExpr) Next(t time.Time) time.Time {
 	// the upcoming second
@@ -230,7 +231,8 @@ retry:
 	for 1<<uint(t.Hour())&e.hour == 0 {
 		if !initFlag {
 			initFlag = true
-			t = t.Truncate(time.Hour)
+			// not Truncate, the offset of the location may be a half hour
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
 		}
 
 		t = t.Add(time.Hour)
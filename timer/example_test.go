@@ -44,6 +44,20 @@ func ExampleCronExpr() {
 	// 2000-01-01 21:00:00 +0000 UTC
 }
 
+func ExampleCronExpr_Next() {
+	// every day at 05:00:30 in India, whatever the timezone of the host
+	cronExpr, err := timer.NewCronExpr("30 0 5 * * *")
+	if err != nil {
+		return
+	}
+
+	ist := time.FixedZone("IST", 5*3600+1800)
+	fmt.Println(cronExpr.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, ist)))
+
+	// Output:
+	// 2000-01-01 05:00:30 +0530 IST
+}
+
 func ExampleCron() {
 	d := timer.NewDispatcher(10)
 
@@ -66,3 +80,68 @@ func ExampleCron() {
 	// Output:
 	// My name is Leaf
 }
+
+func ExampleNewWheelDispatcher() {
+	d := timer.NewWheelDispatcher(10, 10*time.Millisecond)
+	defer d.Close()
+
+	d.AfterFunc(20*time.Millisecond, func() {
+		fmt.Println("buff expired")
+	})
+	t := d.AfterFunc(10*time.Millisecond, func() {
+		fmt.Println("will not print")
+	})
+	t.Stop()
+
+	// dispatch
+	(<-d.ChanTimer).Cb()
+	fmt.Println(d.Len())
+
+	// Output:
+	// buff expired
+	// 0
+}
+
+// memStore stands for a database, e.g. mongodb.TimerStore
+type memStore map[string]time.Time
+
+func (s memStore) Save(key string, at time.Time) error {
+	s[key] = at
+	return nil
+}
+
+func (s memStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s memStore) LoadAll() (map[string]time.Time, error) {
+	return s, nil
+}
+
+func ExampleDispatcher_AfterFuncPersistent() {
+	store := memStore{}
+
+	// before the restart
+	d := timer.NewDispatcher(10)
+	d.SetStore(store)
+	d.AfterFuncPersistent("auction:1", time.Hour, func() {})
+	fmt.Println(len(store))
+
+	// after the restart, the auction is overdue
+	store["auction:1"] = time.Now().Add(-time.Minute)
+	d = timer.NewDispatcher(10)
+	d.SetStore(store)
+	d.Restore(func(key string) func() {
+		return func() {
+			fmt.Println(key, "closed")
+		}
+	})
+	(<-d.ChanTimer).Cb()
+	fmt.Println(len(store))
+
+	// Output:
+	// 1
+	// auction:1 closed
+	// 0
+}
@@ -0,0 +1,82 @@
+package timer
+
+import (
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
+)
+
+// OverlapPolicy decides what happens when a cron job is due while its
+// previous run is still executing
+type OverlapPolicy int
+
+const (
+	// run concurrently with the previous run
+	OverlapAllow OverlapPolicy = iota
+	// skip the run, skipped runs are logged
+	OverlapSkip
+	// run once the previous run finishes
+	OverlapQueue
+)
+
+// CronJob runs in its own goroutine so that slow jobs don't block the
+// dispatcher goroutine
+type CronJob struct {
+	Name    string
+	Expr    *CronExpr
+	Overlap OverlapPolicy
+	Run     func()
+	// Expr is matched with the wall clock of Location, time.Local if nil
+	Location *time.Location
+
+	running int
+	queued  int
+}
+
+// CronJob schedules job, its overlap bookkeeping is done on the dispatcher
+// goroutine
+func (disp *Dispatcher) CronJob(job *CronJob) *Cron {
+	loc := job.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return disp.CronFuncIn(loc, job.Expr, func() {
+		if job.running > 0 {
+			switch job.Overlap {
+			case OverlapSkip:
+				log.Release("cron job %v skipped: previous run still executing", job.Name)
+				return
+			case OverlapQueue:
+				job.queued++
+				return
+			}
+		}
+		disp.startJob(job)
+	})
+}
+
+func (disp *Dispatcher) startJob(job *CronJob) {
+	job.running++
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				util.Recovered(r, util.PanicContext{Source: "cron", Module: disp.Name, ID: job.Name})
+			}
+			disp.post(func() {
+				job.running--
+				if job.queued > 0 {
+					job.queued--
+					disp.startJob(job)
+				}
+			})
+		}()
+
+		job.Run()
+	}()
+}
+
+// post runs cb on the dispatcher goroutine
+func (disp *Dispatcher) post(cb func()) {
+	disp.ChanTimer <- &Timer{cb: cb}
+}
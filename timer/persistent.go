@@ -0,0 +1,94 @@
+package timer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/czx-lab/leaf/log"
+)
+
+// TimerStore records the fire times of the persistent timers, e.g.
+// mongodb.TimerStore
+type TimerStore interface {
+	Save(key string, at time.Time) error
+	Delete(key string) error
+	LoadAll() (map[string]time.Time, error)
+}
+
+// SetStore sets the store of the persistent timers, call it before
+// AfterFuncPersistent and Restore
+func (disp *Dispatcher) SetStore(store TimerStore) {
+	disp.store = store
+}
+
+// AfterFuncPersistent is AfterFunc recording the fire time of the timer in
+// the store under key, so Restore schedules it again after a restart. The
+// record is deleted once cb returns or the timer is stopped, a timer of the
+// same key is replaced
+func (disp *Dispatcher) AfterFuncPersistent(key string, d time.Duration, cb func()) (*Timer, error) {
+	if disp.store == nil {
+		return nil, errors.New("timer store not set")
+	}
+	if err := disp.store.Save(key, time.Now().Add(d)); err != nil {
+		return nil, err
+	}
+	return disp.afterFuncPersistent(key, d, cb), nil
+}
+
+func (disp *Dispatcher) afterFuncPersistent(key string, d time.Duration, cb func()) *Timer {
+	if old := disp.persistent[key]; old != nil {
+		// replaced, the record is the new one's
+		old.key = ""
+		old.Stop()
+	}
+	if disp.persistent == nil {
+		disp.persistent = make(map[string]*Timer)
+	}
+
+	var t *Timer
+	t = disp.AfterFunc(d, func() {
+		defer disp.forget(t)
+		cb()
+	})
+	t.key = key
+	disp.persistent[key] = t
+	return t
+}
+
+// Restore schedules the timers recorded in the store, the overdue ones fire
+// at once. resolve returns the callback of a key, the records of the keys
+// resolved to nil are deleted
+func (disp *Dispatcher) Restore(resolve func(key string) func()) error {
+	if disp.store == nil {
+		return errors.New("timer store not set")
+	}
+	records, err := disp.store.LoadAll()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for key, at := range records {
+		cb := resolve(key)
+		if cb == nil {
+			if err := disp.store.Delete(key); err != nil {
+				log.Error("delete timer %v: %v", key, err)
+			}
+			continue
+		}
+		disp.afterFuncPersistent(key, at.Sub(now), cb)
+	}
+	return nil
+}
+
+// forget deletes the record of t
+func (disp *Dispatcher) forget(t *Timer) {
+	if t.key == "" || disp.persistent[t.key] != t {
+		return
+	}
+	delete(disp.persistent, t.key)
+	if err := disp.store.Delete(t.key); err != nil {
+		log.Error("delete timer %v: %v", t.key, err)
+	}
+	t.key = ""
+}
@@ -1,16 +1,23 @@
 package timer
 
 import (
-	"runtime"
+	"sync/atomic"
 	"time"
 
-	"github.com/czx-lab/leaf/conf"
-	"github.com/czx-lab/leaf/log"
+	"github.com/czx-lab/leaf/util"
 )
 
 // one dispatcher per goroutine (goroutine not safe)
 type Dispatcher struct {
 	ChanTimer chan *Timer
+	timers    atomic.Int64
+	// the module, reported with the panics recovered
+	Name string
+	// nil unless created by NewWheelDispatcher
+	wheel *wheel
+	// key -> timer of AfterFuncPersistent
+	store      TimerStore
+	persistent map[string]*Timer
 }
 
 func NewDispatcher(l int) *Dispatcher {
@@ -19,32 +26,80 @@ func NewDispatcher(l int) *Dispatcher {
 	return disp
 }
 
+// NewWheelDispatcher schedules the timers with a timing wheel ticking every
+// tick instead of a runtime timer each, for many timers. A timer fires up to
+// a tick late. Close stops the wheel
+func NewWheelDispatcher(l int, tick time.Duration) *Dispatcher {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+
+	disp := NewDispatcher(l)
+	disp.wheel = newWheel(tick, disp.ChanTimer)
+	return disp
+}
+
+// Close stops the wheel of the dispatcher, the timers pending never fire
+func (disp *Dispatcher) Close() {
+	if disp.wheel != nil {
+		disp.wheel.stop()
+	}
+}
+
+// Len returns the number of timers not fired or stopped
+// goroutine safe
+func (disp *Dispatcher) Len() int {
+	return int(disp.timers.Load())
+}
+
 // Timer
 type Timer struct {
 	t  *time.Timer
 	cb func()
+	// nil for the callbacks posted to the dispatcher
+	disp *Dispatcher
+
+	// the wheel slot, guarded by the wheel mutex
+	expire     uint64
+	bucket     *bucket
+	prev, next *Timer
+	// the key of a persistent timer
+	key string
 }
 
 func (t *Timer) Stop() {
-	t.t.Stop()
+	if t.t != nil {
+		t.t.Stop()
+	} else if t.disp != nil && t.disp.wheel != nil {
+		t.disp.wheel.remove(t)
+	}
+	if t.key != "" && t.cb != nil {
+		t.disp.forget(t)
+	}
+	t.done()
 	t.cb = nil
 }
 
+func (t *Timer) done() {
+	if t.cb != nil && t.disp != nil {
+		t.disp.timers.Add(-1)
+	}
+}
+
 func (t *Timer) Cb() {
 	defer func() {
 		t.cb = nil
 		if r := recover(); r != nil {
-			if conf.LenStackBuf > 0 {
-				buf := make([]byte, conf.LenStackBuf)
-				l := runtime.Stack(buf, false)
-				log.Error("%v: %s", r, buf[:l])
-			} else {
-				log.Error("%v", r)
+			ctx := util.PanicContext{Source: "timer"}
+			if t.disp != nil {
+				ctx.Module = t.disp.Name
 			}
+			util.Recovered(r, ctx)
 		}
 	}()
 
 	if t.cb != nil {
+		t.done()
 		t.cb()
 	}
 }
@@ -52,6 +107,12 @@ func (t *Timer) Cb() {
 func (disp *Dispatcher) AfterFunc(d time.Duration, cb func()) *Timer {
 	t := new(Timer)
 	t.cb = cb
+	t.disp = disp
+	disp.timers.Add(1)
+	if disp.wheel != nil {
+		disp.wheel.add(t, d)
+		return t
+	}
 	t.t = time.AfterFunc(d, func() {
 		disp.ChanTimer <- t
 	})
@@ -70,9 +131,16 @@ func (c *Cron) Stop() {
 }
 
 func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
+	return disp.CronFuncIn(time.Local, cronExpr, _cb)
+}
+
+// CronFuncIn matches cronExpr with the wall clock of loc, e.g. a daily reset
+// at 05:00 of the players' timezone whatever the timezone of the host. A
+// wall clock time repeated when DST ends fires once
+func (disp *Dispatcher) CronFuncIn(loc *time.Location, cronExpr *CronExpr, _cb func()) *Cron {
 	c := new(Cron)
 
-	now := time.Now()
+	now := time.Now().In(loc)
 	nextTime := cronExpr.Next(now)
 	if nextTime.IsZero() {
 		return c
@@ -83,8 +151,12 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	cb = func() {
 		defer _cb()
 
-		now := time.Now()
-		nextTime := cronExpr.Next(now)
+		last := nextTime
+		now := time.Now().In(loc)
+		nextTime = cronExpr.Next(now)
+		if sameWallClock(nextTime, last) {
+			nextTime = cronExpr.Next(nextTime)
+		}
 		if nextTime.IsZero() {
 			return
 		}
@@ -94,3 +166,12 @@ func (disp *Dispatcher) CronFunc(cronExpr *CronExpr, _cb func()) *Cron {
 	c.t = disp.AfterFunc(nextTime.Sub(now), cb)
 	return c
 }
+
+// sameWallClock reports whether a and b read the same on a clock of their
+// location, a and b differ when the clock was set back
+func sameWallClock(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd &&
+		a.Hour() == b.Hour() && a.Minute() == b.Minute() && a.Second() == b.Second()
+}
@@ -0,0 +1,187 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// the wheel has a level of 256 ticks and three levels of 64 slots, a timer
+// due later than 2^26 ticks is cascaded down again when its slot expires
+const (
+	wheelBits0   = 8
+	wheelBits    = 6
+	wheelLevels  = 4
+	wheelSlots0  = 1 << wheelBits0
+	wheelSlots   = 1 << wheelBits
+	wheelMaxSpan = 1<<(wheelBits0+(wheelLevels-1)*wheelBits) - 1
+)
+
+// bucket is a list of timers linked through the timers
+type bucket struct {
+	head *Timer
+}
+
+func (b *bucket) push(t *Timer) {
+	t.bucket = b
+	t.prev = nil
+	t.next = b.head
+	if b.head != nil {
+		b.head.prev = t
+	}
+	b.head = t
+}
+
+func (b *bucket) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		b.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.bucket, t.prev, t.next = nil, nil, nil
+}
+
+// take empties b and returns its timers
+func (b *bucket) take() *Timer {
+	h := b.head
+	b.head = nil
+	return h
+}
+
+// wheel is a hierarchical timing wheel driven by one ticker goroutine,
+// adding and stopping a timer is O(1)
+type wheel struct {
+	mutex sync.Mutex
+	tick  time.Duration
+	start time.Time
+	// the next tick to expire
+	now    uint64
+	levels [wheelLevels][]bucket
+	ch     chan *Timer
+	close  chan struct{}
+}
+
+func newWheel(tick time.Duration, ch chan *Timer) *wheel {
+	w := new(wheel)
+	w.tick = tick
+	w.start = time.Now()
+	w.ch = ch
+	w.close = make(chan struct{})
+	w.levels[0] = make([]bucket, wheelSlots0)
+	for i := 1; i < wheelLevels; i++ {
+		w.levels[i] = make([]bucket, wheelSlots)
+	}
+	go w.run()
+	return w
+}
+
+// add schedules t d from now, it never expires early
+func (w *wheel) add(t *Timer, d time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ticks := uint64(0)
+	if d > 0 {
+		ticks = uint64((d + w.tick - 1) / w.tick)
+	}
+	// the next tick expires within a tick
+	t.expire = w.now + ticks
+	w.place(t)
+}
+
+// place puts t in the slot of its expiry, the caller holds the mutex
+func (w *wheel) place(t *Timer) {
+	expire := t.expire
+	if expire < w.now {
+		expire = w.now
+	}
+	delta := expire - w.now
+	if delta > wheelMaxSpan {
+		expire = w.now + wheelMaxSpan
+		delta = wheelMaxSpan
+	}
+
+	if delta < wheelSlots0 {
+		w.levels[0][expire&(wheelSlots0-1)].push(t)
+		return
+	}
+	for i := 1; i < wheelLevels; i++ {
+		shift := uint(wheelBits0 + i*wheelBits)
+		if delta < 1<<shift || i == wheelLevels-1 {
+			w.levels[i][(expire>>(shift-wheelBits))&(wheelSlots-1)].push(t)
+			return
+		}
+	}
+}
+
+// remove returns false if t already expired
+func (w *wheel) remove(t *Timer) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if t.bucket == nil {
+		return false
+	}
+	t.bucket.remove(t)
+	return true
+}
+
+// step expires the tick w.now and returns the timers expired
+func (w *wheel) step() *Timer {
+	idx := w.now & (wheelSlots0 - 1)
+	// the timers of the next slot of each level move down
+	for i := 1; i < wheelLevels && idx == 0; i++ {
+		shift := uint(wheelBits0 + (i-1)*wheelBits)
+		idx = (w.now >> shift) & (wheelSlots - 1)
+		for t := w.levels[i][idx].take(); t != nil; {
+			next := t.next
+			t.bucket, t.prev, t.next = nil, nil, nil
+			w.place(t)
+			t = next
+		}
+	}
+
+	expired := w.levels[0][w.now&(wheelSlots0-1)].take()
+	for t := expired; t != nil; t = t.next {
+		t.bucket = nil
+	}
+	w.now++
+	return expired
+}
+
+func (w *wheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.close:
+			return
+		case now := <-ticker.C:
+			// catches up with the ticks dropped
+			due := uint64(now.Sub(w.start) / w.tick)
+			w.mutex.Lock()
+			var expired []*Timer
+			for w.now < due {
+				for t := w.step(); t != nil; t = t.next {
+					expired = append(expired, t)
+				}
+			}
+			w.mutex.Unlock()
+
+			for _, t := range expired {
+				select {
+				case w.ch <- t:
+				case <-w.close:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *wheel) stop() {
+	close(w.close)
+}
@@ -0,0 +1,161 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is a LRU cache with optional per-entry expiry
+// goroutine safe
+type Cache[K comparable, V any] struct {
+	maxEntries int
+	ttl        time.Duration
+	// OnEvict is called outside the lock when an entry is evicted, expired
+	// or deleted. Set it before using the cache
+	OnEvict func(key K, value V)
+
+	mutex  sync.Mutex
+	ll     *list.List
+	items  map[K]*list.Element
+	hits   uint64
+	misses uint64
+	evicts uint64
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	expireAt time.Time
+}
+
+type CacheStats struct {
+	Len       int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// maxEntries <= 0 means no limit, ttl <= 0 means entries never expire
+func NewCache[K comparable, V any](maxEntries int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[K]*list.Element),
+	}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	var evicted []*cacheEntry[K, V]
+	c.mutex.Lock()
+	e, ok := c.items[key]
+	if ok {
+		ent := e.Value.(*cacheEntry[K, V])
+		if ent.expireAt.IsZero() || time.Now().Before(ent.expireAt) {
+			c.ll.MoveToFront(e)
+			c.hits++
+			c.mutex.Unlock()
+			return ent.value, true
+		}
+		evicted = append(evicted, c.remove(e))
+	}
+	c.misses++
+	c.mutex.Unlock()
+
+	c.evicted(evicted)
+	var zero V
+	return zero, false
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetTTL(key, value, c.ttl)
+}
+
+// SetTTL stores value with its own ttl, ttl <= 0 means it never expires
+func (c *Cache[K, V]) SetTTL(key K, value V, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+
+	var evicted []*cacheEntry[K, V]
+	c.mutex.Lock()
+	if e, ok := c.items[key]; ok {
+		ent := e.Value.(*cacheEntry[K, V])
+		ent.value = value
+		ent.expireAt = expireAt
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry[K, V]{key: key, value: value, expireAt: expireAt})
+		for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+			evicted = append(evicted, c.remove(c.ll.Back()))
+		}
+	}
+	c.mutex.Unlock()
+
+	c.evicted(evicted)
+}
+
+func (c *Cache[K, V]) Del(key K) {
+	var evicted []*cacheEntry[K, V]
+	c.mutex.Lock()
+	if e, ok := c.items[key]; ok {
+		evicted = append(evicted, c.remove(e))
+		c.evicts--
+	}
+	c.mutex.Unlock()
+
+	c.evicted(evicted)
+}
+
+// Purge removes all expired entries
+func (c *Cache[K, V]) Purge() {
+	now := time.Now()
+	var evicted []*cacheEntry[K, V]
+	c.mutex.Lock()
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		ent := e.Value.(*cacheEntry[K, V])
+		if !ent.expireAt.IsZero() && !now.Before(ent.expireAt) {
+			evicted = append(evicted, c.remove(e))
+		}
+		e = prev
+	}
+	c.mutex.Unlock()
+
+	c.evicted(evicted)
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{
+		Len:       c.ll.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evicts,
+	}
+}
+
+func (c *Cache[K, V]) remove(e *list.Element) *cacheEntry[K, V] {
+	ent := c.ll.Remove(e).(*cacheEntry[K, V])
+	delete(c.items, ent.key)
+	c.evicts++
+	return ent
+}
+
+func (c *Cache[K, V]) evicted(entries []*cacheEntry[K, V]) {
+	if c.OnEvict == nil {
+		return
+	}
+	for _, ent := range entries {
+		c.OnEvict(ent.key, ent.value)
+	}
+}
@@ -3,51 +3,160 @@ package util
 // reference: https://github.com/mohae/deepcopy
 import (
 	"reflect"
+	"sync"
 )
 
-func deepCopy(dst, src reflect.Value) {
-	switch src.Kind() {
+// a copy plan is computed once per type
+type copier func(dst, src reflect.Value)
+
+type plan struct {
+	copy copier
+}
+
+var (
+	plans      sync.Map
+	mutexPlans sync.Mutex
+)
+
+func planOf(t reflect.Type) *plan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*plan)
+	}
+
+	mutexPlans.Lock()
+	defer mutexPlans.Unlock()
+	building := make(map[reflect.Type]*plan)
+	p := buildPlan(t, building)
+	for t, p := range building {
+		plans.Store(t, p)
+	}
+	return p
+}
+
+// types whose values can be copied by assignment
+func isPlain(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+		return false
+	case reflect.Array:
+		return isPlain(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			return false
+		}
+		visiting[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Tag.Get("deepcopy") == "-" || !isPlain(f.Type, visiting) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+func buildPlan(t reflect.Type, building map[reflect.Type]*plan) *plan {
+	if p, ok := plans.Load(t); ok {
+		return p.(*plan)
+	}
+	if p, ok := building[t]; ok {
+		// recursive type, filled in below
+		return p
+	}
+	p := new(plan)
+	building[t] = p
+
+	if isPlain(t, make(map[reflect.Type]bool)) {
+		p.copy = func(dst, src reflect.Value) {
+			dst.Set(src)
+		}
+		return p
+	}
+
+	switch t.Kind() {
 	case reflect.Interface:
-		value := src.Elem()
-		if !value.IsValid() {
-			return
+		p.copy = func(dst, src reflect.Value) {
+			value := src.Elem()
+			if !value.IsValid() {
+				return
+			}
+			newValue := reflect.New(value.Type()).Elem()
+			planOf(value.Type()).copy(newValue, value)
+			dst.Set(newValue)
 		}
-		newValue := reflect.New(value.Type()).Elem()
-		deepCopy(newValue, value)
-		dst.Set(newValue)
 	case reflect.Ptr:
-		value := src.Elem()
-		if !value.IsValid() {
-			return
+		elem := buildPlan(t.Elem(), building)
+		p.copy = func(dst, src reflect.Value) {
+			if src.IsNil() {
+				return
+			}
+			value := src.Elem()
+			dst.Set(reflect.New(value.Type()))
+			elem.copy(dst.Elem(), value)
 		}
-		dst.Set(reflect.New(value.Type()))
-		deepCopy(dst.Elem(), value)
 	case reflect.Map:
-		dst.Set(reflect.MakeMap(src.Type()))
-		keys := src.MapKeys()
-		for _, key := range keys {
-			value := src.MapIndex(key)
-			newValue := reflect.New(value.Type()).Elem()
-			deepCopy(newValue, value)
-			dst.SetMapIndex(key, newValue)
+		elem := buildPlan(t.Elem(), building)
+		p.copy = func(dst, src reflect.Value) {
+			if src.IsNil() {
+				return
+			}
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+			newValue := reflect.New(t.Elem()).Elem()
+			iter := src.MapRange()
+			for iter.Next() {
+				newValue.SetZero()
+				elem.copy(newValue, iter.Value())
+				dst.SetMapIndex(iter.Key(), newValue)
+			}
 		}
 	case reflect.Slice:
-		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Cap()))
-		for i := 0; i < src.Len(); i++ {
-			deepCopy(dst.Index(i), src.Index(i))
+		elem := buildPlan(t.Elem(), building)
+		plainElem := isPlain(t.Elem(), make(map[reflect.Type]bool))
+		p.copy = func(dst, src reflect.Value) {
+			if src.IsNil() {
+				return
+			}
+			dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Cap()))
+			if plainElem {
+				reflect.Copy(dst, src)
+				return
+			}
+			for i := 0; i < src.Len(); i++ {
+				elem.copy(dst.Index(i), src.Index(i))
+			}
+		}
+	case reflect.Array:
+		elem := buildPlan(t.Elem(), building)
+		p.copy = func(dst, src reflect.Value) {
+			for i := 0; i < src.Len(); i++ {
+				elem.copy(dst.Index(i), src.Index(i))
+			}
 		}
 	case reflect.Struct:
-		typeSrc := src.Type()
-		for i := 0; i < src.NumField(); i++ {
-			value := src.Field(i)
-			tag := typeSrc.Field(i).Tag
-			if value.CanSet() && tag.Get("deepcopy") != "-" {
-				deepCopy(dst.Field(i), value)
+		type fieldPlan struct {
+			index int
+			plan  *plan
+		}
+		var fields []fieldPlan
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && f.Tag.Get("deepcopy") != "-" {
+				fields = append(fields, fieldPlan{i, buildPlan(f.Type, building)})
+			}
+		}
+		p.copy = func(dst, src reflect.Value) {
+			for _, f := range fields {
+				f.plan.copy(dst.Field(f.index), src.Field(f.index))
 			}
 		}
 	default:
-		dst.Set(src)
+		p.copy = func(dst, src reflect.Value) {
+			dst.Set(src)
+		}
 	}
+	return p
 }
 
 func DeepCopy(dst, src interface{}) {
@@ -66,11 +175,11 @@ func DeepCopy(dst, src interface{}) {
 		panic("DeepCopy: invalid arguments")
 	}
 
-	deepCopy(valueDst, valueSrc)
+	planOf(typeSrc.Elem()).copy(valueDst, valueSrc)
 }
 
 func DeepClone(v interface{}) interface{} {
 	dst := reflect.New(reflect.TypeOf(v)).Elem()
-	deepCopy(dst, reflect.ValueOf(v))
+	planOf(reflect.TypeOf(v)).copy(dst, reflect.ValueOf(v))
 	return dst.Interface()
 }
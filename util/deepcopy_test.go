@@ -0,0 +1,57 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/czx-lab/leaf/util"
+)
+
+type benchItem struct {
+	ID    int
+	Count int32
+	Attrs map[string]int
+}
+
+type benchPlayer struct {
+	Name  string
+	Level int
+	Pos   [3]float32
+	Items []benchItem
+	Equip map[int]*benchItem
+	Extra interface{}
+}
+
+func newBenchPlayer() *benchPlayer {
+	p := &benchPlayer{
+		Name:  "leaf",
+		Level: 10,
+		Equip: make(map[int]*benchItem),
+		Extra: []int{1, 2, 3},
+	}
+	for i := 0; i < 20; i++ {
+		item := benchItem{ID: i, Count: 1, Attrs: map[string]int{"atk": i}}
+		p.Items = append(p.Items, item)
+		if i < 5 {
+			p.Equip[i] = &item
+		}
+	}
+	return p
+}
+
+func BenchmarkDeepCopy(b *testing.B) {
+	src := newBenchPlayer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var dst benchPlayer
+		util.DeepCopy(&dst, src)
+	}
+}
+
+func BenchmarkDeepCopyPlain(b *testing.B) {
+	src := make([][3]float32, 1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var dst [][3]float32
+		util.DeepCopy(&dst, &src)
+	}
+}
@@ -1,6 +1,7 @@
 package util_test
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/czx-lab/leaf/util"
@@ -91,3 +92,132 @@ func ExampleDeepClone() {
 	// 2
 	// 3
 }
+
+func ExampleShardedMap() {
+	m := util.NewShardedMap[string, int](16)
+
+	fmt.Println(m.Get("key"))
+	m.Set("key", 1)
+	fmt.Println(m.Get("key"))
+	fmt.Println(m.TestAndSet("key", 2))
+	m.Del("key")
+	fmt.Println(m.Len())
+
+	// Output:
+	// 0 false
+	// 1 true
+	// 1 true
+	// 0
+}
+
+func ExampleRandWeighted() {
+	type item struct {
+		name   string
+		weight uint32
+	}
+	items := []item{{"common", 0}, {"rare", 1}}
+
+	r := util.NewRand(42)
+	it, ok := util.RandWeighted(r, items, func(it item) uint32 { return it.weight })
+	fmt.Println(it.name, ok)
+
+	// the same seed produces the same sequence
+	a, b := util.NewRand(7), util.NewRand(7)
+	fmt.Println(a.Interval(1, 100) == b.Interval(1, 100))
+
+	// Output:
+	// rare true
+	// true
+}
+
+func ExampleSemaphore() {
+	s := util.MakeSemaphore(1)
+
+	fmt.Println(s.TryAcquire())
+	fmt.Println(s.TryAcquire())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fmt.Println(s.AcquireCtx(ctx))
+
+	s.Release()
+	fmt.Println(s.AcquireCtx(context.Background()))
+
+	// Output:
+	// true
+	// false
+	// context canceled
+	// <nil>
+}
+
+func ExampleCache() {
+	c := util.NewCache[string, int](2, 0)
+	c.OnEvict = func(key string, value int) {
+		fmt.Println("evict", key, value)
+	}
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	fmt.Println(c.Get("b"))
+	fmt.Printf("%+v\n", c.Stats())
+
+	// Output:
+	// evict b 2
+	// 0 false
+	// {Len:2 Hits:1 Misses:1 Evictions:1}
+}
+
+func ExampleRing() {
+	r := util.NewRing[int](3, true)
+	for i := 1; i <= 5; i++ {
+		r.Push(i)
+	}
+	fmt.Println(r.Slice())
+	fmt.Println(r.Pop())
+
+	f := util.NewRing[int](1, false)
+	fmt.Println(f.Push(1), f.Push(2))
+
+	// Output:
+	// [3 4 5]
+	// 3 true
+	// <nil> ring buffer full
+}
+
+func ExampleSafeGo() {
+	util.TrackGoroutines = true
+	defer func() { util.TrackGoroutines = false }()
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	util.SafeGo("worker", func() {
+		defer close(done)
+		<-release
+	})
+	fmt.Println(util.Goroutines())
+
+	close(release)
+	<-done
+
+	// Output:
+	// [{worker 1}]
+}
+
+func ExampleSetPanicHandler() {
+	done := make(chan struct{})
+	util.SetPanicHandler(func(r interface{}, stack []byte, ctx util.PanicContext) {
+		fmt.Println(ctx, r, len(stack) > 0)
+		close(done)
+	})
+	defer util.SetPanicHandler(nil)
+
+	util.SafeGo("worker", func() {
+		panic("boom")
+	})
+	<-done
+
+	// Output:
+	// goroutine worker boom true
+}
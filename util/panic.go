@@ -0,0 +1,72 @@
+package util
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+)
+
+// PanicContext describes where a panic was recovered
+type PanicContext struct {
+	// chanrpc, callback (of chanrpc), go, timer, cron, goroutine, actor or
+	// module
+	Source string
+	// the type of the module, empty if unknown
+	Module string
+	// the chanrpc function id (the message type of a routed message), cron
+	// job name, goroutine name or actor id
+	ID interface{}
+	// the arguments of the chanrpc function, e.g. the message and the agent
+	Args []interface{}
+}
+
+func (c PanicContext) String() string {
+	s := c.Source
+	if c.ID != nil {
+		s += fmt.Sprintf(" %v", c.ID)
+	}
+	if c.Module != "" {
+		s = c.Module + " " + s
+	}
+	return s
+}
+
+// PanicHandler is called with the value recovered and the whole stack of
+// the goroutine which panicked. The goroutine goes on once it returns, a
+// handler exiting the process crashes fast instead
+type PanicHandler func(recovered interface{}, stack []byte, ctx PanicContext)
+
+var panicHandler atomic.Pointer[PanicHandler]
+
+// SetPanicHandler replaces the logging of the panics recovered by leaf, nil
+// restores it
+// goroutine safe
+func SetPanicHandler(h PanicHandler) {
+	if h == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&h)
+}
+
+// Recovered reports r recovered by a deferred function of ctx
+// goroutine safe
+func Recovered(r interface{}, ctx PanicContext) {
+	stack := debug.Stack()
+	if h := panicHandler.Load(); h != nil {
+		(*h)(r, stack, ctx)
+		return
+	}
+
+	if conf.LenStackBuf > 0 {
+		if len(stack) > conf.LenStackBuf {
+			stack = stack[:conf.LenStackBuf]
+		}
+		log.Error("%v: %v: %s", ctx, r, stack)
+	} else {
+		log.Error("%v: %v", ctx, r)
+	}
+}
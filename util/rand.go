@@ -9,28 +9,103 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// Rand is a random source with an explicit seed, runs seeded alike produce
+// the same sequence. A nil *Rand uses the global source
+// It's not goroutine safe unless nil
+type Rand struct {
+	r *rand.Rand
+}
+
+func NewRand(seed int64) *Rand {
+	return &Rand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Rand) Int63n(n int64) int64 {
+	if r == nil {
+		return rand.Int63n(n)
+	}
+	return r.r.Int63n(n)
+}
+
+func (r *Rand) Intn(n int) int {
+	if r == nil {
+		return rand.Intn(n)
+	}
+	return r.r.Intn(n)
+}
+
+func (r *Rand) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	return r.r.Float64()
+}
+
 func RandGroup(p ...uint32) int {
+	return (*Rand)(nil).Group(p...)
+}
+
+func RandInterval(b1, b2 int32) int32 {
+	return (*Rand)(nil).Interval(b1, b2)
+}
+
+func RandIntervalN(b1, b2 int32, n uint32) []int32 {
+	return (*Rand)(nil).IntervalN(b1, b2, n)
+}
+
+// RandWeighted returns one of items picked with probability proportional to
+// its weight, ok is false if every weight is zero
+func RandWeighted[T any](r *Rand, items []T, weight func(T) uint32) (item T, ok bool) {
+	var total uint64
+	for _, it := range items {
+		total += uint64(weight(it))
+	}
+	if total == 0 {
+		return
+	}
+
+	rn := uint64(r.Int63n(int64(total)))
+	for _, it := range items {
+		w := uint64(weight(it))
+		if rn < w {
+			return it, true
+		}
+		rn -= w
+	}
+
+	panic("bug")
+}
+
+// Shuffle randomizes the order of s in place
+func Shuffle[T any](r *Rand, s []T) {
+	for i := len(s) - 1; i > 0; i-- {
+		j := r.Intn(i + 1)
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+func (r *Rand) Group(p ...uint32) int {
 	if p == nil {
 		panic("args not found")
 	}
 
-	r := make([]uint32, len(p))
+	sum := make([]uint32, len(p))
 	for i := 0; i < len(p); i++ {
 		if i == 0 {
-			r[0] = p[0]
+			sum[0] = p[0]
 		} else {
-			r[i] = r[i-1] + p[i]
+			sum[i] = sum[i-1] + p[i]
 		}
 	}
 
-	rl := r[len(r)-1]
+	rl := sum[len(sum)-1]
 	if rl == 0 {
 		return 0
 	}
 
-	rn := uint32(rand.Int63n(int64(rl)))
-	for i := 0; i < len(r); i++ {
-		if rn < r[i] {
+	rn := uint32(r.Int63n(int64(rl)))
+	for i := 0; i < len(sum); i++ {
+		if rn < sum[i] {
 			return i
 		}
 	}
@@ -38,7 +113,7 @@ func RandGroup(p ...uint32) int {
 	panic("bug")
 }
 
-func RandInterval(b1, b2 int32) int32 {
+func (r *Rand) Interval(b1, b2 int32) int32 {
 	if b1 == b2 {
 		return b1
 	}
@@ -47,10 +122,10 @@ func RandInterval(b1, b2 int32) int32 {
 	if min > max {
 		min, max = max, min
 	}
-	return int32(rand.Int63n(max-min+1) + min)
+	return int32(r.Int63n(max-min+1) + min)
 }
 
-func RandIntervalN(b1, b2 int32, n uint32) []int32 {
+func (r *Rand) IntervalN(b1, b2 int32, n uint32) []int32 {
 	if b1 == b2 {
 		return []int32{b1}
 	}
@@ -64,15 +139,15 @@ func RandIntervalN(b1, b2 int32, n uint32) []int32 {
 		n = uint32(l)
 	}
 
-	r := make([]int32, n)
+	s := make([]int32, n)
 	m := make(map[int32]int32)
 	for i := uint32(0); i < n; i++ {
-		v := int32(rand.Int63n(l) + min)
+		v := int32(r.Int63n(l) + min)
 
 		if mv, ok := m[v]; ok {
-			r[i] = mv
+			s[i] = mv
 		} else {
-			r[i] = v
+			s[i] = v
 		}
 
 		lv := int32(l - 1 + min)
@@ -87,5 +162,5 @@ func RandIntervalN(b1, b2 int32, n uint32) []int32 {
 		l--
 	}
 
-	return r
+	return s
 }
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"errors"
+)
+
+var ErrRingFull = errors.New("ring buffer full")
+
+// Ring is a fixed capacity FIFO buffer. When full, Push either overwrites
+// the oldest element or fails with ErrRingFull
+// It's not goroutine safe
+type Ring[T any] struct {
+	buf       []T
+	head      int
+	len       int
+	overwrite bool
+}
+
+func NewRing[T any](capacity int, overwrite bool) *Ring[T] {
+	if capacity <= 0 {
+		panic("invalid ring capacity")
+	}
+	return &Ring[T]{buf: make([]T, capacity), overwrite: overwrite}
+}
+
+func (r *Ring[T]) Push(v T) error {
+	if r.len == len(r.buf) {
+		if !r.overwrite {
+			return ErrRingFull
+		}
+		r.buf[r.head] = v
+		r.head = (r.head + 1) % len(r.buf)
+		return nil
+	}
+	r.buf[(r.head+r.len)%len(r.buf)] = v
+	r.len++
+	return nil
+}
+
+// Pop removes and returns the oldest element
+func (r *Ring[T]) Pop() (v T, ok bool) {
+	if r.len == 0 {
+		return
+	}
+	var zero T
+	v, r.buf[r.head] = r.buf[r.head], zero
+	r.head = (r.head + 1) % len(r.buf)
+	r.len--
+	return v, true
+}
+
+// Peek returns the oldest element without removing it
+func (r *Ring[T]) Peek() (v T, ok bool) {
+	if r.len == 0 {
+		return
+	}
+	return r.buf[r.head], true
+}
+
+func (r *Ring[T]) Len() int {
+	return r.len
+}
+
+func (r *Ring[T]) Cap() int {
+	return len(r.buf)
+}
+
+func (r *Ring[T]) Full() bool {
+	return r.len == len(r.buf)
+}
+
+// Slice returns a copy of the elements, oldest first
+func (r *Ring[T]) Slice() []T {
+	s := make([]T, 0, r.len)
+	if r.head+r.len <= len(r.buf) {
+		return append(s, r.buf[r.head:r.head+r.len]...)
+	}
+	s = append(s, r.buf[r.head:]...)
+	return append(s, r.buf[:r.head+r.len-len(r.buf)]...)
+}
+
+func (r *Ring[T]) Reset() {
+	clear(r.buf)
+	r.head = 0
+	r.len = 0
+}
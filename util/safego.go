@@ -0,0 +1,64 @@
+package util
+
+import (
+	"sort"
+	"sync"
+)
+
+// TrackGoroutines enables counting live goroutines started by SafeGo,
+// see Goroutines
+var TrackGoroutines bool
+
+var (
+	mutexGoroutines sync.Mutex
+	goroutines      = make(map[string]int)
+)
+
+// SafeGo runs fn in a new goroutine, a panic is recovered and logged with
+// the goroutine name and stack instead of crashing the process
+func SafeGo(name string, fn func()) {
+	track := TrackGoroutines
+	if track {
+		mutexGoroutines.Lock()
+		goroutines[name]++
+		mutexGoroutines.Unlock()
+	}
+
+	go func() {
+		defer func() {
+			if track {
+				mutexGoroutines.Lock()
+				if goroutines[name]--; goroutines[name] == 0 {
+					delete(goroutines, name)
+				}
+				mutexGoroutines.Unlock()
+			}
+			if r := recover(); r != nil {
+				Recovered(r, PanicContext{Source: "goroutine", ID: name})
+			}
+		}()
+
+		fn()
+	}()
+}
+
+type GoroutineInfo struct {
+	Name  string
+	Count int
+}
+
+// Goroutines lists the live goroutines started by SafeGo while
+// TrackGoroutines was set, sorted by name
+func Goroutines() []GoroutineInfo {
+	mutexGoroutines.Lock()
+	r := make([]GoroutineInfo, 0, len(goroutines))
+	for name, n := range goroutines {
+		r = append(r, GoroutineInfo{name, n})
+	}
+	mutexGoroutines.Unlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
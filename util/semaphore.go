@@ -1,5 +1,9 @@
 package util
 
+import (
+	"context"
+)
+
 type Semaphore chan struct{}
 
 func MakeSemaphore(n int) Semaphore {
@@ -10,6 +14,27 @@ func (s Semaphore) Acquire() {
 	s <- struct{}{}
 }
 
+// TryAcquire acquires the semaphore only if it's available immediately
+func (s Semaphore) TryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// AcquireCtx blocks until the semaphore is acquired or ctx is done, in which
+// case it returns ctx.Err()
+func (s Semaphore) AcquireCtx(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s Semaphore) Release() {
 	<-s
 }
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// ShardedMap spreads keys over independently locked shards
+// goroutine safe
+type ShardedMap[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []mapShard[K, V]
+}
+
+type mapShard[K comparable, V any] struct {
+	sync.RWMutex
+	m map[K]V
+}
+
+// n is rounded up to a power of two
+func NewShardedMap[K comparable, V any](n int) *ShardedMap[K, V] {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+
+	m := new(ShardedMap[K, V])
+	m.seed = maphash.MakeSeed()
+	m.shards = make([]mapShard[K, V], size)
+	for i := range m.shards {
+		m.shards[i].m = make(map[K]V)
+	}
+	return m
+}
+
+func (m *ShardedMap[K, V]) shard(key K) *mapShard[K, V] {
+	h := maphash.Comparable(m.seed, key)
+	return &m.shards[h&uint64(len(m.shards)-1)]
+}
+
+func (m *ShardedMap[K, V]) Get(key K) (V, bool) {
+	s := m.shard(key)
+	s.RLock()
+	v, ok := s.m[key]
+	s.RUnlock()
+	return v, ok
+}
+
+func (m *ShardedMap[K, V]) Set(key K, value V) {
+	s := m.shard(key)
+	s.Lock()
+	s.m[key] = value
+	s.Unlock()
+}
+
+// TestAndSet sets the value if key is absent, otherwise it returns the
+// existing value and true
+func (m *ShardedMap[K, V]) TestAndSet(key K, value V) (V, bool) {
+	s := m.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if v, ok := s.m[key]; ok {
+		return v, true
+	}
+	s.m[key] = value
+	var zero V
+	return zero, false
+}
+
+func (m *ShardedMap[K, V]) Del(key K) {
+	s := m.shard(key)
+	s.Lock()
+	delete(s.m, key)
+	s.Unlock()
+}
+
+// Update calls f with the current value under the shard lock and stores the
+// returned value, or deletes the key if f returns false
+func (m *ShardedMap[K, V]) Update(key K, f func(value V, ok bool) (V, bool)) {
+	s := m.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.m[key]
+	if v, keep := f(v, ok); keep {
+		s.m[key] = v
+	} else {
+		delete(s.m, key)
+	}
+}
+
+func (m *ShardedMap[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		n += len(s.m)
+		s.RUnlock()
+	}
+	return n
+}
+
+// Range calls f for every entry until f returns false, one shard is read
+// locked at a time so f must not modify the map
+func (m *ShardedMap[K, V]) Range(f func(key K, value V) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		for k, v := range s.m {
+			if !f(k, v) {
+				s.RUnlock()
+				return
+			}
+		}
+		s.RUnlock()
+	}
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/czx-lab/leaf/conf"
+	"github.com/czx-lab/leaf/log"
+)
+
+type slowRecord struct {
+	sync.Mutex
+	lastLog    time.Time
+	suppressed int
+}
+
+var slowRecords sync.Map
+
+// CheckSlow logs a warning when the handler identified by id ran longer than
+// conf.SlowHandlerThreshold. Warnings are rate limited per id by
+// conf.SlowHandlerLogInterval, suppressed warnings are counted and reported
+// with the next one.
+// goroutine safe
+func CheckSlow(id interface{}, begin time.Time) {
+	CheckSlowThreshold(id, time.Since(begin), conf.SlowHandlerThreshold)
+}
+
+// CheckSlowThreshold is CheckSlow of a handler which took d with its own
+// threshold
+// goroutine safe
+func CheckSlowThreshold(id interface{}, d time.Duration, threshold time.Duration) {
+	if threshold <= 0 || d < threshold {
+		return
+	}
+
+	v, _ := slowRecords.LoadOrStore(id, new(slowRecord))
+	r := v.(*slowRecord)
+
+	r.Lock()
+	now := time.Now()
+	if now.Sub(r.lastLog) < conf.SlowHandlerLogInterval {
+		r.suppressed++
+		r.Unlock()
+		return
+	}
+	suppressed := r.suppressed
+	r.lastLog = now
+	r.suppressed = 0
+	r.Unlock()
+
+	if suppressed > 0 {
+		log.Release("slow handler %v: took %v (threshold %v, %v similar warnings suppressed)",
+			id, d, threshold, suppressed)
+	} else {
+		log.Release("slow handler %v: took %v (threshold %v)",
+			id, d, threshold)
+	}
+}